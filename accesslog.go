@@ -0,0 +1,134 @@
+package pretty_error
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// accessLogEntry is the JSON line written for every replaced error.
+type accessLogEntry struct {
+	Time           string `json:"time"`
+	Middleware     string `json:"middleware"`
+	Host           string `json:"host"`
+	Method         string `json:"method"`
+	Path           string `json:"path"`
+	ClientIP       string `json:"clientIp"`
+	OriginalStatus int    `json:"originalStatus"`
+	Status         int    `json:"status"`
+	ReferenceID    string `json:"referenceId"`
+	BytesWritten   int    `json:"bytesWritten"`
+}
+
+// accessLog writes one JSON line per replaced error, ready to be shipped to log aggregators.
+type accessLog struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+// accessLogFiles holds the access logs writing to files by path. Traefik creates the middleware again on every
+// configuration reload, they share the file opened first instead of leaking a new handle each time.
+var accessLogFiles = struct {
+	mu   sync.Mutex
+	logs map[string]*accessLog
+}{logs: make(map[string]*accessLog)}
+
+// newAccessLog open the configured access log destination: stdout, stderr or the path of a file to append to.
+// The access log is disabled, and nil, without destination.
+func newAccessLog(destination string, v *validator) *accessLog {
+	switch destination {
+	case "":
+		return nil
+	case "stdout":
+		return &accessLog{writer: os.Stdout}
+	case "stderr":
+		return &accessLog{writer: os.Stderr}
+	}
+
+	accessLogFiles.mu.Lock()
+	defer accessLogFiles.mu.Unlock()
+
+	if log, ok := accessLogFiles.logs[destination]; ok {
+		return log
+	}
+
+	file, err := os.OpenFile(destination, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if !v.check("accessLog", err) {
+		return nil
+	}
+
+	log := &accessLog{writer: file}
+	accessLogFiles.logs[destination] = log
+
+	return log
+}
+
+// record write the entry of a replaced error, doing nothing when the access log is disabled.
+func (accessLog *accessLog) record(entry accessLogEntry) error {
+	if accessLog == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	accessLog.mu.Lock()
+	defer accessLog.mu.Unlock()
+
+	_, err = accessLog.writer.Write(append(line, '\n'))
+
+	return err
+}
+
+// newAccessLogEntry describe the error page served for req, identified by reference.
+func newAccessLogEntry(
+	middleware string,
+	req *http.Request,
+	reference string,
+	originalStatus, status, written int,
+) accessLogEntry {
+	return accessLogEntry{
+		Time:           time.Now().UTC().Format(time.RFC3339Nano),
+		Middleware:     middleware,
+		Host:           req.Host,
+		Method:         req.Method,
+		Path:           req.URL.Path,
+		ClientIP:       clientIP(req),
+		OriginalStatus: originalStatus,
+		Status:         status,
+		ReferenceID:    reference,
+		BytesWritten:   written,
+	}
+}
+
+// clientIP get the address of the client connected to the proxy.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
+
+// referenceID get the identifier of req given by X-Request-Id, or a new random one.
+func referenceID(req *http.Request) string {
+	if id := req.Header.Get("X-Request-Id"); id != "" {
+		return id
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(id)
+}
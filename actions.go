@@ -0,0 +1,73 @@
+package pretty_error
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/packruler/pretty-error/types"
+)
+
+// Action holds one per-status alternative to rendering an error page.
+type Action struct {
+	Status   []string `json:"status,omitempty" toml:"status,omitempty" yaml:"status,omitempty" export:"true"`
+	Redirect string   `json:"redirect,omitempty" toml:"redirect,omitempty" yaml:"redirect,omitempty" export:"true"`
+}
+
+type action struct {
+	httpCodeRanges types.HTTPCodeRanges
+	redirect       string
+}
+
+func newActions(configs []Action, v *validator) []action {
+	actions := make([]action, len(configs))
+
+	for index, actionConfig := range configs {
+		field := fmt.Sprintf("actions[%d]", index)
+
+		if actionConfig.Redirect == "" {
+			v.check(field+".redirect", errors.New("is required"))
+		}
+
+		actions[index] = action{
+			httpCodeRanges: v.parseStatus(field+".status", actionConfig.Status, anyStatusRanges),
+			redirect:       actionConfig.Redirect,
+		}
+	}
+
+	return actions
+}
+
+// findAction get the first action configured for status, if any.
+func findAction(actions []action, status int) (action, bool) {
+	for _, candidate := range actions {
+		if candidate.httpCodeRanges.Contains(status) {
+			return candidate, true
+		}
+	}
+
+	return action{}, false
+}
+
+// serve redirect the client, replacing {url} in the target with the escaped original request URL.
+func (a action) serve(response http.ResponseWriter, req *http.Request) {
+	location := strings.ReplaceAll(a.redirect, "{url}", url.QueryEscape(requestURL(req)))
+
+	http.Redirect(response, req, location, http.StatusFound)
+}
+
+// requestURL rebuild the absolute URL requested by the client.
+func requestURL(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+
+	if proto := req.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+
+	return scheme + "://" + req.Host + req.URL.RequestURI()
+}
@@ -0,0 +1,85 @@
+package pretty_error
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// defaultAssetsPath prefix of the asset URLs when Assets.Path is empty.
+const defaultAssetsPath = "/_error-assets/"
+
+// Assets holds the configuration of the static files, such as styles, scripts, images or fonts, served for the pages.
+// Files of Dir are served under Path, which templates get as {{ .AssetsPath }}.
+type Assets struct {
+	Dir  string `json:"dir,omitempty" toml:"dir,omitempty" yaml:"dir,omitempty" export:"true"`
+	Path string `json:"path,omitempty" toml:"path,omitempty" yaml:"path,omitempty" export:"true"`
+}
+
+// assetHandler serves the files of a directory under a path prefix, without listing directories.
+type assetHandler struct {
+	prefix  string
+	handler http.Handler
+}
+
+func newAssetHandler(config *Assets, v *validator) *assetHandler {
+	if config == nil {
+		return nil
+	}
+
+	prefix := config.Path
+	if prefix == "" {
+		prefix = defaultAssetsPath
+	}
+
+	if !strings.HasPrefix(prefix, "/") {
+		v.check("assets.path", errors.New("must start with /"))
+	}
+
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	if config.Dir == "" {
+		v.check("assets.dir", errors.New("is required"))
+	} else {
+		checkDir("assets.dir", config.Dir, v)
+	}
+
+	return &assetHandler{
+		prefix:  prefix,
+		handler: http.StripPrefix(prefix, http.FileServer(http.Dir(config.Dir))),
+	}
+}
+
+// path get the prefix of the asset URLs, empty when no assets are served.
+func (assets *assetHandler) path() string {
+	if assets == nil {
+		return ""
+	}
+
+	return assets.prefix
+}
+
+// matches determine if req targets an asset.
+func (assets *assetHandler) matches(req *http.Request) bool {
+	return assets != nil && strings.HasPrefix(req.URL.Path, assets.prefix)
+}
+
+func (assets *assetHandler) ServeHTTP(response http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		response.Header().Set("Allow", "GET, HEAD")
+		http.Error(response, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	if strings.HasSuffix(req.URL.Path, "/") {
+		http.NotFound(response, req)
+
+		return
+	}
+
+	response.Header().Set("X-Content-Type-Options", "nosniff")
+	assets.handler.ServeHTTP(response, req)
+}
@@ -0,0 +1,61 @@
+package pretty_error
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/packruler/pretty-error/htmltemplates"
+)
+
+// defaultBannerBefore is the tag the banner is inserted before when Banner.Before is not set.
+const defaultBannerBefore = "</body>"
+
+// bannerSkipped matches the parts of a page in which the tag the banner is inserted before is text, not markup:
+// scripts, styles and comments, up to the end of the body when they are not closed yet.
+const bannerSkipped = `<script\b.*?(?:</script\s*>|$)|<style\b.*?(?:</style\s*>|$)|<!--.*?(?:-->|$)`
+
+// Banner holds a snippet inserted in upstream HTML pages, as an alternative to replacing them,
+// such as a notice on degraded but working pages.
+type Banner struct {
+	Snippet     string          `json:"snippet,omitempty" toml:"snippet,omitempty" yaml:"snippet,omitempty" export:"true"`
+	SnippetFile string          `json:"snippetFile,omitempty" toml:"snippetFile,omitempty" yaml:"snippetFile,omitempty" export:"true"`
+	Before      string          `json:"before,omitempty" toml:"before,omitempty" yaml:"before,omitempty" export:"true"`
+	Status      []string        `json:"status,omitempty" toml:"status,omitempty" yaml:"status,omitempty" export:"true"`
+	Headers     []HeaderMatcher `json:"headers,omitempty" toml:"headers,omitempty" yaml:"headers,omitempty" export:"true"`
+}
+
+// newBannerRewrites get the rewrite inserting the banner before the first occurrence of its tag outside scripts,
+// styles and comments, if configured. In offline mode, the snippet must not reference external resources.
+func newBannerRewrites(banner *Banner, offline bool, v *validator) []rewrite {
+	if banner == nil {
+		return nil
+	}
+
+	snippet := readConfigFile("banner.snippetFile", banner.Snippet, banner.SnippetFile, v)
+	if snippet == "" && banner.SnippetFile == "" {
+		v.check("banner.snippet", errors.New("is required"))
+	}
+
+	if offline && htmltemplates.HasExternalReferences([]byte(snippet)) {
+		v.check("banner.snippet", errExternalReferences)
+	}
+
+	before := banner.Before
+	if before == "" {
+		before = defaultBannerBefore
+	}
+
+	return []rewrite{{
+		// the tag comes first, to be found rather than skipped when it starts a skipped part.
+		regex: regexp.MustCompile("(?is)(" + regexp.QuoteMeta(before) + ")|" + bannerSkipped),
+		group: 1,
+		// the snippet is inserted as is, followed by the matched tag.
+		replacement:     []byte(strings.ReplaceAll(snippet, "$", "$$") + "${0}"),
+		httpCodeRanges:  v.parseStatus("banner.status", banner.Status, anyStatusRanges),
+		anyStatus:       len(banner.Status) == 0,
+		contentTypes:    []string{"text/html"},
+		maxReplacements: 1,
+		headers:         newHeaderMatchers("banner.headers", banner.Headers, v),
+	}}
+}
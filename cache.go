@@ -0,0 +1,84 @@
+package pretty_error
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/packruler/pretty-error/httputil"
+)
+
+// notModifiedHeaders headers kept on a 304 response, other headers would override the ones stored with the page.
+var notModifiedHeaders = []string{"Cache-Control", "Content-Location", "Date", "ETag", "Expires", "Vary"}
+
+// setCacheHeaders set the caching policy of a generated page unless it was already set from configuration.
+// Pages are not stored by default; with a positive maxAge they may be cached briefly and revalidated.
+// The nonce is excluded from the ETag so the page validates across responses, the ETag of a nonced page being weak as
+// its bytes differ from one response to the other.
+func (bodyRewrite *rewriteBody) setCacheHeaders(header http.Header, body []byte, nonce string) {
+	if header.Get("Cache-Control") == "" {
+		if bodyRewrite.cacheMaxAge > 0 {
+			header.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", bodyRewrite.cacheMaxAge))
+		} else {
+			header.Set("Cache-Control", "no-store")
+		}
+	}
+
+	if !bodyRewrite.cacheValidators {
+		return
+	}
+
+	if nonce != "" {
+		body = bytes.ReplaceAll(body, []byte(nonce), nil)
+	}
+
+	header.Set("ETag", pageETag(body, nonce != ""))
+}
+
+// pageETag build an entity tag for a generated page, weak when the page is only semantically equivalent to the
+// other responses with the same tag.
+func pageETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+	if weak {
+		return "W/" + etag
+	}
+
+	return etag
+}
+
+// writeNotModified send a 304 response, only keeping the headers allowed to update the stored page.
+func writeNotModified(response http.ResponseWriter) {
+	header := response.Header()
+
+	for name := range header {
+		if !httputil.MatchesHeaderName(name, notModifiedHeaders) {
+			delete(header, name)
+		}
+	}
+
+	response.WriteHeader(http.StatusNotModified)
+}
+
+// isNotModified determine if the client already holds the generated page described by header.
+// Only an If-None-Match listing the tag of the page is honored: If-Modified-Since and "*" would also match the stored
+// copy of the resource itself, answering 304 in place of the error.
+func isNotModified(req *http.Request, header http.Header) bool {
+	// If-None-Match uses the weak comparison, ignoring whether the tags are weak.
+	etag := strings.TrimPrefix(header.Get("ETag"), "W/")
+	if etag == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(req.Header.Get("If-None-Match"), ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,142 @@
+// Package caddy registers the pretty error middleware as the http.handlers.pretty_error Caddy module, along with the
+// pretty_error Caddyfile directive. It lives in its own module, the Traefik plugin only using the standard library.
+package caddy
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	prettyerror "github.com/packruler/pretty-error"
+)
+
+// defaultName identifies the instances without a name in logs and metrics.
+const defaultName = "caddy"
+
+func init() {
+	caddy.RegisterModule(Handler{})
+	httpcaddyfile.RegisterHandlerDirective("pretty_error", parseCaddyfile)
+	// the pages are rendered before encode compresses the response, from the responses of the content handlers.
+	httpcaddyfile.RegisterDirectiveOrder("pretty_error", httpcaddyfile.Before, "encode")
+}
+
+// Handler replaces the error responses of the next handlers with error pages, like the Traefik middleware.
+type Handler struct {
+	// Config configures the middleware with the fields of its Traefik configuration, CreateConfig() when not set.
+	Config *prettyerror.Config `json:"config,omitempty"`
+	// Name identifies the instance in logs and metrics, "caddy" when not set.
+	Name string `json:"name,omitempty"`
+
+	interceptor prettyerror.Interceptor
+}
+
+// Interface guards.
+var (
+	_ caddy.Provisioner           = (*Handler)(nil)
+	_ caddyhttp.MiddlewareHandler = (*Handler)(nil)
+	_ caddyfile.Unmarshaler       = (*Handler)(nil)
+)
+
+// CaddyModule get the information of the http.handlers.pretty_error module.
+func (Handler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.pretty_error",
+		New: func() caddy.Module { return new(Handler) },
+	}
+}
+
+// Provision create the middleware, failing with the problems of an invalid configuration.
+func (h *Handler) Provision(caddy.Context) error {
+	config := h.Config
+	if config == nil {
+		config = prettyerror.CreateConfig()
+	}
+
+	name := h.Name
+	if name == "" {
+		name = defaultName
+	}
+
+	interceptor, err := prettyerror.NewInterceptor(config, name)
+	if err != nil {
+		return err
+	}
+
+	h.interceptor = interceptor
+
+	return nil
+}
+
+// ServeHTTP serve the request with next, replacing its error responses. The errors next returns instead of writing a
+// response, such as the ones of the error directive, are written as their status for the middleware to replace them.
+func (h *Handler) ServeHTTP(response http.ResponseWriter, req *http.Request, next caddyhttp.Handler) error {
+	h.interceptor.ServeHTTP(response, req, http.HandlerFunc(func(response http.ResponseWriter, req *http.Request) {
+		if err := next.ServeHTTP(response, req); err != nil {
+			response.WriteHeader(errorStatus(err))
+		}
+	}))
+
+	return nil
+}
+
+// errorStatus get the status of a handler error, 500 when it has none as Caddy does.
+func errorStatus(err error) int {
+	var handlerErr caddyhttp.HandlerError
+	if errors.As(err, &handlerErr) && handlerErr.StatusCode != 0 {
+		return handlerErr.StatusCode
+	}
+
+	return http.StatusInternalServerError
+}
+
+// parseCaddyfile set up the handler of a pretty_error directive.
+func parseCaddyfile(helper httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	handler := new(Handler)
+
+	return handler, handler.UnmarshalCaddyfile(helper.Dispenser)
+}
+
+// UnmarshalCaddyfile set up the handler from the pretty_error directive:
+//
+//	pretty_error {
+//	    name         api
+//	    status       5xx 404
+//	    template_dir /etc/caddy/error-pages
+//	    theme        dark
+//	}
+//
+// The other fields of the configuration are set in the JSON configuration of the module.
+func (h *Handler) UnmarshalCaddyfile(dispenser *caddyfile.Dispenser) error {
+	dispenser.Next() // the directive name
+
+	if h.Config == nil {
+		h.Config = prettyerror.CreateConfig()
+	}
+
+	for dispenser.NextBlock(0) {
+		var ok bool
+
+		switch dispenser.Val() {
+		case "name":
+			ok = dispenser.Args(&h.Name)
+		case "status":
+			h.Config.Status = dispenser.RemainingArgs()
+			ok = len(h.Config.Status) > 0
+		case "template_dir":
+			ok = dispenser.Args(&h.Config.TemplateDir)
+		case "theme":
+			ok = dispenser.Args(&h.Config.Theme)
+		default:
+			return dispenser.Errf("unrecognized subdirective %q", dispenser.Val())
+		}
+
+		if !ok {
+			return dispenser.ArgErr()
+		}
+	}
+
+	return nil
+}
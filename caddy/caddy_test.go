@@ -0,0 +1,116 @@
+package caddy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	prettyerror "github.com/packruler/pretty-error"
+)
+
+func TestServeHTTP(t *testing.T) {
+	handler := &Handler{Config: &prettyerror.Config{Status: []string{"5xx"}}, Name: "caddyTest"}
+	if err := handler.Provision(caddy.Context{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc      string
+		next      caddyhttp.HandlerFunc
+		expStatus int
+		expBody   string
+	}{
+		{
+			desc: "written error",
+			next: func(response http.ResponseWriter, _ *http.Request) error {
+				response.WriteHeader(http.StatusBadGateway)
+				_, err := response.Write([]byte("upstream failure"))
+
+				return err
+			},
+			expStatus: http.StatusBadGateway,
+			expBody:   "Bad Gateway",
+		},
+		{
+			desc: "handler error",
+			next: func(http.ResponseWriter, *http.Request) error {
+				return caddyhttp.Error(http.StatusServiceUnavailable, errors.New("maintenance"))
+			},
+			expStatus: http.StatusServiceUnavailable,
+			expBody:   "Service Unavailable",
+		},
+		{
+			desc: "other error",
+			next: func(http.ResponseWriter, *http.Request) error {
+				return errors.New("failure")
+			},
+			expStatus: http.StatusInternalServerError,
+			expBody:   "Internal Server Error",
+		},
+		{
+			desc: "success",
+			next: func(response http.ResponseWriter, _ *http.Request) error {
+				_, err := response.Write([]byte("ok"))
+
+				return err
+			},
+			expStatus: http.StatusOK,
+			expBody:   "ok",
+		},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+
+		if err := handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil), test.next); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.desc, err)
+		}
+
+		if recorder.Code != test.expStatus || !strings.Contains(recorder.Body.String(), test.expBody) {
+			t.Errorf("%s: got %d %q, want %d with %q", test.desc, recorder.Code, recorder.Body.String(),
+				test.expStatus, test.expBody)
+		}
+	}
+}
+
+func TestProvisionInvalidConfig(t *testing.T) {
+	handler := &Handler{Config: &prettyerror.Config{Status: []string{"abc"}}}
+
+	if err := handler.Provision(caddy.Context{}); err == nil {
+		t.Error("expected an invalid configuration to be rejected")
+	}
+}
+
+func TestUnmarshalCaddyfile(t *testing.T) {
+	dispenser := caddyfile.NewTestDispenser(`pretty_error {
+		name api
+		status 5xx 404
+		template_dir /etc/caddy/error-pages
+		theme dark
+	}`)
+
+	var handler Handler
+	if err := handler.UnmarshalCaddyfile(dispenser); err != nil {
+		t.Fatal(err)
+	}
+
+	if handler.Name != "api" || handler.Config.TemplateDir != "/etc/caddy/error-pages" || handler.Config.Theme != "dark" {
+		t.Errorf("unexpected handler %+v", handler)
+	}
+
+	if !reflect.DeepEqual(handler.Config.Status, []string{"5xx", "404"}) {
+		t.Errorf("got status %v", handler.Config.Status)
+	}
+
+	for _, invalid := range []string{"pretty_error {\n\tstatus\n}", "pretty_error {\n\tcolor blue\n}"} {
+		if err := new(Handler).UnmarshalCaddyfile(caddyfile.NewTestDispenser(invalid)); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
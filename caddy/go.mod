@@ -0,0 +1,11 @@
+module github.com/packruler/pretty-error/caddy
+
+go 1.22
+
+require (
+	github.com/caddyserver/caddy/v2 v2.8.4
+	github.com/packruler/pretty-error v0.0.0
+)
+
+// the adapter is built against the middleware of the same checkout.
+replace github.com/packruler/pretty-error => ../
@@ -0,0 +1,118 @@
+// Command pretty-error-gen renders the error pages of a middleware configuration into a directory of static HTML
+// files, so the same designs can be served by nginx, an S3 bucket or Traefik's errors middleware.
+//
+// Usage:
+//
+//	pretty-error-gen -config pretty-error.json -out error-pages -status 4xx,5xx -lang en,fr
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	prettyerror "github.com/packruler/pretty-error"
+	"github.com/packruler/pretty-error/htmltemplates"
+	"github.com/packruler/pretty-error/internal/cliconfig"
+	"github.com/packruler/pretty-error/types"
+)
+
+func main() {
+	configPath := flag.String("config", "", "JSON middleware configuration, the embedded templates are used without")
+	templateDir := flag.String("templates", "", "template directory, overriding templateDir of the configuration")
+	output := flag.String("out", "error-pages", "directory the pages are written to")
+	statuses := flag.String("status", "4xx,5xx", "comma separated statuses or ranges to render")
+	languages := flag.String("lang", "en", "comma separated languages to render, each in its own directory when several")
+	flag.Parse()
+
+	config, err := cliconfig.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *templateDir != "" {
+		config.TemplateDir = *templateDir
+	}
+
+	ranges, err := types.NewHTTPCodeRanges([]string{*statuses})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	written, err := generate(config, ranges, strings.Split(*languages, ","), *output)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("%d pages written to %s", written, *output)
+}
+
+// generate render the page of every known status of ranges for each language, returning the number of pages written.
+func generate(config *prettyerror.Config, ranges types.HTTPCodeRanges, languages []string, output string) (int, error) {
+	// static pages are the pages themselves, not redirects to them.
+	config.Actions = nil
+	config.Compress = false
+
+	upstream := http.HandlerFunc(func(response http.ResponseWriter, req *http.Request) {
+		status, _ := strconv.Atoi(req.Header.Get("X-Status"))
+		response.WriteHeader(status)
+	})
+
+	handler, err := prettyerror.NewWithCodeMatcher(context.Background(), upstream, config, "pretty-error-gen", ranges)
+	if err != nil {
+		return 0, err
+	}
+
+	var written int
+
+	for _, language := range languages {
+		language = strings.TrimSpace(language)
+
+		dir := output
+		if len(languages) > 1 {
+			dir = filepath.Join(output, language)
+		}
+
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return written, err
+		}
+
+		for status := 100; status <= 599; status++ {
+			if !ranges.Contains(status) || http.StatusText(status) == "" || htmltemplates.ValidateStatus(status) != nil {
+				continue
+			}
+
+			if err := render(handler, status, language, filepath.Join(dir, strconv.Itoa(status)+".html")); err != nil {
+				return written, err
+			}
+
+			written++
+		}
+	}
+
+	return written, nil
+}
+
+// render write the page of status in language to path.
+func render(handler http.Handler, status int, language, path string) error {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("Accept-Language", language)
+	req.Header.Set("X-Status", strconv.Itoa(status))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != status || recorder.Body.Len() == 0 {
+		return fmt.Errorf("unable to render the page of %d, got %d with %d bytes", status, recorder.Code, recorder.Body.Len())
+	}
+
+	return os.WriteFile(path, recorder.Body.Bytes(), 0o644)
+}
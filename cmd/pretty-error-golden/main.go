@@ -0,0 +1,93 @@
+// Command pretty-error-golden writes golden files of the error pages rendered by htmltemplates.RenderAll, the
+// snapshots compared by the tests of template changes. It is meant to be run by go generate.
+//
+// Usage:
+//
+//	pretty-error-golden -template error.html -out testdata/golden -status 404,5xx -lang en,fr
+package main
+
+import (
+	"flag"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/packruler/pretty-error/htmltemplates"
+	"github.com/packruler/pretty-error/types"
+)
+
+func main() {
+	templatePath := flag.String("template", "", "page template, the embedded template is used without")
+	output := flag.String("out", "testdata/golden", "directory the golden files are written to, one per language")
+	statuses := flag.String("status", "400,401,403,404,429,500,502,503,504", "comma separated statuses or ranges")
+	languages := flag.String("lang", "en", "comma separated languages to render")
+	flag.Parse()
+
+	var opts []htmltemplates.Option
+
+	if *templatePath != "" {
+		temp, err := template.New(filepath.Base(*templatePath)).Funcs(htmltemplates.FuncMap()).ParseFiles(*templatePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		opts = append(opts, htmltemplates.WithTemplate(temp))
+	}
+
+	ranges, err := types.NewHTTPCodeRanges([]string{*statuses})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var written int
+
+	for _, language := range strings.Split(*languages, ",") {
+		language = strings.TrimSpace(language)
+
+		count, err := write(filepath.Join(*output, language), language, knownStatuses(ranges), opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		written += count
+	}
+
+	log.Printf("%d golden files written to %s", written, *output)
+}
+
+// knownStatuses get the statuses of ranges with a standard text.
+func knownStatuses(ranges types.HTTPCodeRanges) []int {
+	var statuses []int
+
+	for status := 100; status <= 599; status++ {
+		if ranges.Contains(status) && http.StatusText(status) != "" {
+			statuses = append(statuses, status)
+		}
+	}
+
+	return statuses
+}
+
+// write render the pages of statuses in language to dir, returning the number of files written.
+func write(dir, language string, statuses []int, opts []htmltemplates.Option) (int, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, err
+	}
+
+	pages, err := htmltemplates.RenderAll(statuses, language, opts...)
+	if err != nil {
+		return 0, err
+	}
+
+	for status, body := range pages {
+		if err := os.WriteFile(filepath.Join(dir, strconv.Itoa(status)+".html"), body, 0o644); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(pages), nil
+}
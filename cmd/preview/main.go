@@ -0,0 +1,206 @@
+// Command preview serves the error pages of a template directory for local development, with a status picker and a
+// live reload of the page whenever a template changes, so templates can be written without running Traefik.
+//
+// Usage:
+//
+//	preview -templates ./error-pages -addr localhost:8080
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	prettyerror "github.com/packruler/pretty-error"
+	"github.com/packruler/pretty-error/internal/cliconfig"
+	"github.com/packruler/pretty-error/types"
+)
+
+// pollInterval how often the template directory is checked for changes.
+const pollInterval = 500 * time.Millisecond
+
+func main() {
+	configPath := flag.String("config", "", "JSON middleware configuration")
+	templateDir := flag.String("templates", "", "template directory, overriding templateDir of the configuration")
+	addr := flag.String("addr", "localhost:8080", "address the preview is served on")
+	flag.Parse()
+
+	config, err := cliconfig.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *templateDir != "" {
+		config.TemplateDir = *templateDir
+	}
+
+	server := &previewServer{config: config}
+	server.reload()
+
+	go server.watch(pollInterval)
+
+	http.HandleFunc("/", server.picker)
+	http.HandleFunc("/version", server.version)
+	http.HandleFunc("/status/", server.page)
+
+	log.Printf("previewing %s on http://%s", config.TemplateDir, *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+// previewServer renders pages with the middleware built from the latest templates.
+type previewServer struct {
+	config *prettyerror.Config
+
+	mu        sync.RWMutex
+	handler   http.Handler
+	err       error
+	revision  int
+	signature string
+}
+
+// reload rebuild the middleware, keeping the configuration problem to show it instead of the pages.
+func (server *previewServer) reload() {
+	upstream := http.HandlerFunc(func(response http.ResponseWriter, req *http.Request) {
+		status, _ := strconv.Atoi(strings.TrimPrefix(req.URL.Path, "/status/"))
+		response.WriteHeader(status)
+	})
+
+	config := *server.config
+	// the picker previews the pages themselves, not redirects to them.
+	config.Actions = nil
+	// pages are shown in a frame of the picker, which the default policy forbids with frame-ancestors.
+	config.SecurityHeaders = map[string]string{"Content-Security-Policy": ""}
+
+	anyStatus := types.CodeMatcherFunc(func(int) bool { return true })
+	handler, err := prettyerror.NewWithCodeMatcher(context.Background(), upstream, &config, "preview", anyStatus)
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.handler, server.err = handler, err
+	server.revision++
+
+	if err != nil {
+		log.Printf("unable to load templates: %v", err)
+	}
+}
+
+// watch reload the middleware whenever a file of the template directory is added, removed or modified.
+func (server *previewServer) watch(interval time.Duration) {
+	server.signature = directorySignature(server.config.TemplateDir)
+
+	for range time.Tick(interval) {
+		signature := directorySignature(server.config.TemplateDir)
+		if signature == server.signature {
+			continue
+		}
+
+		server.signature = signature
+		log.Print("templates changed, reloading")
+		server.reload()
+	}
+}
+
+// directorySignature summarize the names, sizes and modification times of the files of dir.
+func directorySignature(dir string) string {
+	if dir == "" {
+		return ""
+	}
+
+	var builder strings.Builder
+
+	_ = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+
+		if info, err := entry.Info(); err == nil {
+			fmt.Fprintf(&builder, "%s:%d:%d\n", path, info.Size(), info.ModTime().UnixNano())
+		}
+
+		return nil
+	})
+
+	return builder.String()
+}
+
+// version write the revision of the templates, polled by the picker to reload the page.
+func (server *previewServer) version(response http.ResponseWriter, _ *http.Request) {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+
+	response.Header().Set("Cache-Control", "no-store")
+	fmt.Fprint(response, server.revision)
+}
+
+// page render the page of the status ending the path, or the problem preventing the templates from loading.
+func (server *previewServer) page(response http.ResponseWriter, req *http.Request) {
+	server.mu.RLock()
+	handler, err := server.handler, server.err
+	server.mu.RUnlock()
+
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	handler.ServeHTTP(response, req)
+}
+
+// pickerStatuses the statuses offered by the picker.
+var pickerStatuses = []int{400, 401, 403, 404, 405, 408, 409, 410, 413, 418, 429, 500, 501, 502, 503, 504}
+
+var pickerTemplate = template.Must(template.New("picker").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>pretty-error preview</title>
+  <style>
+    body { display: flex; margin: 0; height: 100vh; font-family: sans-serif; }
+    nav { width: 10rem; overflow-y: auto; padding: 1rem; border-right: 1px solid #ccc; }
+    nav a { display: block; padding: .25rem 0; }
+    iframe { flex: 1; border: 0; }
+  </style>
+</head>
+<body>
+  <nav>
+    {{ range . }}<a href="/status/{{ . }}" target="page">{{ . }}</a>
+    {{ end }}
+  </nav>
+  <iframe name="page" src="/status/{{ index . 0 }}"></iframe>
+  <script>
+    let revision;
+    setInterval(async () => {
+      const current = await (await fetch("/version")).text();
+      if (revision !== undefined && current !== revision) {
+        window.frames.page.location.reload();
+      }
+      revision = current;
+    }, 1000);
+  </script>
+</body>
+</html>
+`))
+
+// picker write the status picker, showing the selected page in a frame reloaded when templates change.
+func (server *previewServer) picker(response http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		http.NotFound(response, req)
+
+		return
+	}
+
+	if err := pickerTemplate.Execute(response, pickerStatuses); err != nil {
+		log.Printf("unable to write the picker: %v", err)
+	}
+}
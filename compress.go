@@ -0,0 +1,105 @@
+package pretty_error
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/packruler/pretty-error/compressutil"
+	"github.com/packruler/pretty-error/httputil"
+)
+
+// identityEncoding the content coding of uncompressed pages.
+const identityEncoding = "identity"
+
+// maxCompressedVariants bounds the cached compressed pages, the cache starts over once full.
+const maxCompressedVariants = 64
+
+// pageEncodings content codings generated pages can be compressed with, in order of preference.
+// Brotli is left out since plugins are limited to the standard library.
+var pageEncodings = []string{"gzip"}
+
+// pageCompressor compresses generated pages with the coding accepted by the client. Pages rendered without the
+// per-response nonce are identical across responses, their compressed variants are cached.
+type pageCompressor struct {
+	mu       sync.Mutex
+	variants map[[sha256.Size]byte][]byte
+}
+
+// newPageCompressor get a compressor when enabled, nil otherwise.
+func newPageCompressor(enabled bool) *pageCompressor {
+	if !enabled {
+		return nil
+	}
+
+	return &pageCompressor{variants: make(map[[sha256.Size]byte][]byte)}
+}
+
+// negotiate get the coding the page for req is compressed with, "identity" when compression is disabled.
+func (compressor *pageCompressor) negotiate(req *http.Request) string {
+	if compressor == nil {
+		return identityEncoding
+	}
+
+	return httputil.PreferredEncoding(req, pageEncodings)
+}
+
+// compress get body encoded with encoding, from the cache when cached is set.
+func (compressor *pageCompressor) compress(body []byte, encoding string, cached bool) ([]byte, error) {
+	if compressor == nil || encoding == identityEncoding {
+		return body, nil
+	}
+
+	if !cached {
+		return compressutil.Encode(body, encoding)
+	}
+
+	key := sha256.Sum256(append([]byte(encoding+"\n"), body...))
+
+	compressor.mu.Lock()
+	variant, exists := compressor.variants[key]
+	compressor.mu.Unlock()
+
+	if exists {
+		return variant, nil
+	}
+
+	variant, err := compressutil.Encode(body, encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	compressor.mu.Lock()
+	if len(compressor.variants) >= maxCompressedVariants {
+		compressor.variants = make(map[[sha256.Size]byte][]byte)
+	}
+
+	compressor.variants[key] = variant
+	compressor.mu.Unlock()
+
+	return variant, nil
+}
+
+// setHeaders describe the coding of a generated page in header. The ETag of the uncompressed page is made specific
+// to the coding, as a compressed variant is a different representation.
+func (compressor *pageCompressor) setHeaders(header http.Header, encoding string) {
+	// an upstream coding never applies to the generated page.
+	header.Del("Content-Encoding")
+
+	if compressor == nil {
+		return
+	}
+
+	httputil.AddVary(header, "Accept-Encoding")
+
+	if encoding == identityEncoding {
+		return
+	}
+
+	header.Set("Content-Encoding", encoding)
+
+	if etag := header.Get("ETag"); etag != "" {
+		header.Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+encoding+`"`)
+	}
+}
@@ -6,7 +6,8 @@ import (
 	"compress/flate"
 	"compress/gzip"
 	"io"
-	"log"
+
+	"github.com/packruler/pretty-error/logging"
 )
 
 // ReaderError for notating that an error occurred while reading compressed data.
@@ -58,13 +59,13 @@ func compressWithGzip(bodyBytes []byte) ([]byte, error) {
 	gzipWriter := gzip.NewWriter(&buf)
 
 	if _, err := gzipWriter.Write(bodyBytes); err != nil {
-		log.Printf("unable to recompress rewrited body: %v", err)
+		logging.GetLogger().Error("unable to recompress rewrited body", logging.F("error", err))
 
 		return nil, err
 	}
 
 	if err := gzipWriter.Close(); err != nil {
-		log.Printf("unable to close gzip writer: %v", err)
+		logging.GetLogger().Error("unable to close gzip writer", logging.F("error", err))
 
 		return nil, err
 	}
@@ -77,13 +78,13 @@ func compressWithZlib(bodyBytes []byte) ([]byte, error) {
 	zlibWriter, _ := flate.NewWriter(&buf, flate.DefaultCompression)
 
 	if _, err := zlibWriter.Write(bodyBytes); err != nil {
-		log.Printf("unable to recompress rewrited body: %v", err)
+		logging.GetLogger().Error("unable to recompress rewrited body", logging.F("error", err))
 
 		return nil, err
 	}
 
 	if err := zlibWriter.Close(); err != nil {
-		log.Printf("unable to close zlib writer: %v", err)
+		logging.GetLogger().Error("unable to close zlib writer", logging.F("error", err))
 
 		return nil, err
 	}
@@ -0,0 +1,78 @@
+package pretty_error
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/packruler/pretty-error/httputil"
+)
+
+// CORS holds the CORS headers synthesized on generated pages when the upstream response did not provide them.
+type CORS struct {
+	AllowOrigins     []string `json:"allowOrigins,omitempty" toml:"allowOrigins,omitempty" yaml:"allowOrigins,omitempty" export:"true"`
+	AllowCredentials bool     `json:"allowCredentials,omitempty" toml:"allowCredentials,omitempty" yaml:"allowCredentials,omitempty" export:"true"`
+	ExposeHeaders    []string `json:"exposeHeaders,omitempty" toml:"exposeHeaders,omitempty" yaml:"exposeHeaders,omitempty" export:"true"`
+}
+
+// anyOrigin allows every origin, answered with a literal "*" which browsers refuse for credentialed requests.
+const anyOrigin = "*"
+
+// validate check that any origin is not allowed along with credentials, which would let every site read the pages
+// with the cookies of the user.
+func (cors *CORS) validate() error {
+	if cors == nil || !cors.AllowCredentials {
+		return nil
+	}
+
+	for _, allowed := range cors.AllowOrigins {
+		if allowed == anyOrigin {
+			return errors.New(`"*" cannot be combined with allowCredentials`)
+		}
+	}
+
+	return nil
+}
+
+// allowedOrigin get the Access-Control-Allow-Origin value for origin: origin itself when it is one of the configured
+// origins, "*" when any origin is allowed, or "" when it is not allowed.
+func (cors *CORS) allowedOrigin(origin string) string {
+	allowed := ""
+
+	for _, configured := range cors.AllowOrigins {
+		if strings.EqualFold(configured, origin) {
+			return origin
+		}
+
+		if configured == anyOrigin {
+			allowed = anyOrigin
+		}
+	}
+
+	return allowed
+}
+
+// apply set the CORS headers for req on the generated response unless they were preserved from upstream.
+func (cors *CORS) apply(header http.Header, req *http.Request) {
+	origin := req.Header.Get("Origin")
+	if cors == nil || origin == "" || header.Get("Access-Control-Allow-Origin") != "" {
+		return
+	}
+
+	httputil.AddVary(header, "Origin")
+
+	allowed := cors.allowedOrigin(origin)
+	if allowed == "" {
+		return
+	}
+
+	header.Set("Access-Control-Allow-Origin", allowed)
+
+	if cors.AllowCredentials && allowed != anyOrigin {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if len(cors.ExposeHeaders) > 0 {
+		header.Set("Access-Control-Expose-Headers", strings.Join(cors.ExposeHeaders, ", "))
+	}
+}
@@ -0,0 +1,58 @@
+package pretty_error
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	defaultDeadlineStatus = http.StatusGatewayTimeout
+	// defaultCanceledStatus is the nonstandard status proxies such as nginx and Traefik log for clients gone away.
+	defaultCanceledStatus = 499
+)
+
+// contextStatuses holds the statuses rendered when the request context ends before a response was sent.
+type contextStatuses struct {
+	deadline int
+	canceled int
+}
+
+func newContextStatuses(config *Config, v *validator) contextStatuses {
+	return contextStatuses{
+		deadline: newContextStatus("deadlineStatus", config.DeadlineStatus, defaultDeadlineStatus, v),
+		canceled: newContextStatus("canceledStatus", config.CanceledStatus, defaultCanceledStatus, v),
+	}
+}
+
+func newContextStatus(field string, status, defaultStatus int, v *validator) int {
+	if status == 0 {
+		return defaultStatus
+	}
+
+	if status < http.StatusBadRequest || status > 599 {
+		v.check(field, fmt.Errorf("%d is not an error status", status))
+	}
+
+	return status
+}
+
+// interruptedStatus get the status to render when the request context ended before the response was sent,
+// such as when the upstream took too long or the client went away while the response was buffered.
+func (statuses contextStatuses) interruptedStatus(req *http.Request, catcher responseInterceptor) (int, bool) {
+	if catcher.headersWritten() {
+		return 0, false
+	}
+
+	err := req.Context().Err()
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return statuses.deadline, true
+	case errors.Is(err, context.Canceled):
+		return statuses.canceled, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,104 @@
+package pretty_error
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/packruler/pretty-error/logging"
+)
+
+// Kinds of the diagnostics recorded when the upstream handler misuses the response writer or fails to complete its
+// response, usually the reason why an error page is not shown.
+const (
+	// diagnosticSuperfluousWriteHeader is a WriteHeader call once the status of the response was decided, ignored.
+	diagnosticSuperfluousWriteHeader = "superfluousWriteHeader"
+	// diagnosticWriteAfterCatch is a body written for a response replaced with an error page once the upstream handler
+	// returned, such as from a goroutine it left running, dropped.
+	diagnosticWriteAfterCatch = "writeAfterCatch"
+	// diagnosticTruncatedResponse is a response the upstream failed to complete once part of it was sent.
+	diagnosticTruncatedResponse = "truncatedResponse"
+)
+
+// catcherFunctions prefix the names of the methods of the codeCatcher and of its wrappers, skipped when looking for the
+// upstream caller.
+var catcherFunctions = []string{
+	"github.com/packruler/pretty-error.(*codeCatcher).",
+	"github.com/packruler/pretty-error.codeCatcher",
+}
+
+// checkWriteHeader diagnose a WriteHeader call of the upstream once the status of the response was decided, such as
+// a WriteHeader after a first Write already sent a 200.
+func (cc *codeCatcher) checkWriteHeader(code int) {
+	if !cc.decided() {
+		return
+	}
+
+	cc.diagnose(diagnosticSuperfluousWriteHeader, "superfluous WriteHeader call ignored",
+		logging.F("status", cc.getCode()), logging.F("ignored", code))
+}
+
+// checkWrite diagnose the first write of a body the error page replaces made once the upstream handler returned.
+// Bodies written before are expected, the error page replacing them.
+func (cc *codeCatcher) checkWrite() {
+	if !cc.isFilteredCode() || cc.droppedBody || !cc.returned() {
+		return
+	}
+
+	cc.droppedBody = true
+
+	cc.diagnose(diagnosticWriteAfterCatch, "body of a replaced response dropped", logging.F("status", cc.getCode()))
+}
+
+// returned report whether the upstream handler returned, ending its use of the response writer.
+func (cc *codeCatcher) returned() bool {
+	select {
+	case <-cc.released:
+		return true
+	default:
+		return false
+	}
+}
+
+// diagnose count a misuse of the response writer of kind, and log it at debug level along with the upstream caller.
+// The caller is only looked for when debug messages are written.
+func (cc *codeCatcher) diagnose(kind, message string, fields ...logging.Field) {
+	if cc.config.metrics != nil {
+		cc.config.metrics.Diagnostic(kind)
+	}
+
+	if !logging.Enabled(cc.config.logger, logging.LevelDebug) {
+		return
+	}
+
+	fields = append(fields, logging.F("path", cc.request.URL.Path), logging.F("caller", upstreamCaller()))
+	cc.config.logger.Debug(message, fields...)
+}
+
+// upstreamCaller get the location of the first caller outside the codeCatcher, or "unknown".
+func upstreamCaller() string {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+
+	for {
+		frame, more := frames.Next()
+		if frame.Function != "" && !isCatcherFunction(frame.Function) {
+			return frame.Function + " " + frame.File + ":" + strconv.Itoa(frame.Line)
+		}
+
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// isCatcherFunction report whether function is a method of the codeCatcher or of its wrappers.
+func isCatcherFunction(function string) bool {
+	for _, prefix := range catcherFunctions {
+		if strings.HasPrefix(function, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,92 @@
+package pretty_error
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/packruler/pretty-error/httputil"
+	"github.com/packruler/pretty-error/logging"
+)
+
+// discardWriter is a http.ResponseWriter dropping the response it receives, only keeping its status.
+type discardWriter struct {
+	header http.Header
+	status int
+}
+
+func newDiscardWriter() *discardWriter {
+	return &discardWriter{header: make(http.Header)}
+}
+
+func (writer *discardWriter) Header() http.Header {
+	return writer.header
+}
+
+func (writer *discardWriter) Write(buf []byte) (int, error) {
+	return len(buf), nil
+}
+
+func (writer *discardWriter) WriteHeader(status int) {
+	writer.status = status
+}
+
+// startShadowing send the headers of a response rewritten in dry run, whose body is then teed to the client and
+// the buffer, so the rewrites can run on it once complete.
+func (cc *codeCatcher) startShadowing() {
+	cc.shadowing = true
+
+	cc.sendHeaders()
+}
+
+// isShadowing returns whether the codeCatcher forwards a response rewritten in dry run.
+func (cc *codeCatcher) isShadowing() bool {
+	return cc.shadowing
+}
+
+// dryRunPage render the page which would have replaced the response, without sending it. Unlike served pages, it is
+// neither counted in the page metrics nor traced, the log line with wouldReplace set being its only record.
+func (bodyRewrite *rewriteBody) dryRunPage(req *http.Request, catcher responseInterceptor) {
+	status, written := http.StatusFound, 0
+	metadata := bodyRewrite.pageMetadata(req)
+	_, redirected := findAction(bodyRewrite.actions, catcher.getCode())
+
+	if !redirected || metadata.OutputFormat != httputil.OutputFormatHTML {
+		page, err := bodyRewrite.renderPage(req.Context(), catcher.getCode(), metadata)
+		if err != nil {
+			bodyRewrite.logger.Warn("dry run: unable to render error page", logging.F("status", catcher.getCode()),
+				logging.F("error", err))
+
+			page = minimalPage(catcher.getCode(), metadata)
+		}
+
+		status, written = bodyRewrite.writePage(newDiscardWriter(), req, catcher.getCode(), metadata, page)
+	}
+
+	bodyRewrite.logger.Info("dry run: response would have been replaced",
+		logging.F("middleware", bodyRewrite.name),
+		logging.F("path", req.URL.Path),
+		logging.F("status", catcher.getCode()),
+		logging.F("servedStatus", status),
+		logging.F("size", written),
+		logging.F("wouldReplace", true))
+}
+
+// dryRunRewrite run the rewrites on the forwarded response, only reporting whether they changed it.
+func (bodyRewrite *rewriteBody) dryRunRewrite(req *http.Request, catcher responseInterceptor) {
+	original := catcher.getBuffer().Bytes()
+	if len(original) == 0 {
+		return
+	}
+
+	rewritten := bodyRewrite.rewrite(req, catcher.getCode(), catcher.Header(), original)
+	if bytes.Equal(rewritten, original) {
+		return
+	}
+
+	bodyRewrite.logger.Info("dry run: response would have been rewritten",
+		logging.F("middleware", bodyRewrite.name),
+		logging.F("path", req.URL.Path),
+		logging.F("status", catcher.getCode()),
+		logging.F("size", len(original)),
+		logging.F("rewrittenSize", len(rewritten)))
+}
@@ -0,0 +1,37 @@
+package pretty_error
+
+import (
+	"net/http"
+
+	"github.com/packruler/pretty-error/httputil"
+)
+
+// holdsEmptyBody determine if the error response with code is held back until its body turns out to be empty,
+// in which case it is replaced although its status is not intercepted.
+func (cc *codeCatcher) holdsEmptyBody(code int) bool {
+	return cc.config.emptyBodies && cc.replacing && code >= http.StatusBadRequest &&
+		cc.config.observeLimit == 0 && !cc.config.dryRun
+}
+
+// releaseEmptyBody forward a held back response whose body is not empty after all.
+func (cc *codeCatcher) releaseEmptyBody() {
+	if !cc.holding {
+		return
+	}
+
+	cc.holding = false
+
+	if cc.forward() == httputil.ForwardResponse {
+		cc.core.SendHeaders()
+	}
+}
+
+// catchEmptyBody replace a response still held back once the upstream handler returned, its body being empty.
+func (cc *codeCatcher) catchEmptyBody() {
+	if !cc.holding {
+		return
+	}
+
+	cc.holding = false
+	cc.core.Catch()
+}
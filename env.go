@@ -0,0 +1,100 @@
+package pretty_error
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// envReference matches the ${NAME} references expanded from the environment.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replace the ${NAME} references of value by the environment variables they name, recording a problem
+// against field for every unset variable. References for which keep returns true are left as is.
+func expandEnv(field, value string, keep func(name string) bool, v *validator) string {
+	return envReference.ReplaceAllStringFunc(value, func(reference string) string {
+		name := envReference.FindStringSubmatch(reference)[1]
+		if keep != nil && keep(name) {
+			return reference
+		}
+
+		expanded, ok := os.LookupEnv(name)
+		if !ok {
+			v.check(field, fmt.Errorf("environment variable %s is not set", name))
+
+			return reference
+		}
+
+		return expanded
+	})
+}
+
+// expandConfig get a copy of config in which the template paths, messages and URLs have their ${NAME} references
+// expanded from the environment, leaving config untouched.
+func expandConfig(config *Config, v *validator) *Config {
+	expanded := *config
+
+	expanded.TemplateDir = expandEnv("templateDir", config.TemplateDir, nil, v)
+	expanded.SentryDSN = expandEnv("sentryDsn", config.SentryDSN, nil, v)
+
+	if config.Rewrites != nil {
+		expanded.Rewrites = make([]Rewrite, len(config.Rewrites))
+
+		for index, rewriteConfig := range config.Rewrites {
+			field := fmt.Sprintf("rewrites[%d]", index)
+			keep := replacementGroups(rewriteConfig.Regex)
+			rewriteConfig.Replacement = expandEnv(field+".replacement", rewriteConfig.Replacement, keep, v)
+			rewriteConfig.ReplacementFile = expandEnv(field+".replacementFile", rewriteConfig.ReplacementFile, nil, v)
+			expanded.Rewrites[index] = rewriteConfig
+		}
+	}
+
+	if config.Actions != nil {
+		expanded.Actions = make([]Action, len(config.Actions))
+
+		for index, action := range config.Actions {
+			action.Redirect = expandEnv(fmt.Sprintf("actions[%d].redirect", index), action.Redirect, nil, v)
+			expanded.Actions[index] = action
+		}
+	}
+
+	if config.Banner != nil {
+		banner := *config.Banner
+		banner.Snippet = expandEnv("banner.snippet", banner.Snippet, nil, v)
+		banner.SnippetFile = expandEnv("banner.snippetFile", banner.SnippetFile, nil, v)
+		expanded.Banner = &banner
+	}
+
+	if config.Service != nil {
+		service := *config.Service
+		service.URL = expandEnv("service.url", service.URL, nil, v)
+		expanded.Service = &service
+	}
+
+	if config.Webhook != nil {
+		webhook := *config.Webhook
+		webhook.URL = expandEnv("webhook.url", webhook.URL, nil, v)
+		expanded.Webhook = &webhook
+	}
+
+	if config.Assets != nil {
+		assets := *config.Assets
+		assets.Dir = expandEnv("assets.dir", assets.Dir, nil, v)
+		expanded.Assets = &assets
+	}
+
+	return &expanded
+}
+
+// replacementGroups report the names of the capture groups of regex, which replacements reference as ${name}.
+func replacementGroups(regex string) func(name string) bool {
+	compiled, err := regexp.Compile(regex)
+	if err != nil {
+		// the invalid regex is reported by newRewrites.
+		return nil
+	}
+
+	return func(name string) bool {
+		return compiled.SubexpIndex(name) >= 0
+	}
+}
@@ -0,0 +1,46 @@
+package pretty_error
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Flush policies, deciding what a flush of the upstream does while the fate of its response is not decided yet.
+const (
+	// flushImmediate commits the response as is on a flush, like a streaming 200, and flushes it right away.
+	flushImmediate = "immediate"
+	// flushDelayed keeps a flush pending until the status is decided, then flushes the response if it is forwarded.
+	flushDelayed = "delayed"
+	// flushDisabled ignores flushes, the response is sent once complete.
+	flushDisabled = "disabled"
+)
+
+// newFlushPolicy get the configured flush policy, immediate by default.
+func newFlushPolicy(config *Config, v *validator) string {
+	policy := strings.ToLower(config.FlushPolicy)
+	switch policy {
+	case "":
+		policy = flushImmediate
+	case flushImmediate, flushDelayed, flushDisabled:
+	default:
+		v.check("flushPolicy", fmt.Errorf("unknown policy %q", config.FlushPolicy))
+	}
+
+	return policy
+}
+
+// decided returns whether the status of the response was decided, its headers being sent, held back or caught.
+func (cc *codeCatcher) decided() bool {
+	return !cc.core.HeaderWriteTime().IsZero()
+}
+
+// flushPending apply a flush delayed until the status of the response was decided.
+func (cc *codeCatcher) flushPending() {
+	if !cc.pendingFlush || !cc.decided() {
+		return
+	}
+
+	cc.pendingFlush = false
+
+	cc.flush()
+}
@@ -0,0 +1,35 @@
+package pretty_error
+
+import (
+	"net/http"
+
+	"github.com/packruler/pretty-error/httputil"
+)
+
+// catchGRPCFailure determine if a failed gRPC-web response is caught, whatever its content type, because its
+// gRPC status maps to an intercepted HTTP status, which it returns. Observed and dry run responses are left to their
+// HTTP status.
+func (cc *codeCatcher) catchGRPCFailure() (int, bool) {
+	if !cc.config.grpcStatus || !cc.replacing || cc.config.observeLimit > 0 || cc.config.dryRun {
+		return 0, false
+	}
+
+	status, _, failed := httputil.GRPCStatus(cc.Header())
+	if !failed || !cc.config.codeMatcher.Match(status) {
+		return 0, false
+	}
+
+	return status, true
+}
+
+// statusDetail get the message replacing the standard one of the status on the page: the message of a failed gRPC-web
+// call when gRPC statuses are mapped.
+func (bodyRewrite *rewriteBody) statusDetail(header http.Header) string {
+	if !bodyRewrite.catcherConfig.grpcStatus {
+		return ""
+	}
+
+	_, message, _ := httputil.GRPCStatus(header)
+
+	return message
+}
@@ -0,0 +1,86 @@
+package pretty_error
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/packruler/pretty-error/httputil"
+)
+
+// defaultPreserveHeaders headers copied from the caught response when Config.PreserveHeaders is not set.
+// Authentication challenges must survive so browser and basic-auth prompts keep working,
+// CORS headers must survive so XHRs see the real error instead of a CORS failure, and Retry-After, Deprecation and
+// Sunset tell clients when to come back or to move away.
+var defaultPreserveHeaders = []string{
+	"WWW-Authenticate",
+	"Proxy-Authenticate",
+	"Access-Control-*",
+	"Retry-After",
+	"Deprecation",
+	"Sunset",
+}
+
+// preserveHeaders copy the allowlisted headers of the caught upstream response onto the generated one.
+func (bodyRewrite *rewriteBody) preserveHeaders(response http.ResponseWriter, caught http.Header) {
+	httputil.CopyMatchingHeaders(response.Header(), caught, bodyRewrite.preservedHeaders)
+}
+
+// setResponseHeaders set the configured extra headers on the generated response.
+func (bodyRewrite *rewriteBody) setResponseHeaders(header http.Header) {
+	for name, value := range bodyRewrite.responseHeaders {
+		header.Set(name, value)
+	}
+}
+
+// defaultMarkerValue value of the marker header when Config.MarkerHeader only names it.
+const defaultMarkerValue = "pretty-error"
+
+// markerHeader identifies generated pages to monitoring, CDNs and debugging tools.
+type markerHeader struct {
+	name  string
+	value string
+}
+
+// newMarkerHeader parse a "Name: value" or "Name" marker header configuration, nil when none is configured.
+func newMarkerHeader(config string, v *validator) *markerHeader {
+	if config == "" {
+		return nil
+	}
+
+	parts := strings.SplitN(config, ":", 2)
+	marker := &markerHeader{name: http.CanonicalHeaderKey(strings.TrimSpace(parts[0])), value: defaultMarkerValue}
+
+	if marker.name == "" || strings.ContainsAny(marker.name, " \t") {
+		v.check("markerHeader", errors.New("is not a valid header name"))
+
+		return nil
+	}
+
+	if len(parts) == 2 && strings.TrimSpace(parts[1]) != "" {
+		marker.value = strings.TrimSpace(parts[1])
+	}
+
+	return marker
+}
+
+// set add the marker header to a generated response.
+func (marker *markerHeader) set(header http.Header) {
+	if marker == nil {
+		return
+	}
+
+	header.Set(marker.name, marker.value)
+}
+
+// serverHeaders headers identifying the backend software, removed from error responses by Config.HideServerHeaders.
+var serverHeaders = []string{
+	"Server",
+	"X-Powered-By",
+	"X-AspNet-Version",
+	"X-AspNetMvc-Version",
+	"X-Runtime",
+	"X-Generator",
+	"X-Drupal-*",
+	"X-Backend-Server",
+}
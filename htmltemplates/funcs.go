@@ -0,0 +1,118 @@
+package htmltemplates
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"html/template"
+	"os"
+	"reflect"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// FuncMap get the functions available to custom templates, in the spirit of sprig without its dependencies:
+//
+//	upper, lower         change the case of a string: {{ .Message | upper }}
+//	default              a fallback for empty values: {{ .Labels.team | default "platform" }}
+//	now                  the current time
+//	formatTime           format a time with a Go layout: {{ now | formatTime "2006-01-02 15:04" }}
+//	env                  the value of an environment variable: {{ env "STATUS_PAGE_URL" }}
+//	trunc                keep the first characters of a string: {{ .Message | trunc 40 }}
+//	safeURL              trust a URL, such as one from env, in attributes: <a href="{{ env "HOME_URL" | safeURL }}">
+//
+// Templates parsed by the caller use them with template.New(name).Funcs(htmltemplates.FuncMap()).
+func FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"default":    defaultValue,
+		"now":        time.Now,
+		"formatTime": formatTime,
+		"env":        os.Getenv,
+		"trunc":      trunc,
+		"safeURL":    safeURL,
+	}
+}
+
+// TextFuncMap get the functions available to text/template templates: those of FuncMap but safeURL, plus json and
+// xml to escape values themselves, as nothing is escaped automatically:
+//
+//	json                 a value as a JSON literal: {"message": {{ .Message | json }}}
+//	xml                  a string escaped for XML text and attributes: <message>{{ .Message | xml }}</message>
+func TextFuncMap() texttemplate.FuncMap {
+	funcs := texttemplate.FuncMap{
+		"json": jsonValue,
+		"xml":  xmlText,
+	}
+
+	for name, function := range FuncMap() {
+		if name != "safeURL" {
+			funcs[name] = function
+		}
+	}
+
+	return funcs
+}
+
+// jsonValue encode value as a JSON literal, including the quotes of strings.
+func jsonValue(value interface{}) (string, error) {
+	encoded, err := json.Marshal(value)
+
+	return string(encoded), err
+}
+
+// xmlText escape value for XML text and attribute values.
+func xmlText(value string) (string, error) {
+	var escaped strings.Builder
+
+	err := xml.EscapeText(&escaped, []byte(value))
+
+	return escaped.String(), err
+}
+
+// defaultValue get value, or fallback when value is empty: nil, the zero value of its type or an empty collection.
+func defaultValue(fallback, value interface{}) interface{} {
+	if value == nil {
+		return fallback
+	}
+
+	reflected := reflect.ValueOf(value)
+
+	switch reflected.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		if reflected.Len() == 0 {
+			return fallback
+		}
+	default:
+		if reflected.IsZero() {
+			return fallback
+		}
+	}
+
+	return value
+}
+
+func formatTime(layout string, t time.Time) string {
+	return t.Format(layout)
+}
+
+// trunc keep the first length characters of value, all of them when length is negative.
+func trunc(length int, value string) string {
+	if length < 0 {
+		return value
+	}
+
+	runes := []rune(value)
+	if len(runes) <= length {
+		return value
+	}
+
+	return string(runes[:length])
+}
+
+// safeURL mark value as a trusted URL, left unescaped in attributes. It must only be given URLs from the
+// configuration, never from the request.
+func safeURL(value string) template.URL {
+	return template.URL(value)
+}
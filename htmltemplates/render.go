@@ -0,0 +1,180 @@
+package htmltemplates
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Option customizes a page built by Render.
+type Option func(*renderOptions)
+
+type renderOptions struct {
+	metadata Metadata
+	message  string
+	data     map[string]interface{}
+	template *template.Template
+	// textTemplate renders outputs which are not HTML, such as JSON, without HTML escaping.
+	textTemplate *texttemplate.Template
+}
+
+// WithMetadata render the page with metadata instead of DefaultMetadata.
+func WithMetadata(metadata Metadata) Option {
+	return func(opts *renderOptions) {
+		opts.metadata = metadata
+	}
+}
+
+// WithLocale render the page for language, such as "de".
+func WithLocale(language string) Option {
+	return func(opts *renderOptions) {
+		opts.metadata.Language = language
+	}
+}
+
+// WithMessage replace the standard message of the status, and the Detail of the metadata.
+func WithMessage(message string) Option {
+	return func(opts *renderOptions) {
+		opts.message = message
+	}
+}
+
+// WithData make data available to the template as {{ .Data }}, merged with the data of previous options.
+func WithData(data map[string]interface{}) Option {
+	return func(opts *renderOptions) {
+		if opts.data == nil {
+			opts.data = make(map[string]interface{}, len(data))
+		}
+
+		for key, value := range data {
+			opts.data[key] = value
+		}
+	}
+}
+
+// WithTemplate render the page from temp instead of the default template.
+func WithTemplate(temp *template.Template) Option {
+	return func(opts *renderOptions) {
+		opts.template = temp
+	}
+}
+
+// WithTextTemplate render the body from a text/template instead, for outputs which are not HTML and must not be HTML
+// escaped, such as JSON. Values are escaped with the json and xml functions of TextFuncMap instead.
+func WithTextTemplate(temp *texttemplate.Template) Option {
+	return func(opts *renderOptions) {
+		opts.textTemplate = temp
+	}
+}
+
+// Render build the error page of status, customized by opts. Status must be within 100-599.
+func Render(status int, opts ...Option) ([]byte, error) {
+	if err := ValidateStatus(status); err != nil {
+		return nil, err
+	}
+
+	options := renderOptions{metadata: DefaultMetadata()}
+
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	temp, err := options.executor()
+	if err != nil {
+		return nil, err
+	}
+
+	message := options.message
+	if message == "" {
+		message = metadataMessage(status, options.metadata)
+	}
+
+	params := statusMap{
+		Status:   status,
+		Message:  message,
+		Data:     options.data,
+		Metadata: options.metadata,
+	}
+
+	var buffer bytes.Buffer
+
+	err = temp.Execute(&buffer, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+//go:generate go run ../cmd/pretty-error-golden -out testdata/golden
+
+// RenderError is returned by RenderAll when some statuses could not be rendered.
+type RenderError struct {
+	// Errors holds the error of each status which could not be rendered.
+	Errors map[int]error
+}
+
+func (e *RenderError) Error() string {
+	statuses := make([]int, 0, len(e.Errors))
+	for status := range e.Errors {
+		statuses = append(statuses, status)
+	}
+
+	sort.Ints(statuses)
+
+	failures := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		failures = append(failures, fmt.Sprintf("%d: %v", status, e.Errors[status]))
+	}
+
+	return "unable to render " + strings.Join(failures, "; ")
+}
+
+// RenderAll render the page of each of statuses for locale, such as "de", customized by opts like Render, to compare
+// pages with golden files. Statuses which cannot be rendered are left out of the returned map and reported by a
+// *RenderError.
+func RenderAll(statuses []int, locale string, opts ...Option) (map[int][]byte, error) {
+	pages := make(map[int][]byte, len(statuses))
+	failures := make(map[int]error)
+
+	opts = append(opts[:len(opts):len(opts)], WithLocale(locale))
+
+	for _, status := range statuses {
+		body, err := Render(status, opts...)
+		if err != nil {
+			failures[status] = err
+
+			continue
+		}
+
+		pages[status] = body
+	}
+
+	if len(failures) > 0 {
+		return pages, &RenderError{Errors: failures}
+	}
+
+	return pages, nil
+}
+
+// executor executes the template of html/template or text/template rendering a body.
+type executor interface {
+	Execute(writer io.Writer, data interface{}) error
+}
+
+// executor get the template the options render with, the default one when none was given.
+func (opts renderOptions) executor() (executor, error) {
+	if opts.textTemplate != nil {
+		return opts.textTemplate, nil
+	}
+
+	if opts.template != nil {
+		return opts.template, nil
+	}
+
+	return template.New("error body").Parse(templateString)
+}
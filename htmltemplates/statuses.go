@@ -1,7 +1,7 @@
 package htmltemplates
 
-func getStatusMessage(status int16) string {
-	statusMap := map[int16]string{
+func getStatusMessage(status int) string {
+	statusMap := map[int]string{
 		400: "Bad Request",
 		401: "Unauthorized",
 		402: "Payment Required Experimental",
@@ -31,6 +31,7 @@ func getStatusMessage(status int16) string {
 		429: "Too Many Requests",
 		431: "Request Header Fields Too Large",
 		451: "Unavailable For Legal Reasons",
+		499: "Client Closed Request",
 		500: "Internal Server Error",
 		501: "Not Implemented",
 		502: "Bad Gateway",
@@ -1,11 +1,17 @@
 package htmltemplates_test
 
 import (
+	"errors"
 	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	texttemplate "text/template"
 
 	"github.com/packruler/pretty-error/htmltemplates"
+	"github.com/packruler/pretty-error/prettytest"
 )
 
 func TestEncode(t *testing.T) {
@@ -26,3 +32,249 @@ func TestEncode(t *testing.T) {
 		status++
 	}
 }
+
+func TestRenderEncode(t *testing.T) {
+	for status := 400; status < 404; status++ {
+		output, err := htmltemplates.Render(status)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		if !strings.Contains(string(output), fmt.Sprint(status)) {
+			t.Errorf("expected status: %d got: %s", status, output)
+		}
+	}
+}
+
+func TestGetErrorPageMetadata(t *testing.T) {
+	metadata := htmltemplates.Metadata{
+		OutputFormat: "html",
+		Language:     "de",
+		Theme:        "light",
+		Encoding:     "identity",
+	}
+
+	output, err := htmltemplates.GetErrorPage(404, metadata)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, expected := range []string{`lang="de"`, `class="theme-light"`, "format=html", "encoding=identity"} {
+		if !strings.Contains(string(output), expected) {
+			t.Errorf("expected %q in body: %s", expected, output)
+		}
+	}
+}
+
+func TestGetErrorEnvelope(t *testing.T) {
+	metadata := htmltemplates.DefaultMetadata()
+	metadata.OutputFormat = "json"
+
+	output, err := htmltemplates.GetErrorEnvelope(503, metadata)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := `{"error":{"status":503,"message":"Service Unavailable"},` +
+		`"meta":{"outputFormat":"json","language":"en","theme":"dark","encoding":"identity"}}`
+
+	if string(output) != expected {
+		t.Errorf("expected envelope: %s got: %s", expected, output)
+	}
+}
+
+func TestOfflinePage(t *testing.T) {
+	metadata := htmltemplates.DefaultMetadata()
+
+	online, err := htmltemplates.GetErrorPage(500, metadata)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !htmltemplates.HasExternalReferences(online) {
+		t.Error("expected default page to reference external resources")
+	}
+
+	metadata.Offline = true
+
+	offline, err := htmltemplates.GetErrorPage(500, metadata)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if htmltemplates.HasExternalReferences(offline) {
+		t.Errorf("expected offline page without external references: %s", offline)
+	}
+}
+
+func TestHasExternalReferences(t *testing.T) {
+	tests := map[string]bool{
+		`<script src="https://cdn.example.com/app.js"></script>`:          true,
+		`<img src=//cdn.example.com/logo.png>`:                            true,
+		`<img srcset="logo.png 1x, https://cdn.example.com/logo.png 2x">`: true,
+		`<style>@import url("https://fonts.example.com/css");</style>`:    true,
+		`<div style="background: url('//cdn.example.com/bg.png')">`:       true,
+		`<form action="https://example.com/report">`:                      true,
+		`<p>See https://status.example.com for updates.</p>`:              false,
+		`<a href="/status">status</a> <img src="logo.png">`:               false,
+		`<img src="data:image/png;base64,AAAA">`:                          false,
+	}
+
+	for body, expected := range tests {
+		if external := htmltemplates.HasExternalReferences([]byte(body)); external != expected {
+			t.Errorf("got %t for %s, want %t", external, body, expected)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	temp := template.Must(template.New("custom").Parse(
+		`{{ .Status }} {{ .Message }} {{ .Language }} {{ .Data.incident }} {{ .Data.team }}`))
+
+	output, err := htmltemplates.Render(503,
+		htmltemplates.WithTemplate(temp),
+		htmltemplates.WithLocale("fr"),
+		htmltemplates.WithMessage("Maintenance"),
+		htmltemplates.WithData(map[string]interface{}{"incident": "INC-42"}),
+		htmltemplates.WithData(map[string]interface{}{"team": "ops"}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "503 Maintenance fr INC-42 ops"; string(output) != expected {
+		t.Errorf("expected %q got %q", expected, output)
+	}
+
+	body, err := htmltemplates.GetErrorBody(404)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rendered, err := htmltemplates.Render(404)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if string(body) != string(rendered) {
+		t.Error("expected GetErrorBody to render like Render")
+	}
+}
+
+func TestInvalidStatus(t *testing.T) {
+	for _, status := range []int{0, 99, 600, 65536 + 404} {
+		if _, err := htmltemplates.Render(status); !errors.Is(err, htmltemplates.ErrInvalidStatus) {
+			t.Errorf("Render(%d): expected ErrInvalidStatus, got %v", status, err)
+		}
+
+		if _, err := htmltemplates.GetErrorEnvelope(status, htmltemplates.DefaultMetadata()); err == nil {
+			t.Errorf("GetErrorEnvelope(%d): expected an error", status)
+		}
+	}
+}
+
+func TestMinimalPage(t *testing.T) {
+	metadata := htmltemplates.DefaultMetadata()
+	metadata.Detail = "<script>alert(1)</script>"
+
+	page := string(htmltemplates.MinimalPage(503, metadata))
+	if !strings.Contains(page, "<h1>503 &lt;script&gt;alert(1)&lt;/script&gt;</h1>") || strings.Contains(page, "<script>") {
+		t.Errorf("unexpected page %q", page)
+	}
+
+	if page := string(htmltemplates.MinimalPage(799, htmltemplates.DefaultMetadata())); !strings.Contains(page, "<h1>799 Error</h1>") {
+		t.Errorf("expected invalid statuses to get a page, got %q", page)
+	}
+
+	envelope := string(htmltemplates.MinimalEnvelope(799, htmltemplates.DefaultMetadata()))
+	if !strings.HasPrefix(envelope, `{"error":{"status":799,"message":"Error"}`) {
+		t.Errorf("unexpected envelope %q", envelope)
+	}
+}
+
+func TestFuncMap(t *testing.T) {
+	previous, set := os.LookupEnv("PRETTY_ERROR_HOME")
+	if err := os.Setenv("PRETTY_ERROR_HOME", "https://status.example.com/?q=a b"); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if set {
+			_ = os.Setenv("PRETTY_ERROR_HOME", previous)
+		} else {
+			_ = os.Unsetenv("PRETTY_ERROR_HOME")
+		}
+	}()
+
+	tests := map[string]string{
+		`{{ .Message | upper }} {{ .Message | lower }}`:                        "SERVICE UNAVAILABLE service unavailable",
+		`{{ .Data.team | default "platform" }}`:                                "platform",
+		`{{ .Data.count | default 1 }} {{ .Data.name | default "x" }}`:         "1 ops",
+		`{{ .Message | trunc 7 }}|{{ "été" | trunc 2 }}|{{ "ok" | trunc -1 }}`: "Service|ét|ok",
+		`{{ now | formatTime "2006" | len }}`:                                  "4",
+		`<a href="{{ env "PRETTY_ERROR_HOME" }}">`:                             `<a href="https://status.example.com/?q=a%20b">`,
+		`<a href="{{ "tel:+15550100" }}">`:                                     `<a href="#ZgotmplZ">`,
+		`<a href="{{ "tel:+15550100" | safeURL }}">`:                           `<a href="tel:&#43;15550100">`,
+	}
+
+	for text, expected := range tests {
+		temp := template.Must(template.New("funcs").Funcs(htmltemplates.FuncMap()).Parse(text))
+
+		output, err := htmltemplates.Render(503, htmltemplates.WithTemplate(temp),
+			htmltemplates.WithData(map[string]interface{}{"count": 0, "name": "ops"}))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", text, err)
+
+			continue
+		}
+
+		if string(output) != expected {
+			t.Errorf("%s: got %q, want %q", text, output, expected)
+		}
+	}
+}
+
+func TestTextTemplate(t *testing.T) {
+	temp := texttemplate.Must(texttemplate.New("json").Funcs(htmltemplates.TextFuncMap()).Parse(
+		`{"message": {{ .Message | json }}, "raw": "{{ .Data.raw }}", "xml": "{{ .Data.raw | xml }}"}`))
+
+	output, err := htmltemplates.Render(503, htmltemplates.WithTextTemplate(temp),
+		htmltemplates.WithMessage(`"down" <now>`), htmltemplates.WithData(map[string]interface{}{"raw": "a&b"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := `{"message": "\"down\" \u003cnow\u003e", "raw": "a&b", "xml": "a&amp;b"}`; string(output) != expected {
+		t.Errorf("expected %q got %q", expected, output)
+	}
+
+	if _, ok := htmltemplates.TextFuncMap()["safeURL"]; ok {
+		t.Error("expected safeURL to be left out of text templates")
+	}
+}
+
+func TestRenderAll(t *testing.T) {
+	pages, err := htmltemplates.RenderAll([]int{404, 503, 799}, "de")
+
+	var renderErr *htmltemplates.RenderError
+	if !errors.As(err, &renderErr) || len(renderErr.Errors) != 1 || renderErr.Errors[799] == nil {
+		t.Errorf("expected the invalid status to be reported, got %v", err)
+	}
+
+	if len(pages) != 2 {
+		t.Fatalf("expected the invalid status to be left out, got %d pages", len(pages))
+	}
+
+	if !strings.Contains(string(pages[503]), `<html lang="de">`) {
+		t.Errorf("expected the page to be rendered for de, got %q", pages[503])
+	}
+}
+
+func TestGolden(t *testing.T) {
+	pages, err := htmltemplates.RenderAll([]int{400, 401, 403, 404, 429, 500, 502, 503, 504}, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prettytest.AssertGolden(t, filepath.Join("testdata", "golden", "en"), pages)
+}
@@ -2,41 +2,188 @@
 package htmltemplates
 
 import (
-	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
 	"html/template"
+	"regexp"
+	texttemplate "text/template"
 )
 
+// DefaultTheme the theme used when none is configured.
+const DefaultTheme = "dark"
+
+// Metadata describes how an error response was negotiated and produced.
+type Metadata struct {
+	OutputFormat string `json:"outputFormat"`
+	Language     string `json:"language"`
+	Theme        string `json:"theme"`
+	Encoding     string `json:"encoding"`
+
+	// Nonce allows the inline styles and scripts of the page under a Content-Security-Policy.
+	Nonce string `json:"-"`
+	// Offline excludes every reference to external resources from the page.
+	Offline bool `json:"-"`
+	// AssetsPath prefix of the static assets served along the pages, empty when there are none.
+	AssetsPath string `json:"-"`
+	// Middleware name of the middleware instance serving the page, such as the router it is attached to.
+	Middleware string `json:"-"`
+	// Labels configured on the middleware instance, such as {{ .Labels.service }}.
+	Labels map[string]string `json:"-"`
+	// Detail replaces the standard message of the status when set, such as with the message of a gRPC status.
+	Detail string `json:"-"`
+	// Request describes the request the page answers, such as {{ .Request.Path }}.
+	Request RequestInfo `json:"-"`
+	// UpstreamBody is the start of the body of the replaced response, when kept. It comes from the upstream and must be
+	// escaped, as html/template does.
+	UpstreamBody string `json:"-"`
+}
+
+// RequestInfo describes the request an error page answers. Its values come from the client: they are sanitized,
+// without control characters and of bounded length, but must still be escaped, as html/template does.
+type RequestInfo struct {
+	Method string
+	Host   string
+	// Path is percent-encoded, as sent by the client.
+	Path string
+	// Query is the re-encoded query string, without the leading "?".
+	Query string
+}
+
 type statusMap struct {
-	Status  int16
+	Status  int
 	Message string
+	Data    map[string]interface{}
+
+	Metadata
+}
+
+type envelope struct {
+	Error envelopeError `json:"error"`
+	Meta  Metadata      `json:"meta"`
+}
+
+type envelopeError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// DefaultMetadata get the Metadata used when nothing was negotiated.
+func DefaultMetadata() Metadata {
+	return Metadata{
+		OutputFormat: "html",
+		Language:     "en",
+		Theme:        DefaultTheme,
+		Encoding:     "identity",
+	}
+}
+
+// ErrInvalidStatus reports a status code outside of the 100-599 range.
+var ErrInvalidStatus = errors.New("invalid status code")
+
+// ValidateStatus check status is within the 100-599 range of HTTP status codes.
+func ValidateStatus(status int) error {
+	if status < 100 || status > 599 {
+		return fmt.Errorf("%w: %d", ErrInvalidStatus, status)
+	}
+
+	return nil
 }
 
 // GetErrorBody build error response HTML body.
+//
+// Deprecated: use Render, which takes the int status of net/http.
 func GetErrorBody(status int16) ([]byte, error) {
-	message := getStatusMessage(status)
+	return Render(int(status))
+}
 
-	params := statusMap{
-		Status:  status,
-		Message: message,
-	}
+// GetErrorPage build error response HTML body with access to the negotiated Metadata.
+func GetErrorPage(status int, metadata Metadata) ([]byte, error) {
+	return Render(status, WithMetadata(metadata))
+}
 
-	temp, err := template.New("error body").Parse(templateString)
-	if err != nil {
+// ExecuteTemplate build error response body from another template, with the same fields as the default one.
+func ExecuteTemplate(temp *template.Template, status int, metadata Metadata) ([]byte, error) {
+	return Render(status, WithMetadata(metadata), WithTemplate(temp))
+}
+
+// ExecuteTextTemplate build error response body, such as JSON, from a text/template with the same fields as the default
+// template.
+func ExecuteTextTemplate(temp *texttemplate.Template, status int, metadata Metadata) ([]byte, error) {
+	return Render(status, WithMetadata(metadata), WithTextTemplate(temp))
+}
+
+// externalReferencePattern matches attributes, srcset candidates and CSS urls and imports loading protocol-relative or
+// absolute resources, ignoring other text mentioning an URL.
+var externalReferencePattern = regexp.MustCompile(`(?i)(\b(src|href|action|formaction|poster|data)\s*=\s*["']?|` +
+	`\bsrcset\s*=\s*["']?([^"'>]*,\s*)?|url\(\s*["']?|@import\s+(url\(\s*)?["']?)([a-z][a-z0-9+.-]*:)?//`)
+
+// HasExternalReferences determine if the page body references resources of another origin in any way.
+func HasExternalReferences(body []byte) bool {
+	return externalReferencePattern.Match(body)
+}
+
+// GetErrorEnvelope build error response JSON body with the negotiated Metadata.
+func GetErrorEnvelope(status int, metadata Metadata) ([]byte, error) {
+	if err := ValidateStatus(status); err != nil {
 		return nil, err
 	}
 
-	var buffer bytes.Buffer
+	return json.Marshal(envelope{
+		Error: envelopeError{
+			Status:  status,
+			Message: metadataMessage(status, metadata),
+		},
+		Meta: metadata,
+	})
+}
 
-	err = temp.Execute(&buffer, params)
-	if err != nil {
-		return nil, err
+// minimalPageFormat bare page, without styles nor scripts, formatted with the status and its escaped message.
+const minimalPageFormat = `<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    <meta name="robots" content="noindex, nofollow">
+    <title>%[1]d %[2]s</title>
+  </head>
+  <body>
+    <h1>%[1]d %[2]s</h1>
+  </body>
+</html>
+`
+
+// MinimalPage build a bare HTML body for status without any template, so it cannot fail. It is the last resort when
+// rendering the error page fails.
+func MinimalPage(status int, metadata Metadata) []byte {
+	return []byte(fmt.Sprintf(minimalPageFormat, status, html.EscapeString(metadataMessage(status, metadata))))
+}
+
+// MinimalEnvelope build the JSON body for status without validating it, the JSON counterpart of MinimalPage.
+func MinimalEnvelope(status int, metadata Metadata) []byte {
+	// an envelope only holds strings and integers, encoding it cannot fail.
+	body, _ := json.Marshal(envelope{
+		Error: envelopeError{
+			Status:  status,
+			Message: metadataMessage(status, metadata),
+		},
+		Meta: metadata,
+	})
+
+	return body
+}
+
+// metadataMessage get the message of status, the detail of metadata when there is one.
+func metadataMessage(status int, metadata Metadata) string {
+	if metadata.Detail != "" {
+		return metadata.Detail
 	}
 
-	return buffer.Bytes(), nil
+	return getStatusMessage(status)
 }
 
 const templateString = `
-<html lang="en">
+<html lang="{{ .Language }}">
 
   <head>
     <meta charset="utf-8">
@@ -44,8 +191,10 @@ const templateString = `
       content="width=device-width, initial-scale=1">
     <meta name="robots"
       content="noindex, nofollow">
+    <meta name="generator"
+      content="pretty-error; format={{ .OutputFormat }}; language={{ .Language }}; theme={{ .Theme }}; encoding={{ .Encoding }}">
     <title>{{ .Message }}</title>
-    <style>
+    <style nonce="{{ .Nonce }}">
       html,
       body {
         background-color: #222526;
@@ -83,10 +232,15 @@ const templateString = `
         text-align: center;
         padding: 10px
       }
+
+      body.theme-light {
+        background-color: #f5f5f5;
+        color: #222526
+      }
     </style>
   </head>
 
-  <body>
+  <body class="theme-{{ .Theme }}">
     <div class="flex-center position-ref full-height">
       <div>
         <div class="flex-center">
@@ -99,7 +253,8 @@ const templateString = `
         </div>
       </div>
     </div>
-    <script>
+    {{- if not .Offline }}
+    <script nonce="{{ .Nonce }}">
       if (navigator.language.substring(0, 2).toLowerCase() !== 'en') {
         ((s, p) => { // localize the page (details here - https://github.com/tarampampam/error-pages/tree/master/l10n)
           s.src = 'https://cdn.jsdelivr.net/gh/tarampampam/error-pages@2/l10n/l10n.min.js'; // '../l10n/l10n.js';
@@ -109,6 +264,7 @@ const templateString = `
         })(document.createElement('script'), document.body);
       }
     </script>
+    {{- end }}
   </body>
 
 </html>
@@ -5,15 +5,55 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
-	"log"
+	"io"
 	"net"
 	"net/http"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/packruler/pretty-error/compressutil"
+	"github.com/packruler/pretty-error/logging"
 	"github.com/packruler/pretty-error/types"
 )
 
+// Decision is what a CodeCatcher does with a final response, once its status and headers are known.
+type Decision int
+
+const (
+	// ForwardResponse sends the headers to the client, the body following as it is written.
+	ForwardResponse Decision = iota
+	// CatchResponse holds the response back for the error handler, its body handled according to the
+	// FilteredBodyMode of the CodeCatcher.
+	CatchResponse
+	// DeferResponse neither sends nor catches the response: its body goes to the writer set with SetBodyWriter, and
+	// its headers are sent with SendHeaders, if ever.
+	DeferResponse
+)
+
+// Decider decides what a CodeCatcher does with the response of status code and header, returning the status of the
+// response, which it may change, such as to the HTTP status of a failed gRPC call.
+type Decider func(code int, header http.Header) (int, Decision)
+
+// FilteredBodyMode is what a CodeCatcher does with the body of a caught response.
+type FilteredBodyMode int
+
+const (
+	// PassthroughFilteredBody writes the body of caught responses to the client, as the CodeCatcher always did.
+	PassthroughFilteredBody FilteredBodyMode = iota
+	// DropFilteredBody discards the body of caught responses, which the error handler replaces.
+	DropFilteredBody
+	// BufferFilteredBody keeps the body of caught responses in the buffer, up to a limit, and discards the rest.
+	BufferFilteredBody
+)
+
+// DefaultXSRFCookies cookie name patterns of the XSRF cookies, whose responses are not rewritten, when none are
+// configured.
+var DefaultXSRFCookies = []string{"XSRF-TOKEN"}
+
+// DefaultFilteredBodyLimit the number of body bytes BufferFilteredBody keeps when no limit is given.
+const DefaultFilteredBodyLimit = 64 << 10
+
 // CodeCatcher a CodeCatcher used to simplify ResponseWriter data and manipulation.
 type CodeCatcher struct {
 	buffer             bytes.Buffer
@@ -21,27 +61,46 @@ type CodeCatcher struct {
 	wroteHeader        bool
 	headerMap          http.Header
 	code               int
-	httpCodeRanges     types.HTTPCodeRanges
+	codeMatcher        types.CodeMatcher
+	contentTypes       []string
+	xsrfCookies        []string
 	caughtFilteredCode bool
 	headersSent        bool
+	deferred           bool
+	tee                bool
+	bytesWritten       int64
+	createdAt          time.Time
+	headerWriteTime    time.Time
+	lastWriteTime      time.Time
+	decider            Decider
+	bodyWriter         io.Writer
+	filteredBody       FilteredBodyMode
+	filteredBodyLimit  int
+	teeLimit           int
 
 	http.ResponseWriter
 }
 
 // CodeCatcherWithCloseNotify an extending struct that includes CloseNotify support.
+//
+// Deprecated: NewCodeCatcher exposes CloseNotify, along with the other optional interfaces, exactly when the wrapped
+// writer implements it.
 type CodeCatcherWithCloseNotify struct {
 	CodeCatcher
 }
 
-// ResponseInterceptor interface for providing functionality to external packages.
+// ResponseInterceptor interface for providing functionality to external packages. The optional interfaces of
+// http.ResponseWriter, such as http.Flusher, are implemented when the wrapped writer implements them.
 type ResponseInterceptor interface {
 	http.ResponseWriter
-	http.Flusher
 	GetCode() int
 	IsFilteredCode() bool
 	GetContent() ([]byte, error)
 	GetBuffer() *bytes.Buffer
 	SetContent(data []byte)
+	BytesWritten() int64
+	HeaderWriteTime() time.Time
+	Duration() time.Duration
 }
 
 // CloseNotify returns a channel that receives at most a
@@ -54,45 +113,29 @@ func (codeCatcher *CodeCatcherWithCloseNotify) CloseNotify() <-chan bool {
 	return make(<-chan bool)
 }
 
-// NewCodeCatcher create a new instance of codeCatcher or codeCatcherWithCloseNotify based on provided content.
-func NewCodeCatcher(responseWriter http.ResponseWriter, httpCodeRanges types.HTTPCodeRanges) ResponseInterceptor {
-	catcher := CodeCatcher{
+//go:generate go run ../internal/wrappergen -package httputil -catcher CodeCatcher -writer ResponseWriter -interceptor ResponseInterceptor wrappers.go
+
+// NewCodeCatcher create a CodeCatcher implementing the same optional interfaces as responseWriter, among http.Flusher,
+// http.Hijacker, http.CloseNotifier, http.Pusher and io.ReaderFrom.
+// Any types.CodeMatcher, like types.HTTPCodeRanges, decides which status codes are caught.
+func NewCodeCatcher(responseWriter http.ResponseWriter, codeMatcher types.CodeMatcher) ResponseInterceptor {
+	return wrapCodeCatcher(NewBareCodeCatcher(responseWriter, codeMatcher))
+}
+
+// NewBareCodeCatcher create a CodeCatcher without any of the optional interfaces, for wrappers exposing the optional
+// interfaces of responseWriter on their own.
+func NewBareCodeCatcher(responseWriter http.ResponseWriter, codeMatcher types.CodeMatcher) *CodeCatcher {
+	return &CodeCatcher{
 		headerMap:      make(http.Header),
 		code:           http.StatusOK, // If backend does not call WriteHeader on us, we consider it's a 200.
 		ResponseWriter: responseWriter,
-		httpCodeRanges: httpCodeRanges,
-	}
-
-	if _, ok := responseWriter.(http.CloseNotifier); ok {
-		return &CodeCatcherWithCloseNotify{catcher}
+		codeMatcher:    codeMatcher,
+		contentTypes:   DefaultContentTypes,
+		xsrfCookies:    DefaultXSRFCookies,
+		createdAt:      time.Now(),
 	}
-
-	return &catcher
 }
 
-// // WriteHeader into wrapped ResponseWriter.
-// func (codeCatcher *codeCatcher) WriteHeader(statusCode int) {
-// 	if !codeCatcher.lastModified {
-// 		codeCatcher.ResponseWriter.Header().Del("Last-Modified")
-// 	}
-
-// 	codeCatcher.wroteHeader = true
-
-// 	// Delegates the Content-Length Header creation to the final body write.
-// 	codeCatcher.ResponseWriter.Header().Del("Content-Length")
-
-// 	codeCatcher.ResponseWriter.WriteHeader(statusCode)
-// }
-
-// // Write data to internal buffer and mark the status code as http.StatusOK.
-// func (codeCatcher *codeCatcher) Write(data []byte) (int, error) {
-// 	if !codeCatcher.wroteHeader {
-// 		codeCatcher.WriteHeader(http.StatusOK)
-// 	}
-
-// 	return codeCatcher.buffer.Write(data)
-// }
-
 // GetBuffer get a pointer to the ResponseWriter buffer.
 func (codeCatcher *CodeCatcher) GetBuffer() *bytes.Buffer {
 	return &codeCatcher.buffer
@@ -118,15 +161,23 @@ func (codeCatcher *CodeCatcher) SetContent(data []byte) {
 	}
 
 	if _, err := codeCatcher.ResponseWriter.Write(bodyBytes); err != nil {
-		log.Printf("unable to write rewriten body: %v", err)
+		logging.GetLogger().Warn("unable to write rewriten body", logging.F("error", err))
 		codeCatcher.LogHeaders()
 	}
 }
 
-// SupportsProcessing determine if http.Request is supported by this plugin.
+// DefaultMethods request methods supported by this plugin when none are configured.
+var DefaultMethods = []string{http.MethodGet, http.MethodHead}
+
+// SupportsProcessing determine if http.Request is supported by this plugin with the DefaultMethods.
 func SupportsProcessing(request *http.Request) bool {
-	// Ignore non GET requests
-	if request.Method != "GET" {
+	return SupportsProcessingMethods(request, DefaultMethods)
+}
+
+// SupportsProcessingMethods determine if http.Request is supported by this plugin with the given methods.
+func SupportsProcessingMethods(request *http.Request, methods []string) bool {
+	// Ignore requests with other methods
+	if !containsMethod(methods, request.Method) {
 		return false
 	}
 
@@ -135,16 +186,36 @@ func SupportsProcessing(request *http.Request) bool {
 		return false
 	}
 
+	// Server-Sent Events are streamed and break when intercepted
+	if strings.Contains(request.Header.Get("Accept"), "text/event-stream") {
+		return false
+	}
+
+	// Partial content must never be replaced or rewritten
+	if request.Header.Get("Range") != "" {
+		return false
+	}
+
 	return true
 }
 
+func containsMethod(methods []string, method string) bool {
+	for _, candidate := range methods {
+		if strings.EqualFold(candidate, method) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (codeCatcher *CodeCatcher) getHeader(headerName string) string {
 	return codeCatcher.ResponseWriter.Header().Get(headerName)
 }
 
 // LogHeaders writes current response headers.
 func (codeCatcher *CodeCatcher) LogHeaders() {
-	log.Printf("Error Headers: %v", codeCatcher.ResponseWriter.Header())
+	logging.GetLogger().Warn("error headers", logging.F("headers", codeCatcher.ResponseWriter.Header()))
 }
 
 // getContentEncoding get the Content-Encoding header value.
@@ -157,41 +228,126 @@ func (codeCatcher *CodeCatcher) getContentType() string {
 	return codeCatcher.getHeader("Content-Type")
 }
 
-func (codeCatcher *CodeCatcher) getSetCookie() string {
-	return codeCatcher.getHeader("Set-Cookie")
+// setsXSRFCookie report whether the response sets a cookie whose name matches one of the XSRF cookie patterns.
+func (codeCatcher *CodeCatcher) setsXSRFCookie() bool {
+	for _, setCookie := range codeCatcher.ResponseWriter.Header().Values("Set-Cookie") {
+		name := strings.TrimSpace(strings.SplitN(setCookie, "=", 2)[0])
+
+		for _, pattern := range codeCatcher.xsrfCookies {
+			if matched, _ := path.Match(pattern, name); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// SkipReason explains why a response is not processed.
+type SkipReason int
+
+const (
+	// NotSkipped the response can be processed.
+	NotSkipped SkipReason = iota
+	// SkipContentType the content type of the response is not eligible, or is streamed.
+	SkipContentType
+	// SkipEncoding the response is compressed with an unsupported encoding.
+	SkipEncoding
+	// SkipXSRFCookie the response sets a cookie matching the XSRF cookie patterns, which must reach the client
+	// untouched.
+	SkipXSRFCookie
+)
+
+// String get a short description of the reason, for logs.
+func (reason SkipReason) String() string {
+	switch reason {
+	case NotSkipped:
+		return "not skipped"
+	case SkipContentType:
+		return "content type not eligible"
+	case SkipEncoding:
+		return "unsupported encoding"
+	case SkipXSRFCookie:
+		return "XSRF cookie set"
+	default:
+		return "unknown reason"
+	}
 }
 
 // SupportsWriting determine if response headers support updating content.
 func (codeCatcher *CodeCatcher) SupportsWriting() bool {
-	setCookie := codeCatcher.getSetCookie()
-
-	return !strings.Contains(setCookie, "XSRF-TOKEN")
+	return codeCatcher.writingSkipReason() == NotSkipped
 }
 
 // SupportsProcessing determine if HttpWrapper is supported by this plugin based on encoding.
 func (codeCatcher *CodeCatcher) SupportsProcessing() bool {
+	return codeCatcher.processingSkipReason() == NotSkipped
+}
+
+// SkipReason get why the response is not processed, combining SupportsProcessing and SupportsWriting, or NotSkipped.
+func (codeCatcher *CodeCatcher) SkipReason() SkipReason {
+	if reason := codeCatcher.processingSkipReason(); reason != NotSkipped {
+		return reason
+	}
+
+	return codeCatcher.writingSkipReason()
+}
+
+func (codeCatcher *CodeCatcher) writingSkipReason() SkipReason {
+	if codeCatcher.setsXSRFCookie() {
+		return SkipXSRFCookie
+	}
+
+	return NotSkipped
+}
+
+func (codeCatcher *CodeCatcher) processingSkipReason() SkipReason {
 	contentType := codeCatcher.getContentType()
 
 	// If content type does not match return values with false
-	if contentType != "" && !strings.Contains(contentType, "text") {
-		return false
+	if !MatchesContentType(contentType, codeCatcher.contentTypes) || IsStreamingContentType(contentType) {
+		return SkipContentType
 	}
 
-	encoding := codeCatcher.getContentEncoding()
-
 	// If content type is supported validate encoding as well
-	switch encoding {
-	case "gzip":
-		fallthrough
-	case "deflate":
-		fallthrough
-	case "identity":
-		fallthrough
-	case "":
-		return true
+	switch codeCatcher.getContentEncoding() {
+	case "gzip", "deflate", "identity", "":
+		return NotSkipped
 	default:
-		return false
+		return SkipEncoding
+	}
+}
+
+// SetXSRFCookies update the name patterns, as understood by path.Match, of the XSRF cookies whose responses are not
+// rewritten, such as "csrftoken" or "XSRF-*". An empty list disables the check.
+func (codeCatcher *CodeCatcher) SetXSRFCookies(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("cookie pattern %q: %w", pattern, err)
+		}
 	}
+
+	codeCatcher.xsrfCookies = patterns
+
+	return nil
+}
+
+// SetContentTypes update the media type prefixes eligible for processing from non-package-based users.
+func (codeCatcher *CodeCatcher) SetContentTypes(prefixes []string) {
+	codeCatcher.contentTypes = prefixes
+}
+
+// SetTee update whether the start of the body is also copied to the internal buffer while it is written to the
+// client, so it can be inspected once complete without being held back. The tee keeps DefaultFilteredBodyLimit bytes
+// unless SetTeeLimit gives another limit.
+func (codeCatcher *CodeCatcher) SetTee(value bool) {
+	codeCatcher.tee = value
+}
+
+// SetTeeLimit update the number of body bytes copied to the internal buffer by the tee, DefaultFilteredBodyLimit
+// when not positive.
+func (codeCatcher *CodeCatcher) SetTeeLimit(limit int) {
+	codeCatcher.teeLimit = limit
 }
 
 // SetLastModified update the local lastModified variable from non-package-based users.
@@ -199,6 +355,23 @@ func (codeCatcher *CodeCatcher) SetLastModified(value bool) {
 	codeCatcher.lastModified = value
 }
 
+// SetDecider replace the default decision, catching the statuses of the codeMatcher and forwarding the others.
+func (codeCatcher *CodeCatcher) SetDecider(decider Decider) {
+	codeCatcher.decider = decider
+}
+
+// SetBodyWriter set the writer receiving the body of deferred responses.
+func (codeCatcher *CodeCatcher) SetBodyWriter(writer io.Writer) {
+	codeCatcher.bodyWriter = writer
+}
+
+// SetFilteredBodyMode update what is done with the body of caught responses. The limit bounds the bytes kept by
+// BufferFilteredBody, DefaultFilteredBodyLimit when not positive. The body of the other responses is never buffered.
+func (codeCatcher *CodeCatcher) SetFilteredBodyMode(mode FilteredBodyMode, limit int) {
+	codeCatcher.filteredBody = mode
+	codeCatcher.filteredBodyLimit = limit
+}
+
 // // GetStatus get the response status code.
 // func (codeCatcher *codeCatcher) GetStatus() int16 {
 // 	return codeCatcher.status
@@ -215,6 +388,30 @@ func (codeCatcher *CodeCatcher) Header() http.Header {
 	return codeCatcher.headerMap
 }
 
+// BytesWritten get the number of body bytes written by the upstream handler.
+func (codeCatcher *CodeCatcher) BytesWritten() int64 {
+	return codeCatcher.bytesWritten
+}
+
+// HeaderWriteTime get when the upstream handler wrote the final response header, zero if it did not yet.
+func (codeCatcher *CodeCatcher) HeaderWriteTime() time.Time {
+	return codeCatcher.headerWriteTime
+}
+
+// Duration get the time the upstream handler took from the creation of the CodeCatcher to its last write.
+func (codeCatcher *CodeCatcher) Duration() time.Duration {
+	last := codeCatcher.lastWriteTime
+	if last.Before(codeCatcher.headerWriteTime) {
+		last = codeCatcher.headerWriteTime
+	}
+
+	if last.IsZero() {
+		return 0
+	}
+
+	return last.Sub(codeCatcher.createdAt)
+}
+
 // GetCode get status code contained in CodeCatcher.
 func (codeCatcher *CodeCatcher) GetCode() int {
 	return codeCatcher.code
@@ -226,44 +423,127 @@ func (codeCatcher *CodeCatcher) IsFilteredCode() bool {
 	return codeCatcher.caughtFilteredCode
 }
 
+// HeadersSent returns whether the headers were already sent to the client.
+func (codeCatcher *CodeCatcher) HeadersSent() bool {
+	return codeCatcher.headersSent
+}
+
+// Catch hold back a deferred response for the error handler, such as once its body turned out to be empty.
+func (codeCatcher *CodeCatcher) Catch() {
+	codeCatcher.caughtFilteredCode = true
+}
+
+// CatchCode hold back a deferred response for the error handler like Catch, replacing its status code with code.
+func (codeCatcher *CodeCatcher) CatchCode(code int) {
+	codeCatcher.code = code
+	codeCatcher.Catch()
+}
+
 func (codeCatcher *CodeCatcher) Write(buf []byte) (int, error) {
 	// If WriteHeader was already called from the caller, this is a NOOP.
 	// Otherwise, codeCatcher.code is actually a 200 here.
 	codeCatcher.WriteHeader(codeCatcher.code)
 
-	// if codeCatcher.caughtFilteredCode {
-	// 	// We don't care about the contents of the response,
-	// 	// since we want to serve the ones from the error page,
-	// 	// so we just drop them.
-	// 	return len(buf), nil
-	// }
+	codeCatcher.bytesWritten += int64(len(buf))
+	codeCatcher.lastWriteTime = time.Now()
+
+	if codeCatcher.caughtFilteredCode && codeCatcher.filteredBody != PassthroughFilteredBody {
+		// the body is replaced by the error page, only its start may be kept.
+		if codeCatcher.filteredBody == BufferFilteredBody {
+			codeCatcher.keep(buf, codeCatcher.filteredBodyLimit)
+		}
+
+		return len(buf), nil
+	}
+
+	if codeCatcher.code == http.StatusNotModified {
+		// A 304 response never has a body.
+		return len(buf), nil
+	}
+
+	if codeCatcher.deferred && codeCatcher.bodyWriter != nil {
+		return codeCatcher.bodyWriter.Write(buf)
+	}
+
+	if codeCatcher.tee {
+		codeCatcher.keep(buf, codeCatcher.teeLimit)
+	}
 
 	return codeCatcher.ResponseWriter.Write(buf)
 }
 
+// keep buffer the start of the body, up to limit bytes, DefaultFilteredBodyLimit when not positive.
+func (codeCatcher *CodeCatcher) keep(buf []byte, limit int) {
+	if limit <= 0 {
+		limit = DefaultFilteredBodyLimit
+	}
+
+	remaining := limit - codeCatcher.buffer.Len()
+	if remaining <= 0 {
+		return
+	}
+
+	if len(buf) > remaining {
+		buf = buf[:remaining]
+	}
+
+	codeCatcher.buffer.Write(buf)
+}
+
 // WriteHeader status code to CodeCatcher.
 func (codeCatcher *CodeCatcher) WriteHeader(code int) {
-	if codeCatcher.headersSent || codeCatcher.caughtFilteredCode {
+	if codeCatcher.headersSent || codeCatcher.caughtFilteredCode || codeCatcher.deferred {
 		return
 	}
 
+	// Interim responses are forwarded as is, the final response is still to come.
+	if IsInformational(code) {
+		WriteInformational(codeCatcher.ResponseWriter, codeCatcher.Header(), code)
+
+		return
+	}
+
+	codeCatcher.headerWriteTime = time.Now()
 	codeCatcher.code = code
-	for _, block := range codeCatcher.httpCodeRanges {
-		if codeCatcher.code >= block[0] && codeCatcher.code <= block[1] {
-			codeCatcher.caughtFilteredCode = true
-			// it will be up to the caller to send the headers,
-			// so it is out of our hands now.
-			return
-		}
+
+	decision := codeCatcher.decide
+	if codeCatcher.decider != nil {
+		decision = codeCatcher.decider
+	}
+
+	code, outcome := decision(code, codeCatcher.Header())
+	codeCatcher.code = code
+
+	switch outcome {
+	case CatchResponse:
+		// it will be up to the caller to send the headers,
+		// so it is out of our hands now.
+		codeCatcher.caughtFilteredCode = true
+	case DeferResponse:
+		codeCatcher.deferred = true
+	case ForwardResponse:
+		codeCatcher.SendHeaders()
 	}
+}
 
+// decide catch the statuses of the codeMatcher, forwarding the others.
+func (codeCatcher *CodeCatcher) decide(code int, _ http.Header) (int, Decision) {
+	if !IsPassthroughStatus(code) && codeCatcher.codeMatcher.Match(code) {
+		return code, CatchResponse
+	}
+
+	return code, ForwardResponse
+}
+
+// SendHeaders forward the headers and status code to the client.
+func (codeCatcher *CodeCatcher) SendHeaders() {
 	CopyHeaders(codeCatcher.ResponseWriter.Header(), codeCatcher.Header())
 	codeCatcher.ResponseWriter.WriteHeader(codeCatcher.code)
 	codeCatcher.headersSent = true
 }
 
-// Hijack hijacks the connection.
-func (codeCatcher *CodeCatcher) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+// hijack hijacks the connection.
+func (codeCatcher *CodeCatcher) hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if hj, ok := codeCatcher.ResponseWriter.(http.Hijacker); ok {
 		return hj.Hijack()
 	}
@@ -271,8 +551,57 @@ func (codeCatcher *CodeCatcher) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return nil, nil, fmt.Errorf("%T is not a http.Hijacker", codeCatcher.ResponseWriter)
 }
 
-// Flush sends any buffered data to the client.
-func (codeCatcher *CodeCatcher) Flush() {
+// closeNotify returns a channel that receives at most a
+// single value (true) when the client connection has gone away.
+func (codeCatcher *CodeCatcher) closeNotify() <-chan bool {
+	if w, ok := codeCatcher.ResponseWriter.(http.CloseNotifier); ok {
+		return w.CloseNotify()
+	}
+
+	return make(<-chan bool)
+}
+
+// Unwrap get the wrapped writer, for http.ResponseController to reach the methods the catcher does not implement.
+func (codeCatcher *CodeCatcher) Unwrap() http.ResponseWriter {
+	return codeCatcher.ResponseWriter
+}
+
+// readFrom copy the body with CopyFrom, for the wrappers implementing io.ReaderFrom.
+func (codeCatcher *CodeCatcher) readFrom(reader io.Reader) (int64, error) {
+	return codeCatcher.CopyFrom(reader)
+}
+
+// CopyFrom copy the body with the io.ReaderFrom of the wrapped writer, allowing the sendfile fast path, or with
+// Write when the body does not go straight to the client.
+func (codeCatcher *CodeCatcher) CopyFrom(reader io.Reader) (int64, error) {
+	// If WriteHeader was already called from the caller, this is a NOOP.
+	// Otherwise, codeCatcher.code is actually a 200 here.
+	codeCatcher.WriteHeader(codeCatcher.code)
+
+	readerFrom, ok := codeCatcher.ResponseWriter.(io.ReaderFrom)
+	if !ok || codeCatcher.code == http.StatusNotModified || codeCatcher.tee || codeCatcher.deferred ||
+		(codeCatcher.caughtFilteredCode && codeCatcher.filteredBody != PassthroughFilteredBody) {
+		return io.Copy(codeCatcher, reader)
+	}
+
+	written, err := readerFrom.ReadFrom(reader)
+	codeCatcher.bytesWritten += written
+	codeCatcher.lastWriteTime = time.Now()
+
+	return written, err
+}
+
+// push initiates an HTTP/2 server push when the wrapped writer supports it.
+func (codeCatcher *CodeCatcher) push(target string, opts *http.PushOptions) error {
+	if pusher, ok := codeCatcher.ResponseWriter.(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+
+	return http.ErrNotSupported
+}
+
+// flush sends any buffered data to the client.
+func (codeCatcher *CodeCatcher) flush() {
 	// If WriteHeader was already called from the caller, this is a NOOP.
 	// Otherwise, codeCatcher.code is actually a 200 here.
 	codeCatcher.WriteHeader(codeCatcher.code)
@@ -0,0 +1,55 @@
+package httputil
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// grpcHTTPStatuses HTTP statuses of the gRPC status codes, as mapped by gRPC-gateway.
+var grpcHTTPStatuses = map[int]int{
+	1:  499, // Canceled, the client closed the request.
+	2:  http.StatusInternalServerError,
+	3:  http.StatusBadRequest,
+	4:  http.StatusGatewayTimeout,
+	5:  http.StatusNotFound,
+	6:  http.StatusConflict,
+	7:  http.StatusForbidden,
+	8:  http.StatusTooManyRequests,
+	9:  http.StatusBadRequest,
+	10: http.StatusConflict,
+	11: http.StatusBadRequest,
+	12: http.StatusNotImplemented,
+	13: http.StatusInternalServerError,
+	14: http.StatusServiceUnavailable,
+	15: http.StatusInternalServerError,
+	16: http.StatusUnauthorized,
+}
+
+// GRPCStatus get the HTTP status and the message of a failed gRPC-web response whose status is sent in the headers,
+// as trailers-only responses do. It reports false for other responses, including successful gRPC-web ones and native
+// gRPC ones, whose clients expect a gRPC status and would fail on any page.
+func GRPCStatus(header http.Header) (int, string, bool) {
+	if !strings.HasPrefix(strings.ToLower(header.Get("Content-Type")), "application/grpc-web") {
+		return 0, "", false
+	}
+
+	code, err := strconv.Atoi(header.Get("Grpc-Status"))
+	if err != nil || code == 0 {
+		return 0, "", false
+	}
+
+	status, known := grpcHTTPStatuses[code]
+	if !known {
+		status = http.StatusInternalServerError
+	}
+
+	// grpc-message is percent-encoded, kept as sent when it is not valid.
+	message := header.Get("Grpc-Message")
+	if decoded, err := url.PathUnescape(message); err == nil {
+		message = decoded
+	}
+
+	return status, message, true
+}
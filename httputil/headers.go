@@ -1,6 +1,10 @@
 package httputil
 
-import "net/http"
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
 
 // CopyHeaders copies http headers from source to destination, it
 // does not override, but adds multiple headers.
@@ -9,3 +13,189 @@ func CopyHeaders(dst http.Header, src http.Header) {
 		dst[k] = append(dst[k], vv...)
 	}
 }
+
+// CopyMatchingHeaders copies http headers whose name matches one of patterns from source to destination,
+// replacing existing values. A pattern ending with "*" matches every header name starting with its prefix.
+func CopyMatchingHeaders(dst http.Header, src http.Header, patterns []string) {
+	for k, vv := range src {
+		if MatchesHeaderName(k, patterns) {
+			dst[k] = append([]string(nil), vv...)
+		}
+	}
+}
+
+// MatchesHeaderName determine if the header name matches one of patterns, ignoring case.
+func MatchesHeaderName(name string, patterns []string) bool {
+	name = strings.ToLower(name)
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(name, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+
+			continue
+		}
+
+		if name == pattern {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasTrailers determine if header announces trailers, with the Trailer header or an http.TrailerPrefix key.
+func HasTrailers(header http.Header) bool {
+	if header.Get("Trailer") != "" {
+		return true
+	}
+
+	for name := range header {
+		if strings.HasPrefix(name, http.TrailerPrefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CopyTrailers copies the trailers set in source after the body was written to destination: the headers declared by
+// its Trailer header and the keys prefixed by http.TrailerPrefix.
+func CopyTrailers(dst http.Header, src http.Header) {
+	for _, declared := range src.Values("Trailer") {
+		for _, name := range strings.Split(declared, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if values, exists := src[name]; exists {
+				dst[name] = append([]string(nil), values...)
+			}
+		}
+	}
+
+	for name, values := range src {
+		if strings.HasPrefix(name, http.TrailerPrefix) {
+			dst[name] = append([]string(nil), values...)
+		}
+	}
+}
+
+// SetBodyLength finalize the framing headers of a replaced body of length bytes, negative when unknown. The upstream
+// Content-Length and Transfer-Encoding never apply to it: the length is set when known, and left out when trailers
+// follow the body since they are only sent on chunked responses.
+func SetBodyLength(header http.Header, length int) {
+	header.Del("Transfer-Encoding")
+
+	if length < 0 || HasTrailers(header) {
+		header.Del("Content-Length")
+
+		return
+	}
+
+	header.Set("Content-Length", strconv.Itoa(length))
+}
+
+// AddVary add names to the Vary header, skipping the ones it already lists whatever their case.
+// Nothing is added once Vary is "*", which already covers every request header.
+func AddVary(header http.Header, names ...string) {
+	listed := make(map[string]bool)
+
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			listed[strings.ToLower(strings.TrimSpace(name))] = true
+		}
+	}
+
+	if listed["*"] {
+		return
+	}
+
+	for _, name := range names {
+		if !listed[strings.ToLower(name)] {
+			header.Add("Vary", name)
+			listed[strings.ToLower(name)] = true
+		}
+	}
+}
+
+// DeleteMatchingHeaders deletes every header whose name matches one of patterns.
+func DeleteMatchingHeaders(header http.Header, patterns []string) {
+	for k := range header {
+		if MatchesHeaderName(k, patterns) {
+			delete(header, k)
+		}
+	}
+}
+
+// streamingContentTypes media types of streaming protocols which must never be buffered or replaced.
+var streamingContentTypes = []string{
+	"text/event-stream",
+	"application/grpc",
+}
+
+// IsStreamingContentType determine if the Content-Type belongs to a streaming protocol,
+// like Server-Sent Events or gRPC (including application/grpc+proto and application/grpc-web).
+func IsStreamingContentType(contentType string) bool {
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+
+	for _, streaming := range streamingContentTypes {
+		if strings.HasPrefix(contentType, streaming) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsInformational determine if code is an interim 1xx response, which precedes the final response.
+// 101 Switching Protocols is excluded since it ends the HTTP exchange.
+func IsInformational(code int) bool {
+	return code >= 100 && code < 200 && code != http.StatusSwitchingProtocols
+}
+
+// WriteInformational send an interim 1xx response with header (for example the Link headers of 103 Early Hints)
+// without leaving header on the final response.
+func WriteInformational(responseWriter http.ResponseWriter, header http.Header, code int) {
+	dst := responseWriter.Header()
+	added := make([]string, 0, len(header))
+
+	for k, vv := range header {
+		if _, exists := dst[k]; !exists {
+			dst[k] = vv
+			added = append(added, k)
+		}
+	}
+
+	responseWriter.WriteHeader(code)
+
+	for _, k := range added {
+		delete(dst, k)
+	}
+}
+
+// IsPassthroughStatus determine if responses with code must always be forwarded untouched, whatever the configured
+// ranges: partial content must not be replaced and 304 Not Modified only revalidates the client's cached response.
+func IsPassthroughStatus(code int) bool {
+	return code == http.StatusPartialContent || code == http.StatusNotModified
+}
+
+// DefaultContentTypes media type prefixes of responses eligible for replacement when none are configured.
+var DefaultContentTypes = []string{"text/html", "text/plain"}
+
+// MatchesContentType determine if contentType starts with one of the media type prefixes, ignoring case.
+// Responses without Content-Type always match, since they usually have no body worth keeping.
+func MatchesContentType(contentType string, prefixes []string) bool {
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	if contentType == "" {
+		return true
+	}
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(contentType, strings.ToLower(prefix)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,697 @@
+package httputil_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/packruler/pretty-error/httputil"
+	"github.com/packruler/pretty-error/types"
+)
+
+func TestCopyMatchingHeaders(t *testing.T) {
+	src := http.Header{}
+	src.Set("WWW-Authenticate", `Basic realm="example"`)
+	src.Set("Access-Control-Allow-Origin", "*")
+	src.Set("Access-Control-Allow-Credentials", "true")
+	src.Set("X-Internal-Trace", "abc")
+
+	dst := http.Header{}
+	httputil.CopyMatchingHeaders(dst, src, []string{"www-authenticate", "Access-Control-Allow-*"})
+
+	for _, name := range []string{"WWW-Authenticate", "Access-Control-Allow-Origin", "Access-Control-Allow-Credentials"} {
+		if dst.Get(name) != src.Get(name) {
+			t.Errorf("expected header %s to be copied, got %q", name, dst.Get(name))
+		}
+	}
+
+	if _, exists := dst["X-Internal-Trace"]; exists {
+		t.Error("expected X-Internal-Trace not to be copied")
+	}
+}
+
+func TestSupportsProcessingMethods(t *testing.T) {
+	tests := []struct {
+		desc    string
+		method  string
+		methods []string
+		upgrade string
+		accept  string
+		ranges  string
+		exp     bool
+	}{
+		{desc: "should support GET by default", method: http.MethodGet, methods: httputil.DefaultMethods, exp: true},
+		{desc: "should support HEAD by default", method: http.MethodHead, methods: httputil.DefaultMethods, exp: true},
+		{desc: "should not support POST by default", method: http.MethodPost, methods: httputil.DefaultMethods},
+		{desc: "should support configured methods", method: http.MethodPost, methods: []string{"post"}, exp: true},
+		{desc: "should not support websockets", method: http.MethodGet, methods: httputil.DefaultMethods, upgrade: "websocket"},
+		{desc: "should not support event streams", method: http.MethodGet, methods: httputil.DefaultMethods, accept: "text/event-stream"},
+		{desc: "should not support range requests", method: http.MethodGet, methods: httputil.DefaultMethods, ranges: "bytes=0-99"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, "/", nil)
+			req.Header.Set("Upgrade", test.upgrade)
+			req.Header.Set("Accept", test.accept)
+			req.Header.Set("Range", test.ranges)
+
+			if supported := httputil.SupportsProcessingMethods(req, test.methods); supported != test.exp {
+				t.Errorf("got supported %v, want %v", supported, test.exp)
+			}
+		})
+	}
+}
+
+func TestIsStreamingContentType(t *testing.T) {
+	tests := map[string]bool{
+		"text/event-stream":                true,
+		"text/event-stream; charset=utf-8": true,
+		"application/grpc":                 true,
+		"application/grpc+proto":           true,
+		"application/grpc-web-text":        true,
+		"text/html; charset=utf-8":         false,
+		"application/json":                 false,
+		"":                                 false,
+	}
+
+	for contentType, expected := range tests {
+		if streaming := httputil.IsStreamingContentType(contentType); streaming != expected {
+			t.Errorf("got streaming %v for %q, want %v", streaming, contentType, expected)
+		}
+	}
+}
+
+func TestMatchesContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		prefixes    []string
+		exp         bool
+	}{
+		{contentType: "", prefixes: httputil.DefaultContentTypes, exp: true},
+		{contentType: "text/html; charset=utf-8", prefixes: httputil.DefaultContentTypes, exp: true},
+		{contentType: "Text/Plain", prefixes: httputil.DefaultContentTypes, exp: true},
+		{contentType: "application/json", prefixes: httputil.DefaultContentTypes},
+		{contentType: "application/json", prefixes: []string{"application/json"}, exp: true},
+		{contentType: "image/png", prefixes: []string{"application/json"}},
+	}
+
+	for _, test := range tests {
+		if matches := httputil.MatchesContentType(test.contentType, test.prefixes); matches != test.exp {
+			t.Errorf("got matches %v for %q in %v, want %v", matches, test.contentType, test.prefixes, test.exp)
+		}
+	}
+}
+
+func TestPreferredEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                            "identity",
+		"gzip":                        "gzip",
+		"deflate, GZIP;q=0.5":         "gzip",
+		"br, gzip;q=0":                "identity",
+		"*":                           "gzip",
+		"identity;q=1, gzip;q=0.8":    "gzip",
+		"compress, br;q=0.9, x-gzip":  "identity",
+		"gzip;q=0.2, *;q=0.5, br;q=1": "gzip",
+		"gzip;q=0, *":                 "identity",
+		"*;q=0":                       "identity",
+		"*;q=0, gzip;q=0.1":           "gzip",
+	}
+
+	for acceptEncoding, expected := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+
+		if encoding := httputil.PreferredEncoding(req, []string{"gzip"}); encoding != expected {
+			t.Errorf("got %q for %q, want %q", encoding, acceptEncoding, expected)
+		}
+	}
+
+	multiple := map[string]string{
+		"br;q=0, gzip;q=0, *": "identity",
+		"br;q=0, *":           "gzip",
+		"*, br;q=0.5":         "gzip",
+		"gzip;q=0.5, *":       "br",
+	}
+
+	for acceptEncoding, expected := range multiple {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+
+		if encoding := httputil.PreferredEncoding(req, []string{"br", "gzip"}); encoding != expected {
+			t.Errorf("got %q for %q, want %q", encoding, acceptEncoding, expected)
+		}
+	}
+}
+
+func TestAcceptsHTML(t *testing.T) {
+	tests := map[string]bool{
+		"":                                  false,
+		"*/*":                               false,
+		"application/json":                  false,
+		"text/html":                         true,
+		"text/html,application/xhtml+xml":   true,
+		"application/json, TEXT/HTML;q=0.1": true,
+		"text/html;q=0, */*":                false,
+		"text/html;level=1;q=0.5":           true,
+	}
+
+	for accept, expected := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", accept)
+
+		if accepts := httputil.AcceptsHTML(req); accepts != expected {
+			t.Errorf("got %t for %q, want %t", accepts, accept, expected)
+		}
+	}
+}
+
+func TestNegotiateContentType(t *testing.T) {
+	offered := []string{"text/html", "application/json", "application/xml"}
+
+	tests := map[string]string{
+		"":                                        "text/html",
+		"*/*":                                     "text/html",
+		"application/json":                        "application/json",
+		"application/json, text/plain, */*":       "application/json",
+		"application/json, text/html":             "text/html",
+		"application/json, text/html;q=0.5":       "application/json",
+		"text/*;q=0.3, application/*;q=0.7":       "application/json",
+		"application/*, application/json;q=0":     "application/xml",
+		"*/*;q=0.1, APPLICATION/XML":              "application/xml",
+		"text/html;level=1, text/html;q=0.2, */*": "application/json",
+		"image/png":                               "",
+		"text/html;q=0, application/json;q=0":     "",
+		"text/html;q=0, */*;q=0":                  "",
+	}
+
+	for accept, expected := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", accept)
+
+		if mediaType := httputil.NegotiateContentType(req, offered); mediaType != expected {
+			t.Errorf("got %q for %q, want %q", mediaType, accept, expected)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Add("Accept", "text/html;q=0.5")
+	req.Header.Add("Accept", "application/json")
+
+	if format := httputil.PreferredOutputFormat(req); format != httputil.OutputFormatJSON {
+		t.Errorf("got output format %q for several Accept headers, want json", format)
+	}
+}
+
+func TestSanitizeValue(t *testing.T) {
+	tests := []struct {
+		value    string
+		limit    int
+		expected string
+	}{
+		{value: "/orders/42", limit: 20, expected: "/orders/42"},
+		{value: "/a\r\nSet-Cookie: x\x00", limit: 40, expected: "/aSet-Cookie: x"},
+		{value: "/\u202eevil\xff", limit: 40, expected: "/evil"},
+		{value: "/éléphant", limit: 4, expected: "/élé…"},
+		{value: "/four", limit: 5, expected: "/four"},
+	}
+
+	for _, test := range tests {
+		if sanitized := httputil.SanitizeValue(test.value, test.limit); sanitized != test.expected {
+			t.Errorf("SanitizeValue(%q, %d): got %q, want %q", test.value, test.limit, sanitized, test.expected)
+		}
+	}
+
+	if host := httputil.SanitizeHost(`shop.example.com:8443"><script>`, 253); host != "shop.example.com:8443script" {
+		t.Errorf("got host %q", host)
+	}
+}
+
+func TestCodeCatcherFilteredBodyMode(t *testing.T) {
+	tests := []struct {
+		desc      string
+		mode      httputil.FilteredBodyMode
+		limit     int
+		expBody   string
+		expBuffer string
+	}{
+		{desc: "passthrough", mode: httputil.PassthroughFilteredBody, expBody: "upstream failure"},
+		{desc: "drop", mode: httputil.DropFilteredBody},
+		{desc: "buffer", mode: httputil.BufferFilteredBody, expBuffer: "upstream failure"},
+		{desc: "bounded buffer", mode: httputil.BufferFilteredBody, limit: 8, expBuffer: "upstream"},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		catcher := httputil.NewBareCodeCatcher(recorder, types.NewCodeSet(http.StatusBadGateway))
+		catcher.SetFilteredBodyMode(test.mode, test.limit)
+
+		catcher.WriteHeader(http.StatusBadGateway)
+		_, _ = catcher.Write([]byte("upstream "))
+		_, _ = catcher.Write([]byte("failure"))
+
+		if !catcher.IsFilteredCode() || catcher.HeadersSent() {
+			t.Errorf("%s: expected the response to be caught without sending its headers", test.desc)
+		}
+
+		if recorder.Body.String() != test.expBody || catcher.GetBuffer().String() != test.expBuffer {
+			t.Errorf("%s: got body %q and buffer %q, want %q and %q", test.desc, recorder.Body.String(),
+				catcher.GetBuffer().String(), test.expBody, test.expBuffer)
+		}
+
+		if catcher.BytesWritten() != 16 {
+			t.Errorf("%s: got %d bytes written, want 16", test.desc, catcher.BytesWritten())
+		}
+	}
+}
+
+func TestCodeCatcherDecider(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	catcher := httputil.NewBareCodeCatcher(recorder, types.NewCodeSet(http.StatusBadGateway))
+
+	var deferred bytes.Buffer
+
+	catcher.SetBodyWriter(&deferred)
+	catcher.SetDecider(func(code int, header http.Header) (int, httputil.Decision) {
+		if header.Get("X-Defer") != "" {
+			return code, httputil.DeferResponse
+		}
+
+		return http.StatusServiceUnavailable, httputil.CatchResponse
+	})
+
+	catcher.Header().Set("X-Defer", "true")
+	catcher.WriteHeader(http.StatusOK)
+	_, _ = catcher.Write([]byte("held"))
+
+	if catcher.HeadersSent() || catcher.IsFilteredCode() || deferred.String() != "held" || recorder.Body.Len() != 0 {
+		t.Errorf("expected the body to go to the body writer only, got %q and %q", deferred.String(), recorder.Body)
+	}
+
+	catcher.SendHeaders()
+
+	if recorder.Code != http.StatusOK || recorder.Header().Get("X-Defer") != "true" {
+		t.Errorf("expected the deferred headers to be sent, got %d %v", recorder.Code, recorder.Header())
+	}
+
+	catcher = httputil.NewBareCodeCatcher(httptest.NewRecorder(), types.NewCodeSet(http.StatusBadGateway))
+	catcher.SetDecider(func(code int, header http.Header) (int, httputil.Decision) {
+		return http.StatusServiceUnavailable, httputil.CatchResponse
+	})
+	catcher.WriteHeader(http.StatusOK)
+
+	if !catcher.IsFilteredCode() || catcher.GetCode() != http.StatusServiceUnavailable {
+		t.Errorf("expected the decided status to be caught, got %d", catcher.GetCode())
+	}
+
+	catcher = httputil.NewBareCodeCatcher(httptest.NewRecorder(), types.NewCodeSet(http.StatusBadGateway))
+	catcher.SetDecider(func(code int, header http.Header) (int, httputil.Decision) {
+		return code, httputil.DeferResponse
+	})
+	catcher.WriteHeader(http.StatusOK)
+	catcher.CatchCode(http.StatusInternalServerError)
+
+	if !catcher.IsFilteredCode() || catcher.GetCode() != http.StatusInternalServerError {
+		t.Errorf("expected the deferred response to be caught with 500, got %d", catcher.GetCode())
+	}
+}
+
+func TestCodeCatcherFilteredBodyLimit(t *testing.T) {
+	catcher := httputil.NewBareCodeCatcher(httptest.NewRecorder(), types.NewCodeSet(http.StatusBadGateway))
+	catcher.SetFilteredBodyMode(httputil.BufferFilteredBody, 0)
+
+	catcher.WriteHeader(http.StatusOK)
+	_, _ = catcher.Write(bytes.Repeat([]byte("a"), httputil.DefaultFilteredBodyLimit+1))
+
+	if catcher.GetBuffer().Len() != 0 {
+		t.Errorf("expected the body of a forwarded response not to be buffered, got %d bytes", catcher.GetBuffer().Len())
+	}
+
+	catcher = httputil.NewBareCodeCatcher(httptest.NewRecorder(), types.NewCodeSet(http.StatusBadGateway))
+	catcher.SetFilteredBodyMode(httputil.BufferFilteredBody, 0)
+
+	catcher.WriteHeader(http.StatusBadGateway)
+	_, _ = catcher.Write(bytes.Repeat([]byte("a"), httputil.DefaultFilteredBodyLimit+1))
+
+	if catcher.GetBuffer().Len() != httputil.DefaultFilteredBodyLimit {
+		t.Errorf("got %d bytes buffered, want %d", catcher.GetBuffer().Len(), httputil.DefaultFilteredBodyLimit)
+	}
+}
+
+func TestCodeCatcherSkipReason(t *testing.T) {
+	tests := []struct {
+		desc        string
+		contentType string
+		encoding    string
+		setCookie   string
+		exp         httputil.SkipReason
+	}{
+		{desc: "html", contentType: "text/html", encoding: "gzip", exp: httputil.NotSkipped},
+		{desc: "image", contentType: "image/png", exp: httputil.SkipContentType},
+		{desc: "event stream", contentType: "text/event-stream", exp: httputil.SkipContentType},
+		{desc: "brotli", contentType: "text/html", encoding: "br", exp: httputil.SkipEncoding},
+		{desc: "xsrf cookie", contentType: "text/html", setCookie: "XSRF-TOKEN=abc; Path=/", exp: httputil.SkipXSRFCookie},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.desc, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			recorder.Header().Set("Content-Type", test.contentType)
+			recorder.Header().Set("Content-Encoding", test.encoding)
+			recorder.Header().Set("Set-Cookie", test.setCookie)
+
+			catcher := httputil.NewBareCodeCatcher(recorder, types.NewCodeSet(http.StatusBadGateway))
+			catcher.SetContentTypes([]string{"text/"})
+
+			if reason := catcher.SkipReason(); reason != test.exp {
+				t.Errorf("got %q, want %q", reason, test.exp)
+			}
+
+			supported := catcher.SupportsProcessing() && catcher.SupportsWriting()
+			if supported != (test.exp == httputil.NotSkipped) {
+				t.Errorf("got supported %t for reason %q", supported, test.exp)
+			}
+		})
+	}
+}
+
+func TestCodeCatcherXSRFCookies(t *testing.T) {
+	tests := []struct {
+		desc     string
+		patterns []string
+		cookies  []string
+		exp      bool
+	}{
+		{desc: "default pattern", cookies: []string{"session=1", "XSRF-TOKEN=abc; Path=/"}, exp: false},
+		{desc: "other cookies", cookies: []string{"csrftoken=abc"}, exp: true},
+		{desc: "glob", patterns: []string{"csrftoken", "_csrf*"}, cookies: []string{"_csrf_token=abc"}, exp: false},
+		{desc: "replaced default", patterns: []string{"csrftoken"}, cookies: []string{"XSRF-TOKEN=abc"}, exp: true},
+		{desc: "disabled", patterns: []string{}, cookies: []string{"XSRF-TOKEN=abc"}, exp: true},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.desc, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			for _, cookie := range test.cookies {
+				recorder.Header().Add("Set-Cookie", cookie)
+			}
+
+			catcher := httputil.NewBareCodeCatcher(recorder, types.NewCodeSet(http.StatusBadGateway))
+			if test.patterns != nil {
+				if err := catcher.SetXSRFCookies(test.patterns); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if supported := catcher.SupportsWriting(); supported != test.exp {
+				t.Errorf("got supported %t, want %t", supported, test.exp)
+			}
+		})
+	}
+
+	catcher := httputil.NewBareCodeCatcher(httptest.NewRecorder(), types.NewCodeSet(http.StatusBadGateway))
+	if err := catcher.SetXSRFCookies([]string{"["}); err == nil {
+		t.Error("expected an error for a malformed pattern")
+	}
+}
+
+func TestNegotiateLanguage(t *testing.T) {
+	tests := []struct {
+		desc      string
+		header    string
+		supported []string
+		exp       string
+	}{
+		{desc: "highest quality", header: "fr;q=0.5, de-CH;q=0.9, en;q=0.1", exp: "de-CH"},
+		{desc: "order on ties", header: "es, it", exp: "es"},
+		{desc: "no header", exp: ""},
+		{desc: "wildcard only", header: "*", exp: ""},
+		{desc: "exact match", header: "de-CH, de;q=0.9", supported: []string{"de", "de-CH"}, exp: "de-CH"},
+		{desc: "case insensitive", header: "PT-br", supported: []string{"en", "pt-BR"}, exp: "pt-BR"},
+		{desc: "range prefix of supported", header: "de", supported: []string{"en", "de-AT"}, exp: "de-AT"},
+		{desc: "fall back to prefix", header: "de-CH-1996", supported: []string{"en", "de"}, exp: "de"},
+		{desc: "quality beats order", header: "ja;q=0.2, fr;q=0.8", supported: []string{"ja", "fr"}, exp: "fr"},
+		{desc: "refused language", header: "fr;q=0, *;q=0.5", supported: []string{"fr", "it"}, exp: "it"},
+		{desc: "malformed quality", header: "fr;q=high, it;q=0.5", supported: []string{"it", "fr"}, exp: "fr"},
+		{desc: "none acceptable", header: "ja", supported: []string{"en", "fr"}, exp: "en"},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.desc, func(t *testing.T) {
+			if language := httputil.NegotiateLanguage(test.header, test.supported); language != test.exp {
+				t.Errorf("got %q, want %q", language, test.exp)
+			}
+		})
+	}
+}
+
+func TestGRPCStatus(t *testing.T) {
+	tests := []struct {
+		header     http.Header
+		expStatus  int
+		expMessage string
+		expFailed  bool
+	}{
+		{
+			header:     http.Header{"Content-Type": {"application/grpc-web+proto"}, "Grpc-Status": {"14"}, "Grpc-Message": {"checkout%20is%20down"}},
+			expStatus:  http.StatusServiceUnavailable,
+			expMessage: "checkout is down",
+			expFailed:  true,
+		},
+		{
+			header:    http.Header{"Content-Type": {"application/grpc-web-text"}, "Grpc-Status": {"42"}},
+			expStatus: http.StatusInternalServerError,
+			expFailed: true,
+		},
+		{header: http.Header{"Content-Type": {"application/grpc-web"}, "Grpc-Status": {"0"}}},
+		{header: http.Header{"Content-Type": {"application/grpc"}, "Grpc-Status": {"14"}}},
+		{header: http.Header{"Content-Type": {"application/grpc+proto"}, "Grpc-Status": {"14"}}},
+		{header: http.Header{"Content-Type": {"application/grpc-web"}}},
+		{header: http.Header{"Content-Type": {"text/html"}, "Grpc-Status": {"14"}}},
+	}
+
+	for _, test := range tests {
+		status, message, failed := httputil.GRPCStatus(test.header)
+		if status != test.expStatus || message != test.expMessage || failed != test.expFailed {
+			t.Errorf("got %d %q %t for %v, want %d %q %t", status, message, failed, test.header,
+				test.expStatus, test.expMessage, test.expFailed)
+		}
+	}
+}
+
+type pushRecorder struct {
+	*httptest.ResponseRecorder
+	pushed []string
+}
+
+func (recorder *pushRecorder) Push(target string, _ *http.PushOptions) error {
+	recorder.pushed = append(recorder.pushed, target)
+
+	return nil
+}
+
+func TestCodeCatcherPush(t *testing.T) {
+	recorder := &pushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	catcher := httputil.NewCodeCatcher(recorder, types.NewCodeSet(http.StatusBadGateway))
+
+	pusher, ok := catcher.(http.Pusher)
+	if !ok {
+		t.Fatal("expected the catcher to be a http.Pusher")
+	}
+
+	if err := pusher.Push("/style.css", nil); err != nil || len(recorder.pushed) != 1 {
+		t.Errorf("expected push to be forwarded, got %v %v", err, recorder.pushed)
+	}
+
+	catcher = httputil.NewCodeCatcher(httptest.NewRecorder(), types.NewCodeSet(http.StatusBadGateway))
+	if _, ok := catcher.(http.Pusher); ok {
+		t.Error("expected the catcher not to be a http.Pusher when the wrapped writer is not")
+	}
+}
+
+type readFromRecorder struct {
+	*httptest.ResponseRecorder
+	readFrom int
+}
+
+func (recorder *readFromRecorder) ReadFrom(reader io.Reader) (int64, error) {
+	recorder.readFrom++
+
+	return io.Copy(recorder.ResponseRecorder, reader)
+}
+
+func TestCodeCatcherReadFrom(t *testing.T) {
+	recorder := &readFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	catcher := httputil.NewCodeCatcher(recorder, types.NewCodeSet(http.StatusBadGateway))
+
+	written, err := io.Copy(catcher, io.LimitReader(strings.NewReader("hello"), 5))
+	if err != nil || written != 5 {
+		t.Fatalf("got %d %v", written, err)
+	}
+
+	if recorder.readFrom != 1 || recorder.Body.String() != "hello" || recorder.Code != http.StatusOK {
+		t.Errorf("expected the body to be copied with ReadFrom, got %d calls %d %q",
+			recorder.readFrom, recorder.Code, recorder.Body.String())
+	}
+}
+
+type plainWriter struct {
+	header http.Header
+}
+
+func (writer *plainWriter) Header() http.Header {
+	return writer.header
+}
+
+func (writer *plainWriter) Write(buf []byte) (int, error) {
+	return len(buf), nil
+}
+
+func (writer *plainWriter) WriteHeader(int) {}
+
+func TestCodeCatcherCapabilities(t *testing.T) {
+	catcher := httputil.NewCodeCatcher(&plainWriter{header: make(http.Header)}, types.NewCodeSet(http.StatusBadGateway))
+
+	if _, ok := catcher.(http.Flusher); ok {
+		t.Error("expected the catcher not to be a http.Flusher")
+	}
+
+	if _, ok := catcher.(http.Hijacker); ok {
+		t.Error("expected the catcher not to be a http.Hijacker")
+	}
+
+	if _, ok := catcher.(io.ReaderFrom); ok {
+		t.Error("expected the catcher not to be an io.ReaderFrom")
+	}
+
+	catcher = httputil.NewCodeCatcher(httptest.NewRecorder(), types.NewCodeSet(http.StatusBadGateway))
+
+	if _, ok := catcher.(http.Flusher); !ok {
+		t.Error("expected the catcher to be a http.Flusher like httptest.ResponseRecorder")
+	}
+
+	if _, ok := catcher.(http.Hijacker); ok {
+		t.Error("expected the catcher not to be a http.Hijacker unlike httptest.ResponseRecorder")
+	}
+}
+
+func TestCodeCatcherUnwrap(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	catcher := httputil.NewCodeCatcher(recorder, types.NewCodeSet(http.StatusBadGateway))
+
+	unwrapper, ok := catcher.(interface{ Unwrap() http.ResponseWriter })
+	if !ok || unwrapper.Unwrap() != recorder {
+		t.Error("expected Unwrap to get the wrapped writer")
+	}
+}
+
+func TestCopyTrailers(t *testing.T) {
+	src := http.Header{}
+	src.Set("Trailer", "x-checksum, X-Missing")
+	src.Set("X-Checksum", "abc")
+	src.Set(http.TrailerPrefix+"X-Elapsed", "1ms")
+	src.Set("X-Other", "kept out")
+
+	if !httputil.HasTrailers(src) || httputil.HasTrailers(http.Header{"X-Other": {"value"}}) {
+		t.Error("expected trailers to be detected from Trailer and http.TrailerPrefix")
+	}
+
+	dst := http.Header{}
+	httputil.CopyTrailers(dst, src)
+
+	expected := http.Header{"X-Checksum": {"abc"}, http.TrailerPrefix + "X-Elapsed": {"1ms"}}
+	if len(dst) != len(expected) || dst.Get("X-Checksum") != "abc" || dst.Get(http.TrailerPrefix+"X-Elapsed") != "1ms" {
+		t.Errorf("expected %v, got %v", expected, dst)
+	}
+}
+
+func TestSetBodyLength(t *testing.T) {
+	header := http.Header{"Content-Length": {"3"}, "Transfer-Encoding": {"chunked"}}
+	httputil.SetBodyLength(header, 42)
+
+	if header.Get("Content-Length") != "42" || header.Get("Transfer-Encoding") != "" {
+		t.Errorf("expected only Content-Length 42, got %v", header)
+	}
+
+	httputil.SetBodyLength(header, -1)
+
+	if _, exists := header["Content-Length"]; exists {
+		t.Errorf("expected no Content-Length for an unknown length, got %v", header)
+	}
+
+	header = http.Header{"Trailer": {"X-Checksum"}}
+	httputil.SetBodyLength(header, 42)
+
+	if _, exists := header["Content-Length"]; exists {
+		t.Errorf("expected no Content-Length along trailers, got %v", header)
+	}
+}
+
+func TestAddVary(t *testing.T) {
+	header := http.Header{"Vary": {"accept, Cookie"}}
+	httputil.AddVary(header, "Accept", "Accept-Language", "Accept-Language")
+
+	expected := []string{"accept, Cookie", "Accept-Language"}
+	if values := header.Values("Vary"); strings.Join(values, "|") != strings.Join(expected, "|") {
+		t.Errorf("got Vary %q, want %q", values, expected)
+	}
+
+	header = http.Header{"Vary": {"*"}}
+	httputil.AddVary(header, "Accept")
+
+	if values := header.Values("Vary"); len(values) != 1 {
+		t.Errorf("expected Vary to stay *, got %q", values)
+	}
+}
+
+func TestCodeCatcherStatistics(t *testing.T) {
+	catcher := httputil.NewCodeCatcher(httptest.NewRecorder(), types.NewCodeSet(http.StatusBadGateway))
+
+	if catcher.BytesWritten() != 0 || !catcher.HeaderWriteTime().IsZero() || catcher.Duration() != 0 {
+		t.Error("expected empty statistics before the response is written")
+	}
+
+	time.Sleep(time.Millisecond)
+	catcher.WriteHeader(http.StatusOK)
+	_, _ = catcher.Write([]byte("hello"))
+	_, _ = catcher.Write([]byte(" world"))
+
+	if catcher.BytesWritten() != 11 {
+		t.Errorf("got %d bytes written, want 11", catcher.BytesWritten())
+	}
+
+	if catcher.HeaderWriteTime().IsZero() || catcher.Duration() < time.Millisecond {
+		t.Errorf("got header time %v and duration %v", catcher.HeaderWriteTime(), catcher.Duration())
+	}
+}
+
+func TestCodeCatcherTee(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	catcher := httputil.NewBareCodeCatcher(recorder, types.NewCodeSet(http.StatusBadGateway))
+	catcher.SetTee(true)
+
+	_, _ = catcher.Write([]byte("hello"))
+
+	if recorder.Body.String() != "hello" || catcher.GetBuffer().String() != "hello" {
+		t.Errorf("expected body in both the client and the buffer, got %q and %q",
+			recorder.Body.String(), catcher.GetBuffer().String())
+	}
+
+	catcher.SetTeeLimit(7)
+	_, _ = catcher.Write([]byte(" world"))
+
+	if recorder.Body.String() != "hello world" || catcher.GetBuffer().String() != "hello w" {
+		t.Errorf("expected the whole body sent and its start buffered, got %q and %q",
+			recorder.Body.String(), catcher.GetBuffer().String())
+	}
+}
@@ -0,0 +1,342 @@
+package httputil
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// OutputFormatHTML is the output format used for browsers and unknown clients.
+	OutputFormatHTML = "html"
+	// OutputFormatJSON is the output format used for clients asking for JSON.
+	OutputFormatJSON = "json"
+
+	defaultLanguage = "en"
+)
+
+// outputMediaTypes media types of the output formats, HTML first for clients accepting both as much.
+var outputMediaTypes = []string{"text/html", "application/json"}
+
+// PreferredOutputFormat determine which output format the request is asking for: JSON when negotiated from its Accept
+// header, HTML otherwise.
+func PreferredOutputFormat(request *http.Request) string {
+	if NegotiateContentType(request, outputMediaTypes) == "application/json" {
+		return OutputFormatJSON
+	}
+
+	return OutputFormatHTML
+}
+
+// NegotiateContentType get the media type of offered the request accepts with the highest quality, following the
+// q-values of its Accept header as RFC 7231 defines them: the quality of an offered type is the one of the most
+// specific media range matching it, text/html;level=1 over text/html over text/* over */*. Ties go to the type matched
+// by the most specific range, then to the type offered first. The first offered type is returned without Accept
+// header, "" when none is acceptable.
+func NegotiateContentType(request *http.Request, offered []string) string {
+	header := strings.Join(request.Header.Values("Accept"), ",")
+	if strings.TrimSpace(header) == "" {
+		if len(offered) == 0 {
+			return ""
+		}
+
+		return offered[0]
+	}
+
+	ranges := parseAccept(header)
+	preferred, preferredQuality, preferredSpecificity := "", 0.0, -1
+
+	for _, mediaType := range offered {
+		quality, specificity := mediaTypeQuality(ranges, mediaType)
+		if quality > preferredQuality || (quality > 0 && quality == preferredQuality && specificity > preferredSpecificity) {
+			preferred, preferredQuality, preferredSpecificity = mediaType, quality, specificity
+		}
+	}
+
+	return preferred
+}
+
+// mediaTypeQuality get the quality of the most specific media range of ranges matching mediaType, and its
+// specificity: 0 for */*, 1 for type/*, 2 for type/subtype and 3 when it has parameters too, -1 when none matches.
+func mediaTypeQuality(ranges []acceptedValue, mediaType string) (float64, int) {
+	fields := strings.Split(mediaType, ";")
+	mainType, subtype := splitMediaType(fields[0])
+	params := normalizeParams(fields[1:])
+
+	quality, specificity := 0.0, -1
+
+	for _, mediaRange := range ranges {
+		rangeType, rangeSubtype := splitMediaType(mediaRange.value)
+		rangeParams := normalizeParams(mediaRange.params)
+
+		var rangeSpecificity int
+
+		switch {
+		case rangeType == "*" && rangeSubtype == "*":
+			rangeSpecificity = 0
+		case rangeType == mainType && rangeSubtype == "*":
+			rangeSpecificity = 1
+		case rangeType == mainType && rangeSubtype == subtype && len(rangeParams) > 0:
+			rangeSpecificity = 3
+		case rangeType == mainType && rangeSubtype == subtype:
+			rangeSpecificity = 2
+		default:
+			continue
+		}
+
+		if rangeSpecificity > specificity && containsAll(params, rangeParams) {
+			quality, specificity = mediaRange.quality, rangeSpecificity
+		}
+	}
+
+	return quality, specificity
+}
+
+// splitMediaType get the lower case type and subtype of a media type or range.
+func splitMediaType(mediaType string) (string, string) {
+	parts := strings.SplitN(strings.ToLower(strings.TrimSpace(mediaType)), "/", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+}
+
+// normalizeParams get media type parameters in lower case, without spaces around their name and value.
+func normalizeParams(params []string) []string {
+	normalized := make([]string, 0, len(params))
+
+	for _, param := range params {
+		parts := strings.SplitN(param, "=", 2)
+		if len(parts) == 2 {
+			param = strings.TrimSpace(parts[0]) + "=" + strings.Trim(strings.TrimSpace(parts[1]), `"`)
+		}
+
+		if param = strings.ToLower(strings.TrimSpace(param)); param != "" {
+			normalized = append(normalized, param)
+		}
+	}
+
+	return normalized
+}
+
+// containsAll report whether every one of wanted is in values.
+func containsAll(values, wanted []string) bool {
+	for _, value := range wanted {
+		found := false
+
+		for _, candidate := range values {
+			if candidate == value {
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AcceptsHTML determine if the request explicitly accepts HTML: its Accept header lists text/html with a non zero
+// quality. Wildcards such as */* do not count, they are sent by programmatic clients too.
+func AcceptsHTML(request *http.Request) bool {
+	for _, part := range strings.Split(request.Header.Get("Accept"), ",") {
+		fields := strings.Split(part, ";")
+		if strings.ToLower(strings.TrimSpace(fields[0])) != "text/html" {
+			continue
+		}
+
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+
+			if quality, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil && quality == 0 {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// PreferredLanguage get the primary language tag of the language with the highest quality in the Accept-Language
+// header, "en" when none.
+func PreferredLanguage(request *http.Request) string {
+	language := NegotiateLanguage(request.Header.Get("Accept-Language"), nil)
+	if language == "" {
+		return defaultLanguage
+	}
+
+	return strings.ToLower(strings.SplitN(language, "-", 2)[0])
+}
+
+// NegotiateLanguage get the language of supported best matching the Accept-Language header, following its q-values.
+// A language range matches the supported tags equal to it, ignoring case, then those it is a prefix of, such as "de"
+// for "de-CH", and falls back to its own prefixes, such as "de-CH" to "de". The first supported language is returned
+// when none is acceptable. With no supported language, any is: the range with the highest quality is returned, or ""
+// when there is none.
+func NegotiateLanguage(header string, supported []string) string {
+	ranges := parseAccept(header)
+
+	var excluded []string
+
+	for _, languageRange := range ranges {
+		if languageRange.quality <= 0 {
+			excluded = append(excluded, languageRange.value)
+		}
+	}
+
+	for _, languageRange := range ranges {
+		if languageRange.quality <= 0 {
+			continue
+		}
+
+		if len(supported) == 0 {
+			if languageRange.value != "*" {
+				return languageRange.value
+			}
+
+			continue
+		}
+
+		if language := matchLanguage(languageRange.value, supported, excluded); language != "" {
+			return language
+		}
+	}
+
+	if len(supported) == 0 {
+		return ""
+	}
+
+	return supported[0]
+}
+
+// matchLanguage get the first language of supported matched by languageRange and not excluded, or "".
+func matchLanguage(languageRange string, supported, excluded []string) string {
+	for prefix := languageRange; prefix != ""; prefix = truncateLanguage(prefix) {
+		for _, language := range supported {
+			if matchesLanguage(prefix, language, true) && !excludesLanguage(excluded, language) {
+				return language
+			}
+		}
+
+		for _, language := range supported {
+			if matchesLanguage(prefix, language, false) && !excludesLanguage(excluded, language) {
+				return language
+			}
+		}
+	}
+
+	return ""
+}
+
+// matchesLanguage report whether languageRange matches language, only when equal if exact, or as its prefix otherwise.
+// The * range matches every language.
+func matchesLanguage(languageRange, language string, exact bool) bool {
+	if languageRange == "*" || strings.EqualFold(languageRange, language) {
+		return true
+	}
+
+	return !exact && len(language) > len(languageRange) && language[len(languageRange)] == '-' &&
+		strings.EqualFold(language[:len(languageRange)], languageRange)
+}
+
+// excludesLanguage report whether one of the ranges refused with a zero quality matches language.
+func excludesLanguage(excluded []string, language string) bool {
+	for _, languageRange := range excluded {
+		if languageRange != "*" && matchesLanguage(languageRange, language, false) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// truncateLanguage remove the last subtag of a language tag, such as "de-CH" to "de", "" when it has a single one.
+func truncateLanguage(language string) string {
+	index := strings.LastIndex(language, "-")
+	if index < 0 {
+		return ""
+	}
+
+	return language[:index]
+}
+
+// PreferredEncoding get the content coding of supported with the highest quality in the Accept-Encoding header,
+// the one listed first on ties, or "identity" when none is accepted. A quality of 0 refuses a coding, and "*" only
+// stands for the codings which are not listed.
+func PreferredEncoding(request *http.Request, supported []string) string {
+	accepted := parseAccept(strings.ToLower(request.Header.Get("Accept-Encoding")))
+
+	listed := make(map[string]bool, len(accepted))
+	for _, coding := range accepted {
+		listed[coding.value] = true
+	}
+
+	for _, coding := range accepted {
+		if coding.quality == 0 {
+			break
+		}
+
+		for _, candidate := range supported {
+			if coding.value == candidate || (coding.value == "*" && !listed[candidate]) {
+				return candidate
+			}
+		}
+	}
+
+	return "identity"
+}
+
+// acceptedValue one element of an Accept like header, with its parameters other than the quality.
+type acceptedValue struct {
+	value   string
+	params  []string
+	quality float64
+}
+
+// parseAccept split an Accept like header into its elements, by decreasing quality, keeping the order of the header
+// on ties. A missing or malformed quality is 1.
+func parseAccept(header string) []acceptedValue {
+	var values []acceptedValue
+
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+
+		accepted := acceptedValue{value: strings.TrimSpace(fields[0]), quality: 1}
+		if accepted.value == "" {
+			continue
+		}
+
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+
+			if len(param) > 2 && strings.EqualFold(param[:2], "q=") {
+				if quality, err := strconv.ParseFloat(param[2:], 64); err == nil && quality >= 0 && quality <= 1 {
+					accepted.quality = quality
+				}
+
+				continue
+			}
+
+			accepted.params = append(accepted.params, param)
+		}
+
+		values = append(values, accepted)
+	}
+
+	sort.SliceStable(values, func(i, j int) bool {
+		return values[i].quality > values[j].quality
+	})
+
+	return values
+}
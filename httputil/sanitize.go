@@ -0,0 +1,58 @@
+package httputil
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ellipsis marks a value cut by SanitizeValue.
+const ellipsis = "…"
+
+// SanitizeValue make a value taken from a request safe to reflect in pages and logs: invalid UTF-8 and control
+// characters, such as line breaks used to forge log lines, are removed, and the value is cut to limit characters, an
+// ellipsis marking the cut. Escaping for the output, such as HTML, is still needed.
+func SanitizeValue(value string, limit int) string {
+	var builder strings.Builder
+
+	count := 0
+
+	for len(value) > 0 {
+		char, size := utf8.DecodeRuneInString(value)
+		value = value[size:]
+
+		if char == utf8.RuneError && size == 1 {
+			continue
+		}
+
+		if unicode.IsControl(char) || unicode.Is(unicode.Cf, char) {
+			continue
+		}
+
+		if count == limit {
+			builder.WriteString(ellipsis)
+
+			break
+		}
+
+		builder.WriteRune(char)
+		count++
+	}
+
+	return builder.String()
+}
+
+// SanitizeHost keep the characters of host names, IP addresses and ports from host, cut to limit characters.
+func SanitizeHost(host string, limit int) string {
+	return SanitizeValue(strings.Map(func(char rune) rune {
+		if char < utf8.RuneSelf && (isAlphanumeric(byte(char)) || strings.ContainsRune(".-_:[]", char)) {
+			return char
+		}
+
+		return -1
+	}, host), limit)
+}
+
+func isAlphanumeric(char byte) bool {
+	return 'a' <= char && char <= 'z' || 'A' <= char && char <= 'Z' || '0' <= char && char <= '9'
+}
@@ -0,0 +1,101 @@
+package pretty_error
+
+import (
+	"net/http"
+	"time"
+)
+
+// outcome is what the middleware does with an upstream response.
+type outcome int
+
+const (
+	// outcomePassthrough sends the upstream response as is.
+	outcomePassthrough outcome = iota
+	// outcomeReplace replaces the upstream response with the error page of its status.
+	outcomeReplace
+	// outcomeInterrupt replaces the response of a request whose context ended before the upstream answered.
+	outcomeInterrupt
+	// outcomeObserve passes the upstream response through, logging or dry running its replacement.
+	outcomeObserve
+	// outcomeShadow passes the upstream response through, dry running its rewrites.
+	outcomeShadow
+	// outcomeRewrite sends the upstream response with its body rewritten.
+	outcomeRewrite
+	// outcomeBroken replaces a response the upstream failed to complete before any of it was sent with the 502 page.
+	outcomeBroken
+	// outcomeTruncate leaves truncated a response the upstream failed to complete once part of it was sent.
+	outcomeTruncate
+)
+
+// interception holds the state of one intercepted request. The rewriteBody serving it is shared by concurrent
+// requests and never modified once created, everything specific to a request lives here instead.
+type interception struct {
+	response http.ResponseWriter
+	req      *http.Request
+	catcher  responseInterceptor
+	// startedAt is when the middleware started intercepting the request, upstreamDuration how long next took to write
+	// its response.
+	startedAt        time.Time
+	upstreamDuration time.Duration
+	// outcome is decided once next served the request, status is the status of the error page replacing the response.
+	outcome outcome
+	status  int
+	// aborted is set when the upstream aborted its response, such as on a broken backend connection.
+	aborted bool
+}
+
+// newInterception start intercepting the response to req written to response.
+func newInterception(response http.ResponseWriter, req *http.Request, config *catcherConfig) *interception {
+	return &interception{
+		response:  response,
+		req:       req,
+		catcher:   newCodeCatcher(response, req, config),
+		startedAt: time.Now(),
+	}
+}
+
+// decide what to do with the response caught once next served the request.
+func (interception *interception) decide(statuses contextStatuses) {
+	catcher := interception.catcher
+	interception.upstreamDuration = catcher.duration()
+
+	if status, interrupted := statuses.interruptedStatus(interception.req, catcher); interrupted {
+		interception.outcome, interception.status = outcomeInterrupt, status
+
+		return
+	}
+
+	// the body of caught responses is replaced anyway.
+	if interception.truncated() && !catcher.isFilteredCode() {
+		if catcher.headersWritten() {
+			interception.outcome = outcomeTruncate
+		} else {
+			interception.outcome, interception.status = outcomeBroken, http.StatusBadGateway
+		}
+
+		return
+	}
+
+	switch {
+	case catcher.isObserving():
+		interception.outcome = outcomeObserve
+	case catcher.isShadowing():
+		interception.outcome = outcomeShadow
+	case catcher.isBuffering():
+		interception.outcome = outcomeRewrite
+	case catcher.isFilteredCode():
+		interception.outcome, interception.status = outcomeReplace, catcher.getCode()
+	default:
+		interception.outcome = outcomePassthrough
+	}
+}
+
+// replaced report whether the upstream response is replaced with an error page.
+func (interception *interception) replaced() bool {
+	switch interception.outcome {
+	case outcomeReplace, outcomeInterrupt, outcomeBroken:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,28 @@
+package pretty_error
+
+import "net/http"
+
+// Interceptor applies the middleware around a next handler given with each request, as the handler chains of other
+// servers pass it, instead of one fixed at construction. It is the entry point of adapters such as the Caddy module
+// of the caddy directory.
+type Interceptor interface {
+	ServeHTTP(response http.ResponseWriter, req *http.Request, next http.Handler)
+}
+
+// NewInterceptor creates an Interceptor configured like the middleware returned by New.
+func NewInterceptor(config *Config, name string, opts ...Option) (Interceptor, error) {
+	bodyRewrite, err := newDetachedRewriteBody(config, name, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return interceptor{bodyRewrite: bodyRewrite}, nil
+}
+
+type interceptor struct {
+	bodyRewrite *rewriteBody
+}
+
+func (i interceptor) ServeHTTP(response http.ResponseWriter, req *http.Request, next http.Handler) {
+	i.bodyRewrite.serve(next, response, req)
+}
@@ -0,0 +1,29 @@
+// Package cliconfig loads the middleware configuration of the commands from a JSON file.
+package cliconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	prettyerror "github.com/packruler/pretty-error"
+)
+
+// Load read the JSON middleware configuration at path, the default configuration when path is empty.
+func Load(path string) (*prettyerror.Config, error) {
+	config := prettyerror.CreateConfig()
+	if path == "" {
+		return config, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("invalid configuration %s: %w", path, err)
+	}
+
+	return config, nil
+}
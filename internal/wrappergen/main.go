@@ -0,0 +1,178 @@
+// Command wrappergen generates the code catcher wrappers exposing the optional http.ResponseWriter interfaces
+// of the wrapped writer, one type per combination.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"strings"
+)
+
+// capability an optional interface of http.ResponseWriter, implemented by a method of codeCatcher.
+type capability struct {
+	name      string
+	assertion string
+	method    string
+	call      string
+}
+
+var capabilities = []capability{
+	{
+		name:      "Flusher",
+		assertion: "http.Flusher",
+		method:    "Flush()",
+		call:      "wrapper.flush()",
+	},
+	{
+		name:      "Hijacker",
+		assertion: "http.Hijacker",
+		method:    "Hijack() (net.Conn, *bufio.ReadWriter, error)",
+		call:      "return wrapper.hijack()",
+	},
+	{
+		name:      "CloseNotifier",
+		assertion: "http.CloseNotifier",
+		method:    "CloseNotify() <-chan bool",
+		call:      "return wrapper.closeNotify()",
+	},
+	{
+		name:      "Pusher",
+		assertion: "http.Pusher",
+		method:    "Push(target string, opts *http.PushOptions) error",
+		call:      "return wrapper.push(target, opts)",
+	},
+	{
+		name:      "ReaderFrom",
+		assertion: "io.ReaderFrom",
+		method:    "ReadFrom(reader io.Reader) (int64, error)",
+		call:      "return wrapper.readFrom(reader)",
+	},
+}
+
+// target describes the code catcher the wrappers are generated for.
+type target struct {
+	// pkg is the package of the code catcher.
+	pkg string
+	// catcher is the code catcher type, embedded as a pointer by the wrappers.
+	catcher string
+	// writer is the field of the code catcher holding the wrapped writer.
+	writer string
+	// interceptor is the interface returned by wrapCodeCatcher.
+	interceptor string
+}
+
+func main() {
+	var generated target
+
+	flag.StringVar(&generated.pkg, "package", "pretty_error", "package of the code catcher")
+	flag.StringVar(&generated.catcher, "catcher", "codeCatcher", "code catcher type")
+	flag.StringVar(&generated.writer, "writer", "responseWriter", "field of the wrapped writer")
+	flag.StringVar(&generated.interceptor, "interceptor", "responseInterceptor", "interface returned by the wrappers")
+	flag.Parse()
+
+	output := "wrappers.go"
+	if flag.NArg() > 0 {
+		output = flag.Arg(0)
+	}
+
+	source, err := format.Source(generated.generate())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.WriteFile(output, source, 0o600); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (generated target) generate() []byte {
+	var buffer bytes.Buffer
+
+	fmt.Fprintf(&buffer, `// Code generated by internal/wrappergen; DO NOT EDIT.
+
+package %s
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+`, generated.pkg)
+
+	buffer.WriteString("// Optional interfaces of the wrapped http.ResponseWriter.\nconst (\n")
+
+	for index, current := range capabilities {
+		if index == 0 {
+			fmt.Fprintf(&buffer, "\tsupports%s = 1 << iota\n", current.name)
+		} else {
+			fmt.Fprintf(&buffer, "\tsupports%s\n", current.name)
+		}
+	}
+
+	buffer.WriteString(")\n\n")
+
+	fmt.Fprintf(&buffer, `// wrapCodeCatcher get catcher wrapped in the type exposing exactly the optional interfaces of the wrapped writer.
+func wrapCodeCatcher(catcher *%s) %s {
+	var supported int
+
+`, generated.catcher, generated.interceptor)
+
+	for _, current := range capabilities {
+		fmt.Fprintf(&buffer, "\tif _, ok := catcher.%s.(%s); ok {\n\t\tsupported |= supports%s\n\t}\n\n",
+			generated.writer, current.assertion, current.name)
+	}
+
+	buffer.WriteString("\tswitch supported {\n")
+
+	for mask := 1; mask < 1<<len(capabilities); mask++ {
+		fmt.Fprintf(&buffer, "\tcase %s:\n\t\treturn %s{catcher}\n", maskExpression(mask), typeName(mask))
+	}
+
+	buffer.WriteString("\tdefault:\n\t\treturn catcher\n\t}\n}\n")
+
+	for mask := 1; mask < 1<<len(capabilities); mask++ {
+		name := typeName(mask)
+
+		fmt.Fprintf(&buffer, "\ntype %s struct {\n\t*%s\n}\n", name, generated.catcher)
+
+		for index, current := range capabilities {
+			if mask&(1<<index) == 0 {
+				continue
+			}
+
+			fmt.Fprintf(&buffer, "\nfunc (wrapper %s) %s {\n\t%s\n}\n", name, current.method, current.call)
+		}
+	}
+
+	return buffer.Bytes()
+}
+
+func maskExpression(mask int) string {
+	var names []string
+
+	for index, current := range capabilities {
+		if mask&(1<<index) != 0 {
+			names = append(names, "supports"+current.name)
+		}
+	}
+
+	return strings.Join(names, " | ")
+}
+
+func typeName(mask int) string {
+	name := "codeCatcher"
+
+	for index, current := range capabilities {
+		if mask&(1<<index) != 0 {
+			name += current.name
+		}
+	}
+
+	return name
+}
@@ -0,0 +1,155 @@
+package pretty_error
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonPathSegment is one step of a JSON path: an object key, an array index or a wildcard.
+type jsonPathSegment struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// jsonPath addresses values in a JSON document, such as $.error.message, $.errors[0].detail or $.errors[*].detail.
+type jsonPath []jsonPathSegment
+
+// parseJSONPath parse the subset of JSON path made of dotted keys, bracketed keys, indexes and wildcards.
+func parseJSONPath(path string) (jsonPath, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, errors.New(`must start with "$"`)
+	}
+
+	var segments jsonPath
+
+	rest := path[1:]
+
+	for rest != "" {
+		var (
+			segment jsonPathSegment
+			err     error
+		)
+
+		switch rest[0] {
+		case '.':
+			segment, rest, err = parseDottedSegment(rest[1:])
+		case '[':
+			segment, rest, err = parseBracketSegment(rest[1:])
+		default:
+			err = fmt.Errorf("unexpected %q", rest[0])
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		segments = append(segments, segment)
+	}
+
+	if len(segments) == 0 {
+		return nil, errors.New("must address a value below the root")
+	}
+
+	return segments, nil
+}
+
+func parseDottedSegment(rest string) (jsonPathSegment, string, error) {
+	end := strings.IndexAny(rest, ".[")
+	if end < 0 {
+		end = len(rest)
+	}
+
+	key := rest[:end]
+	if key == "" {
+		return jsonPathSegment{}, "", errors.New("empty key")
+	}
+
+	if key == "*" {
+		return jsonPathSegment{wildcard: true}, rest[end:], nil
+	}
+
+	return jsonPathSegment{key: key}, rest[end:], nil
+}
+
+func parseBracketSegment(rest string) (jsonPathSegment, string, error) {
+	end := strings.Index(rest, "]")
+	if end < 0 {
+		return jsonPathSegment{}, "", errors.New(`missing "]"`)
+	}
+
+	content := rest[:end]
+	rest = rest[end+1:]
+
+	switch {
+	case content == "*":
+		return jsonPathSegment{wildcard: true}, rest, nil
+	case len(content) >= 2 && (content[0] == '\'' || content[0] == '"') && content[len(content)-1] == content[0]:
+		return jsonPathSegment{key: content[1 : len(content)-1]}, rest, nil
+	}
+
+	index, err := strconv.Atoi(content)
+	if err != nil || index < 0 {
+		return jsonPathSegment{}, "", fmt.Errorf("invalid index %q", content)
+	}
+
+	return jsonPathSegment{index: index, isIndex: true}, rest, nil
+}
+
+// replace call replacer with every value of document addressed by the path, storing what it returns instead.
+func (path jsonPath) replace(
+	document interface{},
+	replacer func(value interface{}) (interface{}, error),
+) (interface{}, error) {
+	if len(path) == 0 {
+		return replacer(document)
+	}
+
+	segment, rest := path[0], path[1:]
+
+	switch node := document.(type) {
+	case map[string]interface{}:
+		if segment.isIndex {
+			return document, nil
+		}
+
+		// keys are visited in order so limited replacements always pick the same values.
+		keys := make([]string, 0, len(node))
+
+		for key := range node {
+			if segment.wildcard || key == segment.key {
+				keys = append(keys, key)
+			}
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			replaced, err := rest.replace(node[key], replacer)
+			if err != nil {
+				return nil, err
+			}
+
+			node[key] = replaced
+		}
+	case []interface{}:
+		for index, value := range node {
+			if !segment.wildcard && (!segment.isIndex || index != segment.index) {
+				continue
+			}
+
+			replaced, err := rest.replace(value, replacer)
+			if err != nil {
+				return nil, err
+			}
+
+			node[index] = replaced
+		}
+	}
+
+	return document, nil
+}
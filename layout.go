@@ -0,0 +1,86 @@
+package pretty_error
+
+import (
+	"html/template"
+	"io/fs"
+	"strings"
+)
+
+const (
+	// layoutTemplate names the base layout of the pages, holding the chrome shared by every status.
+	layoutTemplate = "layout.html"
+	// contentTemplate is the partial the layout includes with {{ template "content" . }}. Defined on its own, it is
+	// the content of every status without a more specific one.
+	contentTemplate = "content"
+	// contentPrefix starts the names of the per-status partials, such as "content-404" or "content-5xx".
+	contentPrefix = contentTemplate + "-"
+)
+
+// hasLayout determine if the root templates are composed around a base layout.
+func hasLayout(names []string) bool {
+	for _, name := range names {
+		if name == layoutTemplate {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseLayout parse the root templates names of fsys as one set around the layout, adding to templates a page for
+// each partial they define: "content-503", "content-5xx" and "content" become the 503.html, 5xx.html and error.html
+// pages, the layout including the partial of the page as "content".
+func parseLayout(
+	fsys fs.FS,
+	names []string,
+	field string,
+	delims delimiters,
+	templates map[string]*template.Template,
+	v *validator,
+) {
+	content, err := fs.ReadFile(fsys, layoutTemplate)
+	if !v.check(field+"."+layoutTemplate, err) {
+		return
+	}
+
+	layout, err := newPageTemplate(layoutTemplate, delims).Parse(string(content))
+	if !v.check(field+"."+layoutTemplate, err) {
+		return
+	}
+
+	for _, name := range names {
+		if name == layoutTemplate {
+			continue
+		}
+
+		content, err := fs.ReadFile(fsys, name)
+		if !v.check(field+"."+name, err) {
+			continue
+		}
+
+		_, err = layout.New(name).Parse(string(content))
+		v.check(field+"."+name, err)
+	}
+
+	for _, partial := range layout.Templates() {
+		var pageName string
+
+		switch {
+		case partial.Name() == contentTemplate:
+			pageName = "error.html"
+		case strings.HasPrefix(partial.Name(), contentPrefix):
+			pageName = strings.TrimPrefix(partial.Name(), contentPrefix) + ".html"
+		default:
+			continue
+		}
+
+		page, err := layout.Clone()
+		if err == nil {
+			_, err = page.AddParseTree(contentTemplate, partial.Tree)
+		}
+
+		if v.check(field+"."+partial.Name(), err) {
+			templates[pageName] = page
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Package logging gives the pretty error middleware a small structured Logger interface.
+// Messages go to the standard library logger by default; library users can install adapters for zerolog, zap,
+// slog or any other logger with SetLogger.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Field is a key value pair giving context to a log message.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F creates a Field.
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Logger writes structured messages at four levels.
+type Logger interface {
+	Debug(message string, fields ...Field)
+	Info(message string, fields ...Field)
+	Warn(message string, fields ...Field)
+	Error(message string, fields ...Field)
+}
+
+// StdLogger is a Logger writing "LEVEL message key=value..." lines to a standard library logger.
+type StdLogger struct {
+	logger *log.Logger
+}
+
+// NewStdLogger creates a StdLogger writing to logger, or to the standard logger when nil.
+func NewStdLogger(logger *log.Logger) *StdLogger {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return &StdLogger{logger: logger}
+}
+
+// Debug writes a DEBUG message.
+func (std *StdLogger) Debug(message string, fields ...Field) {
+	std.write("DEBUG", message, fields)
+}
+
+// Info writes an INFO message.
+func (std *StdLogger) Info(message string, fields ...Field) {
+	std.write("INFO", message, fields)
+}
+
+// Warn writes a WARN message.
+func (std *StdLogger) Warn(message string, fields ...Field) {
+	std.write("WARN", message, fields)
+}
+
+// Error writes an ERROR message.
+func (std *StdLogger) Error(message string, fields ...Field) {
+	std.write("ERROR", message, fields)
+}
+
+func (std *StdLogger) write(level, message string, fields []Field) {
+	var builder strings.Builder
+
+	builder.WriteString(level)
+	builder.WriteString(" ")
+	builder.WriteString(message)
+
+	for _, field := range fields {
+		value := fmt.Sprint(field.Value)
+		// quoting also escapes control characters, so values cannot forge log lines.
+		if value == "" || strings.ContainsAny(value, " \"=") || strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			value = fmt.Sprintf("%q", value)
+		}
+
+		fmt.Fprintf(&builder, " %s=%s", field.Key, value)
+	}
+
+	std.logger.Print(builder.String())
+}
+
+var (
+	mu      sync.RWMutex
+	current Logger = NewStdLogger(nil)
+)
+
+// SetLogger install the Logger used by the middleware. A nil logger restores the standard library logger.
+func SetLogger(logger Logger) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if logger == nil {
+		logger = NewStdLogger(nil)
+	}
+
+	current = logger
+}
+
+// GetLogger get the installed Logger.
+func GetLogger() Logger {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return current
+}
+
+// Level is the importance of a message, with the values of log/slog levels.
+type Level int
+
+// Levels of messages, from the least to the most important.
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+// ParseLevel parse a level name: debug, info, warn or error, ignoring case.
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, fmt.Errorf("unknown level %q", name)
+	}
+}
+
+// String get the level name.
+func (level Level) String() string {
+	switch {
+	case level >= LevelError:
+		return "ERROR"
+	case level >= LevelWarn:
+		return "WARN"
+	case level >= LevelInfo:
+		return "INFO"
+	default:
+		return "DEBUG"
+	}
+}
+
+// leveledLogger drops messages below its level and writes the others to the installed Logger.
+type leveledLogger struct {
+	level Level
+}
+
+// WithLevel get a Logger writing messages of at least level to the Logger installed when they are written.
+func WithLevel(level Level) Logger {
+	return leveledLogger{level: level}
+}
+
+// Enabled report whether logger writes messages of level, always the case of loggers not created by WithLevel.
+// It spares building fields which would be dropped.
+func Enabled(logger Logger, level Level) bool {
+	if leveled, ok := logger.(leveledLogger); ok {
+		return leveled.level <= level
+	}
+
+	return logger != nil
+}
+
+func (logger leveledLogger) Debug(message string, fields ...Field) {
+	if logger.level <= LevelDebug {
+		GetLogger().Debug(message, fields...)
+	}
+}
+
+func (logger leveledLogger) Info(message string, fields ...Field) {
+	if logger.level <= LevelInfo {
+		GetLogger().Info(message, fields...)
+	}
+}
+
+func (logger leveledLogger) Warn(message string, fields ...Field) {
+	if logger.level <= LevelWarn {
+		GetLogger().Warn(message, fields...)
+	}
+}
+
+func (logger leveledLogger) Error(message string, fields ...Field) {
+	if logger.level <= LevelError {
+		GetLogger().Error(message, fields...)
+	}
+}
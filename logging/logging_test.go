@@ -0,0 +1,122 @@
+package logging_test
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"testing"
+
+	"github.com/packruler/pretty-error/logging"
+)
+
+func TestStdLogger(t *testing.T) {
+	var buffer bytes.Buffer
+
+	logger := logging.NewStdLogger(log.New(&buffer, "", 0))
+	logger.Warn("unable to write", logging.F("error", errors.New("broken pipe")), logging.F("status", 502))
+
+	if expected := "WARN unable to write error=\"broken pipe\" status=502\n"; buffer.String() != expected {
+		t.Errorf("got %q, want %q", buffer.String(), expected)
+	}
+}
+
+func TestStdLoggerControlCharacters(t *testing.T) {
+	var buffer bytes.Buffer
+
+	logger := logging.NewStdLogger(log.New(&buffer, "", 0))
+	logger.Info("intercepting request", logging.F("path", "/a\nINFO\tforged"))
+
+	if expected := "INFO intercepting request path=\"/a\\nINFO\\tforged\"\n"; buffer.String() != expected {
+		t.Errorf("got %q, want %q", buffer.String(), expected)
+	}
+}
+
+type recordingLogger struct {
+	messages []string
+}
+
+func (logger *recordingLogger) Debug(message string, _ ...logging.Field) {
+	logger.messages = append(logger.messages, message)
+}
+
+func (logger *recordingLogger) Info(message string, _ ...logging.Field) {
+	logger.messages = append(logger.messages, message)
+}
+
+func (logger *recordingLogger) Warn(message string, _ ...logging.Field) {
+	logger.messages = append(logger.messages, message)
+}
+
+func (logger *recordingLogger) Error(message string, _ ...logging.Field) {
+	logger.messages = append(logger.messages, message)
+}
+
+func TestSetLogger(t *testing.T) {
+	defer logging.SetLogger(nil)
+
+	logger := &recordingLogger{}
+	logging.SetLogger(logger)
+	logging.GetLogger().Error("failed")
+
+	if len(logger.messages) != 1 || logger.messages[0] != "failed" {
+		t.Errorf("expected message to reach the installed logger, got %v", logger.messages)
+	}
+
+	logging.SetLogger(nil)
+
+	if _, ok := logging.GetLogger().(*logging.StdLogger); !ok {
+		t.Errorf("expected the standard logger to be restored, got %T", logging.GetLogger())
+	}
+}
+
+func TestWithLevel(t *testing.T) {
+	defer logging.SetLogger(nil)
+
+	recorder := &recordingLogger{}
+	logging.SetLogger(recorder)
+
+	logger := logging.WithLevel(logging.LevelWarn)
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Warn("warn")
+	logger.Error("error")
+
+	if len(recorder.messages) != 2 || recorder.messages[0] != "warn" || recorder.messages[1] != "error" {
+		t.Errorf("expected warn and error messages only, got %v", recorder.messages)
+	}
+
+	if logging.Enabled(logger, logging.LevelInfo) || !logging.Enabled(logger, logging.LevelWarn) {
+		t.Error("expected only the levels written by the logger to be enabled")
+	}
+
+	if !logging.Enabled(recorder, logging.LevelDebug) || logging.Enabled(nil, logging.LevelError) {
+		t.Error("expected every level of loggers without levels to be enabled")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name     string
+		expLevel logging.Level
+		expErr   bool
+	}{
+		{name: "debug", expLevel: logging.LevelDebug},
+		{name: " INFO ", expLevel: logging.LevelInfo},
+		{name: "warning", expLevel: logging.LevelWarn},
+		{name: "Error", expLevel: logging.LevelError},
+		{name: "loud", expLevel: logging.LevelInfo, expErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			level, err := logging.ParseLevel(test.name)
+			if (err != nil) != test.expErr {
+				t.Errorf("got error %v, want error %v", err, test.expErr)
+			}
+
+			if level != test.expLevel {
+				t.Errorf("got level %s, want %s", level, test.expLevel)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package pretty_error
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+)
+
+// fetchHeader marks the requests of the middleware for the pages of the error page service. They are forwarded
+// untouched when they reach an instance of the middleware, so a failing service routed through it cannot loop.
+const fetchHeader = "X-Pretty-Error-Fetch"
+
+// fetchToken is the value of the fetchHeader of the requests of this process. It is random, so clients cannot have
+// their requests forwarded untouched by setting the header themselves. It is empty, never matching, when no random
+// value could be generated.
+var fetchToken, _ = newNonce()
+
+// isFetch determine if req is a request of the middleware for a page of the error page service.
+func isFetch(req *http.Request) bool {
+	value := req.Header.Get(fetchHeader)
+
+	return fetchToken != "" && value != "" && subtle.ConstantTimeCompare([]byte(value), []byte(fetchToken)) == 1
+}
+
+// generatedKey is the context key of the generatedFlag shared by the instances a request goes through.
+type generatedKey struct{}
+
+// generatedFlag is set once an instance generated the response of the request, so the instances it is nested in
+// forward that response instead of replacing it again.
+type generatedFlag struct {
+	set bool
+}
+
+// withGeneratedFlag get req with a generatedFlag, the one of an enclosing instance when there is one.
+func withGeneratedFlag(req *http.Request) *http.Request {
+	if _, ok := req.Context().Value(generatedKey{}).(*generatedFlag); ok {
+		return req
+	}
+
+	return req.WithContext(context.WithValue(req.Context(), generatedKey{}, &generatedFlag{}))
+}
+
+// markGenerated record the response of req as generated by the middleware.
+func markGenerated(req *http.Request) {
+	if flag, ok := req.Context().Value(generatedKey{}).(*generatedFlag); ok {
+		flag.set = true
+	}
+}
+
+// isGenerated determine if a nested instance of the middleware generated the response of req.
+func isGenerated(req *http.Request) bool {
+	flag, ok := req.Context().Value(generatedKey{}).(*generatedFlag)
+
+	return ok && flag.set
+}
@@ -0,0 +1,207 @@
+package pretty_error
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// regexPrefix marks a path pattern as a regular expression instead of a glob.
+const regexPrefix = "regex:"
+
+// pathMatcher matches request paths against a glob or a regular expression.
+type pathMatcher struct {
+	glob  string
+	regex *regexp.Regexp
+}
+
+// newPathMatchers compile path patterns. Patterns prefixed by "regex:" are regular expressions, others are globs
+// as understood by path.Match, where a trailing "/**" also matches everything below the prefix.
+func newPathMatchers(field string, patterns []string, v *validator) []pathMatcher {
+	matchers := make([]pathMatcher, len(patterns))
+
+	for index, pattern := range patterns {
+		patternField := fmt.Sprintf("%s[%d]", field, index)
+
+		if strings.HasPrefix(pattern, regexPrefix) {
+			regex, err := regexp.Compile(strings.TrimPrefix(pattern, regexPrefix))
+			if v.check(patternField, err) {
+				matchers[index] = pathMatcher{regex: regex}
+			}
+
+			continue
+		}
+
+		_, err := path.Match(pattern, "")
+		if v.check(patternField, err) {
+			matchers[index] = pathMatcher{glob: pattern}
+		}
+	}
+
+	return matchers
+}
+
+func (matcher pathMatcher) match(requestPath string) bool {
+	if matcher.regex != nil {
+		return matcher.regex.MatchString(requestPath)
+	}
+
+	if prefix := strings.TrimSuffix(matcher.glob, "/**"); prefix != matcher.glob {
+		return requestPath == prefix || strings.HasPrefix(requestPath, prefix+"/")
+	}
+
+	matched, _ := path.Match(matcher.glob, requestPath)
+
+	return matched
+}
+
+func matchesAnyPath(matchers []pathMatcher, requestPath string) bool {
+	for _, matcher := range matchers {
+		if matcher.match(requestPath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HeaderMatcher holds one header condition. Without Value, the header only has to be present.
+// Value is compared exactly, or as a regular expression when prefixed by "regex:".
+type HeaderMatcher struct {
+	Name  string `json:"name,omitempty" toml:"name,omitempty" yaml:"name,omitempty" export:"true"`
+	Value string `json:"value,omitempty" toml:"value,omitempty" yaml:"value,omitempty" export:"true"`
+}
+
+type headerMatcher struct {
+	name  string
+	value string
+	regex *regexp.Regexp
+}
+
+func newHeaderMatchers(field string, configs []HeaderMatcher, v *validator) []headerMatcher {
+	matchers := make([]headerMatcher, len(configs))
+
+	for index, matcherConfig := range configs {
+		matcherField := fmt.Sprintf("%s[%d]", field, index)
+
+		if matcherConfig.Name == "" {
+			v.check(matcherField+".name", errors.New("is required"))
+		}
+
+		matcher := headerMatcher{
+			name:  http.CanonicalHeaderKey(matcherConfig.Name),
+			value: matcherConfig.Value,
+		}
+
+		if strings.HasPrefix(matcherConfig.Value, regexPrefix) {
+			regex, err := regexp.Compile(strings.TrimPrefix(matcherConfig.Value, regexPrefix))
+			if v.check(matcherField+".value", err) {
+				matcher.regex = regex
+			}
+		}
+
+		matchers[index] = matcher
+	}
+
+	return matchers
+}
+
+func (matcher headerMatcher) match(header http.Header) bool {
+	values, exists := header[matcher.name]
+	if !exists {
+		return false
+	}
+
+	if matcher.value == "" {
+		return true
+	}
+
+	for _, value := range values {
+		if matcher.matchValue(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (matcher headerMatcher) matchValue(value string) bool {
+	if matcher.regex != nil {
+		return matcher.regex.MatchString(value)
+	}
+
+	return value == matcher.value
+}
+
+func matchesAnyHeader(matchers []headerMatcher, header http.Header) bool {
+	for _, matcher := range matchers {
+		if matcher.match(header) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requestFilter decides from the request alone whether a response may be replaced.
+type requestFilter struct {
+	includePaths []pathMatcher
+	excludePaths []pathMatcher
+	skipHeaders  []headerMatcher
+	bypassCookie *http.Cookie
+}
+
+// allows determine if the response to req may be replaced by an error page.
+func (filter *requestFilter) allows(req *http.Request) bool {
+	if len(filter.includePaths) > 0 && !matchesAnyPath(filter.includePaths, req.URL.Path) {
+		return false
+	}
+
+	if matchesAnyHeader(filter.skipHeaders, req.Header) || filter.hasBypassCookie(req) {
+		return false
+	}
+
+	return !matchesAnyPath(filter.excludePaths, req.URL.Path)
+}
+
+// hasBypassCookie determine if the request asks for the raw upstream response with the bypass cookie.
+func (filter *requestFilter) hasBypassCookie(req *http.Request) bool {
+	if filter.bypassCookie == nil {
+		return false
+	}
+
+	cookie, err := req.Cookie(filter.bypassCookie.Name)
+	if err != nil {
+		return false
+	}
+
+	return filter.bypassCookie.Value == "" || cookie.Value == filter.bypassCookie.Value
+}
+
+// newBypassCookie parse a "name=value" or "name" bypass cookie configuration.
+func newBypassCookie(config string) *http.Cookie {
+	if config == "" {
+		return nil
+	}
+
+	parts := strings.SplitN(config, "=", 2)
+	cookie := &http.Cookie{Name: strings.TrimSpace(parts[0])}
+
+	if len(parts) == 2 {
+		cookie.Value = strings.TrimSpace(parts[1])
+	}
+
+	return cookie
+}
+
+func newRequestFilter(config *Config, v *validator) requestFilter {
+	return requestFilter{
+		includePaths: newPathMatchers("includePaths", config.IncludePaths, v),
+		excludePaths: newPathMatchers("excludePaths", config.ExcludePaths, v),
+		skipHeaders:  newHeaderMatchers("skipHeaders", config.SkipHeaders, v),
+		bypassCookie: newBypassCookie(config.BypassCookie),
+	}
+}
@@ -0,0 +1,353 @@
+// Package metrics keeps measurements of the pretty error middleware and exposes them
+// in the Prometheus text exposition format, without any dependency.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// contentType of the Prometheus text exposition format.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DurationBuckets upper bounds, in seconds, of the rewrite duration histogram.
+var DurationBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+
+// UpstreamDurationBuckets upper bounds, in seconds, of the upstream duration histogram.
+var UpstreamDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// SizeBuckets upper bounds, in bytes, of the buffer size histogram.
+var SizeBuckets = []float64{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20}
+
+// Recorder receives the measurements of one middleware instance.
+// Library users can implement it to forward measurements to another registry.
+type Recorder interface {
+	// PageServed counts an error page served with status.
+	PageServed(status int)
+	// Passthrough counts an upstream response with status forwarded to the client, rewritten or not.
+	Passthrough(status int)
+	// RewriteDuration observes the time spent rewriting one response.
+	RewriteDuration(duration time.Duration)
+	// BufferSize observes the size of one buffered response body.
+	BufferSize(size int)
+	// TemplateError counts an error page which failed to render.
+	TemplateError()
+	// UpstreamResponse observes the body size and duration of one intercepted upstream response.
+	UpstreamResponse(size int, duration time.Duration)
+	// PageSource counts an error page provided by source, such as "service", "templateDir", "templates" or "embedded".
+	PageSource(source string)
+	// Diagnostic counts a misuse of the response writer or a failure of the upstream handler, of kind such as
+	// "superfluousWriteHeader", "writeAfterCatch" or "truncatedResponse".
+	Diagnostic(kind string)
+}
+
+// Registry keeps the measurements of every middleware instance, labeled by instance name.
+type Registry struct {
+	mu              sync.Mutex
+	pagesServed     map[[2]string]uint64
+	passthroughs    map[[2]string]uint64
+	pageSources     map[[2]string]uint64
+	diagnostics     map[[2]string]uint64
+	templateErrors  map[string]uint64
+	rewriteDuration map[string]*histogram
+	bufferSize      map[string]*histogram
+	upstreamSize    map[string]*histogram
+	upstreamTime    map[string]*histogram
+}
+
+// DefaultRegistry is the registry the middleware records into.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		pagesServed:     make(map[[2]string]uint64),
+		passthroughs:    make(map[[2]string]uint64),
+		pageSources:     make(map[[2]string]uint64),
+		diagnostics:     make(map[[2]string]uint64),
+		templateErrors:  make(map[string]uint64),
+		rewriteDuration: make(map[string]*histogram),
+		bufferSize:      make(map[string]*histogram),
+		upstreamSize:    make(map[string]*histogram),
+		upstreamTime:    make(map[string]*histogram),
+	}
+}
+
+// Recorder get the Recorder of the middleware instance named middleware.
+func (registry *Registry) Recorder(middleware string) Recorder {
+	return &registryRecorder{registry: registry, middleware: middleware}
+}
+
+// ServeHTTP write the measurements in the Prometheus text exposition format.
+func (registry *Registry) ServeHTTP(response http.ResponseWriter, _ *http.Request) {
+	response.Header().Set("Content-Type", contentType)
+
+	_, _ = registry.WriteTo(response)
+}
+
+// Handler get a handler writing the measurements of the middleware instance named middleware only, in the
+// Prometheus text exposition format.
+func (registry *Registry) Handler(middleware string) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+		response.Header().Set("Content-Type", contentType)
+
+		_, _ = registry.write(response, middleware)
+	})
+}
+
+// WriteTo write the measurements in the Prometheus text exposition format.
+func (registry *Registry) WriteTo(writer io.Writer) (int64, error) {
+	return registry.write(writer, "")
+}
+
+// write write the measurements of the middleware instance named middleware, or of every instance when empty.
+func (registry *Registry) write(writer io.Writer, middleware string) (int64, error) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	var builder strings.Builder
+
+	writeLabeledCounters(&builder, "pretty_error_pages_served_total",
+		"Error pages served, by served status.", "status", registry.pagesServed, middleware)
+	writeLabeledCounters(&builder, "pretty_error_passthroughs_total",
+		"Upstream responses forwarded to the client, by status.", "status", registry.passthroughs, middleware)
+	writeLabeledCounters(&builder, "pretty_error_page_sources_total",
+		"Error pages served, by the source which provided them.", "source", registry.pageSources, middleware)
+	writeLabeledCounters(&builder, "pretty_error_diagnostics_total",
+		"Misuses of the response writer and failures of upstream handlers, by kind.", "kind", registry.diagnostics,
+		middleware)
+
+	builder.WriteString("# HELP pretty_error_template_errors_total Error pages which failed to render.\n")
+	builder.WriteString("# TYPE pretty_error_template_errors_total counter\n")
+
+	for _, name := range sortedKeys(registry.templateErrors, middleware) {
+		fmt.Fprintf(&builder, "pretty_error_template_errors_total{middleware=%s} %d\n",
+			quote(name), registry.templateErrors[name])
+	}
+
+	writeHistograms(&builder, "pretty_error_rewrite_duration_seconds",
+		"Time spent rewriting response bodies.", registry.rewriteDuration, middleware)
+	writeHistograms(&builder, "pretty_error_buffer_size_bytes",
+		"Size of buffered response bodies.", registry.bufferSize, middleware)
+	writeHistograms(&builder, "pretty_error_upstream_duration_seconds",
+		"Time upstream handlers took to write intercepted responses.", registry.upstreamTime, middleware)
+	writeHistograms(&builder, "pretty_error_upstream_size_bytes",
+		"Size of the bodies written by upstream handlers.", registry.upstreamSize, middleware)
+
+	written, err := io.WriteString(writer, builder.String())
+
+	return int64(written), err
+}
+
+// Snapshot holds the counters of one middleware instance since the registry was created.
+type Snapshot struct {
+	PagesServed    map[string]uint64 `json:"pagesServed"`
+	Passthroughs   map[string]uint64 `json:"passthroughs"`
+	PageSources    map[string]uint64 `json:"pageSources"`
+	Diagnostics    map[string]uint64 `json:"diagnostics"`
+	TemplateErrors uint64            `json:"templateErrors"`
+}
+
+// Snapshot get the current counters of the middleware instance named middleware.
+func (registry *Registry) Snapshot(middleware string) Snapshot {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	return Snapshot{
+		PagesServed:    labeledCounters(registry.pagesServed, middleware),
+		Passthroughs:   labeledCounters(registry.passthroughs, middleware),
+		PageSources:    labeledCounters(registry.pageSources, middleware),
+		Diagnostics:    labeledCounters(registry.diagnostics, middleware),
+		TemplateErrors: registry.templateErrors[middleware],
+	}
+}
+
+type registryRecorder struct {
+	registry   *Registry
+	middleware string
+}
+
+func (recorder *registryRecorder) PageServed(status int) {
+	recorder.registry.mu.Lock()
+	defer recorder.registry.mu.Unlock()
+
+	recorder.registry.pagesServed[[2]string{recorder.middleware, strconv.Itoa(status)}]++
+}
+
+func (recorder *registryRecorder) Passthrough(status int) {
+	recorder.registry.mu.Lock()
+	defer recorder.registry.mu.Unlock()
+
+	recorder.registry.passthroughs[[2]string{recorder.middleware, strconv.Itoa(status)}]++
+}
+
+func (recorder *registryRecorder) PageSource(source string) {
+	recorder.registry.mu.Lock()
+	defer recorder.registry.mu.Unlock()
+
+	recorder.registry.pageSources[[2]string{recorder.middleware, source}]++
+}
+
+func (recorder *registryRecorder) Diagnostic(kind string) {
+	recorder.registry.mu.Lock()
+	defer recorder.registry.mu.Unlock()
+
+	recorder.registry.diagnostics[[2]string{recorder.middleware, kind}]++
+}
+
+func (recorder *registryRecorder) TemplateError() {
+	recorder.registry.mu.Lock()
+	defer recorder.registry.mu.Unlock()
+
+	recorder.registry.templateErrors[recorder.middleware]++
+}
+
+func (recorder *registryRecorder) RewriteDuration(duration time.Duration) {
+	recorder.registry.mu.Lock()
+	defer recorder.registry.mu.Unlock()
+
+	observe(recorder.registry.rewriteDuration, recorder.middleware, DurationBuckets, duration.Seconds())
+}
+
+func (recorder *registryRecorder) BufferSize(size int) {
+	recorder.registry.mu.Lock()
+	defer recorder.registry.mu.Unlock()
+
+	observe(recorder.registry.bufferSize, recorder.middleware, SizeBuckets, float64(size))
+}
+
+func (recorder *registryRecorder) UpstreamResponse(size int, duration time.Duration) {
+	recorder.registry.mu.Lock()
+	defer recorder.registry.mu.Unlock()
+
+	observe(recorder.registry.upstreamSize, recorder.middleware, SizeBuckets, float64(size))
+	observe(recorder.registry.upstreamTime, recorder.middleware, UpstreamDurationBuckets, duration.Seconds())
+}
+
+// histogram counts observations in cumulative buckets, as Prometheus histograms.
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func observe(histograms map[string]*histogram, middleware string, buckets []float64, value float64) {
+	current, exists := histograms[middleware]
+	if !exists {
+		current = &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+		histograms[middleware] = current
+	}
+
+	for index, bound := range current.buckets {
+		if value <= bound {
+			current.counts[index]++
+		}
+	}
+
+	current.count++
+	current.sum += value
+}
+
+// selected report whether the series of name are written when writing the ones of middleware, every instance when
+// empty.
+func selected(name, middleware string) bool {
+	return middleware == "" || name == middleware
+}
+
+func writeLabeledCounters(
+	builder *strings.Builder,
+	name, help, label string,
+	counters map[[2]string]uint64,
+	middleware string,
+) {
+	fmt.Fprintf(builder, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+
+	keys := make([][2]string, 0, len(counters))
+	for key := range counters {
+		if selected(key[0], middleware) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+
+		return keys[i][1] < keys[j][1]
+	})
+
+	for _, key := range keys {
+		fmt.Fprintf(builder, "%s{middleware=%s,%s=%s} %d\n", name, quote(key[0]), label, quote(key[1]), counters[key])
+	}
+}
+
+// labeledCounters get the counters of middleware, by label value.
+func labeledCounters(counters map[[2]string]uint64, middleware string) map[string]uint64 {
+	values := make(map[string]uint64)
+
+	for key, count := range counters {
+		if key[0] == middleware {
+			values[key[1]] = count
+		}
+	}
+
+	return values
+}
+
+func writeHistograms(builder *strings.Builder, name, help string, histograms map[string]*histogram, selection string) {
+	fmt.Fprintf(builder, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+
+	for _, middleware := range sortedKeys(histograms, selection) {
+		current := histograms[middleware]
+		middleware = quote(middleware)
+
+		for index, bound := range current.buckets {
+			fmt.Fprintf(builder, "%s_bucket{middleware=%s,le=%s} %d\n",
+				name, middleware, quote(strconv.FormatFloat(bound, 'g', -1, 64)), current.counts[index])
+		}
+
+		fmt.Fprintf(builder, "%s_bucket{middleware=%s,le=\"+Inf\"} %d\n", name, middleware, current.count)
+		fmt.Fprintf(builder, "%s_sum{middleware=%s} %s\n", name, middleware, strconv.FormatFloat(current.sum, 'g', -1, 64))
+		fmt.Fprintf(builder, "%s_count{middleware=%s} %d\n", name, middleware, current.count)
+	}
+}
+
+// labelEscaper escapes label values as the text exposition format expects.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quote get value as a quoted label value.
+func quote(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+// sortedKeys get the sorted middleware names keying values, only the ones selected when writing middleware.
+func sortedKeys(values interface{}, middleware string) []string {
+	var keys []string
+
+	switch typed := values.(type) {
+	case map[string]uint64:
+		for key := range typed {
+			if selected(key, middleware) {
+				keys = append(keys, key)
+			}
+		}
+	case map[string]*histogram:
+		for key := range typed {
+			if selected(key, middleware) {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,165 @@
+package metrics_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/packruler/pretty-error/metrics"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := metrics.NewRegistry()
+
+	recorder := registry.Recorder(`errors"main`)
+	recorder.PageServed(502)
+	recorder.PageServed(502)
+	recorder.Passthrough(200)
+	recorder.TemplateError()
+	recorder.PageSource("templateDir")
+	recorder.Diagnostic("superfluousWriteHeader")
+	recorder.RewriteDuration(2 * time.Millisecond)
+	recorder.BufferSize(2048)
+	recorder.UpstreamResponse(512, 20*time.Millisecond)
+
+	response := httptest.NewRecorder()
+	registry.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if contentType := response.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("got Content-Type %q", contentType)
+	}
+
+	expected := []string{
+		"# TYPE pretty_error_pages_served_total counter",
+		`pretty_error_pages_served_total{middleware="errors\"main",status="502"} 2`,
+		`pretty_error_passthroughs_total{middleware="errors\"main",status="200"} 1`,
+		`pretty_error_page_sources_total{middleware="errors\"main",source="templateDir"} 1`,
+		`pretty_error_diagnostics_total{middleware="errors\"main",kind="superfluousWriteHeader"} 1`,
+		`pretty_error_template_errors_total{middleware="errors\"main"} 1`,
+		"# TYPE pretty_error_rewrite_duration_seconds histogram",
+		`pretty_error_rewrite_duration_seconds_bucket{middleware="errors\"main",le="0.001"} 0`,
+		`pretty_error_rewrite_duration_seconds_bucket{middleware="errors\"main",le="0.005"} 1`,
+		`pretty_error_rewrite_duration_seconds_count{middleware="errors\"main"} 1`,
+		`pretty_error_buffer_size_bytes_bucket{middleware="errors\"main",le="1024"} 0`,
+		`pretty_error_buffer_size_bytes_bucket{middleware="errors\"main",le="4096"} 1`,
+		`pretty_error_buffer_size_bytes_bucket{middleware="errors\"main",le="+Inf"} 1`,
+		`pretty_error_buffer_size_bytes_sum{middleware="errors\"main"} 2048`,
+		`pretty_error_upstream_duration_seconds_bucket{middleware="errors\"main",le="0.01"} 0`,
+		`pretty_error_upstream_duration_seconds_bucket{middleware="errors\"main",le="0.025"} 1`,
+		`pretty_error_upstream_size_bytes_bucket{middleware="errors\"main",le="1024"} 1`,
+	}
+
+	body := response.Body.String()
+	for _, line := range expected {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected line %s in:\n%s", line, body)
+		}
+	}
+}
+
+func TestRegistryHandler(t *testing.T) {
+	registry := metrics.NewRegistry()
+
+	for _, middleware := range []string{"errors", "other"} {
+		recorder := registry.Recorder(middleware)
+		recorder.PageServed(502)
+		recorder.TemplateError()
+		recorder.BufferSize(2048)
+	}
+
+	response := httptest.NewRecorder()
+	registry.Handler("errors").ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := response.Body.String()
+	for _, line := range []string{
+		`pretty_error_pages_served_total{middleware="errors",status="502"} 1`,
+		`pretty_error_template_errors_total{middleware="errors"} 1`,
+		`pretty_error_buffer_size_bytes_count{middleware="errors"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected line %s in:\n%s", line, body)
+		}
+	}
+
+	if strings.Contains(body, `middleware="other"`) {
+		t.Errorf("expected only the series of the instance, got:\n%s", body)
+	}
+}
+
+func TestRegistrySnapshot(t *testing.T) {
+	registry := metrics.NewRegistry()
+
+	recorder := registry.Recorder("errors")
+	recorder.PageServed(502)
+	recorder.PageServed(502)
+	recorder.PageSource("embedded")
+	recorder.TemplateError()
+	registry.Recorder("other").Passthrough(200)
+
+	snapshot := registry.Snapshot("errors")
+
+	if snapshot.PagesServed["502"] != 2 || snapshot.PageSources["embedded"] != 1 || snapshot.TemplateErrors != 1 {
+		t.Errorf("unexpected counters %+v", snapshot)
+	}
+
+	if len(snapshot.Passthroughs) != 0 {
+		t.Errorf("expected the counters of other middlewares left out, got %v", snapshot.Passthroughs)
+	}
+}
+
+func TestStatsdRecorder(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	statsd, err := metrics.NewStatsdRecorder(listener.LocalAddr().String(), "", "errors,main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry := metrics.NewRegistry()
+
+	recorder := metrics.Tee(registry.Recorder("errors,main"), statsd)
+	recorder.PageServed(502)
+	recorder.PageSource("templateDir")
+	recorder.Diagnostic("writeAfterCatch")
+	recorder.UpstreamResponse(512, 1500*time.Microsecond)
+
+	expected := []string{
+		"pretty_error.pages_served:1|c|#middleware:errors_main,status:502",
+		"pretty_error.page_sources:1|c|#middleware:errors_main,source:templateDir",
+		"pretty_error.diagnostics:1|c|#middleware:errors_main,kind:writeAfterCatch",
+		"pretty_error.upstream_size:512|h|#middleware:errors_main",
+		"pretty_error.upstream_duration:1.5|ms|#middleware:errors_main",
+	}
+
+	buffer := make([]byte, 512)
+
+	for _, packet := range expected {
+		if err := listener.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatal(err)
+		}
+
+		size, _, err := listener.ReadFrom(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if received := string(buffer[:size]); received != packet {
+			t.Errorf("got packet %q, want %q", received, packet)
+		}
+	}
+
+	if snapshot := registry.Snapshot("errors,main"); snapshot.PagesServed["502"] != 1 {
+		t.Errorf("expected the registry to count the page, got %+v", snapshot)
+	}
+
+	if _, err := metrics.NewStatsdRecorder("", "", "errors"); err == nil {
+		t.Error("expected a missing address to be rejected")
+	}
+}
@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultStatsdPrefix prefixes the statsd metric names when no prefix is given.
+const DefaultStatsdPrefix = "pretty_error"
+
+// statsdRecorder sends the measurements of one middleware instance as statsd UDP packets, tagged the DogStatsD way
+// as the Datadog agent expects. Packets are fire and forget, lost ones being ignored.
+type statsdRecorder struct {
+	conn   net.Conn
+	prefix string
+	tags   string
+}
+
+// NewStatsdRecorder get a Recorder sending the measurements of the middleware instance named middleware to the statsd
+// server at address, such as "127.0.0.1:8125", with metric names starting with prefix.
+func NewStatsdRecorder(address, prefix, middleware string) (Recorder, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	if prefix == "" {
+		prefix = DefaultStatsdPrefix
+	}
+
+	return &statsdRecorder{conn: conn, prefix: prefix, tags: "middleware:" + statsdTagValue(middleware)}, nil
+}
+
+func (recorder *statsdRecorder) PageServed(status int) {
+	recorder.send("pages_served", "1", "c", "status:"+strconv.Itoa(status))
+}
+
+func (recorder *statsdRecorder) Passthrough(status int) {
+	recorder.send("passthroughs", "1", "c", "status:"+strconv.Itoa(status))
+}
+
+func (recorder *statsdRecorder) PageSource(source string) {
+	recorder.send("page_sources", "1", "c", "source:"+statsdTagValue(source))
+}
+
+func (recorder *statsdRecorder) Diagnostic(kind string) {
+	recorder.send("diagnostics", "1", "c", "kind:"+statsdTagValue(kind))
+}
+
+func (recorder *statsdRecorder) TemplateError() {
+	recorder.send("template_errors", "1", "c", "")
+}
+
+func (recorder *statsdRecorder) RewriteDuration(duration time.Duration) {
+	recorder.send("rewrite_duration", milliseconds(duration), "ms", "")
+}
+
+func (recorder *statsdRecorder) BufferSize(size int) {
+	recorder.send("buffer_size", strconv.Itoa(size), "h", "")
+}
+
+func (recorder *statsdRecorder) UpstreamResponse(size int, duration time.Duration) {
+	recorder.send("upstream_size", strconv.Itoa(size), "h", "")
+	recorder.send("upstream_duration", milliseconds(duration), "ms", "")
+}
+
+// send write one "name:value|type|#tags" packet.
+func (recorder *statsdRecorder) send(name, value, metricType, tag string) {
+	tags := recorder.tags
+	if tag != "" {
+		tags += "," + tag
+	}
+
+	_, _ = recorder.conn.Write([]byte(recorder.prefix + "." + name + ":" + value + "|" + metricType + "|#" + tags))
+}
+
+// milliseconds format duration as the fractional milliseconds of statsd timers.
+func milliseconds(duration time.Duration) string {
+	return strconv.FormatFloat(float64(duration)/float64(time.Millisecond), 'f', -1, 64)
+}
+
+// statsdReplacer removes the characters separating the parts of a packet from tag values.
+var statsdReplacer = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+
+func statsdTagValue(value string) string {
+	return statsdReplacer.Replace(value)
+}
+
+// Tee get a Recorder giving every measurement to each of recorders.
+func Tee(recorders ...Recorder) Recorder {
+	return teeRecorder(recorders)
+}
+
+type teeRecorder []Recorder
+
+func (recorders teeRecorder) PageServed(status int) {
+	for _, recorder := range recorders {
+		recorder.PageServed(status)
+	}
+}
+
+func (recorders teeRecorder) Passthrough(status int) {
+	for _, recorder := range recorders {
+		recorder.Passthrough(status)
+	}
+}
+
+func (recorders teeRecorder) PageSource(source string) {
+	for _, recorder := range recorders {
+		recorder.PageSource(source)
+	}
+}
+
+func (recorders teeRecorder) Diagnostic(kind string) {
+	for _, recorder := range recorders {
+		recorder.Diagnostic(kind)
+	}
+}
+
+func (recorders teeRecorder) TemplateError() {
+	for _, recorder := range recorders {
+		recorder.TemplateError()
+	}
+}
+
+func (recorders teeRecorder) RewriteDuration(duration time.Duration) {
+	for _, recorder := range recorders {
+		recorder.RewriteDuration(duration)
+	}
+}
+
+func (recorders teeRecorder) BufferSize(size int) {
+	for _, recorder := range recorders {
+		recorder.BufferSize(size)
+	}
+}
+
+func (recorders teeRecorder) UpstreamResponse(size int, duration time.Duration) {
+	for _, recorder := range recorders {
+		recorder.UpstreamResponse(size, duration)
+	}
+}
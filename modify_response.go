@@ -0,0 +1,155 @@
+package pretty_error
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/packruler/pretty-error/httputil"
+	"github.com/packruler/pretty-error/logging"
+)
+
+// NewResponseModifier creates a function for net/http/httputil.ReverseProxy.ModifyResponse applying the middleware to
+// proxied responses, without wrapping the ResponseWriter. Responses the middleware would leave alone are returned as
+// is, the others are replayed through the middleware and replaced by the error page or their rewritten body.
+// Unlike the middleware, the replaced responses are held in memory whole instead of being streamed.
+func NewResponseModifier(config *Config, name string, opts ...Option) (func(*http.Response) error, error) {
+	bodyRewrite, err := newDetachedRewriteBody(config, name, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return bodyRewrite.modifyResponse, nil
+}
+
+// newDetachedRewriteBody creates a middleware instance without a fixed upstream handler.
+func newDetachedRewriteBody(config *Config, name string, opts []Option) (*rewriteBody, error) {
+	problems := &validator{}
+
+	handler, err := newRewriteBody(nil, config, name, configCodeRanges(config, problems), newOptions(opts), problems)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler.(*rewriteBody), nil
+}
+
+// modifyResponse replace resp by the response the middleware writes for it, when it would replace or rewrite it.
+func (bodyRewrite *rewriteBody) modifyResponse(resp *http.Response) error {
+	req := resp.Request
+	if req == nil || resp.StatusCode == http.StatusSwitchingProtocols ||
+		!httputil.SupportsProcessingMethods(req, bodyRewrite.methods) || !bodyRewrite.requestFilter.allows(req) ||
+		!bodyRewrite.catcherConfig.handles(resp.StatusCode, resp.Header) {
+		return nil
+	}
+
+	buffer := &responseBuffer{header: make(http.Header)}
+	bodyRewrite.intercept(bodyRewrite.replay(resp), buffer, req)
+
+	if err := resp.Body.Close(); err != nil {
+		bodyRewrite.logger.Debug("unable to close upstream body", logging.F("error", err))
+	}
+
+	buffer.apply(resp)
+
+	return nil
+}
+
+// handles determine if the middleware replaces or rewrites upstream responses with status and header.
+func (config *catcherConfig) handles(status int, header http.Header) bool {
+	if httputil.IsPassthroughStatus(status) || httputil.IsStreamingContentType(header.Get("Content-Type")) {
+		return false
+	}
+
+	intercepted := config.codeMatcher.Match(status) || matchesAnyHeader(config.interceptHeaders, header)
+	if intercepted && httputil.MatchesContentType(header.Get("Content-Type"), config.contentTypes) {
+		return true
+	}
+
+	return config.shouldBuffer(status, header)
+}
+
+// replay get a handler writing resp, as the upstream handler of the middleware would have.
+func (bodyRewrite *rewriteBody) replay(resp *http.Response) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+		httputil.CopyHeaders(response.Header(), resp.Header)
+
+		for name := range resp.Trailer {
+			response.Header().Add("Trailer", name)
+		}
+
+		response.WriteHeader(resp.StatusCode)
+
+		if _, err := io.Copy(response, resp.Body); err != nil {
+			bodyRewrite.logger.Warn("unable to read upstream body", logging.F("error", err))
+		}
+
+		// trailers are only known once the body was read.
+		for name, values := range resp.Trailer {
+			response.Header()[name] = values
+		}
+	})
+}
+
+// responseBuffer an http.ResponseWriter keeping the response in memory, to be turned back into an http.Response.
+type responseBuffer struct {
+	header     http.Header
+	sentHeader http.Header
+	status     int
+	body       bytes.Buffer
+}
+
+func (buffer *responseBuffer) Header() http.Header {
+	return buffer.header
+}
+
+// WriteHeader record the status and the headers sent with it. Interim responses are dropped, an http.Response only
+// holds the final one.
+func (buffer *responseBuffer) WriteHeader(status int) {
+	if buffer.status != 0 || httputil.IsInformational(status) {
+		return
+	}
+
+	buffer.status = status
+	buffer.sentHeader = buffer.header.Clone()
+}
+
+func (buffer *responseBuffer) Write(data []byte) (int, error) {
+	if buffer.status == 0 {
+		buffer.WriteHeader(http.StatusOK)
+	}
+
+	return buffer.body.Write(data)
+}
+
+// apply replace the status, headers, body and trailers of resp with the buffered response.
+func (buffer *responseBuffer) apply(resp *http.Response) {
+	if buffer.status == 0 {
+		buffer.WriteHeader(http.StatusOK)
+	}
+
+	trailer := make(http.Header)
+	httputil.CopyTrailers(trailer, buffer.header)
+
+	resp.Trailer = nil
+	for name, values := range trailer {
+		if resp.Trailer == nil {
+			resp.Trailer = make(http.Header)
+		}
+
+		resp.Trailer[http.CanonicalHeaderKey(strings.TrimPrefix(name, http.TrailerPrefix))] = values
+	}
+
+	resp.StatusCode = buffer.status
+	resp.Status = fmt.Sprintf("%d %s", buffer.status, http.StatusText(buffer.status))
+	resp.Header = buffer.sentHeader
+	resp.Header.Del("Trailer")
+	resp.Body = io.NopCloser(bytes.NewReader(buffer.body.Bytes()))
+
+	resp.ContentLength = int64(buffer.body.Len())
+	if resp.Trailer != nil {
+		resp.ContentLength = -1
+	}
+}
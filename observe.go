@@ -0,0 +1,61 @@
+package pretty_error
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/packruler/pretty-error/logging"
+)
+
+// defaultObserveLimit is the number of body bytes kept from observed responses when observeLimit is not configured.
+const defaultObserveLimit = 4096
+
+// newObserveLimit get the number of body bytes kept from observed responses, or 0 when responses are replaced.
+func newObserveLimit(config *Config, v *validator) int {
+	if config.ObserveLimit < 0 {
+		v.check("observeLimit", errors.New("must not be negative"))
+	}
+
+	if !config.Observe {
+		return 0
+	}
+
+	if config.ObserveLimit <= 0 {
+		return defaultObserveLimit
+	}
+
+	return config.ObserveLimit
+}
+
+// startObserving send the headers of an intercepted response, whose body is then teed to the client and the buffer.
+func (cc *codeCatcher) startObserving() {
+	cc.observing = true
+
+	cc.sendHeaders()
+}
+
+// observe keep the start of an observed body, up to the observe limit.
+func (cc *codeCatcher) observe(buf []byte) {
+	if remaining := cc.config.observeLimit - cc.getBuffer().Len(); remaining > 0 {
+		if len(buf) > remaining {
+			buf = buf[:remaining]
+		}
+
+		cc.getBuffer().Write(buf)
+	}
+}
+
+// isObserving returns whether the codeCatcher tees an intercepted response instead of dropping it.
+func (cc *codeCatcher) isObserving() bool {
+	return cc.observing
+}
+
+// logObserved report an intercepted response forwarded unmodified, with the start of its body.
+func (bodyRewrite *rewriteBody) logObserved(req *http.Request, catcher responseInterceptor) {
+	bodyRewrite.logger.Info("observed upstream error",
+		logging.F("middleware", bodyRewrite.name),
+		logging.F("path", req.URL.Path),
+		logging.F("status", catcher.getCode()),
+		logging.F("size", catcher.bodySize()),
+		logging.F("body", catcher.getBuffer().String()))
+}
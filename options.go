@@ -0,0 +1,91 @@
+package pretty_error
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+)
+
+// Option configures the middleware with what a Config cannot hold, for library users.
+type Option func(*options)
+
+type options struct {
+	templates fs.FS
+	config    *Config
+	name      string
+	reporters []Reporter
+}
+
+// defaultMiddlewareName name of the instances created by NewMiddleware without WithName.
+const defaultMiddlewareName = "prettyError"
+
+// WithTemplates render HTML pages from the "*.html" templates at the root of fsys, like Config.TemplateDir does for
+// a directory. It allows Go users to embed their pages with go:embed instead of reading them at runtime.
+func WithTemplates(fsys fs.FS) Option {
+	return func(opts *options) {
+		opts.templates = fsys
+	}
+}
+
+// WithConfig configure the middleware created by NewMiddleware, CreateConfig being used without it.
+func WithConfig(config *Config) Option {
+	return func(opts *options) {
+		opts.config = config
+	}
+}
+
+// WithName name the middleware created by NewMiddleware, as it appears in logs, metrics and templates.
+func WithName(name string) Option {
+	return func(opts *options) {
+		opts.name = name
+	}
+}
+
+// NewMiddleware wraps next with the middleware, as plain net/http middleware usable in any server. It panics when the
+// configuration given with WithConfig is invalid, like regexp.MustCompile; NewWithOptions returns the error instead.
+func NewMiddleware(next http.Handler, opts ...Option) http.Handler {
+	applied := newOptions(opts)
+
+	config := applied.config
+	if config == nil {
+		config = CreateConfig()
+	}
+
+	name := applied.name
+	if name == "" {
+		name = defaultMiddlewareName
+	}
+
+	problems := &validator{}
+
+	handler, err := newRewriteBody(next, config, name, configCodeRanges(config, problems), applied, problems)
+	if err != nil {
+		panic(err)
+	}
+
+	return handler
+}
+
+// NewWithOptions creates and returns a new rewrite body plugin instance like New, applying opts.
+// WithConfig and WithName are ignored in favor of config and name.
+func NewWithOptions(
+	_ context.Context,
+	next http.Handler,
+	config *Config,
+	name string,
+	opts ...Option,
+) (http.Handler, error) {
+	problems := &validator{}
+
+	return newRewriteBody(next, config, name, configCodeRanges(config, problems), newOptions(opts), problems)
+}
+
+func newOptions(opts []Option) options {
+	var applied options
+
+	for _, opt := range opts {
+		opt(&applied)
+	}
+
+	return applied
+}
@@ -3,249 +3,1040 @@ package pretty_error
 
 import (
 	"bufio"
+	"bytes"
 	"context"
-	"fmt"
-	"log"
+	"errors"
+	"io"
 	"net"
 	"net/http"
-	"regexp"
+	"time"
 
+	"github.com/packruler/pretty-error/htmltemplates"
 	"github.com/packruler/pretty-error/httputil"
+	"github.com/packruler/pretty-error/logging"
+	"github.com/packruler/pretty-error/metrics"
+	"github.com/packruler/pretty-error/tracing"
 	"github.com/packruler/pretty-error/types"
 )
 
 // Rewrite holds one rewrite body configuration.
 type Rewrite struct {
-	Regex       string `json:"regex,omitempty"`
-	Replacement string `json:"replacement,omitempty"`
+	Regex           string   `json:"regex,omitempty"`
+	Replacement     string   `json:"replacement,omitempty"`
+	ReplacementFile string   `json:"replacementFile,omitempty" toml:"replacementFile,omitempty" yaml:"replacementFile,omitempty" export:"true"`
+	Status          []string `json:"status,omitempty" toml:"status,omitempty" yaml:"status,omitempty" export:"true"`
+	JSONPath        string   `json:"jsonPath,omitempty" toml:"jsonPath,omitempty" yaml:"jsonPath,omitempty" export:"true"`
+	MaxReplacements int      `json:"maxReplacements,omitempty" toml:"maxReplacements,omitempty" yaml:"maxReplacements,omitempty" export:"true"`
+	ContentTypes    []string `json:"contentTypes,omitempty" toml:"contentTypes,omitempty" yaml:"contentTypes,omitempty" export:"true"`
 }
 
 // Config holds the plugin configuration.
 type Config struct {
-	LastModified bool      `json:"lastModified,omitempty"`
-	Rewrites     []Rewrite `json:"rewrites,omitempty"`
-	Status       []string  `json:"status,omitempty" toml:"status,omitempty" yaml:"status,omitempty" export:"true"`
+	LastModified         bool              `json:"lastModified,omitempty"`
+	Rewrites             []Rewrite         `json:"rewrites,omitempty"`
+	Status               []string          `json:"status,omitempty" toml:"status,omitempty" yaml:"status,omitempty" export:"true"`
+	Theme                string            `json:"theme,omitempty" toml:"theme,omitempty" yaml:"theme,omitempty" export:"true"`
+	Actions              []Action          `json:"actions,omitempty" toml:"actions,omitempty" yaml:"actions,omitempty" export:"true"`
+	CORS                 *CORS             `json:"cors,omitempty" toml:"cors,omitempty" yaml:"cors,omitempty" export:"true"`
+	PreserveHeaders      []string          `json:"preserveHeaders,omitempty" toml:"preserveHeaders,omitempty" yaml:"preserveHeaders,omitempty" export:"true"`
+	CacheMaxAge          int               `json:"cacheMaxAge,omitempty" toml:"cacheMaxAge,omitempty" yaml:"cacheMaxAge,omitempty" export:"true"`
+	CacheValidators      bool              `json:"cacheValidators,omitempty" toml:"cacheValidators,omitempty" yaml:"cacheValidators,omitempty" export:"true"`
+	HideServerHeaders    bool              `json:"hideServerHeaders,omitempty" toml:"hideServerHeaders,omitempty" yaml:"hideServerHeaders,omitempty" export:"true"`
+	ResponseHeaders      map[string]string `json:"responseHeaders,omitempty" toml:"responseHeaders,omitempty" yaml:"responseHeaders,omitempty" export:"true"`
+	SecurityHeaders      map[string]string `json:"securityHeaders,omitempty" toml:"securityHeaders,omitempty" yaml:"securityHeaders,omitempty" export:"true"`
+	Offline              bool              `json:"offline,omitempty" toml:"offline,omitempty" yaml:"offline,omitempty" export:"true"`
+	IncludePaths         []string          `json:"includePaths,omitempty" toml:"includePaths,omitempty" yaml:"includePaths,omitempty" export:"true"`
+	ExcludePaths         []string          `json:"excludePaths,omitempty" toml:"excludePaths,omitempty" yaml:"excludePaths,omitempty" export:"true"`
+	SkipHeaders          []HeaderMatcher   `json:"skipHeaders,omitempty" toml:"skipHeaders,omitempty" yaml:"skipHeaders,omitempty" export:"true"`
+	BypassCookie         string            `json:"bypassCookie,omitempty" toml:"bypassCookie,omitempty" yaml:"bypassCookie,omitempty" export:"true"`
+	Methods              []string          `json:"methods,omitempty" toml:"methods,omitempty" yaml:"methods,omitempty" export:"true"`
+	InterceptHeaders     []HeaderMatcher   `json:"interceptHeaders,omitempty" toml:"interceptHeaders,omitempty" yaml:"interceptHeaders,omitempty" export:"true"`
+	ContentTypes         []string          `json:"contentTypes,omitempty" toml:"contentTypes,omitempty" yaml:"contentTypes,omitempty" export:"true"`
+	DisableDefaultStatus bool              `json:"disableDefaultStatus,omitempty" toml:"disableDefaultStatus,omitempty" yaml:"disableDefaultStatus,omitempty" export:"true"`
+	StreamRewrites       bool              `json:"streamRewrites,omitempty" toml:"streamRewrites,omitempty" yaml:"streamRewrites,omitempty" export:"true"`
+	StreamWindow         int               `json:"streamWindow,omitempty" toml:"streamWindow,omitempty" yaml:"streamWindow,omitempty" export:"true"`
+	RewriteBudget        string            `json:"rewriteBudget,omitempty" toml:"rewriteBudget,omitempty" yaml:"rewriteBudget,omitempty" export:"true"`
+	Banner               *Banner           `json:"banner,omitempty" toml:"banner,omitempty" yaml:"banner,omitempty" export:"true"`
+	DeadlineStatus       int               `json:"deadlineStatus,omitempty" toml:"deadlineStatus,omitempty" yaml:"deadlineStatus,omitempty" export:"true"`
+	CanceledStatus       int               `json:"canceledStatus,omitempty" toml:"canceledStatus,omitempty" yaml:"canceledStatus,omitempty" export:"true"`
+	MetricsPath          string            `json:"metricsPath,omitempty" toml:"metricsPath,omitempty" yaml:"metricsPath,omitempty" export:"true"`
+	LogLevel             string            `json:"logLevel,omitempty" toml:"logLevel,omitempty" yaml:"logLevel,omitempty" export:"true"`
+	AccessLog            string            `json:"accessLog,omitempty" toml:"accessLog,omitempty" yaml:"accessLog,omitempty" export:"true"`
+	Service              *ErrorService     `json:"service,omitempty" toml:"service,omitempty" yaml:"service,omitempty" export:"true"`
+	TemplateDir          string            `json:"templateDir,omitempty" toml:"templateDir,omitempty" yaml:"templateDir,omitempty" export:"true"`
+	Assets               *Assets           `json:"assets,omitempty" toml:"assets,omitempty" yaml:"assets,omitempty" export:"true"`
+	Observe              bool              `json:"observe,omitempty" toml:"observe,omitempty" yaml:"observe,omitempty" export:"true"`
+	ObserveLimit         int               `json:"observeLimit,omitempty" toml:"observeLimit,omitempty" yaml:"observeLimit,omitempty" export:"true"`
+	DryRun               bool              `json:"dryRun,omitempty" toml:"dryRun,omitempty" yaml:"dryRun,omitempty" export:"true"`
+	RolloutPercent       int               `json:"rolloutPercent,omitempty" toml:"rolloutPercent,omitempty" yaml:"rolloutPercent,omitempty" export:"true"`
+	Labels               map[string]string `json:"labels,omitempty" toml:"labels,omitempty" yaml:"labels,omitempty" export:"true"`
+	Compress             bool              `json:"compress,omitempty" toml:"compress,omitempty" yaml:"compress,omitempty" export:"true"`
+	MarkerHeader         string            `json:"markerHeader,omitempty" toml:"markerHeader,omitempty" yaml:"markerHeader,omitempty" export:"true"`
+	Preview              *Preview          `json:"preview,omitempty" toml:"preview,omitempty" yaml:"preview,omitempty" export:"true"`
+	StatusPath           string            `json:"statusPath,omitempty" toml:"statusPath,omitempty" yaml:"statusPath,omitempty" export:"true"`
+	GRPCStatus           bool              `json:"grpcStatus,omitempty" toml:"grpcStatus,omitempty" yaml:"grpcStatus,omitempty" export:"true"`
+	HTMLOnly             bool              `json:"htmlOnly,omitempty" toml:"htmlOnly,omitempty" yaml:"htmlOnly,omitempty" export:"true"`
+	EmptyBodies          bool              `json:"emptyBodies,omitempty" toml:"emptyBodies,omitempty" yaml:"emptyBodies,omitempty" export:"true"`
+	RecentErrors         int               `json:"recentErrors,omitempty" toml:"recentErrors,omitempty" yaml:"recentErrors,omitempty" export:"true"`
+	Webhook              *Webhook          `json:"webhook,omitempty" toml:"webhook,omitempty" yaml:"webhook,omitempty" export:"true"`
+	SentryDSN            string            `json:"sentryDsn,omitempty" toml:"sentryDsn,omitempty" yaml:"sentryDsn,omitempty" export:"true"`
+	Statsd               *Statsd           `json:"statsd,omitempty" toml:"statsd,omitempty" yaml:"statsd,omitempty" export:"true"`
+	Strict               bool              `json:"strict,omitempty" toml:"strict,omitempty" yaml:"strict,omitempty" export:"true"`
+	StrictLanguages      []string          `json:"strictLanguages,omitempty" toml:"strictLanguages,omitempty" yaml:"strictLanguages,omitempty" export:"true"`
+	Delimiters           []string          `json:"delimiters,omitempty" toml:"delimiters,omitempty" yaml:"delimiters,omitempty" export:"true"`
+	UpstreamBody         bool              `json:"upstreamBody,omitempty" toml:"upstreamBody,omitempty" yaml:"upstreamBody,omitempty" export:"true"`
+	FlushPolicy          string            `json:"flushPolicy,omitempty" toml:"flushPolicy,omitempty" yaml:"flushPolicy,omitempty" export:"true"`
+	BodySignatures       []BodySignature   `json:"bodySignatures,omitempty" toml:"bodySignatures,omitempty" yaml:"bodySignatures,omitempty" export:"true"`
+	BodySignatureLimit   int               `json:"bodySignatureLimit,omitempty" toml:"bodySignatureLimit,omitempty" yaml:"bodySignatureLimit,omitempty" export:"true"`
+	Languages            []string          `json:"languages,omitempty" toml:"languages,omitempty" yaml:"languages,omitempty" export:"true"`
 }
 
+// defaultStatus status ranges replaced when Config.Status is empty.
+var defaultStatus = []string{"500-599"}
+
 // CreateConfig creates and initializes the plugin configuration.
 func CreateConfig() *Config {
 	return &Config{}
 }
 
-type rewrite struct {
-	regex       *regexp.Regexp
-	replacement []byte
-}
-
+// rewriteBody is shared by the concurrent requests of a middleware instance: it is never modified once created, and
+// its fields are immutable or safe for concurrent use. The state of each request lives in its interception.
 type rewriteBody struct {
-	name           string
-	next           http.Handler
-	rewrites       []rewrite
-	lastModified   bool
-	httpCodeRanges types.HTTPCodeRanges
-}
-
-type codeCatcherWithCloseNotify struct {
-	*codeCatcher
+	name             string
+	next             http.Handler
+	catcherConfig    catcherConfig
+	theme            string
+	actions          []action
+	preservedHeaders []string
+	cors             *CORS
+	responseHeaders  map[string]string
+	cacheMaxAge      int
+	cacheValidators  bool
+	createdAt        time.Time
+	securityHeaders  map[string]string
+	offline          bool
+	requestFilter    requestFilter
+	methods          []string
+	contextStatuses  contextStatuses
+	metrics          metrics.Recorder
+	metricsPath      string
+	logger           logging.Logger
+	accessLog        *accessLog
+	sources          []pageSource
+	assets           *assetHandler
+	labels           map[string]string
+	compressor       *pageCompressor
+	marker           *markerHeader
+	preview          *previewHandler
+	statusPath       string
+	recentErrors     *recentErrors
+	notifier         *notifier
+	reporters        []Reporter
+	// languages are the languages the pages are available in, any when empty.
+	languages []string
 }
 
 type responseInterceptor interface {
 	http.ResponseWriter
-	http.Flusher
 	getCode() int
 	isFilteredCode() bool
+	isBuffering() bool
+	isObserving() bool
+	isShadowing() bool
+	getBuffer() *bytes.Buffer
+	sendHeaders()
+	headersWritten() bool
+	sendTrailers()
+	bodySize() int
+	duration() time.Duration
+	finishStream()
+	catchEmptyBody()
+	finishInspection() error
+	shortBody() bool
+	release()
+}
+
+// catcherConfig holds the interception policy shared by the codeCatchers of a middleware instance.
+type catcherConfig struct {
+	codeMatcher      types.CodeMatcher
+	interceptHeaders []headerMatcher
+	contentTypes     []string
+	stripHeaders     []string
+	rewrites         []rewrite
+	lastModified     bool
+	// streamWindow is the number of trailing bytes held back while streaming rewrites, 0 buffers whole bodies.
+	streamWindow int
+	// rewriteBudget is the time the rewrites of one response may take, 0 when unlimited.
+	rewriteBudget time.Duration
+	// observeLimit is the number of body bytes kept from intercepted responses forwarded unmodified,
+	// 0 when intercepted responses are replaced.
+	observeLimit int
+	// dryRun forwards every response unmodified, the replacements and rewrites only being reported.
+	dryRun bool
+	// rollout selects the requests whose intercepted responses are replaced, others are forwarded.
+	rollout rollout
+	// grpcStatus intercepts failed gRPC-web responses according to the HTTP status of their gRPC status.
+	grpcStatus bool
+	// htmlOnly only replaces the intercepted responses of requests explicitly accepting HTML.
+	htmlOnly bool
+	// emptyBodies replaces error responses with an empty body, whatever their status.
+	emptyBodies bool
+	// snippetSize is the number of body bytes kept from replaced responses, for the recent errors.
+	snippetSize int
+	// upstreamBody keeps the start of the body of replaced responses for the templates, up to
+	// httputil.DefaultFilteredBodyLimit.
+	upstreamBody bool
+	// flushPolicy decides what the flushes of the upstream do before the status of the response is decided.
+	flushPolicy string
+	// signatures replace the 200 responses whose first signatureLimit body bytes match one of them.
+	signatures     []bodySignature
+	signatureLimit int
+	metrics        metrics.Recorder
+	logger         logging.Logger
 }
 
 // codeCatcher is a response writer that detects as soon as possible whether the
 // response is a code within the ranges of codes it watches for. If it is, it
 // simply drops the data from the response. Otherwise, it forwards it directly to
-// the original client (its responseWriter) without any buffering.
+// the original client (its responseWriter) without any buffering, unless some
+// rewrites apply to the response, in which case it is buffered to be rewritten,
+// or rewritten as it streams through when streamRewrites is enabled.
 type codeCatcher struct {
-	headerMap          http.Header
-	code               int
-	httpCodeRanges     types.HTTPCodeRanges
-	caughtFilteredCode bool
-	responseWriter     http.ResponseWriter
-	headersSent        bool
+	// core tracks and sends the response, the codeCatcher deciding what becomes of it.
+	core           *httputil.CodeCatcher
+	config         *catcherConfig
+	request        *http.Request
+	responseWriter http.ResponseWriter
+	buffering      bool
+	stream         *streamRewriter
+	observing      bool
+	shadowing      bool
+	// holding is set while an error response is held back until its body turns out to be empty.
+	holding bool
+	// inspecting is set while a 200 response is held back until the start of its body is inspected for signatures.
+	inspecting bool
+	// replacing is set when the intercepted responses of the request are replaced: the request is part of the rollout
+	// and, in HTML only mode, explicitly accepts HTML.
+	replacing bool
+	// pendingFlush is set while a flush waits for the status of the response to be decided.
+	pendingFlush bool
+	// droppedBody is set once a body written for a replaced response was diagnosed.
+	droppedBody bool
+	// closed is the channel returned by CloseNotify, created on its first call, and released is closed once the
+	// upstream handler returned, ending the goroutine feeding closed.
+	closed   chan bool
+	released chan struct{}
 }
 
 // New creates and returns a new rewrite body plugin instance.
-func New(_ context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
-	httpCodeRanges, err := types.NewHTTPCodeRanges(config.Status)
-	if err != nil {
-		return nil, err
-	}
+// Every problem found in config is reported at once by a *ValidationError.
+func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
+	return NewWithOptions(ctx, next, config, name)
+}
 
-	rewrites := make([]rewrite, len(config.Rewrites))
+// configCodeRanges parse the status ranges intercepted according to config.
+func configCodeRanges(config *Config, problems *validator) types.HTTPCodeRanges {
+	status := config.Status
+	if len(status) == 0 && !config.DisableDefaultStatus {
+		status = defaultStatus
+	}
 
-	for index, rewriteConfig := range config.Rewrites {
-		regex, err := regexp.Compile(rewriteConfig.Regex)
-		if err != nil {
-			return nil, fmt.Errorf("error compiling regex %q: %w", rewriteConfig.Regex, err)
+	if config.DisableDefaultStatus {
+		ranges := problems.parseStatus("status", status, nil)
+		if len(ranges.Include) == 0 && len(ranges.Exclude) > 0 {
+			problems.check("status", errors.New("only holds exclusions, with no default status to exclude them from"))
 		}
 
-		rewrites[index] = rewrite{
-			regex:       regex,
-			replacement: []byte(rewriteConfig.Replacement),
-		}
+		return ranges
 	}
 
-	log.Printf("New: %v", httpCodeRanges)
+	// exclusions alone are carved out of the default status.
+	defaults, _ := types.NewHTTPCodeRanges(defaultStatus)
 
-	return &rewriteBody{
-		name:           name,
-		next:           next,
-		rewrites:       rewrites,
-		lastModified:   config.LastModified,
-		httpCodeRanges: httpCodeRanges,
-	}, nil
+	return problems.parseStatus("status", status, defaults.Include)
+}
+
+// NewWithCodeMatcher creates and returns a new rewrite body plugin instance intercepting the status codes matched by
+// codeMatcher instead of config.Status, allowing library users to implement arbitrary interception policies.
+func NewWithCodeMatcher(
+	_ context.Context,
+	next http.Handler,
+	config *Config,
+	name string,
+	codeMatcher types.CodeMatcher,
+) (http.Handler, error) {
+	problems := &validator{}
+
+	if codeMatcher == nil {
+		problems.check("codeMatcher", errors.New("is required"))
+	}
+
+	return newRewriteBody(next, config, name, codeMatcher, options{}, problems)
+}
+
+func newRewriteBody(
+	next http.Handler,
+	config *Config,
+	name string,
+	codeMatcher types.CodeMatcher,
+	opts options,
+	problems *validator,
+) (http.Handler, error) {
+	config = expandConfig(config, problems)
+
+	rewrites := append(newRewrites(config.Rewrites, problems), newBannerRewrites(config.Banner, config.Offline, problems)...)
+	streamWindow := newStreamWindow(config, problems)
+	observeLimit := newObserveLimit(config, problems)
+	rollout := newRollout(config, problems)
+	contextStatuses := newContextStatuses(config, problems)
+	logger := newLogger(config, problems)
+	accessLog := newAccessLog(config.AccessLog, problems)
+	sources := newPageSources(config, opts, problems)
+	assets := newAssetHandler(config.Assets, problems)
+	recorder := newRecorder(config.Statsd, name, problems)
+	rewriteBudget := newRewriteBudget(config, problems)
+	flushPolicy := newFlushPolicy(config, problems)
+	signatures := newBodySignatures(config.BodySignatures, problems)
+	signatureLimit := newSignatureLimit(config, problems)
+
+	actions := newActions(config.Actions, problems)
+
+	preservedHeaders := config.PreserveHeaders
+	if preservedHeaders == nil {
+		preservedHeaders = defaultPreserveHeaders
+	}
+
+	requestFilter := newRequestFilter(config, problems)
+
+	methods := config.Methods
+	if len(methods) == 0 {
+		methods = httputil.DefaultMethods
+	}
+
+	interceptHeaders := newHeaderMatchers("interceptHeaders", config.InterceptHeaders, problems)
+
+	contentTypes := config.ContentTypes
+	if len(contentTypes) == 0 {
+		contentTypes = httputil.DefaultContentTypes
+	}
+
+	var stripHeaders []string
+	if config.HideServerHeaders {
+		stripHeaders = serverHeaders
+	}
+
+	theme := config.Theme
+	if theme == "" {
+		theme = htmltemplates.DefaultTheme
+	}
+
+	if config.Offline {
+		problems.check("offline", checkOffline(theme))
+		checkOfflineSources(sources, problems)
+	}
+
+	problems.check("cors.allowOrigins", config.CORS.validate())
+
+	marker := newMarkerHeader(config.MarkerHeader, problems)
+	preview := newPreviewHandler(config.Preview, problems)
+	statusPath := newStatusPath(config, problems)
+	metricsPath := newMetricsPath(config, problems)
+	recentErrors := newRecentErrors(config, problems)
+	notifier := newNotifier(config.Webhook, name, logger, problems)
+
+	reporters := opts.reporters
+	if sentry := newConfigSentryReporter(config.SentryDSN, logger, problems); sentry != nil {
+		reporters = append(reporters, sentry)
+	}
+
+	var snippetSize int
+	if recentErrors != nil {
+		snippetSize = recentSnippetSize
+	}
+
+	checkTemplates(config, sources, codeMatcher, strictMetadata(config, name, theme, assets), problems)
+
+	if err := problems.err(); err != nil {
+		return nil, err
+	}
+
+	logger.Debug("middleware created", logging.F("middleware", name), logging.F("codeMatcher", codeMatcher))
+
+	bodyRewrite := &rewriteBody{
+		name: name,
+		next: next,
+		catcherConfig: catcherConfig{
+			codeMatcher:      codeMatcher,
+			interceptHeaders: interceptHeaders,
+			contentTypes:     contentTypes,
+			stripHeaders:     stripHeaders,
+			rewrites:         rewrites,
+			lastModified:     config.LastModified,
+			streamWindow:     streamWindow,
+			observeLimit:     observeLimit,
+			dryRun:           config.DryRun,
+			grpcStatus:       config.GRPCStatus,
+			htmlOnly:         config.HTMLOnly,
+			emptyBodies:      config.EmptyBodies,
+			snippetSize:      snippetSize,
+			upstreamBody:     config.UpstreamBody,
+			flushPolicy:      flushPolicy,
+			signatures:       signatures,
+			signatureLimit:   signatureLimit,
+			rollout:          rollout,
+			rewriteBudget:    rewriteBudget,
+			metrics:          recorder,
+			logger:           logger,
+		},
+		theme:            theme,
+		actions:          actions,
+		preservedHeaders: preservedHeaders,
+		cors:             config.CORS,
+		responseHeaders:  config.ResponseHeaders,
+		cacheMaxAge:      config.CacheMaxAge,
+		cacheValidators:  config.CacheValidators,
+		createdAt:        time.Now(),
+		securityHeaders:  newSecurityHeaders(config.SecurityHeaders, config.Offline, assets != nil),
+		offline:          config.Offline,
+		requestFilter:    requestFilter,
+		methods:          methods,
+		contextStatuses:  contextStatuses,
+		metrics:          recorder,
+		metricsPath:      metricsPath,
+		logger:           logger,
+		accessLog:        accessLog,
+		sources:          sources,
+		assets:           assets,
+		labels:           config.Labels,
+		compressor:       newPageCompressor(config.Compress),
+		marker:           marker,
+		preview:          preview,
+		statusPath:       statusPath,
+		languages:        config.Languages,
+		recentErrors:     recentErrors,
+		notifier:         notifier,
+		reporters:        reporters,
+	}
+
+	if preview != nil {
+		preview.bodyRewrite = bodyRewrite
+	}
+
+	return bodyRewrite, nil
 }
 
 func (bodyRewrite *rewriteBody) ServeHTTP(response http.ResponseWriter, req *http.Request) {
-	// allow default http.ResponseWriter to handle calls targeting WebSocket upgrades and non GET methods
-	if !httputil.SupportsProcessing(req) {
-		bodyRewrite.next.ServeHTTP(response, req)
+	bodyRewrite.serve(bodyRewrite.next, response, req)
+}
+
+// serve answer req with the endpoints of the middleware, or with next, intercepting its response when allowed.
+func (bodyRewrite *rewriteBody) serve(next http.Handler, response http.ResponseWriter, req *http.Request) {
+	if bodyRewrite.metricsPath != "" && req.URL.Path == bodyRewrite.metricsPath {
+		bodyRewrite.serveMetrics(response, req)
 
 		return
 	}
 
-	// wrappedWriter := &httputil.ResponseWrapper{
-	// 	ResponseWriter: response,
-	// }
+	if bodyRewrite.statusPath != "" && req.URL.Path == bodyRewrite.statusPath {
+		bodyRewrite.serveStatus(response, req)
 
-	log.Print("Before catcher")
+		return
+	}
 
-	catcher := newCodeCatcher(response, bodyRewrite.httpCodeRanges)
-	log.Printf("Catcher: %v", catcher)
-	bodyRewrite.next.ServeHTTP(catcher, req)
+	if bodyRewrite.assets.matches(req) {
+		bodyRewrite.assets.ServeHTTP(response, req)
 
-	log.Print("After serve")
+		return
+	}
+
+	if bodyRewrite.preview.matches(req) {
+		bodyRewrite.preview.ServeHTTP(response, req)
+
+		return
+	}
 
-	log.Printf("Status: %d", catcher.getCode())
+	// allow default http.ResponseWriter to handle calls targeting WebSocket upgrades and non GET methods,
+	// and the requests of the middleware itself for error pages.
+	if !httputil.SupportsProcessingMethods(req, bodyRewrite.methods) || !bodyRewrite.requestFilter.allows(req) ||
+		isFetch(req) {
+		next.ServeHTTP(response, req)
 
-	if !catcher.isFilteredCode() {
 		return
 	}
 
-	// look into using https://pkg.go.dev/net/http#RoundTripper
-	// bodyRewrite.next.ServeHTTP(wrappedWriter, req)
+	bodyRewrite.intercept(next, response, req)
+}
+
+// intercept serve req with next, replacing or rewriting the response it writes as configured.
+func (bodyRewrite *rewriteBody) intercept(next http.Handler, response http.ResponseWriter, req *http.Request) {
+	bodyRewrite.logger.Debug("intercepting request", logging.F("middleware", bodyRewrite.name),
+		logging.F("path", req.URL.Path))
+
+	ctx, span := tracing.GetTracer().Start(req.Context(), "pretty-error")
+	defer span.End()
 
-	// if !wrappedWriter.SupportsProcessing() || !wrappedWriter.SupportsWriting() {
-	// 	// We are ignoring these any errors because the content should be unchanged here.
-	// 	// This could "error" if writing is not supported but content will return properly.
-	// 	_, _ = response.Write(wrappedWriter.GetBuffer().Bytes())
+	req = withGeneratedFlag(req.WithContext(tracing.ContextWithSpan(ctx, span)))
+
+	interception := newInterception(response, req, &bodyRewrite.catcherConfig)
+	catcher := interception.catcher
+	interception.serveUpstream(next)
+
+	bodyRewrite.logger.Debug("upstream served", logging.F("middleware", bodyRewrite.name),
+		logging.F("status", catcher.getCode()))
+
+	// the held back start of a response the upstream failed to complete is never sent.
+	if !interception.truncated() {
+		_ = catcher.finishInspection()
+		catcher.finishStream()
+		catcher.catchEmptyBody()
+	}
 
-	// 	return
-	// }
+	interception.decide(bodyRewrite.contextStatuses)
 
-	// bodyBytes, err := catcher.GetContent()
-	// if err != nil {
-	// 	log.Printf("Error loading content: %v", err)
+	bodyRewrite.metrics.UpstreamResponse(catcher.bodySize(), interception.upstreamDuration)
 
-	// 	if _, err := response.Write(catcher.GetBuffer().Bytes()); err != nil {
-	// 		log.Printf("unable to write error content: %v", err)
-	// 	}
+	span.SetAttributes(
+		tracing.Int("pretty_error.upstream.status_code", catcher.getCode()),
+		tracing.Int("pretty_error.upstream.body_size", catcher.bodySize()),
+		tracing.Int("pretty_error.upstream.duration_ms", int(interception.upstreamDuration.Milliseconds())),
+		tracing.Bool("pretty_error.replaced", interception.replaced()),
+	)
 
-	// 	return
-	// }
+	if !interception.replaced() {
+		bodyRewrite.metrics.Passthrough(catcher.getCode())
+	}
 
-	// // log.Printf("Body: %s", bodyBytes)
-	// catcher.SetContent(bodyBytes)
-	log.Printf("Status: %d", catcher.getCode())
+	bodyRewrite.finish(interception)
 }
 
-// CloseNotify returns a channel that receives at most a
-// single value (true) when the client connection has gone away.
-func (cc *codeCatcherWithCloseNotify) CloseNotify() <-chan bool {
-	if w, ok := cc.responseWriter.(http.CloseNotifier); ok {
-		return w.CloseNotify()
+// finish answer the intercepted request according to the outcome decided for its response.
+func (bodyRewrite *rewriteBody) finish(interception *interception) {
+	req, catcher := interception.req, interception.catcher
+
+	switch interception.outcome {
+	case outcomeInterrupt, outcomeBroken:
+		bodyRewrite.serveCaughtError(interception)
+	case outcomeTruncate:
+		bodyRewrite.reportTruncated(interception)
+	case outcomeObserve:
+		catcher.sendTrailers()
+
+		if bodyRewrite.catcherConfig.observeLimit > 0 {
+			bodyRewrite.logObserved(req, catcher)
+		}
+
+		if bodyRewrite.catcherConfig.dryRun {
+			bodyRewrite.dryRunPage(req, catcher)
+		}
+	case outcomeShadow:
+		catcher.sendTrailers()
+		bodyRewrite.dryRunRewrite(req, catcher)
+	case outcomeRewrite:
+		bodyRewrite.writeRewritten(interception.response, req, catcher)
+	case outcomePassthrough:
+		catcher.sendTrailers()
+	case outcomeReplace:
+		bodyRewrite.serveCaughtError(interception)
+
+		bodyRewrite.logger.Debug("error page served", logging.F("middleware", bodyRewrite.name),
+			logging.F("status", interception.status), logging.F("duration", time.Since(interception.startedAt)))
+	}
+}
+
+// tracePageServed describe the error page served on the span of req.
+func tracePageServed(req *http.Request, status int, metadata htmltemplates.Metadata, page renderedPage) {
+	templateName := metadata.OutputFormat
+	if metadata.OutputFormat == httputil.OutputFormatHTML {
+		templateName += "/" + metadata.Theme
+	}
+
+	attributes := []tracing.Attribute{
+		tracing.Int("http.response.status_code", status),
+		tracing.Int("pretty_error.page.size", len(page.body)),
+		tracing.String("pretty_error.page.template", templateName),
+		tracing.String("pretty_error.page.source", page.source),
+	}
+
+	span := tracing.SpanFromContext(req.Context())
+	span.SetAttributes(attributes...)
+	span.AddEvent("pretty_error.page_served", attributes...)
+}
+
+// newLogger get the logger writing messages of the configured level, info by default.
+func newLogger(config *Config, v *validator) logging.Logger {
+	if config.LogLevel == "" {
+		return logging.WithLevel(logging.LevelInfo)
+	}
+
+	level, err := logging.ParseLevel(config.LogLevel)
+	v.check("logLevel", err)
+
+	return logging.WithLevel(level)
+}
+
+// serveCaughtError replace the response held back by the catcher of interception with the error page of its status.
+func (bodyRewrite *rewriteBody) serveCaughtError(interception *interception) {
+	response, req, catcher, status := interception.response, interception.req, interception.catcher, interception.status
+
+	bodyRewrite.preserveHeaders(response, catcher.Header())
+	httputil.DeleteMatchingHeaders(response.Header(), bodyRewrite.catcherConfig.stripHeaders)
+	bodyRewrite.cors.apply(response.Header(), req)
+	bodyRewrite.marker.set(response.Header())
+	bodyRewrite.setResponseHeaders(response.Header())
+	bodyRewrite.recentErrors.record(req, catcher.getCode(), catcher.getBuffer().Bytes())
+
+	detail := bodyRewrite.statusDetail(catcher.Header())
+
+	upstreamBody := bodyRewrite.upstreamBody(catcher)
+
+	servedStatus, written := bodyRewrite.serveErrorPage(response, req, status, detail, upstreamBody)
+	bodyRewrite.notifier.observe(status)
+
+	// the same identifier goes to the reporters and to the access log, for their records to be correlated.
+	reference := referenceID(req)
+	bodyRewrite.report(req, reference, catcher.getCode(), status, detail)
+
+	entry := newAccessLogEntry(bodyRewrite.name, req, reference, catcher.getCode(), servedStatus, written)
+	if err := bodyRewrite.accessLog.record(entry); err != nil {
+		bodyRewrite.logger.Warn("unable to write access log", logging.F("error", err))
+	}
+}
+
+// serveErrorPage write the generated error response for status in the format negotiated with the client, with detail
+// replacing the standard message of the status when set, and upstreamBody the start of the replaced body, if kept.
+// It returns the status actually sent and the number of body bytes written.
+func (bodyRewrite *rewriteBody) serveErrorPage(
+	response http.ResponseWriter,
+	req *http.Request,
+	status int,
+	detail string,
+	upstreamBody string,
+) (int, int) {
+	markGenerated(req)
+
+	metadata := bodyRewrite.pageMetadata(req)
+	metadata.Detail = detail
+	metadata.UpstreamBody = upstreamBody
+
+	// the format and language of the page follow the request, caches must keep one variant for each.
+	httputil.AddVary(response.Header(), "Accept", "Accept-Language")
+
+	if selected, ok := findAction(bodyRewrite.actions, status); ok && metadata.OutputFormat == httputil.OutputFormatHTML {
+		selected.serve(response, req)
+
+		return http.StatusFound, 0
+	}
+
+	page, err := bodyRewrite.renderPage(req.Context(), status, metadata)
+	if err != nil {
+		bodyRewrite.logger.Error("unable to render error page, serving the minimal page", logging.F("status", status),
+			logging.F("error", err))
+		bodyRewrite.metrics.TemplateError()
+
+		page = minimalPage(status, metadata)
+	}
+
+	bodyRewrite.metrics.PageServed(status)
+	bodyRewrite.metrics.PageSource(page.source)
+	tracePageServed(req, status, metadata, page)
+
+	return bodyRewrite.writePage(response, req, status, metadata, page)
+}
+
+// negotiateLanguage get the configured language best matching the Accept-Language header of req, or its primary
+// language when none are configured.
+func (bodyRewrite *rewriteBody) negotiateLanguage(req *http.Request) string {
+	if len(bodyRewrite.languages) == 0 {
+		return httputil.PreferredLanguage(req)
+	}
+
+	return httputil.NegotiateLanguage(req.Header.Get("Accept-Language"), bodyRewrite.languages)
+}
+
+// pageMetadata get the metadata of the page generated for req.
+func (bodyRewrite *rewriteBody) pageMetadata(req *http.Request) htmltemplates.Metadata {
+	metadata := htmltemplates.Metadata{
+		OutputFormat: httputil.PreferredOutputFormat(req),
+		Language:     bodyRewrite.negotiateLanguage(req),
+		Theme:        bodyRewrite.theme,
+		Encoding:     bodyRewrite.compressor.negotiate(req),
+	}
+
+	nonce, err := newNonce()
+	if err != nil {
+		bodyRewrite.logger.Error("unable to generate nonce", logging.F("error", err))
+	}
+
+	metadata.Nonce = nonce
+	metadata.Offline = bodyRewrite.offline
+	metadata.AssetsPath = bodyRewrite.assets.path()
+	metadata.Middleware = bodyRewrite.name
+	metadata.Labels = bodyRewrite.labels
+	metadata.Request = newRequestInfo(req)
+
+	return metadata
+}
+
+// writePage send a rendered page with its headers, or 304 Not Modified when the client already holds it.
+// It returns the status actually sent and the number of body bytes written.
+func (bodyRewrite *rewriteBody) writePage(
+	response http.ResponseWriter,
+	req *http.Request,
+	status int,
+	metadata htmltemplates.Metadata,
+	page renderedPage,
+) (int, int) {
+	nonce := metadata.Nonce
+	if !page.nonced {
+		nonce = ""
+	}
+
+	body, err := bodyRewrite.compressor.compress(page.body, metadata.Encoding, !page.nonced)
+	if err != nil {
+		bodyRewrite.logger.Warn("unable to compress error page", logging.F("error", err))
+
+		body, metadata.Encoding = page.body, identityEncoding
+	}
+
+	response.Header().Set("Content-Type", page.contentType)
+	bodyRewrite.setSecurityHeaders(response.Header(), nonce)
+	bodyRewrite.setCacheHeaders(response.Header(), page.body, nonce)
+	bodyRewrite.compressor.setHeaders(response.Header(), metadata.Encoding)
+	httputil.SetBodyLength(response.Header(), len(body))
+
+	if isNotModified(req, response.Header()) {
+		writeNotModified(response)
+
+		return http.StatusNotModified, 0
+	}
+
+	response.WriteHeader(status)
+
+	if req.Method == http.MethodHead {
+		return status, 0
 	}
 
-	return make(<-chan bool)
+	written, err := response.Write(body)
+	if err != nil {
+		bodyRewrite.logger.Warn("unable to write error page", logging.F("error", err))
+	}
+
+	return status, written
 }
 
-func newCodeCatcher(responseWriter http.ResponseWriter, httpCodeRanges types.HTTPCodeRanges) responseInterceptor {
+//go:generate go run ./internal/wrappergen wrappers.go
+
+// newCodeCatcher create a codeCatcher implementing the same optional interfaces as responseWriter.
+func newCodeCatcher(responseWriter http.ResponseWriter, req *http.Request, config *catcherConfig) responseInterceptor {
 	catcher := &codeCatcher{
-		headerMap:      make(http.Header),
-		code:           http.StatusOK, // If backend does not call WriteHeader on us, we consider it's a 200.
+		core:           httputil.NewBareCodeCatcher(responseWriter, config.codeMatcher),
 		responseWriter: responseWriter,
-		httpCodeRanges: httpCodeRanges,
+		request:        req,
+		config:         config,
+		released:       make(chan struct{}),
+		replacing:      config.rollout.includes(req) && (!config.htmlOnly || httputil.AcceptsHTML(req)),
 	}
 
-	if _, ok := responseWriter.(http.CloseNotifier); ok {
-		return &codeCatcherWithCloseNotify{catcher}
+	catcher.core.SetDecider(catcher.decide)
+	catcher.core.SetBodyWriter(writerFunc(catcher.writeBody))
+
+	// the body of replaced responses is dropped, keeping only its start for the templates or the recent errors.
+	switch {
+	case config.upstreamBody:
+		catcher.core.SetFilteredBodyMode(httputil.BufferFilteredBody, 0)
+	case config.snippetSize > 0:
+		catcher.core.SetFilteredBodyMode(httputil.BufferFilteredBody, config.snippetSize)
+	default:
+		catcher.core.SetFilteredBodyMode(httputil.DropFilteredBody, 0)
 	}
 
-	return catcher
+	return wrapCodeCatcher(catcher)
 }
 
 func (cc *codeCatcher) Header() http.Header {
-	if cc.headerMap == nil {
-		cc.headerMap = make(http.Header)
-	}
-
-	return cc.headerMap
+	return cc.core.Header()
 }
 
 func (cc *codeCatcher) getCode() int {
-	return cc.code
+	return cc.core.GetCode()
 }
 
 // isFilteredCode returns whether the codeCatcher received a response code among the ones it is watching,
 // and for which the response should be deferred to the error handler.
 func (cc *codeCatcher) isFilteredCode() bool {
-	return cc.caughtFilteredCode
+	return cc.core.IsFilteredCode()
+}
+
+// isBuffering returns whether the codeCatcher holds back the response to have it rewritten.
+func (cc *codeCatcher) isBuffering() bool {
+	return cc.buffering
+}
+
+// headersWritten returns whether the headers were already sent to the original client.
+func (cc *codeCatcher) headersWritten() bool {
+	return cc.core.HeadersSent()
+}
+
+// bodySize returns the number of body bytes written by the upstream handler.
+func (cc *codeCatcher) bodySize() int {
+	return int(cc.core.BytesWritten())
+}
+
+// duration returns the time the upstream handler took from the creation of the codeCatcher to its last write.
+func (cc *codeCatcher) duration() time.Duration {
+	return cc.core.Duration()
+}
+
+// getBuffer get a pointer to the buffered response body.
+func (cc *codeCatcher) getBuffer() *bytes.Buffer {
+	return cc.core.GetBuffer()
 }
 
 func (cc *codeCatcher) Write(buf []byte) (int, error) {
-	// If WriteHeader was already called from the caller, this is a NOOP.
-	// Otherwise, cc.code is actually a 200 here.
-	cc.WriteHeader(cc.code)
+	if len(buf) > 0 {
+		cc.checkWrite()
+	}
+
+	written, err := cc.core.Write(buf)
+	cc.flushPending()
+
+	return written, err
+}
+
+// writeBody write the body of a response which was neither simply forwarded nor caught.
+func (cc *codeCatcher) writeBody(buf []byte) (int, error) {
+	if len(buf) > 0 {
+		cc.releaseEmptyBody()
+	}
 
-	if cc.caughtFilteredCode {
-		// We don't care about the contents of the response,
-		// since we want to serve the ones from the error page,
-		// so we just drop them.
+	if cc.holding {
 		return len(buf), nil
 	}
 
+	if cc.inspecting {
+		return cc.inspect(buf)
+	}
+
+	if cc.buffering || cc.stream != nil {
+		// stop buffering or rewriting a response the client went away from.
+		if err := cc.request.Context().Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	if cc.buffering {
+		return cc.getBuffer().Write(buf)
+	}
+
+	if cc.stream != nil {
+		return cc.stream.Write(buf)
+	}
+
+	if cc.observing {
+		cc.observe(buf)
+	}
+
+	if cc.shadowing {
+		cc.getBuffer().Write(buf)
+	}
+
 	return cc.responseWriter.Write(buf)
 }
 
 func (cc *codeCatcher) WriteHeader(code int) {
-	if cc.headersSent || cc.caughtFilteredCode {
-		return
+	cc.checkWriteHeader(code)
+	cc.core.WriteHeader(code)
+	cc.flushPending()
+}
+
+// decide what becomes of the final response of status code.
+func (cc *codeCatcher) decide(code int, header http.Header) (int, httputil.Decision) {
+	if status, failed := cc.catchGRPCFailure(); failed {
+		return status, httputil.CatchResponse
 	}
 
-	cc.code = code
-	for _, block := range cc.httpCodeRanges {
-		if cc.code >= block[0] && cc.code <= block[1] {
-			cc.caughtFilteredCode = true
-			// it will be up to the caller to send the headers,
-			// so it is out of our hands now.
-			return
+	if httputil.IsPassthroughStatus(code) || httputil.IsStreamingContentType(header.Get("Content-Type")) {
+		cc.stripHeaders()
+
+		return code, httputil.ForwardResponse
+	}
+
+	// the response generated by a nested instance is forwarded as is.
+	if isGenerated(cc.request) {
+		return code, cc.forward()
+	}
+
+	intercepted := cc.replacing &&
+		(cc.config.codeMatcher.Match(code) || matchesAnyHeader(cc.config.interceptHeaders, header))
+	if intercepted && httputil.MatchesContentType(header.Get("Content-Type"), cc.config.contentTypes) {
+		if cc.config.observeLimit > 0 || cc.config.dryRun {
+			cc.startObserving()
+
+			return code, httputil.DeferResponse
 		}
+
+		// it will be up to the caller to send the headers,
+		// so it is out of our hands now.
+		return code, httputil.CatchResponse
+	}
+
+	if cc.inspectsBody(code, header) {
+		cc.inspecting = true
+
+		return code, httputil.DeferResponse
 	}
 
-	httputil.CopyHeaders(cc.responseWriter.Header(), cc.Header())
-	cc.responseWriter.WriteHeader(cc.code)
-	cc.headersSent = true
+	if cc.holdsEmptyBody(code) {
+		cc.holding = true
+
+		return code, httputil.DeferResponse
+	}
+
+	return code, cc.forward()
 }
 
-// Hijack hijacks the connection.
-func (cc *codeCatcher) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	if hj, ok := cc.responseWriter.(http.Hijacker); ok {
-		return hj.Hijack()
+// forward send a response which is not replaced, buffering or streaming it when rewrites apply, in which case its body
+// is deferred to writeBody.
+func (cc *codeCatcher) forward() httputil.Decision {
+	if cc.config.shouldBuffer(cc.getCode(), cc.Header()) {
+		if cc.config.dryRun {
+			cc.startShadowing()
+
+			return httputil.DeferResponse
+		}
+
+		if cc.config.canStream(cc.getCode(), cc.Header()) {
+			cc.startStream()
+
+			return httputil.DeferResponse
+		}
+
+		// the caller sends the headers along with the rewritten body.
+		cc.buffering = true
+
+		return httputil.DeferResponse
 	}
 
-	return nil, nil, fmt.Errorf("%T is not a http.Hijacker", cc.responseWriter)
+	cc.stripHeaders()
+
+	return httputil.ForwardResponse
 }
 
-// Flush sends any buffered data to the client.
-func (cc *codeCatcher) Flush() {
+// stripHeaders remove the configured headers from error responses, such as server details.
+func (cc *codeCatcher) stripHeaders() {
+	if cc.getCode() >= http.StatusBadRequest {
+		httputil.DeleteMatchingHeaders(cc.Header(), cc.config.stripHeaders)
+		httputil.DeleteMatchingHeaders(cc.responseWriter.Header(), cc.config.stripHeaders)
+	}
+}
+
+// sendHeaders forward the headers and status code to the original client.
+func (cc *codeCatcher) sendHeaders() {
+	cc.stripHeaders()
+	cc.core.SendHeaders()
+}
+
+// sendTrailers replay the trailers the upstream set after its body on the wrapped writer.
+// The trailers of replaced responses are dropped along with their body.
+func (cc *codeCatcher) sendTrailers() {
+	if !cc.core.HeadersSent() || cc.core.IsFilteredCode() {
+		return
+	}
+
+	httputil.CopyTrailers(cc.responseWriter.Header(), cc.Header())
+}
+
+// closeNotify returns a channel that receives at most a
+// single value (true) when the client connection has gone away.
+// It observes the http.CloseNotifier of the wrapped writer along with the request context, until the upstream
+// handler returned. Every call returns the same channel.
+func (cc *codeCatcher) closeNotify() <-chan bool {
+	if cc.closed != nil {
+		return cc.closed
+	}
+
+	closed := make(chan bool, 1)
+	gone := cc.responseWriter.(http.CloseNotifier).CloseNotify()
+
+	go func() {
+		select {
+		case <-gone:
+		case <-cc.request.Context().Done():
+		case <-cc.released:
+			return
+		}
+
+		closed <- true
+	}()
+
+	cc.closed = closed
+
+	return closed
+}
+
+// release end the observation of the client connection, once the upstream handler returned.
+func (cc *codeCatcher) release() {
+	close(cc.released)
+}
+
+// hijack hijacks the connection, only exposed by the wrappers when the wrapped writer is a http.Hijacker.
+func (cc *codeCatcher) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return cc.responseWriter.(http.Hijacker).Hijack()
+}
+
+// Unwrap get the wrapped writer, for http.ResponseController to reach the methods the catcher does not implement.
+func (cc *codeCatcher) Unwrap() http.ResponseWriter {
+	return cc.responseWriter
+}
+
+// readFrom copy a passthrough body with the io.ReaderFrom of the wrapped writer, allowing the sendfile fast path.
+// Bodies which are dropped, buffered, streamed or observed still go through Write.
+func (cc *codeCatcher) readFrom(reader io.Reader) (int64, error) {
+	cc.checkWrite()
+
+	written, err := cc.core.CopyFrom(reader)
+	cc.flushPending()
+
+	return written, err
+}
+
+// writerFunc adapts a function to io.Writer.
+type writerFunc func(buf []byte) (int, error)
+
+func (write writerFunc) Write(buf []byte) (int, error) {
+	return write(buf)
+}
+
+// push initiates an HTTP/2 server push, only exposed by the wrappers when the wrapped writer is a http.Pusher.
+func (cc *codeCatcher) push(target string, opts *http.PushOptions) error {
+	return cc.responseWriter.(http.Pusher).Push(target, opts)
+}
+
+// flush sends any buffered data to the client, according to the flush policy.
+func (cc *codeCatcher) flush() {
+	switch cc.config.flushPolicy {
+	case flushDisabled:
+		return
+	case flushDelayed:
+		if !cc.decided() {
+			cc.pendingFlush = true
+
+			return
+		}
+	}
+
 	// If WriteHeader was already called from the caller, this is a NOOP.
-	// Otherwise, cc.code is actually a 200 here.
-	cc.WriteHeader(cc.code)
+	// Otherwise, the code is actually a 200 here.
+	cc.core.WriteHeader(cc.getCode())
+
+	// flushing commits to the upstream response, even before its body.
+	cc.releaseEmptyBody()
+	_ = cc.finishInspection()
+
+	// buffered and replaced responses are sent by the middleware once complete.
+	if !cc.core.HeadersSent() {
+		return
+	}
 
 	if flusher, ok := cc.responseWriter.(http.Flusher); ok {
 		flusher.Flush()
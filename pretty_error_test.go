@@ -1,5 +1,132 @@
 package pretty_error
 
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	stdhttputil "net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/packruler/pretty-error/compressutil"
+	"github.com/packruler/pretty-error/logging"
+	"github.com/packruler/pretty-error/metrics"
+	"github.com/packruler/pretty-error/tracing"
+	"github.com/packruler/pretty-error/types"
+)
+
+func TestServeErrorPage(t *testing.T) {
+	tests := []struct {
+		desc           string
+		accept         string
+		acceptLanguage string
+		languages      []string
+		theme          string
+		expContentType string
+		expContains    []string
+	}{
+		{
+			desc:           "should render html page by default",
+			expContentType: "text/html; charset=utf-8",
+			expContains:    []string{`lang="en"`, `class="theme-dark"`, "Service Unavailable"},
+		},
+		{
+			desc:           "should render html page with negotiated language and configured theme",
+			accept:         "text/html,application/json",
+			acceptLanguage: "fr-CA,fr;q=0.9",
+			theme:          "light",
+			expContentType: "text/html; charset=utf-8",
+			expContains:    []string{`lang="fr"`, `class="theme-light"`},
+		},
+		{
+			desc:           "should render html page in the accepted language with the highest quality",
+			acceptLanguage: "fr;q=0.5, de",
+			expContentType: "text/html; charset=utf-8",
+			expContains:    []string{`lang="de"`},
+		},
+		{
+			desc:           "should render html page in the best matching configured language",
+			acceptLanguage: "ja, de;q=0.8",
+			languages:      []string{"en", "de-CH"},
+			expContentType: "text/html; charset=utf-8",
+			expContains:    []string{`lang="de-CH"`},
+		},
+		{
+			desc:           "should render json envelope for json clients",
+			accept:         "application/json",
+			expContentType: "application/json; charset=utf-8",
+			expContains:    []string{`"outputFormat":"json"`, `"status":503`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				Status:    []string{"500-599"},
+				Theme:     test.theme,
+				Languages: test.languages,
+			}
+
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = responseWriter.Write([]byte("upstream body"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept", test.accept)
+			req.Header.Set("Accept-Language", test.acceptLanguage)
+
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != http.StatusServiceUnavailable {
+				t.Errorf("got status %d, want %d", recorder.Code, http.StatusServiceUnavailable)
+			}
+
+			if contentType := recorder.Header().Get("Content-Type"); contentType != test.expContentType {
+				t.Errorf("got content type %q, want %q", contentType, test.expContentType)
+			}
+
+			body := recorder.Body.String()
+			if strings.Contains(body, "upstream body") {
+				t.Errorf("upstream body was not replaced: %s", body)
+			}
+
+			for _, expected := range test.expContains {
+				if !strings.Contains(body, expected) {
+					t.Errorf("expected %q in body: %s", expected, body)
+				}
+			}
+
+			if test.accept == "application/json" && !json.Valid(recorder.Body.Bytes()) {
+				t.Errorf("expected valid json body: %s", body)
+			}
+		})
+	}
+}
+
 // import (
 // 	"bytes"
 // 	"context"
@@ -12,6 +139,4621 @@ package pretty_error
 // 	"github.com/packruler/rewrite-body/compressutil"
 // )
 
+func ExampleNewReverseProxy() {
+	backend := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, req *http.Request) {
+		http.Error(response, "stack trace nobody should see", http.StatusInternalServerError)
+	}))
+	defer backend.Close()
+
+	target, _ := url.Parse(backend.URL)
+
+	proxy, err := NewReverseProxy(target, &Config{Status: []string{"500-599"}})
+	if err != nil {
+		panic(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	proxy.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	fmt.Println(recorder.Code)
+	fmt.Println(strings.Contains(recorder.Body.String(), "Internal Server Error"))
+	fmt.Println(strings.Contains(recorder.Body.String(), "stack trace"))
+	// Output:
+	// 500
+	// true
+	// false
+}
+
+func TestReverseProxyTransportError(t *testing.T) {
+	backend := httptest.NewServer(http.NotFoundHandler())
+	target, _ := url.Parse(backend.URL)
+	backend.Close()
+
+	proxy, err := NewReverseProxy(target, &Config{Status: []string{"502"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	proxy.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if recorder.Code != http.StatusBadGateway {
+		t.Errorf("got status %d, want %d", recorder.Code, http.StatusBadGateway)
+	}
+
+	if !strings.Contains(recorder.Body.String(), "Bad Gateway") {
+		t.Errorf("expected rendered 502 page, got: %s", recorder.Body.String())
+	}
+}
+
+func TestRedirectAction(t *testing.T) {
+	config := &Config{
+		Status: []string{"401", "500-599"},
+		Actions: []Action{
+			{
+				Status:   []string{"401"},
+				Redirect: "https://login.example.com?rd={url}",
+			},
+		},
+	}
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusUnauthorized)
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc        string
+		accept      string
+		expStatus   int
+		expLocation string
+	}{
+		{
+			desc:        "should redirect browsers",
+			accept:      "text/html",
+			expStatus:   http.StatusFound,
+			expLocation: "https://login.example.com?rd=http%3A%2F%2Fexample.com%2Fprivate%3Fa%3Db",
+		},
+		{
+			desc:      "should render json for api clients",
+			accept:    "application/json",
+			expStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/private?a=b", nil)
+			req.Header.Set("Accept", test.accept)
+
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != test.expStatus {
+				t.Errorf("got status %d, want %d", recorder.Code, test.expStatus)
+			}
+
+			if location := recorder.Header().Get("Location"); location != test.expLocation {
+				t.Errorf("got location %q, want %q", location, test.expLocation)
+			}
+		})
+	}
+}
+
+func TestNewActionErrors(t *testing.T) {
+	actions := [][]Action{
+		{{Status: []string{"40x"}, Redirect: "https://example.com"}},
+		{{Status: []string{"401"}}},
+	}
+
+	for _, action := range actions {
+		if _, err := New(context.Background(), nil, &Config{Actions: action}, "prettyError"); err == nil {
+			t.Errorf("expected error for action %+v", action)
+		}
+	}
+}
+
+func TestPreserveHeaders(t *testing.T) {
+	tests := []struct {
+		desc            string
+		preserveHeaders []string
+		expPreserved    []string
+		expDropped      []string
+	}{
+		{
+			desc:         "should preserve auth, cors, retry and deprecation headers by default",
+			expPreserved: []string{"WWW-Authenticate", "Access-Control-Allow-Origin", "Retry-After", "Sunset"},
+			expDropped:   []string{"X-Backend"},
+		},
+		{
+			desc:            "should only preserve configured headers",
+			preserveHeaders: []string{"X-Backend"},
+			expPreserved:    []string{"X-Backend"},
+			expDropped:      []string{"WWW-Authenticate", "Access-Control-Allow-Origin", "Retry-After", "Sunset"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				Status:          []string{"401"},
+				PreserveHeaders: test.preserveHeaders,
+			}
+
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("WWW-Authenticate", `Basic realm="example"`)
+				responseWriter.Header().Set("Access-Control-Allow-Origin", "https://app.example.com")
+				responseWriter.Header().Set("Retry-After", "120")
+				responseWriter.Header().Set("Sunset", "Sat, 31 Oct 2026 23:59:59 GMT")
+				responseWriter.Header().Set("X-Backend", "app-1")
+				responseWriter.WriteHeader(http.StatusUnauthorized)
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			for _, name := range test.expPreserved {
+				if recorder.Header().Get(name) == "" {
+					t.Errorf("expected header %s to be preserved", name)
+				}
+			}
+
+			for _, name := range test.expDropped {
+				if recorder.Header().Get(name) != "" {
+					t.Errorf("expected header %s to be dropped", name)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSHeaders(t *testing.T) {
+	tests := []struct {
+		desc          string
+		origin        string
+		upstreamAllow string
+		expAllow      string
+	}{
+		{
+			desc:     "should synthesize cors headers for allowed origin",
+			origin:   "https://app.example.com",
+			expAllow: "https://app.example.com",
+		},
+		{
+			desc:   "should not synthesize cors headers for other origins",
+			origin: "https://evil.example.com",
+		},
+		{
+			desc:          "should keep upstream cors headers",
+			origin:        "https://app.example.com",
+			upstreamAllow: "*",
+			expAllow:      "*",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				Status: []string{"500-599"},
+				CORS: &CORS{
+					AllowOrigins:     []string{"https://app.example.com"},
+					AllowCredentials: true,
+				},
+			}
+
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				if test.upstreamAllow != "" {
+					responseWriter.Header().Set("Access-Control-Allow-Origin", test.upstreamAllow)
+				}
+
+				responseWriter.WriteHeader(http.StatusBadGateway)
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Origin", test.origin)
+
+			handler.ServeHTTP(recorder, req)
+
+			if allow := recorder.Header().Get("Access-Control-Allow-Origin"); allow != test.expAllow {
+				t.Errorf("got Access-Control-Allow-Origin %q, want %q", allow, test.expAllow)
+			}
+		})
+	}
+}
+
+func TestResponseHeaders(t *testing.T) {
+	config := &Config{
+		Status: []string{"404"},
+		ResponseHeaders: map[string]string{
+			"Cache-Control": "no-store",
+			"X-Error-Page":  "true",
+		},
+	}
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.Header().Set("Cache-Control", "max-age=3600")
+		responseWriter.WriteHeader(http.StatusNotFound)
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	for name, value := range config.ResponseHeaders {
+		if got := recorder.Header().Get(name); got != value {
+			t.Errorf("got header %s %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestCacheHeaders(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusNotFound)
+	}
+
+	t.Run("should not store pages by default", func(t *testing.T) {
+		handler, err := New(context.Background(), http.HandlerFunc(next), &Config{Status: []string{"404"}}, "prettyError")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if cacheControl := recorder.Header().Get("Cache-Control"); cacheControl != "no-store" {
+			t.Errorf("got Cache-Control %q, want %q", cacheControl, "no-store")
+		}
+
+		if etag := recorder.Header().Get("ETag"); etag != "" {
+			t.Errorf("expected no ETag, got %q", etag)
+		}
+	})
+
+	t.Run("should revalidate cached pages", func(t *testing.T) {
+		config := &Config{
+			Status:          []string{"404"},
+			CacheMaxAge:     30,
+			CacheValidators: true,
+		}
+
+		handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if cacheControl := recorder.Header().Get("Cache-Control"); cacheControl != "public, max-age=30" {
+			t.Errorf("got Cache-Control %q, want %q", cacheControl, "public, max-age=30")
+		}
+
+		etag := recorder.Header().Get("ETag")
+		if etag == "" {
+			t.Fatal("expected an ETag header")
+		}
+
+		if !strings.HasPrefix(etag, `W/"`) {
+			t.Errorf("got ETag %q, want a weak ETag for the nonced page", etag)
+		}
+
+		recorder = httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("If-None-Match", etag)
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusNotModified {
+			t.Errorf("got status %d, want %d", recorder.Code, http.StatusNotModified)
+		}
+
+		if recorder.Body.Len() != 0 {
+			t.Errorf("expected empty body, got %q", recorder.Body.String())
+		}
+	})
+
+	t.Run("should not revalidate the stored resource", func(t *testing.T) {
+		config := &Config{
+			Status:          []string{"404"},
+			CacheMaxAge:     30,
+			CacheValidators: true,
+		}
+
+		handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for name, value := range map[string]string{
+			"If-Modified-Since": time.Now().Add(time.Hour).UTC().Format(http.TimeFormat),
+			"If-None-Match":     "*",
+		} {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(name, value)
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != http.StatusNotFound || recorder.Body.Len() == 0 {
+				t.Errorf("%s: got status %d, want the error page", name, recorder.Code)
+			}
+
+			if lastModified := recorder.Header().Get("Last-Modified"); lastModified != "" {
+				t.Errorf("expected no Last-Modified, got %q", lastModified)
+			}
+		}
+	})
+}
+
+func TestHideServerHeaders(t *testing.T) {
+	tests := []struct {
+		desc      string
+		status    int
+		expHidden bool
+	}{
+		{
+			desc:      "should hide headers on generated pages",
+			status:    http.StatusInternalServerError,
+			expHidden: true,
+		},
+		{
+			desc:      "should hide headers on passed through errors",
+			status:    http.StatusNotFound,
+			expHidden: true,
+		},
+		{
+			desc:   "should keep headers on successful responses",
+			status: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				Status:            []string{"500-599"},
+				HideServerHeaders: true,
+				PreserveHeaders:   []string{"Server"},
+			}
+
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Server", "Apache/2.4.1")
+				responseWriter.Header().Set("X-Powered-By", "PHP/5.6")
+				responseWriter.WriteHeader(test.status)
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			for _, name := range []string{"Server", "X-Powered-By"} {
+				if hidden := recorder.Header().Get(name) == ""; hidden != test.expHidden {
+					t.Errorf("got header %s hidden %v, want %v", name, hidden, test.expHidden)
+				}
+			}
+		})
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusInternalServerError)
+	}
+
+	t.Run("should set nonced security headers by default", func(t *testing.T) {
+		handler, err := New(context.Background(), http.HandlerFunc(next), &Config{Status: []string{"500"}}, "prettyError")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if recorder.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Error("expected X-Content-Type-Options: nosniff")
+		}
+
+		csp := recorder.Header().Get("Content-Security-Policy")
+		start := strings.Index(csp, "'nonce-")
+		if start < 0 {
+			t.Fatalf("expected nonce in Content-Security-Policy: %s", csp)
+		}
+
+		nonce := strings.SplitN(csp[start+len("'nonce-"):], "'", 2)[0]
+		if !strings.Contains(recorder.Body.String(), `<style nonce="`+nonce+`">`) {
+			t.Errorf("expected page to use nonce %q", nonce)
+		}
+	})
+
+	t.Run("should allow overriding and disabling security headers", func(t *testing.T) {
+		config := &Config{
+			Status: []string{"500"},
+			SecurityHeaders: map[string]string{
+				"content-security-policy": "",
+				"Referrer-Policy":         "same-origin",
+			},
+		}
+
+		handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if csp := recorder.Header().Get("Content-Security-Policy"); csp != "" {
+			t.Errorf("expected no Content-Security-Policy, got %q", csp)
+		}
+
+		if policy := recorder.Header().Get("Referrer-Policy"); policy != "same-origin" {
+			t.Errorf("got Referrer-Policy %q, want %q", policy, "same-origin")
+		}
+	})
+}
+
+func TestOffline(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusInternalServerError)
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), &Config{Status: []string{"500"}, Offline: true}, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if strings.Contains(recorder.Body.String(), "<script") {
+		t.Errorf("expected no script in offline page: %s", recorder.Body.String())
+	}
+
+	if csp := recorder.Header().Get("Content-Security-Policy"); strings.Contains(csp, "https://") {
+		t.Errorf("expected offline Content-Security-Policy, got %q", csp)
+	}
+}
+
+func TestOfflineChecks(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusInternalServerError)
+	}
+
+	tests := []struct {
+		desc        string
+		config      *Config
+		templates   fstest.MapFS
+		expProblems []string
+	}{
+		{
+			desc:        "external resources",
+			config:      &Config{Banner: &Banner{Snippet: `<img src="//cdn.example.com/notice.png">`}},
+			templates:   fstest.MapFS{"500.html": {Data: []byte(`<img srcset="a.png 1x, https://cdn.example.com/b.png 2x">`)}},
+			expProblems: []string{"banner.snippet: ", "templates.500.html: "},
+		},
+		{
+			desc:   "layout",
+			config: &Config{},
+			templates: fstest.MapFS{
+				"layout.html": {Data: []byte(`<link href="https://fonts.example.com/css" rel="stylesheet">{{ template "content" . }}`)},
+				"pages.html":  {Data: []byte(`{{ define "content" }}<p>{{ .Status }}</p>{{ end }}`)},
+			},
+			expProblems: []string{"templates.error.html: "},
+		},
+		{
+			desc:        "service",
+			config:      &Config{Service: &ErrorService{URL: "http://errors.internal/{status}"}},
+			expProblems: []string{"service: "},
+		},
+		{
+			desc:      "URL in the text",
+			config:    &Config{},
+			templates: fstest.MapFS{"500.html": {Data: []byte(`<p>See https://status.example.com for updates.</p>`)}},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.desc, func(t *testing.T) {
+			test.config.Offline = true
+
+			var opts []Option
+			if test.templates != nil {
+				opts = append(opts, WithTemplates(test.templates))
+			}
+
+			_, err := NewWithOptions(context.Background(), http.HandlerFunc(next), test.config, "offline", opts...)
+			if len(test.expProblems) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+
+				return
+			}
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) || len(validationErr.Problems) != len(test.expProblems) {
+				t.Fatalf("expected %d problems, got %v", len(test.expProblems), err)
+			}
+
+			for index, problem := range validationErr.Problems {
+				if !strings.HasPrefix(problem, test.expProblems[index]) {
+					t.Errorf("got problem %q, want %q", problem, test.expProblems[index])
+				}
+			}
+		})
+	}
+}
+
+func TestPathMatchers(t *testing.T) {
+	tests := []struct {
+		desc        string
+		include     []string
+		exclude     []string
+		path        string
+		expReplaced bool
+	}{
+		{
+			desc:        "should replace any path by default",
+			path:        "/app",
+			expReplaced: true,
+		},
+		{
+			desc:    "should skip excluded glob",
+			exclude: []string{"/healthz", "/metrics/**"},
+			path:    "/metrics/node",
+		},
+		{
+			desc:    "should skip excluded regex",
+			exclude: []string{`regex:\.(css|js)$`},
+			path:    "/static/app.js",
+		},
+		{
+			desc:    "should skip paths not included",
+			include: []string{"/app/*"},
+			path:    "/api/users",
+		},
+		{
+			desc:        "should replace included paths",
+			include:     []string{"/app/*"},
+			path:        "/app/users",
+			expReplaced: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				Status:       []string{"500"},
+				IncludePaths: test.include,
+				ExcludePaths: test.exclude,
+			}
+
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.WriteHeader(http.StatusInternalServerError)
+				_, _ = responseWriter.Write([]byte("upstream body"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+			if replaced := recorder.Body.String() != "upstream body"; replaced != test.expReplaced {
+				t.Errorf("got replaced %v, want %v", replaced, test.expReplaced)
+			}
+		})
+	}
+}
+
+func TestPathMatchersErrors(t *testing.T) {
+	for _, config := range []*Config{
+		{IncludePaths: []string{"regex:("}},
+		{ExcludePaths: []string{"/[a-"}},
+	} {
+		if _, err := New(context.Background(), nil, config, "prettyError"); err == nil {
+			t.Errorf("expected error for config %+v", config)
+		}
+	}
+}
+
+func TestSkipHeaders(t *testing.T) {
+	config := &Config{
+		Status: []string{"500"},
+		SkipHeaders: []HeaderMatcher{
+			{Name: "x-no-pretty-error", Value: "1"},
+			{Name: "Authorization"},
+			{Name: "User-Agent", Value: "regex:^curl/"},
+		},
+	}
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusInternalServerError)
+		_, _ = responseWriter.Write([]byte("upstream body"))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc        string
+		header      http.Header
+		expReplaced bool
+	}{
+		{
+			desc:        "should replace without matching headers",
+			header:      http.Header{"X-No-Pretty-Error": {"0"}, "User-Agent": {"Mozilla/5.0"}},
+			expReplaced: true,
+		},
+		{
+			desc:   "should skip on matching header value",
+			header: http.Header{"X-No-Pretty-Error": {"1"}},
+		},
+		{
+			desc:   "should skip on present header",
+			header: http.Header{"Authorization": {"Bearer token"}},
+		},
+		{
+			desc:   "should skip on matching header regex",
+			header: http.Header{"User-Agent": {"curl/8.0"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header = test.header
+
+			handler.ServeHTTP(recorder, req)
+
+			if replaced := recorder.Body.String() != "upstream body"; replaced != test.expReplaced {
+				t.Errorf("got replaced %v, want %v", replaced, test.expReplaced)
+			}
+		})
+	}
+}
+
+func TestBypassCookie(t *testing.T) {
+	config := &Config{
+		Status:       []string{"500"},
+		BypassCookie: "pretty_error=off",
+	}
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusInternalServerError)
+		_, _ = responseWriter.Write([]byte("upstream body"))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc        string
+		cookie      *http.Cookie
+		expReplaced bool
+	}{
+		{
+			desc:        "should replace without cookie",
+			expReplaced: true,
+		},
+		{
+			desc:        "should replace with other cookie value",
+			cookie:      &http.Cookie{Name: "pretty_error", Value: "on"},
+			expReplaced: true,
+		},
+		{
+			desc:   "should bypass with cookie",
+			cookie: &http.Cookie{Name: "pretty_error", Value: "off"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			if test.cookie != nil {
+				req.AddCookie(test.cookie)
+			}
+
+			handler.ServeHTTP(recorder, req)
+
+			if replaced := recorder.Body.String() != "upstream body"; replaced != test.expReplaced {
+				t.Errorf("got replaced %v, want %v", replaced, test.expReplaced)
+			}
+		})
+	}
+}
+
+func TestMethods(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusInternalServerError)
+		_, _ = responseWriter.Write([]byte("upstream body"))
+	}
+
+	tests := []struct {
+		desc           string
+		methods        []string
+		method         string
+		expBody        bool
+		expContentType string
+	}{
+		{
+			desc:           "should render body for GET",
+			method:         http.MethodGet,
+			expBody:        true,
+			expContentType: "text/html; charset=utf-8",
+		},
+		{
+			desc:           "should only send headers for HEAD",
+			method:         http.MethodHead,
+			expContentType: "text/html; charset=utf-8",
+		},
+		{
+			desc:    "should pass through POST by default",
+			method:  http.MethodPost,
+			expBody: true,
+		},
+		{
+			desc:           "should render body for configured POST",
+			methods:        []string{"GET", "POST"},
+			method:         http.MethodPost,
+			expBody:        true,
+			expContentType: "text/html; charset=utf-8",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				Status:  []string{"500"},
+				Methods: test.methods,
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(test.method, "/", nil))
+
+			if hasBody := recorder.Body.Len() > 0; hasBody != test.expBody {
+				t.Errorf("got body %v, want %v", hasBody, test.expBody)
+			}
+
+			if contentType := recorder.Header().Get("Content-Type"); contentType != test.expContentType {
+				t.Errorf("got content type %q, want %q", contentType, test.expContentType)
+			}
+		})
+	}
+}
+
+func TestStreamingResponses(t *testing.T) {
+	for _, contentType := range []string{"text/event-stream", "application/grpc"} {
+		t.Run(contentType, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", contentType)
+				responseWriter.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = responseWriter.Write([]byte("upstream body"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), &Config{Status: []string{"503"}}, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if recorder.Body.String() != "upstream body" {
+				t.Errorf("expected streaming response to pass through, got: %s", recorder.Body.String())
+			}
+
+			if recorder.Header().Get("Content-Type") != contentType {
+				t.Errorf("got content type %q, want %q", recorder.Header().Get("Content-Type"), contentType)
+			}
+		})
+	}
+}
+
+// interimRecorder records interim responses, which httptest.ResponseRecorder does not support on every Go version.
+type interimRecorder struct {
+	*httptest.ResponseRecorder
+
+	interim      []int
+	interimLinks []string
+}
+
+func (recorder *interimRecorder) WriteHeader(code int) {
+	if code >= 100 && code < 200 {
+		recorder.interim = append(recorder.interim, code)
+		recorder.interimLinks = append(recorder.interimLinks, recorder.Header().Get("Link"))
+
+		return
+	}
+
+	recorder.ResponseRecorder.WriteHeader(code)
+}
+
+func TestInformationalResponses(t *testing.T) {
+	tests := []struct {
+		desc        string
+		finalStatus int
+	}{
+		{desc: "should forward early hints before passed through responses", finalStatus: http.StatusOK},
+		{desc: "should forward early hints before replaced responses", finalStatus: http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Link", "</style.css>; rel=preload; as=style")
+				responseWriter.WriteHeader(http.StatusEarlyHints)
+				responseWriter.Header().Del("Link")
+				responseWriter.WriteHeader(test.finalStatus)
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), &Config{Status: []string{"500"}}, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := &interimRecorder{ResponseRecorder: httptest.NewRecorder()}
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if len(recorder.interim) != 1 || recorder.interim[0] != http.StatusEarlyHints {
+				t.Fatalf("got interim responses %v, want [103]", recorder.interim)
+			}
+
+			if recorder.interimLinks[0] == "" {
+				t.Error("expected Link header on early hints")
+			}
+
+			if recorder.Code != test.finalStatus {
+				t.Errorf("got status %d, want %d", recorder.Code, test.finalStatus)
+			}
+
+			if link := recorder.Header().Get("Link"); link != "" {
+				t.Errorf("expected no Link header on final response, got %q", link)
+			}
+		})
+	}
+}
+
+func TestRangeRequests(t *testing.T) {
+	tests := []struct {
+		desc   string
+		ranges string
+		status int
+	}{
+		{desc: "should pass through range requests", ranges: "bytes=0-10", status: http.StatusRequestedRangeNotSatisfiable},
+		{desc: "should pass through partial content", status: http.StatusPartialContent},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.WriteHeader(test.status)
+				_, _ = responseWriter.Write([]byte("upstream body"))
+			}
+
+			config := &Config{Status: []string{"200-599"}}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Range", test.ranges)
+
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != test.status || recorder.Body.String() != "upstream body" {
+				t.Errorf("expected untouched %d response, got %d: %s", test.status, recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	modTime := time.Date(2022, time.May, 14, 0, 0, 0, 0, time.UTC)
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.Header().Set("ETag", `"v1"`)
+		http.ServeContent(responseWriter, req, "index.html", modTime, strings.NewReader("<html>cached</html>"))
+	}
+
+	tests := []struct {
+		desc      string
+		header    http.Header
+		expStatus int
+		expBody   string
+	}{
+		{
+			desc:      "should serve content without conditions",
+			header:    http.Header{},
+			expStatus: http.StatusOK,
+			expBody:   "<html>cached</html>",
+		},
+		{
+			desc:      "should forward 304 for matching If-None-Match",
+			header:    http.Header{"If-None-Match": {`"v1"`}},
+			expStatus: http.StatusNotModified,
+		},
+		{
+			desc:      "should forward 304 for If-Modified-Since",
+			header:    http.Header{"If-Modified-Since": {modTime.Format(http.TimeFormat)}},
+			expStatus: http.StatusNotModified,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			handler, err := New(context.Background(), http.HandlerFunc(next), &Config{Status: []string{"300-599"}}, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header = test.header
+
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != test.expStatus {
+				t.Errorf("got status %d, want %d", recorder.Code, test.expStatus)
+			}
+
+			if recorder.Body.String() != test.expBody {
+				t.Errorf("got body %q, want %q", recorder.Body.String(), test.expBody)
+			}
+
+			if test.expStatus != http.StatusNotModified {
+				return
+			}
+
+			for _, name := range []string{"Content-Type", "Content-Length"} {
+				if value := recorder.Header().Get(name); value != "" {
+					t.Errorf("expected no %s on 304, got %q", name, value)
+				}
+			}
+		})
+	}
+}
+
+func TestNotModifiedWithBody(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusNotModified)
+		_, _ = responseWriter.Write([]byte("should not be sent"))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), &Config{Status: []string{"304"}}, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if recorder.Code != http.StatusNotModified || recorder.Body.Len() != 0 {
+		t.Errorf("expected empty 304, got %d: %q", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestNewWithCodeMatcher(t *testing.T) {
+	matcher := types.CodeMatcherFunc(func(status int) bool {
+		return status == http.StatusTeapot
+	})
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusTeapot)
+		_, _ = responseWriter.Write([]byte("upstream body"))
+	}
+
+	handler, err := NewWithCodeMatcher(context.Background(), http.HandlerFunc(next), CreateConfig(), "prettyError", matcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if !strings.Contains(recorder.Body.String(), "I&#39;m a teapot") {
+		t.Errorf("expected rendered 418 page, got: %s", recorder.Body.String())
+	}
+
+	if _, err := NewWithCodeMatcher(context.Background(), nil, CreateConfig(), "prettyError", nil); err == nil {
+		t.Error("expected error without code matcher")
+	}
+}
+
+func TestInterceptHeaders(t *testing.T) {
+	config := &Config{
+		Status: []string{"500"},
+		InterceptHeaders: []HeaderMatcher{
+			{Name: "X-Error", Value: "true"},
+		},
+	}
+
+	tests := []struct {
+		desc        string
+		errorHeader string
+		expReplaced bool
+	}{
+		{desc: "should intercept 200 with matching header", errorHeader: "true", expReplaced: true},
+		{desc: "should pass through 200 without matching header", errorHeader: "false"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("X-Error", test.errorHeader)
+				responseWriter.WriteHeader(http.StatusOK)
+				_, _ = responseWriter.Write([]byte("upstream body"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if replaced := recorder.Body.String() != "upstream body"; replaced != test.expReplaced {
+				t.Errorf("got replaced %v, want %v", replaced, test.expReplaced)
+			}
+		})
+	}
+}
+
+func TestContentTypes(t *testing.T) {
+	tests := []struct {
+		desc         string
+		contentTypes []string
+		contentType  string
+		expReplaced  bool
+	}{
+		{desc: "should replace html by default", contentType: "text/html", expReplaced: true},
+		{desc: "should leave json alone by default", contentType: "application/json"},
+		{desc: "should leave binary alone by default", contentType: "application/octet-stream"},
+		{
+			desc:         "should replace configured json",
+			contentTypes: []string{"application/json"},
+			contentType:  "application/json; charset=utf-8",
+			expReplaced:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", test.contentType)
+				responseWriter.WriteHeader(http.StatusInternalServerError)
+				_, _ = responseWriter.Write([]byte("upstream body"))
+			}
+
+			config := &Config{
+				Status:       []string{"500"},
+				ContentTypes: test.contentTypes,
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if replaced := recorder.Body.String() != "upstream body"; replaced != test.expReplaced {
+				t.Errorf("got replaced %v, want %v", replaced, test.expReplaced)
+			}
+		})
+	}
+}
+
+func TestDefaultStatus(t *testing.T) {
+	tests := []struct {
+		desc        string
+		config      *Config
+		status      int
+		expReplaced bool
+	}{
+		{desc: "should replace 5xx by default", config: &Config{}, status: http.StatusBadGateway, expReplaced: true},
+		{desc: "should not replace 4xx by default", config: &Config{}, status: http.StatusNotFound},
+		{desc: "should not replace when defaults are disabled", config: &Config{DisableDefaultStatus: true}, status: http.StatusBadGateway},
+		{
+			desc:        "should exclude from the defaults",
+			config:      &Config{Status: []string{"!503"}},
+			status:      http.StatusBadGateway,
+			expReplaced: true,
+		},
+		{desc: "should not replace excluded defaults", config: &Config{Status: []string{"!503"}}, status: http.StatusServiceUnavailable},
+		{desc: "should not extend exclusions to 4xx", config: &Config{Status: []string{"!503"}}, status: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.WriteHeader(test.status)
+				_, _ = responseWriter.Write([]byte("upstream body"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), test.config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if replaced := recorder.Body.String() != "upstream body"; replaced != test.expReplaced {
+				t.Errorf("got replaced %v, want %v", replaced, test.expReplaced)
+			}
+		})
+	}
+}
+
+func TestStatusExclusionsOnly(t *testing.T) {
+	_, err := New(context.Background(), nil, &Config{Status: []string{"!503"}, DisableDefaultStatus: true}, "prettyError")
+	if err == nil || !strings.Contains(err.Error(), "status: only holds exclusions") {
+		t.Errorf("expected exclusions without default status to be refused, got %v", err)
+	}
+
+	rewrites := newRewrites([]Rewrite{{Regex: "foo", Status: []string{"!503"}}}, &validator{})
+	if !rewrites[0].httpCodeRanges.Contains(http.StatusOK) || rewrites[0].httpCodeRanges.Contains(503) {
+		t.Errorf("expected the rewrite to apply to every status but 503, got %s", rewrites[0].httpCodeRanges)
+	}
+
+	banner := newBannerRewrites(&Banner{Snippet: "notice", Status: []string{"!404"}}, false, &validator{})
+	if !banner[0].httpCodeRanges.Contains(http.StatusOK) || banner[0].httpCodeRanges.Contains(http.StatusNotFound) {
+		t.Errorf("expected the banner on every status but 404, got %s", banner[0].httpCodeRanges)
+	}
+}
+
+func TestNewValidationError(t *testing.T) {
+	config := &Config{
+		Status:        []string{"500", "50x", "abc"},
+		Rewrites:      []Rewrite{{Regex: "foo", Replacement: "{{ .Status"}, {Regex: "*"}, {JSONPath: "error"}},
+		Actions:       []Action{{Status: []string{"401"}}},
+		ExcludePaths:  []string{"regex:("},
+		SkipHeaders:   []HeaderMatcher{{Value: "1"}},
+		RewriteBudget: "soon",
+		LogLevel:      "loud",
+	}
+
+	_, err := New(context.Background(), nil, config, "prettyError")
+
+	var validationError *ValidationError
+	if !errors.As(err, &validationError) {
+		t.Fatalf("expected *ValidationError, got %v", err)
+	}
+
+	expectedFields := []string{
+		"status[1]: ",
+		"status[2]: ",
+		"rewrites[0].replacement: ",
+		"rewrites[1].regex: ",
+		"rewrites[2].jsonPath: ",
+		"logLevel: ",
+		"rewriteBudget: ",
+		"actions[0].redirect: ",
+		"excludePaths[0]: ",
+		"skipHeaders[0].name: ",
+	}
+
+	if len(validationError.Problems) != len(expectedFields) {
+		t.Fatalf("got %d problems, want %d: %v", len(validationError.Problems), len(expectedFields), validationError.Problems)
+	}
+
+	for index, field := range expectedFields {
+		if !strings.HasPrefix(validationError.Problems[index], field) {
+			t.Errorf("got problem %q, want prefix %q", validationError.Problems[index], field)
+		}
+	}
+}
+
+func TestRewriteStatus(t *testing.T) {
+	tests := []struct {
+		desc            string
+		status          int
+		contentEncoding string
+		expBody         string
+	}{
+		{desc: "should rewrite matching status", status: http.StatusNotFound, expBody: "bar is missing"},
+		{desc: "should leave other status alone", status: http.StatusOK, expBody: "foo is missing"},
+		{
+			desc:            "should rewrite gzip body",
+			status:          http.StatusNotFound,
+			contentEncoding: "gzip",
+			expBody:         "bar is missing",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				body := []byte("foo is missing")
+				if test.contentEncoding != "" {
+					body, _ = compressutil.Encode(body, test.contentEncoding)
+					responseWriter.Header().Set("Content-Encoding", test.contentEncoding)
+				}
+
+				responseWriter.Header().Set("Content-Type", "text/plain")
+				responseWriter.WriteHeader(test.status)
+				_, _ = responseWriter.Write(body)
+			}
+
+			config := &Config{
+				Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar", Status: []string{"4xx"}}},
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if recorder.Code != test.status {
+				t.Errorf("got status %d, want %d", recorder.Code, test.status)
+			}
+
+			body, err := compressutil.Decode(recorder.Body, test.contentEncoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(body) != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+}
+
+func TestRewriteContentTypes(t *testing.T) {
+	tests := []struct {
+		desc         string
+		contentTypes []string
+		contentType  string
+		expBody      string
+	}{
+		{desc: "should rewrite text by default", contentType: "text/html", expBody: "bar"},
+		{desc: "should leave json alone by default", contentType: "application/json", expBody: "foo"},
+		{desc: "should rewrite configured type", contentTypes: []string{"text/html"}, contentType: "text/html", expBody: "bar"},
+		{desc: "should leave other types alone", contentTypes: []string{"text/html"}, contentType: "text/css", expBody: "foo"},
+		{
+			desc:         "should rewrite configured json",
+			contentTypes: []string{"application/json"},
+			contentType:  "application/json; charset=utf-8",
+			expBody:      "bar",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", test.contentType)
+				_, _ = responseWriter.Write([]byte("foo"))
+			}
+
+			config := &Config{
+				Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar", ContentTypes: test.contentTypes}},
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+}
+
+func TestRewriteTemplate(t *testing.T) {
+	tests := []struct {
+		desc        string
+		regex       string
+		replacement string
+		expBody     string
+	}{
+		{desc: "should keep plain replacements", regex: "internal-(\\w+)", replacement: "public-$1", expBody: "see public-host:8080"},
+		{desc: "should expand request fields", regex: "internal-host", replacement: "{{ .Request.Host }}", expBody: "see example.com:8080"},
+		{
+			desc:        "should expand named groups and status",
+			regex:       "internal-(?P<name>\\w+):(?P<port>\\d+)",
+			replacement: "{{ .Groups.name }}/{{ .Status }}/{{ .Groups.missing }}",
+			expBody:     "see host/404/",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/plain")
+				responseWriter.WriteHeader(http.StatusNotFound)
+				_, _ = responseWriter.Write([]byte("see internal-host:8080"))
+			}
+
+			config := &Config{
+				Rewrites: []Rewrite{{Regex: test.regex, Replacement: test.replacement}},
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+}
+
+func TestStreamRewrites(t *testing.T) {
+	tests := []struct {
+		desc    string
+		chunks  []string
+		window  int
+		expBody string
+	}{
+		{desc: "should rewrite within chunks", chunks: []string{"foo and ", "foo"}, expBody: "bar and bar"},
+		{desc: "should rewrite across chunks", chunks: []string{"a fo", "o b"}, window: 2, expBody: "a bar b"},
+		{desc: "should rewrite growing matches", chunks: []string{"xxx", "xxx", "y"}, window: 1, expBody: "zy"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/plain")
+				responseWriter.Header().Set("Content-Length", "100")
+
+				for _, chunk := range test.chunks {
+					_, _ = responseWriter.Write([]byte(chunk))
+					responseWriter.(http.Flusher).Flush()
+				}
+			}
+
+			config := &Config{
+				Rewrites:       []Rewrite{{Regex: "foo", Replacement: "bar"}, {Regex: "x+", Replacement: "z"}},
+				StreamRewrites: true,
+				StreamWindow:   test.window,
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+
+			if contentLength := recorder.Header().Get("Content-Length"); contentLength != "" {
+				t.Errorf("got Content-Length %q, want none", contentLength)
+			}
+		})
+	}
+}
+
+func TestRewriteLimits(t *testing.T) {
+	tests := []struct {
+		desc    string
+		config  *Config
+		expBody string
+	}{
+		{
+			desc:    "should limit replacements",
+			config:  &Config{Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar", MaxReplacements: 2}}},
+			expBody: "bar bar foo",
+		},
+		{
+			desc: "should limit replacements across streamed chunks",
+			config: &Config{
+				Rewrites:       []Rewrite{{Regex: "foo", Replacement: "bar", MaxReplacements: 2}},
+				StreamRewrites: true,
+				StreamWindow:   1,
+			},
+			expBody: "bar bar foo",
+		},
+		{
+			desc:    "should pass the original body once out of time",
+			config:  &Config{Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}, RewriteBudget: "1ns"},
+			expBody: "foo foo foo",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/plain")
+
+				for _, chunk := range []string{"foo ", "foo ", "foo"} {
+					_, _ = responseWriter.Write([]byte(chunk))
+				}
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), test.config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+}
+
+func TestRewriteJSONPath(t *testing.T) {
+	tests := []struct {
+		desc    string
+		rewrite Rewrite
+		expBody string
+	}{
+		{
+			desc:    "should replace the addressed value",
+			rewrite: Rewrite{JSONPath: "$.error.message", Replacement: "Something went wrong"},
+			expBody: `{"error":{"code":500,"message":"Something went wrong"},"trace":"db <internal-host>"}`,
+		},
+		{
+			desc:    "should rewrite matches within addressed values",
+			rewrite: Rewrite{JSONPath: "$.errors[*]", Regex: "internal-(\\w+)", Replacement: "$1"},
+			expBody: `{"errors":["host down","host slow"]}`,
+		},
+		{
+			desc:    "should limit replaced values",
+			rewrite: Rewrite{JSONPath: "$.errors[*]", Replacement: "hidden", MaxReplacements: 1},
+			expBody: `{"errors":["hidden","internal-host slow"]}`,
+		},
+		{
+			desc:    "should ignore missing values",
+			rewrite: Rewrite{JSONPath: "$.missing[0].message", Replacement: "hidden"},
+			expBody: `{"errors":["internal-host down","internal-host slow"]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", "application/json")
+				responseWriter.WriteHeader(http.StatusBadRequest)
+
+				if strings.Contains(test.expBody, "trace") {
+					_, _ = responseWriter.Write([]byte(`{"trace": "db <internal-host>", "error": {"message": "db down", "code": 500}}`))
+				} else {
+					_, _ = responseWriter.Write([]byte(`{"errors": ["internal-host down", "internal-host slow"]}`))
+				}
+			}
+
+			config := &Config{Rewrites: []Rewrite{test.rewrite}}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %s, want %s", body, test.expBody)
+			}
+		})
+	}
+}
+
+func TestBanner(t *testing.T) {
+	tests := []struct {
+		desc    string
+		banner  *Banner
+		header  string
+		expBody string
+	}{
+		{
+			desc:    "should insert before body end",
+			banner:  &Banner{Snippet: `<p>We're having issues, $5 refunds soon</p>`},
+			expBody: `<html><BODY>ok<p>We're having issues, $5 refunds soon</p></BODY></html>`,
+		},
+		{
+			desc:    "should insert before configured tag",
+			banner:  &Banner{Snippet: "<p>notice</p>", Before: "<html>"},
+			expBody: `<p>notice</p><html><BODY>ok</BODY></html>`,
+		},
+		{
+			desc:    "should leave other status alone",
+			banner:  &Banner{Snippet: "<p>notice</p>", Status: []string{"5xx"}},
+			expBody: `<html><BODY>ok</BODY></html>`,
+		},
+		{
+			desc:    "should insert on matching header",
+			banner:  &Banner{Snippet: "<p>notice</p>", Headers: []HeaderMatcher{{Name: "X-Degraded"}}},
+			header:  "X-Degraded",
+			expBody: `<html><BODY>ok<p>notice</p></BODY></html>`,
+		},
+		{
+			desc:    "should leave pages without header alone",
+			banner:  &Banner{Snippet: "<p>notice</p>", Headers: []HeaderMatcher{{Name: "X-Degraded"}}},
+			expBody: `<html><BODY>ok</BODY></html>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				if test.header != "" {
+					responseWriter.Header().Set(test.header, "true")
+				}
+
+				responseWriter.Header().Set("Content-Type", "text/html; charset=utf-8")
+				_, _ = responseWriter.Write([]byte(`<html><BODY>ok</BODY></html>`))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), &Config{Banner: test.banner}, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %s, want %s", body, test.expBody)
+			}
+		})
+	}
+}
+
+func TestReplacementFile(t *testing.T) {
+	dir := t.TempDir()
+
+	replacementFile := filepath.Join(dir, "replacement.html")
+	if err := os.WriteFile(replacementFile, []byte("<p>{{ .Status }}</p>"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	snippetFile := filepath.Join(dir, "snippet.html")
+	if err := os.WriteFile(snippetFile, []byte("<p>notice</p>"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.Header().Set("Content-Type", "text/html")
+		responseWriter.WriteHeader(http.StatusNotFound)
+		_, _ = responseWriter.Write([]byte("<body>foo</body>"))
+	}
+
+	config := &Config{
+		Rewrites: []Rewrite{{Regex: "foo", ReplacementFile: replacementFile}},
+		Banner:   &Banner{SnippetFile: snippetFile},
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if expBody := "<body><p>404</p><p>notice</p></body>"; recorder.Body.String() != expBody {
+		t.Errorf("got body %s, want %s", recorder.Body.String(), expBody)
+	}
+
+	config = &Config{
+		Rewrites: []Rewrite{{Regex: "foo", ReplacementFile: filepath.Join(dir, "missing.html")}},
+		Banner:   &Banner{Snippet: "<p>notice</p>", SnippetFile: snippetFile},
+	}
+
+	_, err = New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+
+	var validationError *ValidationError
+	if !errors.As(err, &validationError) || len(validationError.Problems) != 2 {
+		t.Fatalf("expected 2 problems, got %v", err)
+	}
+}
+
+func TestInterruptedRequests(t *testing.T) {
+	tests := []struct {
+		desc      string
+		config    *Config
+		cancel    bool
+		writeBody bool
+		expStatus int
+	}{
+		{desc: "should render deadline status", config: &Config{}, expStatus: http.StatusGatewayTimeout},
+		{desc: "should render canceled status", config: &Config{}, cancel: true, expStatus: 499},
+		{
+			desc:      "should render configured status",
+			config:    &Config{DeadlineStatus: http.StatusServiceUnavailable},
+			expStatus: http.StatusServiceUnavailable,
+		},
+		{
+			desc:      "should stop buffering rewritten responses",
+			config:    &Config{Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}},
+			writeBody: true,
+			expStatus: http.StatusGatewayTimeout,
+		},
+		{desc: "should keep sent responses", config: &Config{}, writeBody: true, expStatus: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				if test.writeBody {
+					responseWriter.Header().Set("Content-Type", "text/plain")
+					_, _ = responseWriter.Write([]byte("foo"))
+				}
+
+				<-req.Context().Done()
+
+				if test.writeBody {
+					if _, err := responseWriter.Write([]byte("foo")); err != nil && test.expStatus == http.StatusOK {
+						t.Errorf("unexpected write error: %v", err)
+					}
+				}
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), test.config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+			defer cancel()
+
+			if test.cancel {
+				cancel()
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+
+			if recorder.Code != test.expStatus {
+				t.Errorf("got status %d, want %d", recorder.Code, test.expStatus)
+			}
+		})
+	}
+}
+
+func TestMetricsPath(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusBadGateway)
+	}
+
+	config := &Config{MetricsPath: "/_pretty-error/metrics", Preview: &Preview{Token: "secret"}}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "metricsPath")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := New(context.Background(), http.HandlerFunc(next), &Config{}, "metricsPathOther")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	other.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/_pretty-error/metrics", nil))
+
+	if recorder.Code != http.StatusUnauthorized || strings.Contains(recorder.Body.String(), "pretty_error_") {
+		t.Errorf("got %d %q, want the metrics refused without token", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/_pretty-error/metrics?token=secret", nil))
+
+	expected := `pretty_error_pages_served_total{middleware="metricsPath",status="502"} 1`
+	if !strings.Contains(recorder.Body.String(), expected) {
+		t.Errorf("expected %s in:\n%s", expected, recorder.Body.String())
+	}
+
+	if strings.Contains(recorder.Body.String(), "metricsPathOther") {
+		t.Errorf("expected only the series of the instance, got:\n%s", recorder.Body.String())
+	}
+
+	if _, err := New(context.Background(), http.HandlerFunc(next), &Config{MetricsPath: "/metrics"}, "open"); err == nil ||
+		!strings.Contains(err.Error(), "metricsPath: requires preview.token") {
+		t.Errorf("expected the metrics endpoint to require the preview token, got %v", err)
+	}
+}
+
+type testSpan struct {
+	attributes map[string]interface{}
+	events     []string
+}
+
+func (span *testSpan) SetAttributes(attributes ...tracing.Attribute) {
+	for _, attribute := range attributes {
+		span.attributes[attribute.Key] = attribute.Value
+	}
+}
+
+func (span *testSpan) AddEvent(name string, _ ...tracing.Attribute) {
+	span.events = append(span.events, name)
+}
+
+func (span *testSpan) End() {}
+
+type testTracer struct {
+	span *testSpan
+}
+
+func (tracer *testTracer) Start(ctx context.Context, _ string) (context.Context, tracing.Span) {
+	tracer.span = &testSpan{attributes: make(map[string]interface{})}
+
+	return ctx, tracer.span
+}
+
+func TestTracing(t *testing.T) {
+	tracer := &testTracer{}
+	tracing.SetTracer(tracer)
+
+	defer tracing.SetTracer(nil)
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusBadGateway)
+		_, _ = responseWriter.Write([]byte("upstream"))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), &Config{}, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	expected := map[string]interface{}{
+		"pretty_error.upstream.status_code": http.StatusBadGateway,
+		"pretty_error.upstream.body_size":   len("upstream"),
+		"pretty_error.page.source":          "embedded",
+		"pretty_error.replaced":             true,
+		"http.response.status_code":         http.StatusBadGateway,
+		"pretty_error.page.template":        "html/dark",
+	}
+
+	for key, value := range expected {
+		if tracer.span.attributes[key] != value {
+			t.Errorf("got attribute %s %v, want %v", key, tracer.span.attributes[key], value)
+		}
+	}
+
+	if len(tracer.span.events) != 1 || tracer.span.events[0] != "pretty_error.page_served" {
+		t.Errorf("expected page served event, got %v", tracer.span.events)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	accessLogPath := filepath.Join(t.TempDir(), "access.log")
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusBadGateway)
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), &Config{AccessLog: accessLogPath}, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/api", nil)
+	req.RemoteAddr = "192.0.2.10:51234"
+	req.Header.Set("X-Request-Id", "abc123")
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	content, err := os.ReadFile(accessLogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(content, &entry); err != nil {
+		t.Fatalf("expected one JSON line, got %s: %v", content, err)
+	}
+
+	expected := map[string]interface{}{
+		"middleware":     "prettyError",
+		"host":           "example.com",
+		"path":           "/api",
+		"clientIp":       "192.0.2.10",
+		"originalStatus": float64(http.StatusBadGateway),
+		"status":         float64(http.StatusBadGateway),
+		"referenceId":    "abc123",
+		"bytesWritten":   float64(recorder.Body.Len()),
+	}
+
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("got %s %v, want %v", key, entry[key], value)
+		}
+	}
+}
+
+func TestErrorService(t *testing.T) {
+	requests := 0
+
+	service := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, req *http.Request) {
+		requests++
+
+		if req.URL.Path != "/502.html" {
+			responseWriter.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		responseWriter.Header().Set("Content-Type", "text/html")
+		_, _ = responseWriter.Write([]byte("<h1>custom 502</h1>"))
+	}))
+	defer service.Close()
+
+	status := http.StatusBadGateway
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(status)
+	}
+
+	config := &Config{Service: &ErrorService{URL: service.URL + "/{status}.html"}}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if recorder.Code != http.StatusBadGateway || recorder.Body.String() != "<h1>custom 502</h1>" {
+			t.Errorf("got %d %s, want service page", recorder.Code, recorder.Body.String())
+		}
+
+		if csp := recorder.Header().Get("Content-Security-Policy"); csp != "" {
+			t.Errorf("got Content-Security-Policy %q on service page", csp)
+		}
+	}
+
+	if requests != 1 {
+		t.Errorf("expected the page to be cached, got %d requests", requests)
+	}
+
+	status = http.StatusServiceUnavailable
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if !strings.Contains(recorder.Body.String(), "Service Unavailable") {
+		t.Errorf("expected the template page as fallback, got %s", recorder.Body.String())
+	}
+}
+
+func TestPageSources(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/502.html" {
+			responseWriter.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		_, _ = responseWriter.Write([]byte("<h1>service 502</h1>"))
+	}))
+	defer service.Close()
+
+	dir := t.TempDir()
+	templates := map[string]string{
+		"5xx.html":   `<h1 style-nonce="{{ .Nonce }}">{{ .Status }} from 5xx</h1>`,
+		"error.html": `<h1>{{ .Status }} {{ .Message }}</h1>`,
+		"503.html":   `<h1>{{ .Missing }}</h1>`,
+	}
+
+	for name, content := range templates {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var status int
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(status)
+	}
+
+	config := &Config{
+		Status:      []string{"400-599"},
+		Service:     &ErrorService{URL: service.URL + "/{status}.html"},
+		TemplateDir: dir,
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "pageSources")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		status   int
+		accept   string
+		expected string
+		nonced   bool
+	}{
+		{status: http.StatusBadGateway, expected: "<h1>service 502</h1>"},
+		{status: http.StatusGatewayTimeout, expected: "504 from 5xx", nonced: true},
+		{status: http.StatusNotFound, expected: "<h1>404 Not Found</h1>", nonced: true},
+		{status: http.StatusServiceUnavailable, expected: "Service Unavailable", nonced: true},
+		{status: http.StatusBadGateway, accept: "application/json", expected: `"status":502`, nonced: true},
+	}
+
+	for _, test := range tests {
+		status = test.status
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.status || !strings.Contains(recorder.Body.String(), test.expected) {
+			t.Errorf("%d: got %d %s, want %s", test.status, recorder.Code, recorder.Body.String(), test.expected)
+		}
+
+		if nonced := recorder.Header().Get("Content-Security-Policy") != ""; nonced != test.nonced {
+			t.Errorf("%d: got Content-Security-Policy %t, want %t", test.status, nonced, test.nonced)
+		}
+	}
+
+	var metricsBody strings.Builder
+	if _, err := metrics.DefaultRegistry.WriteTo(&metricsBody); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		`pretty_error_page_sources_total{middleware="pageSources",source="service"} 1`,
+		`pretty_error_page_sources_total{middleware="pageSources",source="templateDir"} 2`,
+		`pretty_error_page_sources_total{middleware="pageSources",source="embedded"} 2`,
+		`pretty_error_template_errors_total{middleware="pageSources"} 1`,
+	} {
+		if !strings.Contains(metricsBody.String(), expected) {
+			t.Errorf("expected %s in:\n%s", expected, metricsBody.String())
+		}
+	}
+
+	_, err = New(context.Background(), http.HandlerFunc(next), &Config{TemplateDir: filepath.Join(dir, "5xx.html")}, "")
+	if err == nil || !strings.Contains(err.Error(), "templateDir: ") {
+		t.Errorf("expected a templateDir problem, got %v", err)
+	}
+}
+
+func TestAssets(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "error.css"), []byte("h1 { color: red; }"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "error.html"),
+		[]byte(`<link rel="stylesheet" href="{{ .AssetsPath }}error.css">`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	nextCalled := false
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		nextCalled = true
+		responseWriter.WriteHeader(http.StatusBadGateway)
+	}
+
+	config := &Config{TemplateDir: dir, Assets: &Assets{Dir: dir}}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/_error-assets/error.css", nil))
+
+	if nextCalled || recorder.Code != http.StatusOK || recorder.Body.String() != "h1 { color: red; }" {
+		t.Errorf("got %d %q, want the asset", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/_error-assets/", nil))
+
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("got %d listing the assets, want 404", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if body := recorder.Body.String(); body != `<link rel="stylesheet" href="/_error-assets/error.css">` {
+		t.Errorf("got page %s", body)
+	}
+
+	if csp := recorder.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "style-src 'self' ") {
+		t.Errorf("expected styles of the middleware to be allowed, got %q", csp)
+	}
+
+	_, err = New(context.Background(), http.HandlerFunc(next), &Config{Assets: &Assets{Path: "assets"}}, "")
+	if err == nil || !strings.Contains(err.Error(), "assets.path: must start with /; assets.dir: is required") {
+		t.Errorf("expected assets problems, got %v", err)
+	}
+}
+
+func TestWithTemplates(t *testing.T) {
+	templates := fstest.MapFS{
+		"5xx.html": {Data: []byte(`<h1>{{ .Status }} embedded</h1>`)},
+	}
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusBadGateway)
+	}
+
+	handler, err := NewWithOptions(context.Background(), http.HandlerFunc(next), &Config{}, "prettyError",
+		WithTemplates(templates))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if recorder.Code != http.StatusBadGateway || recorder.Body.String() != "<h1>502 embedded</h1>" {
+		t.Errorf("got %d %s, want the page of the templates option", recorder.Code, recorder.Body.String())
+	}
+
+	templates["error.html"] = &fstest.MapFile{Data: []byte(`{{ .Status `)}
+
+	_, err = NewWithOptions(context.Background(), http.HandlerFunc(next), &Config{TemplateDir: t.TempDir()}, "",
+		WithTemplates(templates))
+	if err == nil || !strings.Contains(err.Error(), "templateDir: conflicts with the WithTemplates option") ||
+		!strings.Contains(err.Error(), "templates.error.html: ") {
+		t.Errorf("expected templates problems, got %v", err)
+	}
+}
+
+type pushRecorder struct {
+	*httptest.ResponseRecorder
+	pushed []string
+}
+
+func (recorder *pushRecorder) Push(target string, _ *http.PushOptions) error {
+	recorder.pushed = append(recorder.pushed, target)
+
+	return nil
+}
+
+func TestPush(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		pusher, ok := responseWriter.(http.Pusher)
+		if !ok {
+			t.Fatal("expected the response writer to be a http.Pusher")
+		}
+
+		if err := pusher.Push("/style.css", nil); err != nil {
+			t.Errorf("unexpected push error: %v", err)
+		}
+
+		responseWriter.WriteHeader(http.StatusBadGateway)
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), &Config{}, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &pushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if len(recorder.pushed) != 1 || recorder.pushed[0] != "/style.css" {
+		t.Errorf("expected /style.css to be pushed, got %v", recorder.pushed)
+	}
+}
+
+type readFromRecorder struct {
+	*httptest.ResponseRecorder
+	readFrom int
+}
+
+func (recorder *readFromRecorder) ReadFrom(reader io.Reader) (int64, error) {
+	recorder.readFrom++
+
+	return io.Copy(recorder.ResponseRecorder, reader)
+}
+
+func TestReadFrom(t *testing.T) {
+	tests := []struct {
+		desc     string
+		status   int
+		readFrom int
+		expected string
+	}{
+		{desc: "passthrough", status: http.StatusOK, readFrom: 1, expected: "upstream body"},
+		{desc: "replaced", status: http.StatusBadGateway, readFrom: 0, expected: "Bad Gateway"},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/plain")
+				responseWriter.WriteHeader(test.status)
+				_, _ = io.Copy(responseWriter, io.LimitReader(strings.NewReader("upstream body"), 64))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), &Config{}, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := &readFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if recorder.readFrom != test.readFrom {
+				t.Errorf("got %d ReadFrom calls, want %d", recorder.readFrom, test.readFrom)
+			}
+
+			if !strings.Contains(recorder.Body.String(), test.expected) {
+				t.Errorf("expected %q in %q", test.expected, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	recorder := httptest.NewRecorder()
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		unwrapper, ok := responseWriter.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			t.Fatal("expected the response writer to implement Unwrap")
+		}
+
+		if unwrapper.Unwrap() != recorder {
+			t.Errorf("expected Unwrap to get the wrapped writer, got %T", unwrapper.Unwrap())
+		}
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), &Config{}, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+type plainWriter struct {
+	header http.Header
+}
+
+func (writer *plainWriter) Header() http.Header {
+	return writer.header
+}
+
+func (writer *plainWriter) Write(buf []byte) (int, error) {
+	return len(buf), nil
+}
+
+func (writer *plainWriter) WriteHeader(int) {}
+
+type hijackCloseNotifyWriter struct {
+	plainWriter
+}
+
+func (writer *hijackCloseNotifyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("not hijackable")
+}
+
+func (writer *hijackCloseNotifyWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func TestCodeCatcherInterfaces(t *testing.T) {
+	tests := []struct {
+		desc   string
+		writer http.ResponseWriter
+	}{
+		{desc: "plain", writer: &plainWriter{header: make(http.Header)}},
+		{desc: "flusher", writer: httptest.NewRecorder()},
+		{desc: "flusher and pusher", writer: &pushRecorder{ResponseRecorder: httptest.NewRecorder()}},
+		{desc: "flusher and reader from", writer: &readFromRecorder{ResponseRecorder: httptest.NewRecorder()}},
+		{desc: "hijacker and close notifier", writer: &hijackCloseNotifyWriter{plainWriter{header: make(http.Header)}}},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.desc, func(t *testing.T) {
+			catcher := newCodeCatcher(test.writer, httptest.NewRequest(http.MethodGet, "/", nil), &catcherConfig{})
+
+			implements := func(writer interface{}) [5]bool {
+				_, flusher := writer.(http.Flusher)
+				_, hijacker := writer.(http.Hijacker)
+				_, closeNotifier := writer.(http.CloseNotifier)
+				_, pusher := writer.(http.Pusher)
+				_, readerFrom := writer.(io.ReaderFrom)
+
+				return [5]bool{flusher, hijacker, closeNotifier, pusher, readerFrom}
+			}
+
+			if got, want := implements(catcher), implements(test.writer); got != want {
+				t.Errorf("catcher implements %v, want %v like the wrapped writer", got, want)
+			}
+		})
+	}
+}
+
+func TestClientGone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+
+	writer := &hijackCloseNotifyWriter{plainWriter{header: make(http.Header)}}
+	catcher := newCodeCatcher(writer, req, &catcherConfig{})
+
+	closed := catcher.(http.CloseNotifier).CloseNotify()
+
+	rewrites := newRewrites([]Rewrite{{Regex: "foo", Replacement: "bar"}}, &validator{})
+	run := newRewriteRun(rewrites, http.StatusOK, req, nil, 0)
+
+	if _, err := run.rewrite([]byte("foo")); err != nil {
+		t.Fatalf("unexpected rewrite error: %v", err)
+	}
+
+	cancel()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("expected CloseNotify to report the canceled request")
+	}
+
+	if _, err := run.rewrite([]byte("foo")); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected rewrites to stop with context.Canceled, got %v", err)
+	}
+}
+
+func TestTrailers(t *testing.T) {
+	tests := []struct {
+		desc     string
+		config   *Config
+		status   int
+		expBody  string
+		expTrail bool
+	}{
+		{desc: "passthrough", config: &Config{}, status: http.StatusOK, expBody: "foo", expTrail: true},
+		{
+			desc:     "rewritten",
+			config:   &Config{Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}},
+			status:   http.StatusOK,
+			expBody:  "bar",
+			expTrail: true,
+		},
+		{
+			desc:     "streamed",
+			config:   &Config{Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}, StreamRewrites: true},
+			status:   http.StatusOK,
+			expBody:  "bar",
+			expTrail: true,
+		},
+		{desc: "replaced", config: &Config{}, status: http.StatusBadGateway, expBody: "Bad Gateway"},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/plain")
+				responseWriter.Header().Set("Trailer", "X-Checksum")
+				responseWriter.WriteHeader(test.status)
+				_, _ = responseWriter.Write([]byte("foo"))
+				responseWriter.Header().Set("X-Checksum", "abc")
+				responseWriter.Header().Set(http.TrailerPrefix+"X-Elapsed", "1ms")
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), test.config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			result := recorder.Result()
+			defer func() { _ = result.Body.Close() }()
+
+			if !strings.Contains(recorder.Body.String(), test.expBody) {
+				t.Errorf("expected %q in %q", test.expBody, recorder.Body.String())
+			}
+
+			if result.ContentLength != -1 && test.expTrail {
+				t.Errorf("expected no Content-Length along trailers, got %d", result.ContentLength)
+			}
+
+			checksum, elapsed := result.Trailer.Get("X-Checksum"), result.Trailer.Get("X-Elapsed")
+			if got := checksum == "abc" && elapsed == "1ms"; got != test.expTrail {
+				t.Errorf("got trailers %v, want them: %t", result.Trailer, test.expTrail)
+			}
+		})
+	}
+}
+
+func TestObserve(t *testing.T) {
+	var logs bytes.Buffer
+
+	logging.SetLogger(logging.NewStdLogger(log.New(&logs, "", 0)))
+
+	defer logging.SetLogger(nil)
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.Header().Set("Content-Type", "text/plain")
+		responseWriter.WriteHeader(http.StatusBadGateway)
+		_, _ = responseWriter.Write([]byte("upstream "))
+		_, _ = responseWriter.Write([]byte("failure"))
+	}
+
+	config := &Config{Observe: true, ObserveLimit: 8}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/checkout", nil))
+
+	if recorder.Code != http.StatusBadGateway || recorder.Body.String() != "upstream failure" {
+		t.Errorf("got %d %q, want the unmodified upstream response", recorder.Code, recorder.Body.String())
+	}
+
+	expected := `INFO observed upstream error middleware=prettyError path=/checkout status=502 size=16 body=upstream`
+	if !strings.Contains(logs.String(), expected) {
+		t.Errorf("expected %q in logs:\n%s", expected, logs.String())
+	}
+
+	_, err = New(context.Background(), http.HandlerFunc(next), &Config{ObserveLimit: -1}, "")
+	if err == nil || !strings.Contains(err.Error(), "observeLimit: must not be negative") {
+		t.Errorf("expected observeLimit problem, got %v", err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	var logs bytes.Buffer
+
+	logging.SetLogger(logging.NewStdLogger(log.New(&logs, "", 0)))
+
+	defer logging.SetLogger(nil)
+
+	tests := []struct {
+		desc    string
+		status  int
+		expLog  string
+		expBody string
+	}{
+		{
+			desc:    "replaced",
+			status:  http.StatusBadGateway,
+			expLog:  "INFO dry run: response would have been replaced middleware=dryRun path=/ status=502 servedStatus=502",
+			expBody: "foo failed",
+		},
+		{
+			desc:    "rewritten",
+			status:  http.StatusOK,
+			expLog:  "INFO dry run: response would have been rewritten middleware=dryRun path=/ status=200 size=10",
+			expBody: "foo failed",
+		},
+	}
+
+	config := &Config{DryRun: true, Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.desc, func(t *testing.T) {
+			logs.Reset()
+
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/plain")
+				responseWriter.WriteHeader(test.status)
+				_, _ = responseWriter.Write([]byte("foo failed"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "dryRun")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if recorder.Code != test.status || recorder.Body.String() != test.expBody {
+				t.Errorf("got %d %q, want the original response", recorder.Code, recorder.Body.String())
+			}
+
+			if !strings.Contains(logs.String(), test.expLog) {
+				t.Errorf("expected %q in logs:\n%s", test.expLog, logs.String())
+			}
+
+			if snapshot := metrics.DefaultRegistry.Snapshot("dryRun"); len(snapshot.PagesServed) != 0 ||
+				len(snapshot.PageSources) != 0 {
+				t.Errorf("got counters %+v, want no page counted in dry run", snapshot)
+			}
+		})
+	}
+}
+
+func TestRolloutPercent(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusBadGateway)
+		_, _ = responseWriter.Write([]byte("upstream"))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), &Config{RolloutPercent: 50}, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(requestID string) bool {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Request-Id", requestID)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		return recorder.Body.String() != "upstream"
+	}
+
+	replaced := 0
+
+	for i := 0; i < 200; i++ {
+		requestID := fmt.Sprintf("request-%d", i)
+
+		first := serve(requestID)
+		if first != serve(requestID) {
+			t.Errorf("expected request %s to always get the same response", requestID)
+		}
+
+		if first {
+			replaced++
+		}
+	}
+
+	if replaced < 60 || replaced > 140 {
+		t.Errorf("got %d of 200 responses replaced, want about half", replaced)
+	}
+
+	_, err = New(context.Background(), http.HandlerFunc(next), &Config{RolloutPercent: 101}, "")
+	if err == nil || !strings.Contains(err.Error(), "rolloutPercent: 101 is not between 1 and 100") {
+		t.Errorf("expected rolloutPercent problem, got %v", err)
+	}
+}
+
+func TestInstanceTemplates(t *testing.T) {
+	templates := fstest.MapFS{
+		"error.html":          {Data: []byte(`Service: {{ .Labels.service }} is unavailable ({{ .Middleware }})`)},
+		"checkout/error.html": {Data: []byte(`Checkout is down, your cart is saved`)},
+	}
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		expected string
+	}{
+		{name: "catalog", labels: map[string]string{"service": "catalog"}, expected: "Service: catalog is unavailable (catalog)"},
+		{name: "checkout", expected: "Checkout is down, your cart is saved"},
+	}
+
+	for _, test := range tests {
+		handler, err := NewWithOptions(context.Background(), http.HandlerFunc(next), &Config{Labels: test.labels},
+			test.name, WithTemplates(templates))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if recorder.Body.String() != test.expected {
+			t.Errorf("%s: got %q, want %q", test.name, recorder.Body.String(), test.expected)
+		}
+	}
+}
+
+func TestContentLength(t *testing.T) {
+	tests := []struct {
+		desc   string
+		config *Config
+		status int
+	}{
+		{desc: "replaced", config: &Config{PreserveHeaders: []string{"*"}}, status: http.StatusBadGateway},
+		{
+			desc:   "rewritten",
+			config: &Config{Rewrites: []Rewrite{{Regex: "foo", Replacement: "foobar"}}},
+			status: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/plain")
+				responseWriter.Header().Set("Content-Length", "3")
+				responseWriter.Header().Set("Transfer-Encoding", "chunked")
+				responseWriter.WriteHeader(test.status)
+				_, _ = responseWriter.Write([]byte("foo"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), test.config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if expected := strconv.Itoa(recorder.Body.Len()); recorder.Header().Get("Content-Length") != expected {
+				t.Errorf("got Content-Length %q, want %q", recorder.Header().Get("Content-Length"), expected)
+			}
+
+			if encoding := recorder.Header().Get("Transfer-Encoding"); encoding != "" {
+				t.Errorf("expected no Transfer-Encoding, got %q", encoding)
+			}
+		})
+	}
+}
+
+func TestVary(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.Header().Set("Vary", "Accept")
+		responseWriter.WriteHeader(http.StatusBadGateway)
+	}
+
+	config := &Config{PreserveHeaders: []string{"Vary"}, CORS: &CORS{AllowOrigins: []string{"*"}}}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Origin", "https://example.com")
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	expected := []string{"Accept", "Origin", "Accept-Language"}
+	if values := recorder.Header().Values("Vary"); strings.Join(values, "|") != strings.Join(expected, "|") {
+		t.Errorf("got Vary %q, want %q", values, expected)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusBadGateway)
+	}
+
+	tests := []struct {
+		desc           string
+		compress       bool
+		acceptEncoding string
+		expEncoding    string
+	}{
+		{desc: "disabled", acceptEncoding: "gzip"},
+		{desc: "not accepted", compress: true, acceptEncoding: "br"},
+		{desc: "refused", compress: true, acceptEncoding: "gzip;q=0, *"},
+		{desc: "gzip", compress: true, acceptEncoding: "br, gzip", expEncoding: "gzip"},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{Compress: test.compress, CacheValidators: true}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", test.acceptEncoding)
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if encoding := recorder.Header().Get("Content-Encoding"); encoding != test.expEncoding {
+				t.Fatalf("got Content-Encoding %q, want %q", encoding, test.expEncoding)
+			}
+
+			vary := recorder.Header().Values("Vary")
+			if varies := strings.Contains(strings.Join(vary, ","), "Accept-Encoding"); varies != test.compress {
+				t.Errorf("got Vary %q, expected Accept-Encoding: %t", vary, test.compress)
+			}
+
+			if expected := strconv.Itoa(recorder.Body.Len()); recorder.Header().Get("Content-Length") != expected {
+				t.Errorf("got Content-Length %q, want %q", recorder.Header().Get("Content-Length"), expected)
+			}
+
+			body, err := compressutil.Decode(recorder.Body, test.expEncoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Contains(body, []byte("Bad Gateway")) {
+				t.Errorf("expected the error page, got %q", body)
+			}
+
+			if etag := recorder.Header().Get("ETag"); test.expEncoding != "" && !strings.HasSuffix(etag, "-gzip\"") {
+				t.Errorf("expected an ETag specific to the coding, got %q", etag)
+			}
+		})
+	}
+}
+
+func TestMarkerHeader(t *testing.T) {
+	tests := []struct {
+		desc     string
+		marker   string
+		status   int
+		expName  string
+		expValue string
+	}{
+		{desc: "disabled", status: http.StatusBadGateway, expName: "X-Pretty-Error"},
+		{desc: "name only", marker: "x-pretty-error", status: http.StatusBadGateway, expName: "X-Pretty-Error", expValue: "pretty-error"},
+		{desc: "name and value", marker: "X-Error-Source: edge", status: http.StatusBadGateway, expName: "X-Error-Source", expValue: "edge"},
+		{desc: "upstream page", marker: "X-Error-Source", status: http.StatusOK, expName: "X-Error-Source"},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.WriteHeader(test.status)
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), &Config{MarkerHeader: test.marker}, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if value := recorder.Header().Get(test.expName); value != test.expValue {
+				t.Errorf("got %s %q, want %q", test.expName, value, test.expValue)
+			}
+		})
+	}
+
+	_, err := New(context.Background(), nil, &Config{MarkerHeader: "X Error: value"}, "prettyError")
+	if err == nil || !strings.Contains(err.Error(), "markerHeader: ") {
+		t.Errorf("expected a markerHeader problem, got %v", err)
+	}
+}
+
+func TestPreview(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		t.Error("previews must not reach the upstream handler")
+	}
+
+	config := &Config{Preview: &Preview{Token: "secret"}, MetricsPath: "/metrics"}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "previewed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc      string
+		target    string
+		token     string
+		expStatus int
+		expBody   string
+	}{
+		{desc: "missing token", target: "/_pretty-error/preview/503", expStatus: http.StatusUnauthorized},
+		{desc: "wrong token", target: "/_pretty-error/preview/503?token=guess", expStatus: http.StatusUnauthorized},
+		{desc: "bearer token", target: "/_pretty-error/preview/503", token: "secret", expStatus: 503, expBody: "Service Unavailable"},
+		{desc: "query token", target: "/_pretty-error/preview/418?token=secret", expStatus: 418, expBody: "I&#39;m a teapot"},
+		{desc: "json", target: "/_pretty-error/preview/404?token=secret&format=json", expStatus: 404, expBody: `"status":404`},
+		{desc: "invalid status", target: "/_pretty-error/preview/42?token=secret", expStatus: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.target, nil)
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expStatus {
+			t.Errorf("%s: got status %d, want %d", test.desc, recorder.Code, test.expStatus)
+		}
+
+		if !strings.Contains(recorder.Body.String(), test.expBody) {
+			t.Errorf("%s: expected %q in %q", test.desc, test.expBody, recorder.Body.String())
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if strings.Contains(recorder.Body.String(), `middleware="previewed"`) {
+		t.Errorf("expected previews to stay out of metrics, got %s", recorder.Body.String())
+	}
+
+	_, err = New(context.Background(), nil, &Config{Preview: &Preview{Path: "preview"}}, "prettyError")
+	if err == nil || !strings.Contains(err.Error(), "preview.path: must start with /; preview.token: is required") {
+		t.Errorf("expected preview problems, got %v", err)
+	}
+}
+
+func TestStatusEndpoint(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusBadGateway)
+	}
+
+	templates := fstest.MapFS{
+		"5xx.html":          {Data: []byte("server error")},
+		"reported/404.html": {Data: []byte("not found")},
+	}
+
+	config := &Config{
+		Status:     []string{"404", "500-599"},
+		StatusPath: "/_pretty-error/status",
+		Preview:    &Preview{Token: "secret"},
+	}
+
+	handler, err := NewWithOptions(context.Background(), http.HandlerFunc(next), config, "reported", WithTemplates(templates))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/_pretty-error/status?token=secret", nil))
+
+	var report statusReport
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		t.Fatalf("unable to decode %q: %v", recorder.Body.String(), err)
+	}
+
+	if report.Middleware != "reported" || report.Status != "404,500-599" {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	if len(report.Sources) != 2 || report.Sources[0].Name != sourceTemplates || report.Sources[1].Name != sourceEmbedded {
+		t.Fatalf("unexpected sources %+v", report.Sources)
+	}
+
+	if templates := strings.Join(report.Sources[0].Templates, ","); templates != "5xx.html,reported/404.html" {
+		t.Errorf("got templates %q", templates)
+	}
+
+	if report.Counters.PagesServed["502"] != 1 || report.Counters.PageSources[sourceTemplates] != 1 {
+		t.Errorf("unexpected counters %+v", report.Counters)
+	}
+}
+
+func TestNewMiddleware(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusBadGateway)
+	}
+
+	templates := fstest.MapFS{"error.html": {Data: []byte(`{{ .Middleware }}: {{ .Status }}`)}}
+
+	tests := []struct {
+		desc    string
+		opts    []Option
+		expBody string
+	}{
+		{desc: "defaults", expBody: "Bad Gateway"},
+		{desc: "name and templates", opts: []Option{WithName("checkout"), WithTemplates(templates)}, expBody: "checkout: 502"},
+		{desc: "config", opts: []Option{WithConfig(&Config{Status: []string{"404"}})}},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		NewMiddleware(http.HandlerFunc(next), test.opts...).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if !strings.Contains(recorder.Body.String(), test.expBody) || (test.expBody == "") != (recorder.Body.Len() == 0) {
+			t.Errorf("%s: expected %q, got %q", test.desc, test.expBody, recorder.Body.String())
+		}
+	}
+
+	defer func() {
+		if recovered := recover(); recovered == nil {
+			t.Error("expected an invalid configuration to panic")
+		}
+	}()
+
+	NewMiddleware(http.HandlerFunc(next), WithConfig(&Config{Status: []string{"abc"}}))
+}
+
+func TestResponseModifier(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.Header().Set("Content-Type", "text/plain")
+
+		switch req.URL.Path {
+		case "/error":
+			responseWriter.WriteHeader(http.StatusBadGateway)
+			_, _ = responseWriter.Write([]byte("upstream failure"))
+		case "/rewrite":
+			_, _ = responseWriter.Write([]byte("foo"))
+		default:
+			_, _ = responseWriter.Write([]byte("untouched"))
+		}
+	}))
+	defer backend.Close()
+
+	target, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{Rewrites: []Rewrite{{Regex: "foo", Replacement: "foobar", Status: []string{"200"}}}}
+
+	modify, err := NewResponseModifier(config, "proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := stdhttputil.NewSingleHostReverseProxy(target)
+	proxy.ModifyResponse = modify
+
+	tests := []struct {
+		path      string
+		expStatus int
+		expBody   string
+	}{
+		{path: "/error", expStatus: http.StatusBadGateway, expBody: "Bad Gateway"},
+		{path: "/rewrite", expStatus: http.StatusOK, expBody: "foobar"},
+		{path: "/other", expStatus: http.StatusOK, expBody: "untouched"},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		proxy.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+		if recorder.Code != test.expStatus || !strings.Contains(recorder.Body.String(), test.expBody) {
+			t.Errorf("%s: got %d %q, want %d with %q", test.path, recorder.Code, recorder.Body.String(),
+				test.expStatus, test.expBody)
+		}
+
+		if length := recorder.Header().Get("Content-Length"); length != strconv.Itoa(recorder.Body.Len()) {
+			t.Errorf("%s: got Content-Length %q for %d bytes", test.path, length, recorder.Body.Len())
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.Header().Set("Content-Type", "text/html")
+		responseWriter.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = responseWriter.Write([]byte("<pre>stack trace</pre>"))
+	}))
+	defer backend.Close()
+
+	roundTripper, err := NewTransport(nil, CreateConfig(), "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: roundTripper}
+
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusServiceUnavailable || bytes.Contains(body, []byte("stack trace")) ||
+		!bytes.Contains(body, []byte("Service Unavailable")) {
+		t.Errorf("expected the error page, got %d %q", resp.StatusCode, body)
+	}
+
+	if resp.ContentLength != int64(len(body)) {
+		t.Errorf("got ContentLength %d for %d bytes", resp.ContentLength, len(body))
+	}
+
+	if _, err := NewTransport(nil, &Config{Status: []string{"abc"}}, "client"); err == nil {
+		t.Error("expected an invalid configuration to be rejected")
+	}
+}
+
+func TestInterceptor(t *testing.T) {
+	intercepted, err := NewInterceptor(&Config{MetricsPath: "/metrics", Preview: &Preview{Token: "secret"}}, "adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status := range []int{http.StatusOK, http.StatusBadGateway} {
+		status := status
+		next := http.HandlerFunc(func(responseWriter http.ResponseWriter, req *http.Request) {
+			responseWriter.WriteHeader(status)
+			_, _ = responseWriter.Write([]byte("upstream"))
+		})
+
+		recorder := httptest.NewRecorder()
+		intercepted.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil), next)
+
+		if replaced := !strings.Contains(recorder.Body.String(), "upstream"); replaced != (status == http.StatusBadGateway) {
+			t.Errorf("status %d: got %q", status, recorder.Body.String())
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	intercepted.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics?token=secret", nil), nil)
+
+	if !strings.Contains(recorder.Body.String(), `pretty_error_pages_served_total{middleware="adapter",status="502"} 1`) {
+		t.Errorf("expected the endpoints of the middleware to be served, got %q", recorder.Body.String())
+	}
+}
+
+func TestGRPCStatusMapping(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.Header().Set("Content-Type", "application/grpc-web+proto")
+		responseWriter.Header().Set("Grpc-Status", "14")
+		responseWriter.Header().Set("Grpc-Message", "checkout%20is%20down")
+		responseWriter.WriteHeader(http.StatusOK)
+	}
+
+	tests := []struct {
+		desc      string
+		config    *Config
+		accept    string
+		expStatus int
+		expBody   string
+	}{
+		{desc: "disabled", config: &Config{}, expStatus: http.StatusOK},
+		{desc: "html", config: &Config{GRPCStatus: true}, expStatus: http.StatusServiceUnavailable, expBody: "checkout is down"},
+		{
+			desc:      "json",
+			config:    &Config{GRPCStatus: true},
+			accept:    "application/json",
+			expStatus: http.StatusServiceUnavailable,
+			expBody:   `"message":"checkout is down"`,
+		},
+		{desc: "status not intercepted", config: &Config{GRPCStatus: true, Status: []string{"404"}}, expStatus: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		handler, err := New(context.Background(), http.HandlerFunc(next), test.config, "prettyError")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", test.accept)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expStatus || !strings.Contains(recorder.Body.String(), test.expBody) {
+			t.Errorf("%s: got %d %q, want %d with %q", test.desc, recorder.Code, recorder.Body.String(),
+				test.expStatus, test.expBody)
+		}
+	}
+
+	native := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.Header().Set("Content-Type", "application/grpc")
+		responseWriter.Header().Set("Grpc-Status", "14")
+		responseWriter.WriteHeader(http.StatusOK)
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(native), &Config{GRPCStatus: true}, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", nil))
+
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Grpc-Status") != "14" {
+		t.Errorf("got %d %v, want the native gRPC response forwarded", recorder.Code, recorder.Header())
+	}
+}
+
+func TestHTMLOnly(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.Header().Set("Content-Type", "text/plain")
+		responseWriter.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = responseWriter.Write([]byte("missing"))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), &Config{HTMLOnly: true}, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"text/html,application/xhtml+xml;q=0.9": "<html",
+		"application/json":                      "missing",
+		"*/*":                                   "missing",
+		"":                                      "missing",
+	}
+
+	for accept, expected := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", accept)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusServiceUnavailable || !strings.Contains(recorder.Body.String(), expected) {
+			t.Errorf("%q: got %d %q, want %q", accept, recorder.Code, recorder.Body.String(), expected)
+		}
+	}
+}
+
+func TestEmptyBodies(t *testing.T) {
+	tests := []struct {
+		desc      string
+		status    int
+		body      string
+		flush     bool
+		expStatus int
+		expBody   string
+	}{
+		{desc: "empty error body", status: http.StatusBadGateway, expStatus: http.StatusBadGateway, expBody: "<html"},
+		{desc: "error body", status: http.StatusBadGateway, body: "upstream down", expStatus: http.StatusBadGateway, expBody: "upstream down"},
+		{desc: "flushed", status: http.StatusBadGateway, flush: true, expStatus: http.StatusBadGateway},
+		{desc: "not an error", status: http.StatusNoContent, expStatus: http.StatusNoContent},
+	}
+
+	for _, test := range tests {
+		test := test
+		next := func(responseWriter http.ResponseWriter, req *http.Request) {
+			responseWriter.WriteHeader(test.status)
+
+			if test.flush {
+				responseWriter.(http.Flusher).Flush()
+			}
+
+			_, _ = responseWriter.Write([]byte(test.body))
+		}
+
+		handler, err := New(context.Background(), http.HandlerFunc(next),
+			&Config{Status: []string{"404"}, EmptyBodies: true}, "prettyError")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if recorder.Code != test.expStatus || !strings.Contains(recorder.Body.String(), test.expBody) ||
+			(test.expBody == "" && recorder.Body.Len() > 0) {
+			t.Errorf("%s: got %d %q, want %d with %q", test.desc, recorder.Code, recorder.Body.String(),
+				test.expStatus, test.expBody)
+		}
+	}
+}
+
+func TestNestedInstances(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	inner, err := New(context.Background(), http.HandlerFunc(next), &Config{Theme: "light"}, "inner")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outer, err := New(context.Background(), inner, &Config{Theme: "dark", EmptyBodies: true}, "outer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	outer.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if recorder.Code != http.StatusServiceUnavailable || !strings.Contains(recorder.Body.String(), "theme-light") {
+		t.Errorf("got %d %q, want the page of the inner instance", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestServiceLoop(t *testing.T) {
+	var fetches int32
+
+	failing := func(responseWriter http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		responseWriter.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	var config *Config
+
+	service := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, req *http.Request) {
+		handler, err := New(context.Background(), http.HandlerFunc(failing), config, "prettyError")
+		if err != nil {
+			t.Error(err)
+
+			return
+		}
+
+		handler.ServeHTTP(responseWriter, req)
+	}))
+	defer service.Close()
+
+	config = &Config{Service: &ErrorService{URL: service.URL + "/{status}.html", CacheTTL: "0s"}}
+
+	handler, err := New(context.Background(), http.HandlerFunc(failing), config, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if recorder.Code != http.StatusServiceUnavailable || !strings.Contains(recorder.Body.String(), "Service Unavailable") {
+		t.Errorf("got %d %q, want the embedded page", recorder.Code, recorder.Body.String())
+	}
+
+	if count := atomic.LoadInt32(&fetches); count != 2 {
+		t.Errorf("got %d upstream requests, want the request and the page fetch", count)
+	}
+}
+
+func TestRecentErrors(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusBadGateway)
+		_, _ = responseWriter.Write([]byte("upstream " + req.URL.Path + " " + strings.Repeat("x", 2*recentSnippetSize)))
+	}
+
+	config := &Config{RecentErrors: 2, StatusPath: "/_pretty-error/status", Preview: &Preview{Token: "secret"}}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/first", "/second", "/third"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/_pretty-error/status?token=secret", nil))
+
+	var report statusReport
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		t.Fatalf("unable to decode %q: %v", recorder.Body.String(), err)
+	}
+
+	if len(report.RecentErrors) != 2 {
+		t.Fatalf("got recent errors %+v, want 2", report.RecentErrors)
+	}
+
+	for index, path := range []string{"/third", "/second"} {
+		recent := report.RecentErrors[index]
+		if recent.Path != path || recent.Status != http.StatusBadGateway || recent.Time.IsZero() ||
+			!strings.HasPrefix(recent.Snippet, "upstream "+path) || len(recent.Snippet) != recentSnippetSize {
+			t.Errorf("unexpected recent error %d: %+v", index, recent)
+		}
+	}
+
+	if _, err := New(context.Background(), http.HandlerFunc(next), &Config{RecentErrors: -1}, "prettyError"); err == nil {
+		t.Error("expected a negative recentErrors to be rejected")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	var posted [][]byte
+
+	notifier := newNotifier(&Webhook{URL: "http://hooks.internal/alert", Format: "slack", Threshold: 3, Window: "1m",
+		Cooldown: "5m"}, "prettyError", logging.WithLevel(logging.LevelError), &validator{})
+	notifier.now = func() time.Time { return now }
+	notifier.post = func(payload []byte) { posted = append(posted, payload) }
+
+	steps := []struct {
+		after  time.Duration
+		status int
+		alerts int
+	}{
+		{status: http.StatusNotFound},
+		{status: http.StatusBadGateway},
+		{status: http.StatusBadGateway},
+		{after: 2 * time.Minute, status: http.StatusBadGateway},
+		{status: http.StatusServiceUnavailable},
+		{status: http.StatusBadGateway, alerts: 1},
+		{status: http.StatusBadGateway, alerts: 1},
+		{after: 2 * time.Minute, status: http.StatusBadGateway, alerts: 1},
+		{status: http.StatusBadGateway, alerts: 1},
+		{status: http.StatusBadGateway, alerts: 1},
+		{after: 4 * time.Minute, status: http.StatusBadGateway, alerts: 1},
+		{status: http.StatusBadGateway, alerts: 1},
+		{status: http.StatusBadGateway, alerts: 2},
+	}
+
+	for index, step := range steps {
+		now = now.Add(step.after)
+		notifier.observe(step.status)
+
+		if len(posted) != step.alerts {
+			t.Fatalf("step %d: got %d alerts, want %d", index, len(posted), step.alerts)
+		}
+	}
+
+	var message map[string]string
+	if err := json.Unmarshal(posted[0], &message); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "pretty-error prettyError: 3 server errors replaced within 1m0s (502 x2, 503 x1)"; message["text"] != expected {
+		t.Errorf("got %q, want %q", message["text"], expected)
+	}
+}
+
+func TestWebhookPost(t *testing.T) {
+	received := make(chan webhookAlert, 1)
+
+	hook := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, req *http.Request) {
+		var alert webhookAlert
+		if err := json.NewDecoder(req.Body).Decode(&alert); err != nil {
+			t.Error(err)
+		}
+
+		received <- alert
+	}))
+	defer hook.Close()
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	config := &Config{Webhook: &Webhook{URL: hook.URL, Threshold: 2}}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "alerting")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	select {
+	case alert := <-received:
+		if alert.Middleware != "alerting" || alert.Count != 2 || alert.Statuses["503"] != 2 {
+			t.Errorf("unexpected alert %+v", alert)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no alert posted")
+	}
+
+	if _, err := New(context.Background(), http.HandlerFunc(next), &Config{Webhook: &Webhook{Format: "teams"}},
+		"alerting"); err == nil {
+		t.Error("expected a webhook without url and with an unknown format to be rejected")
+	}
+}
+
+type recordingReporter struct {
+	events []ErrorEvent
+}
+
+func (reporter *recordingReporter) Report(_ context.Context, event ErrorEvent) {
+	reporter.events = append(reporter.events, event)
+}
+
+func TestReporter(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/missing" {
+			responseWriter.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		responseWriter.WriteHeader(http.StatusBadGateway)
+	}
+
+	reporter := &recordingReporter{}
+
+	handler, err := NewWithOptions(context.Background(), http.HandlerFunc(next), &Config{Status: []string{"404", "500-599"}},
+		"reported", WithReporter(reporter))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/missing", "/checkout"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Request-Id", "abc123")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if len(reporter.events) != 1 {
+		t.Fatalf("got events %+v, want only the server error", reporter.events)
+	}
+
+	event := reporter.events[0]
+	if event.Middleware != "reported" || event.Path != "/checkout" || event.Status != http.StatusBadGateway ||
+		event.UpstreamStatus != http.StatusBadGateway || event.ReferenceID != "abc123" || event.Time.IsZero() {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
+
+func TestSentryReporter(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan sentryEvent, 1)
+
+	sentry := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, req *http.Request) {
+		var event sentryEvent
+		if err := json.NewDecoder(req.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+
+		received <- req
+		bodies <- event
+	}))
+	defer sentry.Close()
+
+	dsn := strings.Replace(sentry.URL, "http://", "http://public@", 1) + "/42"
+
+	reporter, err := NewSentryReporter(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reporter.Report(context.Background(), ErrorEvent{
+		Middleware: "reported",
+		Time:       time.Now(),
+		Method:     http.MethodGet,
+		Host:       "shop.example.com",
+		Path:       "/checkout",
+		Status:     http.StatusServiceUnavailable,
+		Detail:     "checkout is down",
+	})
+
+	select {
+	case req := <-received:
+		if req.URL.Path != "/api/42/store/" || !strings.Contains(req.Header.Get("X-Sentry-Auth"), "sentry_key=public") {
+			t.Errorf("unexpected request %s %v", req.URL, req.Header)
+		}
+
+		event := <-bodies
+		if event.Message != "503 Service Unavailable on GET /checkout: checkout is down" || len(event.EventID) != 32 ||
+			event.Tags["middleware"] != "reported" {
+			t.Errorf("unexpected event %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event sent")
+	}
+
+	for _, invalid := range []string{"https://o0.ingest.sentry.io/42", "https://public@o0.ingest.sentry.io/project"} {
+		if _, err := NewSentryReporter(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+
+	logger := logging.WithLevel(logging.LevelInfo)
+	if first := newConfigSentryReporter(dsn, logger, &validator{}); first != newConfigSentryReporter(dsn, logger, &validator{}) {
+		t.Error("expected the reporter of a DSN to be shared across reloads")
+	}
+}
+
+func TestSentryReporterBackpressure(t *testing.T) {
+	received := make(chan struct{}, 2*sentryQueueSize)
+	unblock := make(chan struct{})
+
+	sentry := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, req *http.Request) {
+		received <- struct{}{}
+		<-unblock
+
+		responseWriter.Header().Set("Retry-After", "120")
+		responseWriter.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer sentry.Close()
+
+	reporter, err := newSentryReporter(strings.Replace(sentry.URL, "http://", "http://public@", 1)+"/42",
+		logging.WithLevel(logging.LevelError))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := ErrorEvent{Time: time.Now(), Method: http.MethodGet, Status: http.StatusBadGateway}
+
+	reporter.Report(context.Background(), event)
+	<-received
+
+	for i := 0; i < 2*sentryQueueSize; i++ {
+		reporter.Report(context.Background(), event)
+	}
+
+	if dropped := atomic.LoadUint64(&reporter.dropped); dropped != sentryQueueSize {
+		t.Errorf("got %d events dropped, want %d beyond the queue", dropped, sentryQueueSize)
+	}
+
+	close(unblock)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(reporter.events) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(received) != 0 {
+		t.Errorf("got %d events sent once rate limited, want none", len(received))
+	}
+}
+
+func TestMinimalPageFallback(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(799)
+	}
+
+	matcher := types.CodeMatcherFunc(func(status int) bool { return status == 799 })
+
+	handler, err := NewWithCodeMatcher(context.Background(), http.HandlerFunc(next), &Config{}, "minimal", matcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for accept, expected := range map[string]string{"": "<h1>799 Error</h1>", "application/json": `"status":799`} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", accept)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != 799 || !strings.Contains(recorder.Body.String(), expected) {
+			t.Errorf("%q: got %d %q, want %q", accept, recorder.Code, recorder.Body.String(), expected)
+		}
+
+		if length := recorder.Header().Get("Content-Length"); length != strconv.Itoa(recorder.Body.Len()) {
+			t.Errorf("%q: got Content-Length %q", accept, length)
+		}
+	}
+
+	snapshot := metrics.DefaultRegistry.Snapshot("minimal")
+	if snapshot.TemplateErrors != 2 || snapshot.PageSources[sourceMinimal] != 2 {
+		t.Errorf("unexpected counters %+v", snapshot)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusBadGateway)
+	}
+
+	templates := fstest.MapFS{
+		"error.html": {Data: []byte(`{{ if eq .Language "fr" }}{{ .Missing }}{{ end }}{{ .Status }}`)},
+		"404.html":   {Data: []byte(`{{ .Labels.team }}`)},
+		"503.html":   {Data: []byte(`{{ index .Data "retry" }}`)},
+	}
+
+	tests := []struct {
+		desc     string
+		config   *Config
+		problems []string
+	}{
+		{desc: "disabled", config: &Config{StrictLanguages: []string{"fr"}}},
+		{desc: "default language", config: &Config{Strict: true}},
+		{
+			desc:     "failing language",
+			config:   &Config{Strict: true, StrictLanguages: []string{"en", "fr"}},
+			problems: []string{"strict: status 500, language fr: error rendering error.html"},
+		},
+		{
+			desc:   "only intercepted statuses",
+			config: &Config{Strict: true, StrictLanguages: []string{"fr"}, Status: []string{"404", "503"}},
+		},
+	}
+
+	for _, test := range tests {
+		_, err := NewWithOptions(context.Background(), http.HandlerFunc(next), test.config, "strict", WithTemplates(templates))
+
+		if len(test.problems) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.desc, err)
+			}
+
+			continue
+		}
+
+		if err == nil {
+			t.Errorf("%s: expected problems %v", test.desc, test.problems)
+
+			continue
+		}
+
+		for _, problem := range test.problems {
+			if !strings.Contains(err.Error(), problem) {
+				t.Errorf("%s: expected %q in %v", test.desc, problem, err)
+			}
+		}
+
+		if !strings.Contains(err.Error(), fmt.Sprintf("%d problem(s)", len(test.problems))) {
+			t.Errorf("%s: expected the failing template to be reported once, got %v", test.desc, err)
+		}
+	}
+}
+
+func TestDelimiters(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusBadGateway)
+	}
+
+	templates := fstest.MapFS{
+		"error.html": {Data: []byte(`<div id="app">{{ vueMessage }}</div><h1>[[ .Status ]] [[ .Message | upper ]]</h1>`)},
+	}
+
+	handler, err := NewWithOptions(context.Background(), http.HandlerFunc(next),
+		&Config{Delimiters: []string{"[[", "]]"}}, "delimiters", WithTemplates(templates))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	expected := `<div id="app">{{ vueMessage }}</div><h1>502 BAD GATEWAY</h1>`
+	if recorder.Body.String() != expected {
+		t.Errorf("got %q, want %q", recorder.Body.String(), expected)
+	}
+
+	for _, delims := range [][]string{{"[["}, {"", "]]"}} {
+		_, err := NewWithOptions(context.Background(), http.HandlerFunc(next), &Config{Delimiters: delims}, "delimiters",
+			WithTemplates(templates))
+		if err == nil || !strings.Contains(err.Error(), "delimiters: ") {
+			t.Errorf("%q: expected a delimiters problem, got %v", delims, err)
+		}
+	}
+}
+
+func TestLayout(t *testing.T) {
+	var status int
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(status)
+	}
+
+	templates := fstest.MapFS{
+		"layout.html":     {Data: []byte(`<header>{{ template "brand" }}</header><main>{{ template "content" . }}</main>`)},
+		"partials.html":   {Data: []byte(`{{ define "brand" }}Shop{{ end }}{{ define "content" }}{{ .Status }} oops{{ end }}`)},
+		"404.html":        {Data: []byte(`{{ define "content-404" }}Nothing at {{ .Labels.site }}{{ end }}`)},
+		"5xx.html":        {Data: []byte(`{{ define "content-5xx" }}{{ .Message }}, retry soon{{ end }}`)},
+		"layout/503.html": {Data: []byte(`instance page {{ .Status }}`)},
+	}
+
+	config := &Config{Status: []string{"400-599"}, Labels: map[string]string{"site": "shop.example.com"}}
+
+	handler, err := NewWithOptions(context.Background(), http.HandlerFunc(next), config, "layout", WithTemplates(templates))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[int]string{
+		http.StatusNotFound:           "<header>Shop</header><main>Nothing at shop.example.com</main>",
+		http.StatusBadGateway:         "<header>Shop</header><main>Bad Gateway, retry soon</main>",
+		http.StatusBadRequest:         "<header>Shop</header><main>400 oops</main>",
+		http.StatusServiceUnavailable: "instance page 503",
+	}
+
+	for status = range tests {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if recorder.Body.String() != tests[status] {
+			t.Errorf("%d: got %q, want %q", status, recorder.Body.String(), tests[status])
+		}
+	}
+}
+
+func TestJSONTemplates(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusBadGateway)
+	}
+
+	templates := fstest.MapFS{
+		"error.html": {Data: []byte(`<h1>{{ .Message }}</h1>`)},
+		"5xx.json":   {Data: []byte(`{"code": {{ .Status }}, "error": {{ .Message | json }}, "team": {{ .Labels.team | json }}}`)},
+	}
+
+	config := &Config{Labels: map[string]string{"team": `"ops" & <sre>`}}
+
+	handler, err := NewWithOptions(context.Background(), http.HandlerFunc(next), config, "jsonTemplates",
+		WithTemplates(templates))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/json")
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unable to decode %q: %v", recorder.Body.String(), err)
+	}
+
+	if body["code"] != float64(502) || body["error"] != "Bad Gateway" || body["team"] != `"ops" & <sre>` {
+		t.Errorf("unexpected body %v", body)
+	}
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json; charset=utf-8" {
+		t.Errorf("got Content-Type %q", contentType)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if recorder.Body.String() != "<h1>Bad Gateway</h1>" {
+		t.Errorf("got HTML page %q", recorder.Body.String())
+	}
+}
+
+func TestRequestInfo(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusNotFound)
+	}
+
+	templates := fstest.MapFS{
+		"error.html": {Data: []byte(`<a href="/search?q={{ .Request.Path }}">{{ .Request.Method }} {{ .Request.Host }}{{ .Request.Path }}?{{ .Request.Query }}</a>`)},
+		"error.json": {Data: []byte(`{"path": {{ .Request.Path | json }}}`)},
+	}
+
+	handler, err := NewWithOptions(context.Background(), http.HandlerFunc(next), &Config{Status: []string{"404"}}, "request",
+		WithTemplates(templates))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.URL.Path = `/"><script>alert(1)</script>`
+	req.URL.RawQuery = "next=<img src=x>&a=b"
+	req.Host = `shop.example.com"><b>`
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	expected := `<a href="/search?q=%2f%2522%253E%253Cscript%253Ealert%25281%2529%253C%2fscript%253E">GET shop.example.comb/%22%3E%3Cscript%3Ealert%281%29%3C/script%3E?a=b&amp;next=%3Cimg&#43;src%3Dx%3E</a>`
+	if recorder.Body.String() != expected {
+		t.Errorf("got %q, want %q", recorder.Body.String(), expected)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/"+strings.Repeat("a", 2*maxPathLength), nil)
+	req.Header.Set("Accept", "application/json")
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	var body map[string]string
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unable to decode %q: %v", recorder.Body.String(), err)
+	}
+
+	if path := []rune(body["path"]); len(path) != maxPathLength+1 || string(path[maxPathLength:]) != "…" {
+		t.Errorf("expected a cut path, got %q", body["path"])
+	}
+}
+
+func TestEnvInterpolation(t *testing.T) {
+	if err := os.Setenv("PRETTY_ERROR_NOTICE", "maintenance"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("PRETTY_ERROR_NOTICE")
+
+	config := &Config{
+		Rewrites: []Rewrite{{Regex: "(?P<word>ok)", Replacement: "${word}, ${PRETTY_ERROR_NOTICE}"}},
+		Banner:   &Banner{Snippet: "<p>${PRETTY_ERROR_NOTICE}</p>"},
+	}
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.Header().Set("Content-Type", "text/html")
+		_, _ = responseWriter.Write([]byte("<html><body>ok</body></html>"))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if expected := "<html><body>ok, maintenance<p>maintenance</p></body></html>"; recorder.Body.String() != expected {
+		t.Errorf("got body %q, want %q", recorder.Body.String(), expected)
+	}
+
+	if config.Banner.Snippet != "<p>${PRETTY_ERROR_NOTICE}</p>" {
+		t.Errorf("config was modified, got banner snippet %q", config.Banner.Snippet)
+	}
+
+	_, err = New(context.Background(), http.HandlerFunc(next), &Config{
+		Service: &ErrorService{URL: "https://${PRETTY_ERROR_UNSET}/{status}.html"},
+		Webhook: &Webhook{URL: "${PRETTY_ERROR_UNSET}"},
+	}, "prettyError")
+
+	var validationError *ValidationError
+	if !errors.As(err, &validationError) {
+		t.Fatalf("got error %v, want a *ValidationError", err)
+	}
+
+	expected := []string{
+		"service.url: environment variable PRETTY_ERROR_UNSET is not set",
+		"webhook.url: environment variable PRETTY_ERROR_UNSET is not set",
+	}
+	for _, problem := range expected {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("got error %q, want it to contain %q", err, problem)
+		}
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		status, _ := strconv.Atoi(req.URL.Query().Get("status"))
+
+		responseWriter.Header().Set("Content-Type", "text/html")
+		responseWriter.WriteHeader(status)
+		_, _ = responseWriter.Write([]byte("<html><body>foo</body></html>"))
+	}
+
+	config := &Config{
+		Rewrites:     []Rewrite{{Regex: "foo", Replacement: "bar", Status: []string{"200"}}},
+		RecentErrors: 8,
+		StatusPath:   "/_status",
+		Preview:      &Preview{Token: "secret"},
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := []int{http.StatusOK, http.StatusNotFound, http.StatusBadGateway, http.StatusServiceUnavailable}
+
+	var wait sync.WaitGroup
+
+	for worker := 0; worker < 16; worker++ {
+		wait.Add(1)
+
+		go func(worker int) {
+			defer wait.Done()
+
+			for index := 0; index < 25; index++ {
+				status := statuses[(worker+index)%len(statuses)]
+
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/?status="+strconv.Itoa(status), nil))
+
+				if recorder.Code != status {
+					t.Errorf("got status %d, want %d", recorder.Code, status)
+				}
+
+				switch {
+				case status == http.StatusOK && recorder.Body.String() != "<html><body>bar</body></html>":
+					t.Errorf("expected the body of 200 to be rewritten, got %q", recorder.Body.String())
+				case status >= 500 && strings.Contains(recorder.Body.String(), "<body>foo</body>"):
+					t.Errorf("expected the body of %d to be replaced, got %q", status, recorder.Body.String())
+				case status == http.StatusNotFound && !strings.Contains(recorder.Body.String(), "foo"):
+					t.Errorf("expected the body of 404 to pass through, got %q", recorder.Body.String())
+				}
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/_status?token=secret", nil))
+		}(worker)
+	}
+
+	wait.Wait()
+}
+
+func TestUpstreamBody(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "error.html"), []byte("<p>{{ .UpstreamBody }}</p>"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.Header().Set("Content-Type", "text/html")
+		responseWriter.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = responseWriter.Write([]byte("<b>database "))
+		_, _ = responseWriter.Write([]byte("down</b>"))
+	}
+
+	tests := []struct {
+		desc     string
+		config   *Config
+		expected string
+	}{
+		{
+			desc:     "should expose the upstream body",
+			config:   &Config{TemplateDir: dir, UpstreamBody: true},
+			expected: "<p>&lt;b&gt;database down&lt;/b&gt;</p>",
+		},
+		{
+			desc:     "should not expose the recent errors snippet",
+			config:   &Config{TemplateDir: dir, RecentErrors: 1},
+			expected: "<p></p>",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			handler, err := New(context.Background(), http.HandlerFunc(next), test.config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if recorder.Body.String() != test.expected {
+				t.Errorf("got body %q, want %q", recorder.Body.String(), test.expected)
+			}
+		})
+	}
+}
+
+func TestFlushPolicy(t *testing.T) {
+	tests := []struct {
+		desc       string
+		policy     string
+		status     int
+		expStatus  int
+		expFlushed bool
+	}{
+		{desc: "immediate commits the response", status: http.StatusServiceUnavailable, expStatus: http.StatusOK,
+			expFlushed: true},
+		{desc: "immediate flushes streaming responses", status: http.StatusOK, expStatus: http.StatusOK, expFlushed: true},
+		{desc: "delayed waits for the status", policy: "delayed", status: http.StatusServiceUnavailable,
+			expStatus: http.StatusServiceUnavailable},
+		{desc: "delayed flushes forwarded responses", policy: "delayed", status: http.StatusOK,
+			expStatus: http.StatusOK, expFlushed: true},
+		{desc: "disabled ignores flushes", policy: "disabled", status: http.StatusOK, expStatus: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/plain")
+				responseWriter.(http.Flusher).Flush()
+				responseWriter.WriteHeader(test.status)
+				_, _ = responseWriter.Write([]byte("upstream body"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), &Config{FlushPolicy: test.policy}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if recorder.Code != test.expStatus || recorder.Flushed != test.expFlushed {
+				t.Errorf("got status %d flushed %t, want %d flushed %t", recorder.Code, recorder.Flushed,
+					test.expStatus, test.expFlushed)
+			}
+		})
+	}
+
+	_, err := New(context.Background(), http.HandlerFunc(http.NotFound), &Config{FlushPolicy: "sometimes"}, "")
+	if err == nil || !strings.Contains(err.Error(), `flushPolicy: unknown policy "sometimes"`) {
+		t.Errorf("expected an unknown policy problem, got %v", err)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	var logs bytes.Buffer
+
+	logging.SetLogger(logging.NewStdLogger(log.New(&logs, "", 0)))
+
+	defer logging.SetLogger(nil)
+
+	var leaked http.ResponseWriter
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/late" {
+			_, _ = responseWriter.Write([]byte("ok"))
+			responseWriter.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		leaked = responseWriter
+
+		responseWriter.WriteHeader(http.StatusBadGateway)
+		_, _ = responseWriter.Write([]byte("upstream "))
+		_, _ = responseWriter.Write([]byte("failure"))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), &Config{LogLevel: "debug"}, "diagnostics")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/late", nil))
+
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "ok" {
+		t.Errorf("got %d %q, want the 200 already sent", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/failure", nil))
+
+	if recorder.Code != http.StatusBadGateway {
+		t.Errorf("got status %d, want 502", recorder.Code)
+	}
+
+	snapshot := metrics.DefaultRegistry.Snapshot("diagnostics")
+	diagnostics := snapshot.Diagnostics
+	if diagnostics[diagnosticSuperfluousWriteHeader] != 1 || diagnostics[diagnosticWriteAfterCatch] != 0 {
+		t.Errorf("unexpected diagnostics %v", snapshot.Diagnostics)
+	}
+
+	_, _ = leaked.Write([]byte("late"))
+
+	if strings.HasSuffix(recorder.Body.String(), "late") {
+		t.Errorf("expected the late write to be dropped, got %q", recorder.Body.String())
+	}
+
+	diagnostics = metrics.DefaultRegistry.Snapshot("diagnostics").Diagnostics
+	if diagnostics[diagnosticWriteAfterCatch] != 1 {
+		t.Errorf("expected the write once the handler returned to be diagnosed, got %v", diagnostics)
+	}
+
+	for _, expected := range []string{
+		"DEBUG superfluous WriteHeader call ignored status=200 ignored=500 path=/late caller=",
+		"DEBUG body of a replaced response dropped status=502 path=/failure caller=",
+		"pretty-error.TestDiagnostics.func1 ",
+		"pretty-error.TestDiagnostics ",
+	} {
+		if !strings.Contains(logs.String(), expected) {
+			t.Errorf("expected %q in logs:\n%s", expected, logs.String())
+		}
+	}
+}
+
+func TestBodySignatures(t *testing.T) {
+	tests := []struct {
+		desc      string
+		config    *Config
+		body      string
+		expStatus int
+		expBody   string
+	}{
+		{
+			desc:      "literal signature",
+			config:    &Config{BodySignatures: []BodySignature{{Pattern: "Whitelabel Error Page"}}},
+			body:      "<h1>Whitelabel Error Page</h1><p>This application has no explicit mapping</p>",
+			expStatus: http.StatusInternalServerError,
+			expBody:   "Internal Server Error",
+		},
+		{
+			desc: "regex signature with status",
+			config: &Config{BodySignatures: []BodySignature{
+				{Pattern: "regex:(?i)fatal error:", Status: http.StatusServiceUnavailable},
+			}},
+			body:      "<b>Fatal error</b>: nope" + strings.Repeat(".", 100) + "<b>FATAL ERROR:</b> out of memory",
+			expStatus: http.StatusServiceUnavailable,
+			expBody:   "Service Unavailable",
+		},
+		{
+			desc: "signature beyond the limit",
+			config: &Config{
+				BodySignatures:     []BodySignature{{Pattern: "Fatal error:"}},
+				BodySignatureLimit: 8,
+			},
+			body:      "all fine, Fatal error: is only a quote",
+			expStatus: http.StatusOK,
+			expBody:   "all fine, Fatal error: is only a quote",
+		},
+		{
+			desc: "no signature rewritten",
+			config: &Config{
+				BodySignatures: []BodySignature{{Pattern: "Fatal error:"}},
+				Rewrites:       []Rewrite{{Regex: "fine", Replacement: "great"}},
+			},
+			body:      "all fine",
+			expStatus: http.StatusOK,
+			expBody:   "all great",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/html")
+
+				for _, chunk := range strings.SplitAfter(test.body, " ") {
+					_, _ = responseWriter.Write([]byte(chunk))
+				}
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), test.config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if recorder.Code != test.expStatus || !strings.Contains(recorder.Body.String(), test.expBody) {
+				t.Errorf("got %d %q, want %d with %q", recorder.Code, recorder.Body.String(), test.expStatus, test.expBody)
+			}
+		})
+	}
+
+	config := &Config{BodySignatures: []BodySignature{{Status: 200}, {Pattern: "regex:("}}, BodySignatureLimit: -1}
+
+	_, err := New(context.Background(), http.NotFoundHandler(), config, "")
+	for _, expected := range []string{
+		"bodySignatures[0].status: 200 is not an error status",
+		"bodySignatures[0].pattern: is required",
+		"bodySignatures[1].pattern: error parsing regexp",
+		"bodySignatureLimit: must not be negative",
+	} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestTruncatedUpstream(t *testing.T) {
+	tests := []struct {
+		desc      string
+		rewrite   bool
+		abort     bool
+		expStatus int
+		expBody   string
+		expPanic  bool
+	}{
+		{desc: "short body held back", rewrite: true, expStatus: http.StatusBadGateway, expBody: "Bad Gateway"},
+		{
+			desc: "aborted body held back", rewrite: true, abort: true,
+			expStatus: http.StatusBadGateway, expBody: "Bad Gateway",
+		},
+		{desc: "short body sent", expStatus: http.StatusOK, expBody: "partial"},
+		{desc: "aborted body sent", abort: true, expStatus: http.StatusOK, expBody: "partial", expPanic: true},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/html")
+				responseWriter.Header().Set("Content-Length", "100")
+				_, _ = responseWriter.Write([]byte("partial"))
+
+				if test.abort {
+					panic(http.ErrAbortHandler)
+				}
+			}
+
+			config := &Config{}
+			if test.rewrite {
+				config.Rewrites = []Rewrite{{Regex: "partial", Replacement: "complete"}}
+			}
+
+			name := "truncated " + test.desc
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+
+			panicked := func() (recovered interface{}) {
+				defer func() { recovered = recover() }()
+
+				handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+				return nil
+			}()
+
+			if (panicked == http.ErrAbortHandler) != test.expPanic {
+				t.Errorf("got panic %v, want http.ErrAbortHandler: %t", panicked, test.expPanic)
+			}
+
+			if recorder.Code != test.expStatus || !strings.Contains(recorder.Body.String(), test.expBody) {
+				t.Errorf("got %d %q, want %d with %q", recorder.Code, recorder.Body.String(), test.expStatus, test.expBody)
+			}
+
+			expTruncated := uint64(0)
+			if !test.rewrite {
+				expTruncated = 1
+			}
+
+			if got := metrics.DefaultRegistry.Snapshot(name).Diagnostics[diagnosticTruncatedResponse]; got != expTruncated {
+				t.Errorf("got %d truncated responses, want %d", got, expTruncated)
+			}
+		})
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("upstream bug")
+	}), &Config{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != "upstream bug" {
+			t.Errorf("expected the upstream panic to go on, got %v", recovered)
+		}
+	}()
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestFetchHeaderFromClient(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusInternalServerError)
+		_, _ = responseWriter.Write([]byte("stack trace of internal.example"))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), &Config{}, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, value := range []string{"1", "true", fetchToken + "x"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(fetchHeader, value)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		if strings.Contains(recorder.Body.String(), "stack trace") ||
+			!strings.Contains(recorder.Body.String(), "Internal Server Error") {
+			t.Errorf("%q: got %q, want the error page", value, recorder.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(fetchHeader, fetchToken)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if !strings.Contains(recorder.Body.String(), "stack trace") {
+		t.Errorf("got %q, want the page fetch forwarded untouched", recorder.Body.String())
+	}
+}
+
+func TestRewriteTemplateRequest(t *testing.T) {
+	tests := []struct {
+		desc        string
+		contentType string
+		target      string
+		host        string
+		expBody     string
+	}{
+		{
+			desc:        "should escape request fields in HTML",
+			contentType: "text/html",
+			target:      "/a'b&c/%3Cscript%3E",
+			host:        `example.com"><script>`,
+			expBody:     "<p>example.comscript /a&#39;b&amp;c/%3Cscript%3E</p>",
+		},
+		{
+			desc:        "should sanitize request fields in text",
+			contentType: "text/plain",
+			target:      "/a'b&c/%3Cscript%3E",
+			host:        `example.com"><script>`,
+			expBody:     "<p>example.comscript /a'b&c/%3Cscript%3E</p>",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", test.contentType)
+				responseWriter.WriteHeader(http.StatusNotFound)
+				_, _ = responseWriter.Write([]byte("<p>here</p>"))
+			}
+
+			config := &Config{
+				Rewrites: []Rewrite{{Regex: "here", Replacement: "{{ .Request.Host }} {{ .Request.Path }}"}},
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, test.target, nil)
+			req.Host = test.host
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+}
+
+func TestStatusEndpointToken(t *testing.T) {
+	config := &Config{StatusPath: "/_pretty-error/status"}
+	if _, err := New(context.Background(), http.NotFoundHandler(), config, "prettyError"); err == nil ||
+		!strings.Contains(err.Error(), "statusPath") {
+		t.Errorf("got error %v, want a statusPath problem", err)
+	}
+
+	config.Preview = &Preview{Token: "secret"}
+
+	handler, err := New(context.Background(), http.NotFoundHandler(), config, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc          string
+		target        string
+		authorization string
+		expStatus     int
+	}{
+		{desc: "should refuse requests without token", target: "/_pretty-error/status", expStatus: http.StatusUnauthorized},
+		{desc: "should refuse wrong tokens", target: "/_pretty-error/status?token=guess", expStatus: http.StatusUnauthorized},
+		{desc: "should accept the query token", target: "/_pretty-error/status?token=secret", expStatus: http.StatusOK},
+		{
+			desc:          "should accept the bearer token",
+			target:        "/_pretty-error/status",
+			authorization: "Bearer secret",
+			expStatus:     http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, test.target, nil)
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != test.expStatus {
+				t.Errorf("got status %d, want %d", recorder.Code, test.expStatus)
+			}
+
+			if test.expStatus == http.StatusUnauthorized && strings.Contains(recorder.Body.String(), "middleware") {
+				t.Errorf("got report %q without token", recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestRecentErrorsSnippets(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/failing", nil)
+
+	for _, preview := range []*Preview{nil, {}, {Token: "secret"}} {
+		recent := newRecentErrors(&Config{RecentErrors: 1, Preview: preview}, &validator{})
+		recent.record(req, http.StatusBadGateway, []byte("password=hunter2"))
+
+		listed := recent.list()
+		if len(listed) != 1 || listed[0].Path != "/failing" {
+			t.Fatalf("got recent errors %+v", listed)
+		}
+
+		if expSnippet := preview != nil && preview.Token != ""; (listed[0].Snippet != "") != expSnippet {
+			t.Errorf("preview %+v: got snippet %q", preview, listed[0].Snippet)
+		}
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	config := &Config{CORS: &CORS{AllowOrigins: []string{"*"}, AllowCredentials: true}}
+	if _, err := New(context.Background(), http.NotFoundHandler(), config, "prettyError"); err == nil ||
+		!strings.Contains(err.Error(), "cors.allowOrigins") {
+		t.Errorf("got error %v, want a cors.allowOrigins problem", err)
+	}
+
+	config.CORS.AllowCredentials = false
+
+	handler, err := New(context.Background(), http.HandlerFunc(func(responseWriter http.ResponseWriter, _ *http.Request) {
+		responseWriter.WriteHeader(http.StatusBadGateway)
+	}), config, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+
+	handler.ServeHTTP(recorder, req)
+
+	if allow := recorder.Header().Get("Access-Control-Allow-Origin"); allow != "*" {
+		t.Errorf("got Access-Control-Allow-Origin %q, want *", allow)
+	}
+
+	if credentials := recorder.Header().Get("Access-Control-Allow-Credentials"); credentials != "" {
+		t.Errorf("got Access-Control-Allow-Credentials %q", credentials)
+	}
+}
+
+func TestAccessLogReload(t *testing.T) {
+	accessLogPath := filepath.Join(t.TempDir(), "access.log")
+
+	first := newAccessLog(accessLogPath, &validator{})
+	second := newAccessLog(accessLogPath, &validator{})
+
+	if first == nil || first != second {
+		t.Fatalf("got access logs %p and %p, want the file shared", first, second)
+	}
+
+	for _, accessLog := range []*accessLog{first, second} {
+		if err := accessLog.record(accessLogEntry{Path: "/reloaded"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, err := os.ReadFile(accessLogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := strings.Count(string(content), "/reloaded"); lines != 2 {
+		t.Errorf("got %d lines, want 2", lines)
+	}
+}
+
+func TestStatsdReload(t *testing.T) {
+	config := &Statsd{Address: "127.0.0.1:8125", Prefix: "reload"}
+
+	first := newStatsdRecorder(config, "statsdReload", &validator{})
+	second := newStatsdRecorder(config, "statsdReload", &validator{})
+
+	if first == nil || first != second {
+		t.Fatalf("got recorders %p and %p, want the socket shared", first, second)
+	}
+
+	if other := newStatsdRecorder(config, "statsdOther", &validator{}); other == first {
+		t.Error("expected middlewares with another name to get their own recorder")
+	}
+}
+
+func TestReferenceIDCorrelation(t *testing.T) {
+	accessLogPath := filepath.Join(t.TempDir(), "access.log")
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.WriteHeader(http.StatusBadGateway)
+	}
+
+	reporter := &recordingReporter{}
+
+	handler, err := NewWithOptions(context.Background(), http.HandlerFunc(next), &Config{AccessLog: accessLogPath},
+		"reported", WithReporter(reporter))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	content, err := os.ReadFile(accessLogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entry accessLogEntry
+	if err := json.Unmarshal(content, &entry); err != nil {
+		t.Fatalf("expected one JSON line, got %s: %v", content, err)
+	}
+
+	if len(reporter.events) != 1 || entry.ReferenceID == "" || reporter.events[0].ReferenceID != entry.ReferenceID {
+		t.Errorf("got events %+v and access log reference %q, want the same generated reference",
+			reporter.events, entry.ReferenceID)
+	}
+}
+
+func TestCloseNotifyReleased(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		notifier := responseWriter.(http.CloseNotifier)
+		if notifier.CloseNotify() != notifier.CloseNotify() {
+			t.Error("expected every CloseNotify call to return the same channel")
+		}
+
+		responseWriter.WriteHeader(http.StatusOK)
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), &Config{}, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer := &hijackCloseNotifyWriter{plainWriter{header: make(http.Header)}}
+	handler.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > goroutines; {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d goroutines, want %d once the request was served", runtime.NumGoroutine(), goroutines)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestErrorServiceFailures(t *testing.T) {
+	tests := []struct {
+		desc       string
+		failureTTL string
+		hijack     bool
+		expFetches int32
+	}{
+		{desc: "should skip a status which failed", expFetches: 1},
+		{desc: "should skip every status once unreachable", hijack: true, expFetches: 1},
+		{desc: "should retry without failure ttl", failureTTL: "0s", expFetches: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var fetches int32
+
+			service := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, req *http.Request) {
+				atomic.AddInt32(&fetches, 1)
+
+				if test.hijack {
+					conn, _, err := responseWriter.(http.Hijacker).Hijack()
+					if err == nil {
+						_ = conn.Close()
+					}
+
+					return
+				}
+
+				responseWriter.WriteHeader(http.StatusNotFound)
+			}))
+			defer service.Close()
+
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				status, _ := strconv.Atoi(req.URL.Query().Get("status"))
+				responseWriter.WriteHeader(status)
+			}
+
+			config := &Config{Service: &ErrorService{URL: service.URL + "/{status}.html", FailureTTL: test.failureTTL}}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			statuses := []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}
+			if test.hijack {
+				statuses = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+			}
+
+			for _, status := range statuses {
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/?status="+strconv.Itoa(status), nil))
+
+				if recorder.Code != status || !strings.Contains(recorder.Body.String(), http.StatusText(status)) {
+					t.Errorf("got %d %q, want the embedded page of %d", recorder.Code, recorder.Body.String(), status)
+				}
+			}
+
+			if count := atomic.LoadInt32(&fetches); count != test.expFetches {
+				t.Errorf("got %d fetches, want %d", count, test.expFetches)
+			}
+		})
+	}
+}
+
+func TestBannerSkippedContexts(t *testing.T) {
+	tests := []struct {
+		desc    string
+		body    string
+		expBody string
+	}{
+		{
+			desc:    "should skip scripts",
+			body:    `<body><script>document.write("</body>")</script>ok</body>`,
+			expBody: `<body><script>document.write("</body>")</script>ok<p>notice</p></body>`,
+		},
+		{
+			desc:    "should skip comments",
+			body:    `<body><!-- layout </body> --><style>p::after { content: "</body>" }</style>ok</body>`,
+			expBody: `<body><!-- layout </body> --><style>p::after { content: "</body>" }</style>ok<p>notice</p></body>`,
+		},
+		{
+			desc:    "should leave pages whose tag is only in scripts alone",
+			body:    `<body><SCRIPT type="module">"</body>"</SCRIPT >`,
+			expBody: `<body><SCRIPT type="module">"</body>"</SCRIPT >`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/html; charset=utf-8")
+				_, _ = responseWriter.Write([]byte(test.body))
+			}
+
+			config := &Config{Banner: &Banner{Snippet: "<p>notice</p>"}}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+}
+
 // func TestServeHTTP(t *testing.T) {
 // 	tests := []struct {
 // 		desc            string
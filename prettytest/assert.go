@@ -0,0 +1,128 @@
+package prettytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// generatorMarker is found in the pages generated by the embedded template.
+const generatorMarker = `content="pretty-error;`
+
+// IsGeneratedPage report whether body was generated by the embedded template, rather than sent by the upstream.
+// Pages of custom templates cannot be told apart from upstream bodies.
+func IsGeneratedPage(body []byte) bool {
+	return strings.Contains(string(body), generatorMarker)
+}
+
+// AssertErrorPage fail t unless recorder holds an HTML error page for status, showing the status code.
+func AssertErrorPage(t testing.TB, recorder *httptest.ResponseRecorder, status int) {
+	t.Helper()
+
+	if recorder.Code != status {
+		t.Errorf("got status %d, want %d", recorder.Code, status)
+	}
+
+	if mediaType := contentType(recorder); mediaType != "text/html" {
+		t.Errorf("got content type %q, want text/html", mediaType)
+	}
+
+	if !strings.Contains(recorder.Body.String(), strconv.Itoa(status)) {
+		t.Errorf("expected the page to show status %d, got %q", status, recorder.Body.String())
+	}
+}
+
+// AssertErrorEnvelope fail t unless recorder holds a JSON error envelope for status.
+func AssertErrorEnvelope(t testing.TB, recorder *httptest.ResponseRecorder, status int) {
+	t.Helper()
+
+	if recorder.Code != status {
+		t.Errorf("got status %d, want %d", recorder.Code, status)
+	}
+
+	if mediaType := contentType(recorder); mediaType != "application/json" {
+		t.Errorf("got content type %q, want application/json", mediaType)
+	}
+
+	var envelope struct {
+		Error struct {
+			Status int `json:"status"`
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &envelope); err != nil {
+		t.Errorf("expected a JSON envelope, got %q: %v", recorder.Body.String(), err)
+
+		return
+	}
+
+	if envelope.Error.Status != status {
+		t.Errorf("got envelope status %d, want %d", envelope.Error.Status, status)
+	}
+}
+
+// AssertPassthrough fail t unless recorder holds the upstream response with status and body, left untouched.
+func AssertPassthrough(t testing.TB, recorder *httptest.ResponseRecorder, status int, body string) {
+	t.Helper()
+
+	if recorder.Code != status {
+		t.Errorf("got status %d, want %d", recorder.Code, status)
+	}
+
+	if recorder.Body.String() != body {
+		t.Errorf("got body %q, want %q", recorder.Body.String(), body)
+	}
+}
+
+// AssertGolden fail t unless each of pages, such as rendered by htmltemplates.RenderAll, matches its golden file
+// <status>.html in dir, as written by the pretty-error-golden command, and every golden file of dir has a page.
+func AssertGolden(t testing.TB, dir string, pages map[int][]byte) {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil || len(files) == 0 {
+		t.Errorf("no golden files found in %s, generate them with the pretty-error-golden command", dir)
+	}
+
+	for _, file := range files {
+		status, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(file), ".html"))
+		if err != nil {
+			continue
+		}
+
+		if _, ok := pages[status]; !ok {
+			t.Errorf("no page rendered for the golden file %s", file)
+		}
+	}
+
+	for status, body := range pages {
+		path := filepath.Join(dir, strconv.Itoa(status)+".html")
+
+		golden, err := os.ReadFile(path)
+		if err != nil {
+			t.Errorf("unable to read the golden file of %d: %v", status, err)
+
+			continue
+		}
+
+		if !bytes.Equal(body, golden) {
+			t.Errorf("the page of %d differs from %s, regenerate the golden files if the change is expected", status, path)
+		}
+	}
+}
+
+// contentType get the media type of the recorded response, without parameters.
+func contentType(recorder *httptest.ResponseRecorder) string {
+	mediaType, _, err := mime.ParseMediaType(recorder.Header().Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+
+	return mediaType
+}
@@ -0,0 +1,172 @@
+package prettytest_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	prettyerror "github.com/packruler/pretty-error"
+	"github.com/packruler/pretty-error/prettytest"
+)
+
+func TestRecorderCapabilities(t *testing.T) {
+	for capabilities := prettytest.Capability(0); capabilities <= prettytest.AllCapabilities; capabilities++ {
+		recorder := prettytest.NewRecorder(capabilities)
+		writer := recorder.Writer()
+
+		_, flusher := writer.(http.Flusher)
+		_, hijacker := writer.(http.Hijacker)
+		_, closeNotifier := writer.(http.CloseNotifier)
+		_, pusher := writer.(http.Pusher)
+
+		expected := []bool{
+			capabilities&prettytest.Flusher != 0,
+			capabilities&prettytest.Hijacker != 0,
+			capabilities&prettytest.CloseNotifier != 0,
+			capabilities&prettytest.Pusher != 0,
+		}
+		if got := []bool{flusher, hijacker, closeNotifier, pusher}; !equalBools(got, expected) {
+			t.Errorf("capabilities %04b: got interfaces %v, want %v", capabilities, got, expected)
+		}
+	}
+}
+
+func TestRecorderBehindMiddleware(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		if _, ok := responseWriter.(http.Hijacker); ok {
+			t.Error("expected the response writer not to be a http.Hijacker")
+		}
+
+		if err := responseWriter.(http.Pusher).Push("/style.css", nil); err != nil {
+			t.Errorf("unexpected push error: %v", err)
+		}
+
+		responseWriter.Header().Set("Content-Type", "text/plain")
+		_, _ = responseWriter.Write([]byte("ok"))
+		responseWriter.(http.Flusher).Flush()
+	}
+
+	config := prettyerror.CreateConfig()
+
+	handler, err := prettyerror.New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := prettytest.NewRecorder(prettytest.Flusher | prettytest.Pusher)
+	recorder.Serve(handler, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	prettytest.AssertPassthrough(t, recorder.ResponseRecorder, http.StatusOK, "ok")
+
+	if recorder.Flushes != 1 {
+		t.Errorf("got %d flushes, want 1", recorder.Flushes)
+	}
+
+	if len(recorder.Pushed) != 1 || recorder.Pushed[0] != "/style.css" {
+		t.Errorf("expected /style.css to be pushed, got %v", recorder.Pushed)
+	}
+}
+
+func TestAssertErrorPage(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.Header().Set("Content-Type", "text/plain")
+		responseWriter.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = responseWriter.Write([]byte("upstream failure"))
+	}
+
+	config := prettyerror.CreateConfig()
+
+	handler, err := prettyerror.New(context.Background(), http.HandlerFunc(next), config, "prettyError")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := prettytest.NewRecorder(prettytest.AllCapabilities)
+	recorder.Serve(handler, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	prettytest.AssertErrorPage(t, recorder.ResponseRecorder, http.StatusServiceUnavailable)
+
+	if !prettytest.IsGeneratedPage(recorder.Body.Bytes()) {
+		t.Errorf("expected a generated page, got %q", recorder.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/json")
+
+	recorder = prettytest.NewRecorder(0)
+	recorder.Serve(handler, req)
+
+	prettytest.AssertErrorEnvelope(t, recorder.ResponseRecorder, http.StatusServiceUnavailable)
+}
+
+func TestRecorderHijack(t *testing.T) {
+	recorder := prettytest.NewRecorder(prettytest.Hijacker)
+
+	conn, _, err := recorder.Writer().(http.Hijacker).Hijack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	go func() {
+		_, _ = conn.Write([]byte("hi"))
+	}()
+
+	buf := make([]byte, 2)
+	if _, err := recorder.Conn().Read(buf); err != nil || string(buf) != "hi" {
+		t.Errorf("got %q (%v) from the hijacked connection, want hi", buf, err)
+	}
+
+	if !recorder.Hijacked {
+		t.Error("expected the connection to be recorded as hijacked")
+	}
+}
+
+func equalBools(got, expected []bool) bool {
+	for index := range got {
+		if got[index] != expected[index] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// failureRecorder records the failures of the assertions it is given to instead of failing the test.
+type failureRecorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *failureRecorder) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func TestAssertGoldenMissingPage(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, status := range []string{"404", "503"} {
+		if err := os.WriteFile(filepath.Join(dir, status+".html"), []byte(status), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recorder := &failureRecorder{TB: t}
+	prettytest.AssertGolden(recorder, dir, map[int][]byte{404: []byte("404")})
+
+	if len(recorder.failures) != 1 || !strings.Contains(recorder.failures[0], "503.html") {
+		t.Errorf("expected the golden file without a page to fail, got %q", recorder.failures)
+	}
+
+	recorder = &failureRecorder{TB: t}
+	prettytest.AssertGolden(recorder, t.TempDir(), nil)
+
+	if len(recorder.failures) != 1 {
+		t.Errorf("expected a directory without golden files to fail, got %q", recorder.failures)
+	}
+}
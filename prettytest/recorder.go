@@ -0,0 +1,241 @@
+// Package prettytest provides helpers to unit test handlers served behind the pretty error middleware.
+package prettytest
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+)
+
+// Capability an optional interface of http.ResponseWriter exposed by the writer of a Recorder.
+type Capability int
+
+// Capabilities exposed by Recorder.Writer, combined with |.
+const (
+	Flusher Capability = 1 << iota
+	Hijacker
+	CloseNotifier
+	Pusher
+)
+
+// AllCapabilities exposes every optional interface, like the writers of net/http.
+const AllCapabilities = Flusher | Hijacker | CloseNotifier | Pusher
+
+// Recorder records the response written through its Writer, along with the use of the optional interfaces.
+// The Recorder itself must not be given to handlers: only Writer exposes exactly the selected capabilities.
+type Recorder struct {
+	*httptest.ResponseRecorder
+	// Flushes counts the calls to Flush.
+	Flushes int
+	// Hijacked is set once the connection was hijacked.
+	Hijacked bool
+	// Pushed lists the targets pushed, in order.
+	Pushed []string
+
+	capabilities Capability
+	closeNotify  chan bool
+	client       net.Conn
+}
+
+// NewRecorder get a Recorder whose Writer exposes capabilities.
+func NewRecorder(capabilities Capability) *Recorder {
+	return &Recorder{
+		ResponseRecorder: httptest.NewRecorder(),
+		capabilities:     capabilities,
+		closeNotify:      make(chan bool, 1),
+	}
+}
+
+// Serve serve req with handler, recording the response.
+func (recorder *Recorder) Serve(handler http.Handler, req *http.Request) {
+	handler.ServeHTTP(recorder.Writer(), req)
+}
+
+// NotifyClose signal the client went away to the handler listening to CloseNotify.
+func (recorder *Recorder) NotifyClose() {
+	select {
+	case recorder.closeNotify <- true:
+	default:
+	}
+}
+
+// Conn get the client end of the hijacked connection, nil until the connection is hijacked.
+func (recorder *Recorder) Conn() net.Conn {
+	return recorder.client
+}
+
+// Writer get the http.ResponseWriter recording to recorder, implementing exactly its capabilities.
+func (recorder *Recorder) Writer() http.ResponseWriter {
+	return capabilityWriters[recorder.capabilities&AllCapabilities](writerParts{
+		writer:        recorder.ResponseRecorder,
+		flusher:       recorderFlusher{recorder},
+		hijacker:      recorderHijacker{recorder},
+		closeNotifier: recorderCloseNotifier{recorder},
+		pusher:        recorderPusher{recorder},
+	})
+}
+
+// writerParts the implementations of the interfaces combined by capabilityWriters.
+type writerParts struct {
+	writer        http.ResponseWriter
+	flusher       http.Flusher
+	hijacker      http.Hijacker
+	closeNotifier http.CloseNotifier
+	pusher        http.Pusher
+}
+
+// capabilityWriters build, for every combination of capabilities, the writer embedding exactly their interfaces.
+var capabilityWriters = [AllCapabilities + 1]func(parts writerParts) http.ResponseWriter{
+	0: func(parts writerParts) http.ResponseWriter {
+		return struct {
+			http.ResponseWriter
+		}{parts.writer}
+	},
+	Flusher: func(parts writerParts) http.ResponseWriter {
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+		}{parts.writer, parts.flusher}
+	},
+	Hijacker: func(parts writerParts) http.ResponseWriter {
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+		}{parts.writer, parts.hijacker}
+	},
+	Flusher | Hijacker: func(parts writerParts) http.ResponseWriter {
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+		}{parts.writer, parts.flusher, parts.hijacker}
+	},
+	CloseNotifier: func(parts writerParts) http.ResponseWriter {
+		return struct {
+			http.ResponseWriter
+			http.CloseNotifier
+		}{parts.writer, parts.closeNotifier}
+	},
+	Flusher | CloseNotifier: func(parts writerParts) http.ResponseWriter {
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.CloseNotifier
+		}{parts.writer, parts.flusher, parts.closeNotifier}
+	},
+	Hijacker | CloseNotifier: func(parts writerParts) http.ResponseWriter {
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			http.CloseNotifier
+		}{parts.writer, parts.hijacker, parts.closeNotifier}
+	},
+	Flusher | Hijacker | CloseNotifier: func(parts writerParts) http.ResponseWriter {
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			http.CloseNotifier
+		}{parts.writer, parts.flusher, parts.hijacker, parts.closeNotifier}
+	},
+	Pusher: func(parts writerParts) http.ResponseWriter {
+		return struct {
+			http.ResponseWriter
+			http.Pusher
+		}{parts.writer, parts.pusher}
+	},
+	Flusher | Pusher: func(parts writerParts) http.ResponseWriter {
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Pusher
+		}{parts.writer, parts.flusher, parts.pusher}
+	},
+	Hijacker | Pusher: func(parts writerParts) http.ResponseWriter {
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			http.Pusher
+		}{parts.writer, parts.hijacker, parts.pusher}
+	},
+	Flusher | Hijacker | Pusher: func(parts writerParts) http.ResponseWriter {
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{parts.writer, parts.flusher, parts.hijacker, parts.pusher}
+	},
+	CloseNotifier | Pusher: func(parts writerParts) http.ResponseWriter {
+		return struct {
+			http.ResponseWriter
+			http.CloseNotifier
+			http.Pusher
+		}{parts.writer, parts.closeNotifier, parts.pusher}
+	},
+	Flusher | CloseNotifier | Pusher: func(parts writerParts) http.ResponseWriter {
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.CloseNotifier
+			http.Pusher
+		}{parts.writer, parts.flusher, parts.closeNotifier, parts.pusher}
+	},
+	Hijacker | CloseNotifier | Pusher: func(parts writerParts) http.ResponseWriter {
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			http.CloseNotifier
+			http.Pusher
+		}{parts.writer, parts.hijacker, parts.closeNotifier, parts.pusher}
+	},
+	AllCapabilities: func(parts writerParts) http.ResponseWriter {
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			http.CloseNotifier
+			http.Pusher
+		}{parts.writer, parts.flusher, parts.hijacker, parts.closeNotifier, parts.pusher}
+	},
+}
+
+type recorderFlusher struct {
+	recorder *Recorder
+}
+
+func (flusher recorderFlusher) Flush() {
+	flusher.recorder.Flushes++
+	flusher.recorder.ResponseRecorder.Flush()
+}
+
+type recorderHijacker struct {
+	recorder *Recorder
+}
+
+func (hijacker recorderHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	server, client := net.Pipe()
+	hijacker.recorder.Hijacked = true
+	hijacker.recorder.client = client
+
+	return server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), nil
+}
+
+type recorderCloseNotifier struct {
+	recorder *Recorder
+}
+
+func (closeNotifier recorderCloseNotifier) CloseNotify() <-chan bool {
+	return closeNotifier.recorder.closeNotify
+}
+
+type recorderPusher struct {
+	recorder *Recorder
+}
+
+func (pusher recorderPusher) Push(target string, _ *http.PushOptions) error {
+	pusher.recorder.Pushed = append(pusher.recorder.Pushed, target)
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package pretty_error
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/packruler/pretty-error/htmltemplates"
+	"github.com/packruler/pretty-error/httputil"
+)
+
+// defaultPreviewPath prefix of the preview URLs when Preview.Path is empty.
+const defaultPreviewPath = "/_pretty-error/preview/"
+
+// Preview holds the configuration of the endpoint rendering the page of any status, at Path followed by the status,
+// so templates can be checked without an upstream failure. Requests must carry Token, as a bearer token or in the
+// "token" query parameter.
+type Preview struct {
+	Path  string `json:"path,omitempty" toml:"path,omitempty" yaml:"path,omitempty" export:"true"`
+	Token string `json:"token,omitempty" toml:"token,omitempty" yaml:"token,omitempty" export:"true"`
+}
+
+// previewHandler renders pages like the ones replacing upstream errors, without counting them in metrics.
+type previewHandler struct {
+	prefix      string
+	token       string
+	bodyRewrite *rewriteBody
+}
+
+func newPreviewHandler(config *Preview, v *validator) *previewHandler {
+	if config == nil {
+		return nil
+	}
+
+	prefix := config.Path
+	if prefix == "" {
+		prefix = defaultPreviewPath
+	}
+
+	if !strings.HasPrefix(prefix, "/") {
+		v.check("preview.path", errors.New("must start with /"))
+	}
+
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	if config.Token == "" {
+		v.check("preview.token", errors.New("is required"))
+	}
+
+	return &previewHandler{prefix: prefix, token: config.Token}
+}
+
+// matches determine if req targets the preview endpoint.
+func (preview *previewHandler) matches(req *http.Request) bool {
+	return preview != nil && strings.HasPrefix(req.URL.Path, preview.prefix)
+}
+
+// ServeHTTP render the page of the status ending the path. The "format", "lang" and "theme" query parameters
+// override the negotiated output format, the language and the configured theme.
+func (preview *previewHandler) ServeHTTP(response http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		response.Header().Set("Allow", "GET, HEAD")
+		http.Error(response, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	if !preview.authorized(req) {
+		writeUnauthorized(response)
+
+		return
+	}
+
+	status, err := strconv.Atoi(strings.TrimPrefix(req.URL.Path, preview.prefix))
+	if err != nil || htmltemplates.ValidateStatus(status) != nil {
+		http.NotFound(response, req)
+
+		return
+	}
+
+	bodyRewrite := preview.bodyRewrite
+	metadata := bodyRewrite.pageMetadata(req)
+	query := req.URL.Query()
+
+	if format := query.Get("format"); format == httputil.OutputFormatHTML || format == httputil.OutputFormatJSON {
+		metadata.OutputFormat = format
+	}
+
+	if language := query.Get("lang"); language != "" {
+		metadata.Language = language
+	}
+
+	if theme := query.Get("theme"); theme != "" {
+		metadata.Theme = theme
+	}
+
+	page, err := bodyRewrite.renderPage(req.Context(), status, metadata)
+	if err != nil {
+		// unlike for real errors, the template problem is what the author of the page needs to see.
+		http.Error(response, "unable to render error page: "+err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	response.Header().Set("Cache-Control", "no-store")
+	bodyRewrite.marker.set(response.Header())
+	bodyRewrite.setResponseHeaders(response.Header())
+	bodyRewrite.writePage(response, req, status, metadata, page)
+}
+
+// writeUnauthorized answer a request to an endpoint of the middleware which lacks the preview token.
+func writeUnauthorized(response http.ResponseWriter) {
+	response.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(response, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// authorized determine if req carries the preview token, never when the preview endpoint is not configured.
+func (preview *previewHandler) authorized(req *http.Request) bool {
+	token := req.URL.Query().Get("token")
+	if bearer := req.Header.Get("Authorization"); strings.HasPrefix(bearer, "Bearer ") {
+		token = strings.TrimPrefix(bearer, "Bearer ")
+	}
+
+	return preview != nil && preview.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(preview.token)) == 1
+}
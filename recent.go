@@ -0,0 +1,99 @@
+package pretty_error
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recentSnippetSize is the number of body bytes kept from the intercepted responses listed as recent errors.
+const recentSnippetSize = 256
+
+// recentError describes an intercepted response, as listed by the status endpoint.
+type recentError struct {
+	Time    time.Time `json:"time"`
+	Status  int       `json:"status"`
+	Path    string    `json:"path"`
+	Snippet string    `json:"snippet,omitempty"`
+}
+
+// recentErrors keeps the last intercepted responses in a ring.
+type recentErrors struct {
+	mu      sync.Mutex
+	entries []recentError
+	next    int
+	full    bool
+	// snippets keeps the start of the upstream bodies, only when the status endpoint listing them needs a token.
+	snippets bool
+}
+
+// newRecentErrors get the ring of recent errors, nil when config.RecentErrors is not set. Upstream bodies may hold
+// secrets, their start is only kept when the preview token protects the status endpoint.
+func newRecentErrors(config *Config, v *validator) *recentErrors {
+	if config.RecentErrors < 0 {
+		v.check("recentErrors", errors.New("must not be negative"))
+	}
+
+	if config.RecentErrors <= 0 {
+		return nil
+	}
+
+	return &recentErrors{
+		entries:  make([]recentError, config.RecentErrors),
+		snippets: config.Preview != nil && config.Preview.Token != "",
+	}
+}
+
+// record add the intercepted response to req, replacing the oldest entry once the ring is full.
+func (recent *recentErrors) record(req *http.Request, status int, body []byte) {
+	if recent == nil {
+		return
+	}
+
+	if !recent.snippets {
+		body = nil
+	}
+
+	if len(body) > recentSnippetSize {
+		body = body[:recentSnippetSize]
+	}
+
+	entry := recentError{
+		Time:    time.Now().UTC(),
+		Status:  status,
+		Path:    req.URL.Path,
+		Snippet: strings.ToValidUTF8(string(body), "\uFFFD"),
+	}
+
+	recent.mu.Lock()
+	defer recent.mu.Unlock()
+
+	recent.entries[recent.next] = entry
+	recent.next = (recent.next + 1) % len(recent.entries)
+	recent.full = recent.full || recent.next == 0
+}
+
+// list get the recorded errors, most recent first.
+func (recent *recentErrors) list() []recentError {
+	if recent == nil {
+		return nil
+	}
+
+	recent.mu.Lock()
+	defer recent.mu.Unlock()
+
+	count := recent.next
+	if recent.full {
+		count = len(recent.entries)
+	}
+
+	listed := make([]recentError, 0, count)
+
+	for index := 1; index <= count; index++ {
+		listed = append(listed, recent.entries[(recent.next-index+len(recent.entries))%len(recent.entries)])
+	}
+
+	return listed
+}
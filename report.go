@@ -0,0 +1,62 @@
+package pretty_error
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// ErrorEvent describes an intercepted server error, as given to reporters.
+type ErrorEvent struct {
+	Middleware     string
+	Time           time.Time
+	Method         string
+	Host           string
+	Path           string
+	ClientIP       string
+	UserAgent      string
+	ReferenceID    string
+	UpstreamStatus int
+	Status         int
+	// Detail is the message shown instead of the standard one of the status, such as the message of a gRPC call.
+	Detail string
+}
+
+// Reporter receives the intercepted server errors, turning error pages into an error reporting source for trackers
+// such as Sentry. Report is called once the page is written, on the goroutine serving the request: implementations
+// must not block.
+type Reporter interface {
+	Report(ctx context.Context, event ErrorEvent)
+}
+
+// WithReporter report the intercepted server errors to reporter. It may be given several times.
+func WithReporter(reporter Reporter) Option {
+	return func(opts *options) {
+		opts.reporters = append(opts.reporters, reporter)
+	}
+}
+
+// report give the intercepted server error to the reporters, identified by reference.
+func (bodyRewrite *rewriteBody) report(req *http.Request, reference string, upstreamStatus, status int, detail string) {
+	if len(bodyRewrite.reporters) == 0 || status < http.StatusInternalServerError {
+		return
+	}
+
+	event := ErrorEvent{
+		Middleware:     bodyRewrite.name,
+		Time:           time.Now().UTC(),
+		Method:         req.Method,
+		Host:           req.Host,
+		Path:           req.URL.Path,
+		ClientIP:       clientIP(req),
+		UserAgent:      req.UserAgent(),
+		ReferenceID:    reference,
+		UpstreamStatus: upstreamStatus,
+		Status:         status,
+		Detail:         detail,
+	}
+
+	for _, reporter := range bodyRewrite.reporters {
+		reporter.Report(req.Context(), event)
+	}
+}
@@ -0,0 +1,27 @@
+package pretty_error
+
+import (
+	"net/http"
+
+	"github.com/packruler/pretty-error/htmltemplates"
+	"github.com/packruler/pretty-error/httputil"
+)
+
+// Length limits of the request values given to templates, in characters.
+const (
+	maxMethodLength = 16
+	maxHostLength   = 253
+	maxPathLength   = 256
+	maxQueryLength  = 512
+)
+
+// newRequestInfo describe req to templates, with values sanitized so they cannot be used for injections. The path
+// and query are percent-encoded, leaving no quotes nor angle brackets.
+func newRequestInfo(req *http.Request) htmltemplates.RequestInfo {
+	return htmltemplates.RequestInfo{
+		Method: httputil.SanitizeValue(req.Method, maxMethodLength),
+		Host:   httputil.SanitizeHost(req.Host, maxHostLength),
+		Path:   httputil.SanitizeValue(req.URL.EscapedPath(), maxPathLength),
+		Query:  httputil.SanitizeValue(req.URL.Query().Encode(), maxQueryLength),
+	}
+}
@@ -0,0 +1,34 @@
+package pretty_error
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	stdhttputil "net/http/httputil"
+	"net/url"
+
+	"github.com/packruler/pretty-error/logging"
+)
+
+// NewReverseProxy creates a net/http/httputil.ReverseProxy for target wrapped by the pretty error middleware.
+// Transport errors are reported as a 502 (or 504 on timeout) and rendered like any other filtered status,
+// so config.Status should include them.
+func NewReverseProxy(target *url.URL, config *Config) (http.Handler, error) {
+	proxy := stdhttputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorHandler = ProxyErrorHandler
+
+	return New(context.Background(), proxy, config, "reverseProxy")
+}
+
+// ProxyErrorHandler a ReverseProxy.ErrorHandler that synthesizes an empty 502 response for transport errors.
+// The empty response is left for the wrapping middleware to replace with an error page.
+func ProxyErrorHandler(response http.ResponseWriter, req *http.Request, err error) {
+	logging.GetLogger().Warn("proxy error", logging.F("path", req.URL.Path), logging.F("error", err))
+
+	status := http.StatusBadGateway
+	if errors.Is(err, context.DeadlineExceeded) {
+		status = http.StatusGatewayTimeout
+	}
+
+	response.WriteHeader(status)
+}
@@ -0,0 +1,550 @@
+package pretty_error
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/packruler/pretty-error/compressutil"
+	"github.com/packruler/pretty-error/httputil"
+	"github.com/packruler/pretty-error/logging"
+	"github.com/packruler/pretty-error/types"
+)
+
+// errRewriteBudget is returned once the rewrites of a response ran out of time.
+var errRewriteBudget = errors.New("rewrite time budget exceeded")
+
+// htmlContentTypes are the content types of the bodies in which replacement templates get HTML-escaped values.
+var htmlContentTypes = []string{"text/html", "application/xhtml+xml"}
+
+// replacementData is given to replacement templates for every match.
+type replacementData struct {
+	// Groups maps the named capture groups of the regex to the text they matched.
+	Groups  map[string]string
+	Status  int
+	Request replacementRequest
+}
+
+// replacementRequest exposes the request fields available to replacement templates.
+type replacementRequest struct {
+	Method string
+	Host   string
+	Path   string
+}
+
+type rewrite struct {
+	regex          *regexp.Regexp
+	replacement    []byte
+	template       *template.Template
+	httpCodeRanges types.HTTPCodeRanges
+	anyStatus      bool
+	contentTypes   []string
+	// maxReplacements limits the matches replaced in one response, 0 replaces them all.
+	maxReplacements int
+	// jsonPath addresses the JSON values replaced, in which the regex is optional.
+	jsonPath jsonPath
+	// headers restrict the rewrite to responses with one of the headers, when set.
+	headers []headerMatcher
+	// group restricts the replacements to the matches in which this capture group took part, when set, the other
+	// matches being kept as is.
+	group int
+}
+
+func newRewrites(configs []Rewrite, v *validator) []rewrite {
+	rewrites := make([]rewrite, 0, len(configs))
+
+	for index, rewriteConfig := range configs {
+		field := fmt.Sprintf("rewrites[%d]", index)
+
+		var (
+			regex *regexp.Regexp
+			err   error
+		)
+
+		if rewriteConfig.Regex != "" || rewriteConfig.JSONPath == "" {
+			regex, err = regexp.Compile(rewriteConfig.Regex)
+			v.check(field+".regex", err)
+		}
+
+		replacement := readConfigFile(field+".replacementFile", rewriteConfig.Replacement, rewriteConfig.ReplacementFile, v)
+
+		newRewrite := rewrite{
+			regex:           regex,
+			replacement:     []byte(replacement),
+			httpCodeRanges:  v.parseStatus(field+".status", rewriteConfig.Status, anyStatusRanges),
+			anyStatus:       len(rewriteConfig.Status) == 0,
+			contentTypes:    rewriteConfig.ContentTypes,
+			maxReplacements: rewriteConfig.MaxReplacements,
+		}
+
+		if rewriteConfig.JSONPath != "" {
+			newRewrite.jsonPath, err = parseJSONPath(rewriteConfig.JSONPath)
+			v.check(field+".jsonPath", err)
+		}
+
+		if rewriteConfig.MaxReplacements < 0 {
+			v.check(field+".maxReplacements", errors.New("must not be negative"))
+		}
+
+		if strings.Contains(replacement, "{{") {
+			newRewrite.template, err = template.New(field).Option("missingkey=zero").Parse(replacement)
+			v.check(field+".replacement", err)
+		}
+
+		rewrites = append(rewrites, newRewrite)
+	}
+
+	return rewrites
+}
+
+// readConfigFile get a configuration value given either inline or as the path of a file read at startup,
+// so large values do not have to be embedded in the dynamic configuration.
+func readConfigFile(field, inline, path string, v *validator) string {
+	if path == "" {
+		return inline
+	}
+
+	if inline != "" {
+		v.check(field, errors.New("conflicts with inline value"))
+
+		return inline
+	}
+
+	content, err := os.ReadFile(path)
+	v.check(field, err)
+
+	return string(content)
+}
+
+// newRewriteBudget parse the time the rewrites of one response may take, 0 when unlimited.
+func newRewriteBudget(config *Config, v *validator) time.Duration {
+	return parseDuration("rewriteBudget", config.RewriteBudget, 0, v)
+}
+
+// appliesTo determine if the rewrite runs on responses with status and header.
+// Without configured content types, rewrites only run on text responses, or JSON ones for JSON path rewrites.
+func (r rewrite) appliesTo(status int, header http.Header) bool {
+	if !r.anyStatus && !r.httpCodeRanges.Contains(status) {
+		return false
+	}
+
+	if len(r.headers) > 0 && !matchesAnyHeader(r.headers, header) {
+		return false
+	}
+
+	contentType := header.Get("Content-Type")
+
+	if len(r.contentTypes) == 0 && r.jsonPath != nil {
+		return strings.Contains(contentType, "json")
+	}
+
+	if len(r.contentTypes) == 0 {
+		return contentType == "" || strings.Contains(contentType, "text")
+	}
+
+	return httputil.MatchesContentType(contentType, r.contentTypes)
+}
+
+// rewriteRun holds the state of the rewrites of one response, which may run on several parts of its body.
+type rewriteRun struct {
+	rewrites []rewrite
+	replaced []int
+	status   int
+	request  *http.Request
+	deadline time.Time
+	// escapeHTML escapes the request values given to templates, for HTML bodies.
+	escapeHTML bool
+}
+
+func newRewriteRun(rewrites []rewrite, status int, req *http.Request, header http.Header,
+	budget time.Duration,
+) *rewriteRun {
+	run := &rewriteRun{
+		rewrites:   rewrites,
+		replaced:   make([]int, len(rewrites)),
+		status:     status,
+		request:    req,
+		escapeHTML: httputil.MatchesContentType(header.Get("Content-Type"), htmlContentTypes),
+	}
+
+	if budget > 0 {
+		run.deadline = time.Now().Add(budget)
+	}
+
+	return run
+}
+
+// rewrite run every rewrite on body in order.
+func (run *rewriteRun) rewrite(body []byte) ([]byte, error) {
+	var err error
+
+	for index := range run.rewrites {
+		body, err = run.apply(index, body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return body, nil
+}
+
+// apply replace the matches of a rewrite regex in body, up to its remaining replacements.
+// Templated replacements are executed for every match, others expand $-prefixed capture groups as regexp.Expand.
+func (run *rewriteRun) apply(index int, body []byte) ([]byte, error) {
+	candidate := run.rewrites[index]
+
+	if candidate.jsonPath != nil {
+		return run.applyJSON(index, body)
+	}
+
+	limit := -1
+	if candidate.maxReplacements > 0 {
+		limit = candidate.maxReplacements - run.replaced[index]
+		if limit <= 0 {
+			return body, nil
+		}
+	}
+
+	if err := run.interrupted(); err != nil {
+		return nil, err
+	}
+
+	var matches [][]int
+	if candidate.group > 0 {
+		matches = matchesOfGroup(candidate.regex.FindAllSubmatchIndex(body, -1), candidate.group, limit)
+	} else {
+		matches = candidate.regex.FindAllSubmatchIndex(body, limit)
+	}
+
+	if len(matches) == 0 {
+		return body, nil
+	}
+
+	result, err := run.replaceMatches(candidate, body, matches)
+	if err != nil {
+		return nil, err
+	}
+
+	run.replaced[index] += len(matches)
+
+	return result, nil
+}
+
+// replaceMatches replace every match of the rewrite regex in body.
+func (run *rewriteRun) replaceMatches(candidate rewrite, body []byte, matches [][]int) ([]byte, error) {
+	var result bytes.Buffer
+
+	last := 0
+
+	for _, match := range matches {
+		if err := run.interrupted(); err != nil {
+			return nil, err
+		}
+
+		result.Write(body[last:match[0]])
+
+		if err := run.replace(&result, candidate, body, match); err != nil {
+			return nil, err
+		}
+
+		last = match[1]
+	}
+
+	result.Write(body[last:])
+
+	return result.Bytes(), nil
+}
+
+// matchesOfGroup get the first limit matches in which group took part, all of them when limit is negative.
+func matchesOfGroup(matches [][]int, group, limit int) [][]int {
+	kept := matches[:0]
+
+	for _, match := range matches {
+		if match[2*group] >= 0 && (limit < 0 || len(kept) < limit) {
+			kept = append(kept, match)
+		}
+	}
+
+	return kept
+}
+
+// applyJSON replace the values addressed by the rewrite JSON path in the JSON document body, up to its remaining
+// replacements. Values are replaced whole by the replacement string, or, with a regex, only its matches within
+// string values. The document is encoded again, with its object keys sorted.
+func (run *rewriteRun) applyJSON(index int, body []byte) ([]byte, error) {
+	candidate := run.rewrites[index]
+
+	var document interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("unable to decode JSON body: %w", err)
+	}
+
+	document, err := candidate.jsonPath.replace(document, func(value interface{}) (interface{}, error) {
+		if candidate.maxReplacements > 0 && run.replaced[index] >= candidate.maxReplacements {
+			return value, nil
+		}
+
+		if err := run.interrupted(); err != nil {
+			return nil, err
+		}
+
+		replacement, replaced, err := run.replaceJSONValue(candidate, value)
+		if replaced {
+			run.replaced[index]++
+		}
+
+		return replacement, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result bytes.Buffer
+
+	encoder := json.NewEncoder(&result)
+	encoder.SetEscapeHTML(false)
+
+	if err := encoder.Encode(document); err != nil {
+		return nil, fmt.Errorf("unable to encode JSON body: %w", err)
+	}
+
+	return bytes.TrimSuffix(result.Bytes(), []byte("\n")), nil
+}
+
+// replaceJSONValue get the replacement of a JSON value, and whether it was replaced.
+func (run *rewriteRun) replaceJSONValue(candidate rewrite, value interface{}) (interface{}, bool, error) {
+	if candidate.regex == nil {
+		var result bytes.Buffer
+
+		if err := run.replace(&result, candidate, nil, nil); err != nil {
+			return nil, false, err
+		}
+
+		return result.String(), true, nil
+	}
+
+	text, ok := value.(string)
+	if !ok {
+		return value, false, nil
+	}
+
+	matches := candidate.regex.FindAllSubmatchIndex([]byte(text), -1)
+	if len(matches) == 0 {
+		return value, false, nil
+	}
+
+	result, err := run.replaceMatches(candidate, []byte(text), matches)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return string(result), true, nil
+}
+
+// replace write the replacement of match in body.
+func (run *rewriteRun) replace(result *bytes.Buffer, candidate rewrite, body []byte, match []int) error {
+	if candidate.template == nil && match == nil {
+		result.Write(candidate.replacement)
+
+		return nil
+	}
+
+	if candidate.template == nil {
+		result.Write(candidate.regex.Expand(nil, candidate.replacement, body, match))
+
+		return nil
+	}
+
+	data := replacementData{
+		Groups:  make(map[string]string),
+		Status:  run.status,
+		Request: run.replacementRequest(),
+	}
+
+	for group, name := range candidate.subexpNames() {
+		if name != "" && match[2*group] >= 0 {
+			data.Groups[name] = string(body[match[2*group]:match[2*group+1]])
+		}
+	}
+
+	return candidate.template.Execute(result, data)
+}
+
+// replacementRequest describe the request to replacement templates. The values come from the client, they are
+// sanitized as for the error pages, and HTML-escaped for HTML bodies as text/template does not escape them.
+func (run *rewriteRun) replacementRequest() replacementRequest {
+	info := newRequestInfo(run.request)
+	request := replacementRequest{Method: info.Method, Host: info.Host, Path: info.Path}
+
+	if run.escapeHTML {
+		request.Method = html.EscapeString(request.Method)
+		request.Host = html.EscapeString(request.Host)
+		request.Path = html.EscapeString(request.Path)
+	}
+
+	return request
+}
+
+// subexpNames get the names of the regex capture groups, if the rewrite has a regex.
+func (r rewrite) subexpNames() []string {
+	if r.regex == nil {
+		return nil
+	}
+
+	return r.regex.SubexpNames()
+}
+
+// interrupted get why the rewrites have to stop early: the client went away or they ran out of time.
+func (run *rewriteRun) interrupted() error {
+	if err := run.request.Context().Err(); err != nil {
+		return err
+	}
+
+	if !run.deadline.IsZero() && time.Now().After(run.deadline) {
+		return errRewriteBudget
+	}
+
+	return nil
+}
+
+// shouldBuffer determine if a passed through response has to be buffered for rewrites to run on it once complete.
+func (config *catcherConfig) shouldBuffer(status int, header http.Header) bool {
+	if !supportsRewriting(header) {
+		return false
+	}
+
+	return len(config.applicableRewrites(status, header)) > 0
+}
+
+// applicableRewrites get the rewrites running on responses with status and header.
+func (config *catcherConfig) applicableRewrites(status int, header http.Header) []rewrite {
+	var applicable []rewrite
+
+	for _, candidate := range config.rewrites {
+		if candidate.appliesTo(status, header) {
+			applicable = append(applicable, candidate)
+		}
+	}
+
+	return applicable
+}
+
+// canStream determine if the rewrites of the response described by status and header may run while it is being sent.
+// JSON path rewrites need the whole document.
+func (config *catcherConfig) canStream(status int, header http.Header) bool {
+	if config.streamWindow <= 0 {
+		return false
+	}
+
+	for _, candidate := range config.applicableRewrites(status, header) {
+		if candidate.jsonPath != nil {
+			return false
+		}
+	}
+
+	encoding := header.Get("Content-Encoding")
+
+	return encoding == "" || encoding == "identity"
+}
+
+// supportsRewriting determine if the body described by header can be decoded and rewritten.
+func supportsRewriting(header http.Header) bool {
+	if httputil.IsStreamingContentType(header.Get("Content-Type")) {
+		return false
+	}
+
+	switch header.Get("Content-Encoding") {
+	case "gzip", "deflate", "identity", "":
+		return true
+	default:
+		return false
+	}
+}
+
+// writeRewritten run the rewrites applying to the buffered response and send it to the client.
+func (bodyRewrite *rewriteBody) writeRewritten(
+	response http.ResponseWriter,
+	req *http.Request,
+	catcher responseInterceptor,
+) {
+	original := catcher.getBuffer().Bytes()
+	if len(original) == 0 {
+		// nothing to rewrite, such as a response to a HEAD request.
+		catcher.sendHeaders()
+		catcher.sendTrailers()
+
+		return
+	}
+
+	start := time.Now()
+	body := bodyRewrite.rewrite(req, catcher.getCode(), catcher.Header(), original)
+
+	bodyRewrite.catcherConfig.metrics.RewriteDuration(time.Since(start))
+	bodyRewrite.catcherConfig.metrics.BufferSize(len(original))
+
+	httputil.SetBodyLength(catcher.Header(), len(body))
+
+	if !bodyRewrite.catcherConfig.lastModified {
+		catcher.Header().Del("Last-Modified")
+	}
+
+	catcher.sendHeaders()
+
+	if _, err := response.Write(body); err != nil {
+		bodyRewrite.logger.Warn("unable to write rewritten body", logging.F("error", err))
+	}
+
+	catcher.sendTrailers()
+}
+
+// rewrite decode body, run the rewrites applying to status on it and encode it back.
+// The original body is returned when it cannot be decoded or encoded, when a replacement template fails
+// or when the rewrites run out of time.
+func (bodyRewrite *rewriteBody) rewrite(req *http.Request, status int, header http.Header, original []byte) []byte {
+	encoding := header.Get("Content-Encoding")
+
+	body, err := compressutil.Decode(bytes.NewBuffer(original), encoding)
+	if err != nil {
+		bodyRewrite.logger.Error("unable to decode body for rewriting", logging.F("encoding", encoding),
+			logging.F("error", err))
+
+		return original
+	}
+
+	rewrites := bodyRewrite.catcherConfig.applicableRewrites(status, header)
+
+	body, err = newRewriteRun(rewrites, status, req, header, bodyRewrite.catcherConfig.rewriteBudget).rewrite(body)
+	if req.Context().Err() != nil {
+		bodyRewrite.logger.Debug("client went away while rewriting", logging.F("error", err))
+
+		return original
+	}
+
+	if err != nil {
+		bodyRewrite.logger.Error("unable to rewrite body", logging.F("error", err))
+
+		return original
+	}
+
+	encoded, err := compressutil.Encode(body, encoding)
+	if err != nil {
+		bodyRewrite.logger.Error("unable to encode rewritten body", logging.F("encoding", encoding),
+			logging.F("error", err))
+
+		return original
+	}
+
+	return encoded
+}
@@ -0,0 +1,43 @@
+package pretty_error
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+)
+
+// rollout selects the share of requests whose intercepted responses are replaced.
+type rollout struct {
+	// percent of requests included, 100 includes every request.
+	percent int
+}
+
+func newRollout(config *Config, v *validator) rollout {
+	if config.RolloutPercent == 0 {
+		return rollout{percent: 100}
+	}
+
+	if config.RolloutPercent < 0 || config.RolloutPercent > 100 {
+		v.check("rolloutPercent", fmt.Errorf("%d is not between 1 and 100", config.RolloutPercent))
+	}
+
+	return rollout{percent: config.RolloutPercent}
+}
+
+// includes determine if the intercepted response to req is replaced. Requests with an X-Request-Id header are
+// selected by its hash, so retries of a request get the same response, others at random.
+func (r rollout) includes(req *http.Request) bool {
+	if r.percent >= 100 {
+		return true
+	}
+
+	if requestID := req.Header.Get("X-Request-Id"); requestID != "" {
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(requestID))
+
+		return int(hash.Sum32()%100) < r.percent
+	}
+
+	return rand.Intn(100) < r.percent
+}
@@ -0,0 +1,154 @@
+package pretty_error
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/packruler/pretty-error/htmltemplates"
+)
+
+// nonceTemplate placeholder replaced by the per-response nonce in security header values.
+const nonceTemplate = "{nonce}"
+
+// defaultSecurityHeaders headers set on generated pages unless overridden by Config.SecurityHeaders.
+// The default template only uses nonced inline styles and scripts, plus the localization script.
+var defaultSecurityHeaders = map[string]string{
+	"Content-Security-Policy": "default-src 'none'; style-src 'nonce-" + nonceTemplate + "'; " +
+		"script-src 'nonce-" + nonceTemplate + "' https://cdn.jsdelivr.net; " +
+		"base-uri 'none'; form-action 'none'; frame-ancestors 'none'",
+	"X-Content-Type-Options": "nosniff",
+	"Referrer-Policy":        "no-referrer",
+}
+
+// offlineContentSecurityPolicy replaces the default policy in offline mode, where nothing is loaded from other hosts.
+const offlineContentSecurityPolicy = "default-src 'none'; style-src 'nonce-" + nonceTemplate + "'; " +
+	"script-src 'nonce-" + nonceTemplate + "'; base-uri 'none'; form-action 'none'; frame-ancestors 'none'"
+
+// newSecurityHeaders merge the configured security headers over the defaults.
+// An empty configured value disables the corresponding default header.
+func newSecurityHeaders(configured map[string]string, offline, assets bool) map[string]string {
+	headers := make(map[string]string, len(defaultSecurityHeaders)+len(configured))
+
+	for name, value := range defaultSecurityHeaders {
+		headers[http.CanonicalHeaderKey(name)] = value
+	}
+
+	if offline {
+		headers["Content-Security-Policy"] = offlineContentSecurityPolicy
+	}
+
+	if assets {
+		headers["Content-Security-Policy"] = allowSelf(headers["Content-Security-Policy"])
+	}
+
+	for name, value := range configured {
+		name = http.CanonicalHeaderKey(name)
+
+		if value == "" {
+			delete(headers, name)
+
+			continue
+		}
+
+		headers[name] = value
+	}
+
+	return headers
+}
+
+// allowSelf extend a default policy to the styles, scripts, images and fonts served by the middleware itself.
+func allowSelf(policy string) string {
+	policy = strings.Replace(policy, "style-src ", "style-src 'self' ", 1)
+	policy = strings.Replace(policy, "script-src ", "script-src 'self' ", 1)
+
+	return policy + "; img-src 'self'; font-src 'self'"
+}
+
+// setSecurityHeaders set the security headers not already present on the generated response.
+// Without nonce, as for pages from the error page service, headers relying on the nonce are left out.
+func (bodyRewrite *rewriteBody) setSecurityHeaders(header http.Header, nonce string) {
+	for name, value := range bodyRewrite.securityHeaders {
+		if header.Get(name) != "" {
+			continue
+		}
+
+		if nonce == "" && strings.Contains(value, nonceTemplate) {
+			continue
+		}
+
+		header.Set(name, strings.ReplaceAll(value, nonceTemplate, nonce))
+	}
+}
+
+// newNonce generate a random value allowing the inline styles and scripts of a single page.
+func newNonce() (string, error) {
+	nonce := make([]byte, 16)
+
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(nonce), nil
+}
+
+// errExternalReferences is recorded for the templates and snippets which reference external resources in offline mode.
+var errExternalReferences = errors.New("references external resources, which offline forbids")
+
+// checkOffline verify the embedded page does not reference any external resource.
+func checkOffline(theme string) error {
+	metadata := htmltemplates.DefaultMetadata()
+	metadata.Theme = theme
+	metadata.Offline = true
+
+	body, err := htmltemplates.GetErrorPage(http.StatusInternalServerError, metadata)
+	if err != nil {
+		return fmt.Errorf("error rendering offline page: %w", err)
+	}
+
+	if htmltemplates.HasExternalReferences(body) {
+		return errors.New("offline page references external resources")
+	}
+
+	return nil
+}
+
+// checkOfflineSources verify the templates of sources do not reference any external resource, layouts and partials
+// included. The pages of the error page service are only known once fetched, and cannot be used offline.
+func checkOfflineSources(sources []pageSource, v *validator) {
+	for _, source := range sources {
+		switch source := source.(type) {
+		case *errorService:
+			v.check("service", errors.New("conflicts with offline, its pages cannot be checked for external resources"))
+		case *templateDir:
+			names := make([]string, 0, len(source.templates))
+			for name := range source.templates {
+				names = append(names, name)
+			}
+
+			sort.Strings(names)
+
+			for _, name := range names {
+				if hasExternalTemplates(source.templates[name]) {
+					v.check(source.source+"."+name, errExternalReferences)
+				}
+			}
+		}
+	}
+}
+
+// hasExternalTemplates determine if temp, or a template it is associated with, references an external resource.
+func hasExternalTemplates(temp *template.Template) bool {
+	for _, associated := range temp.Templates() {
+		if associated.Tree != nil && htmltemplates.HasExternalReferences([]byte(associated.Tree.Root.String())) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,248 @@
+package pretty_error
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/packruler/pretty-error/logging"
+)
+
+const (
+	// sentryTimeout bounds the time taken to send an event to Sentry.
+	sentryTimeout = 5 * time.Second
+	// sentryQueueSize bounds the events waiting to be sent, the ones reported while the queue is full being dropped.
+	sentryQueueSize = 100
+	// sentryBackoff is the time Sentry is left alone after rate limiting events without a Retry-After delay.
+	sentryBackoff = time.Minute
+)
+
+// sentryReporters holds the reporters of Config.SentryDSN by DSN. Traefik creates the middleware again on every
+// configuration reload, they share the queue and sender started first instead of leaking a new one each time.
+var sentryReporters = struct {
+	mu        sync.Mutex
+	reporters map[string]*sentryReporter
+}{reporters: make(map[string]*sentryReporter)}
+
+// sentryReporter sends the intercepted server errors to Sentry, with its store endpoint. Events are queued and sent
+// one at a time by a single sender, so an outage does not turn into as many requests to Sentry.
+type sentryReporter struct {
+	// dropped counts the events dropped since the last ones were logged, as the queue was full or Sentry rate limited
+	// them. It comes first to be aligned for atomic operations.
+	dropped  uint64
+	endpoint string
+	auth     string
+	client   *http.Client
+	logger   logging.Logger
+	events   chan []byte
+	start    sync.Once
+	// retryAt is the time events can be sent again once Sentry rate limited them.
+	retryAt time.Time
+	now     func() time.Time
+}
+
+// sentryEvent is the part of the Sentry event payload filled from an ErrorEvent.
+type sentryEvent struct {
+	EventID   string            `json:"event_id"`
+	Timestamp string            `json:"timestamp"`
+	Level     string            `json:"level"`
+	Logger    string            `json:"logger"`
+	Platform  string            `json:"platform"`
+	Message   string            `json:"message"`
+	Tags      map[string]string `json:"tags"`
+	Request   sentryRequest     `json:"request"`
+}
+
+type sentryRequest struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// NewSentryReporter get a Reporter sending the intercepted server errors to the Sentry project of dsn, such as
+// "https://public@o0.ingest.sentry.io/42". Events are sent in the background, failures being logged; events reported
+// while too many are waiting, or while Sentry rate limits them, are dropped.
+func NewSentryReporter(dsn string) (Reporter, error) {
+	return newSentryReporter(dsn, logging.WithLevel(logging.LevelInfo))
+}
+
+func newSentryReporter(dsn string, logger logging.Logger) (*sentryReporter, error) {
+	parsed, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if parsed.User == nil || parsed.User.Username() == "" {
+		return nil, errors.New("missing public key")
+	}
+
+	projectIndex := strings.LastIndex(parsed.Path, "/")
+	projectID := parsed.Path[projectIndex+1:]
+
+	if _, err := strconv.Atoi(projectID); err != nil {
+		return nil, fmt.Errorf("invalid project id %q", projectID)
+	}
+
+	endpoint := url.URL{Scheme: parsed.Scheme, Host: parsed.Host, Path: parsed.Path[:projectIndex] + "/api/" + projectID + "/store/"}
+
+	return &sentryReporter{
+		endpoint: endpoint.String(),
+		auth:     "Sentry sentry_version=7, sentry_client=pretty-error/1.0, sentry_key=" + parsed.User.Username(),
+		client:   &http.Client{Timeout: sentryTimeout},
+		logger:   logger,
+		events:   make(chan []byte, sentryQueueSize),
+		now:      time.Now,
+	}, nil
+}
+
+// newConfigSentryReporter get the reporter of Config.SentryDSN, nil when it is not set.
+func newConfigSentryReporter(dsn string, logger logging.Logger, v *validator) Reporter {
+	if dsn == "" {
+		return nil
+	}
+
+	sentryReporters.mu.Lock()
+	defer sentryReporters.mu.Unlock()
+
+	if reporter, ok := sentryReporters.reporters[dsn]; ok {
+		return reporter
+	}
+
+	reporter, err := newSentryReporter(dsn, logger)
+	if !v.check("sentryDsn", err) {
+		return nil
+	}
+
+	sentryReporters.reporters[dsn] = reporter
+
+	return reporter
+}
+
+func (reporter *sentryReporter) Report(_ context.Context, event ErrorEvent) {
+	payload, err := json.Marshal(newSentryEvent(event))
+	if err != nil {
+		reporter.logger.Warn("unable to encode sentry event", logging.F("error", err))
+
+		return
+	}
+
+	reporter.start.Do(func() { go reporter.run() })
+
+	select {
+	case reporter.events <- payload:
+	default:
+		atomic.AddUint64(&reporter.dropped, 1)
+	}
+}
+
+// run send the queued events one at a time, dropping them while Sentry rate limits them.
+func (reporter *sentryReporter) run() {
+	for payload := range reporter.events {
+		if reporter.now().Before(reporter.retryAt) {
+			atomic.AddUint64(&reporter.dropped, 1)
+
+			continue
+		}
+
+		reporter.send(payload)
+
+		if dropped := atomic.SwapUint64(&reporter.dropped, 0); dropped > 0 {
+			reporter.logger.Warn("sentry events dropped", logging.F("count", dropped))
+		}
+	}
+}
+
+// send post the event to the store endpoint, backing off when Sentry answers 429 Too Many Requests.
+func (reporter *sentryReporter) send(payload []byte) {
+	req, err := http.NewRequest(http.MethodPost, reporter.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		reporter.logger.Warn("unable to send sentry event", logging.F("error", err))
+
+		return
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", reporter.auth)
+
+	response, err := reporter.client.Do(req)
+	if err != nil {
+		reporter.logger.Warn("unable to send sentry event", logging.F("error", err))
+
+		return
+	}
+
+	_ = response.Body.Close()
+
+	if response.StatusCode == http.StatusTooManyRequests {
+		reporter.retryAt = reporter.now().Add(retryAfter(response.Header.Get("Retry-After")))
+		reporter.logger.Warn("sentry rate limited events", logging.F("retryAt", reporter.retryAt))
+
+		return
+	}
+
+	if response.StatusCode >= http.StatusMultipleChoices {
+		reporter.logger.Warn("sentry rejected event", logging.F("status", response.StatusCode))
+	}
+}
+
+// retryAfter get the delay of a Retry-After header, in seconds or as a date, or sentryBackoff when it has none.
+func retryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && time.Until(date) > 0 {
+		return time.Until(date)
+	}
+
+	return sentryBackoff
+}
+
+// newSentryEvent describe event as a Sentry event, grouped by middleware and status.
+func newSentryEvent(event ErrorEvent) sentryEvent {
+	message := fmt.Sprintf("%d %s on %s %s", event.Status, http.StatusText(event.Status), event.Method, event.Path)
+	if event.Detail != "" {
+		message += ": " + event.Detail
+	}
+
+	return sentryEvent{
+		EventID:   newEventID(),
+		Timestamp: event.Time.Format(time.RFC3339),
+		Level:     "error",
+		Logger:    "pretty-error",
+		Platform:  "go",
+		Message:   message,
+		Tags: map[string]string{
+			"middleware":      event.Middleware,
+			"status":          strconv.Itoa(event.Status),
+			"upstream_status": strconv.Itoa(event.UpstreamStatus),
+			"reference_id":    event.ReferenceID,
+		},
+		Request: sentryRequest{
+			URL:     "//" + event.Host + event.Path,
+			Method:  event.Method,
+			Headers: map[string]string{"User-Agent": event.UserAgent},
+		},
+	}
+}
+
+// newEventID generate the 32 hexadecimal digits identifying a Sentry event.
+func newEventID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,213 @@
+package pretty_error
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultServiceTimeout    = 2 * time.Second
+	defaultServiceCacheTTL   = time.Minute
+	defaultServiceFailureTTL = 5 * time.Second
+	// maxServicePageSize bounds the pages read from the error page service.
+	maxServicePageSize = 1 << 20
+)
+
+// ErrorService holds the configuration of a service providing the error pages, like Traefik's errors middleware.
+// In URL, {status} is replaced by the status code of the page, such as "http://errors.internal/{status}.html".
+type ErrorService struct {
+	URL        string `json:"url,omitempty" toml:"url,omitempty" yaml:"url,omitempty" export:"true"`
+	Timeout    string `json:"timeout,omitempty" toml:"timeout,omitempty" yaml:"timeout,omitempty" export:"true"`
+	CacheTTL   string `json:"cacheTtl,omitempty" toml:"cacheTtl,omitempty" yaml:"cacheTtl,omitempty" export:"true"`
+	FailureTTL string `json:"failureTtl,omitempty" toml:"failureTtl,omitempty" yaml:"failureTtl,omitempty" export:"true"`
+}
+
+// errorService fetches error pages, keeping them for cacheTTL. Failures are remembered for failureTTL, the pages of
+// a status which failed, or every page when the service could not be reached, being skipped meanwhile.
+type errorService struct {
+	url        string
+	client     *http.Client
+	cacheTTL   time.Duration
+	failureTTL time.Duration
+
+	mu       sync.Mutex
+	cache    map[int]servicePage
+	failures map[int]serviceFailure
+	// unreachable is the failure to reach the service, for any status.
+	unreachable serviceFailure
+}
+
+// serviceFailure is a failed fetch, remembered until expires.
+type serviceFailure struct {
+	err     error
+	expires time.Time
+}
+
+type servicePage struct {
+	body        []byte
+	contentType string
+	expires     time.Time
+}
+
+func newErrorService(config *ErrorService, v *validator) *errorService {
+	if config == nil {
+		return nil
+	}
+
+	if config.URL == "" {
+		v.check("service.url", errors.New("is required"))
+	} else if _, err := url.Parse(strings.ReplaceAll(config.URL, "{status}", "500")); err != nil {
+		v.check("service.url", err)
+	}
+
+	return &errorService{
+		url:        config.URL,
+		client:     &http.Client{Timeout: parseDuration("service.timeout", config.Timeout, defaultServiceTimeout, v)},
+		cacheTTL:   parseDuration("service.cacheTtl", config.CacheTTL, defaultServiceCacheTTL, v),
+		failureTTL: parseDuration("service.failureTtl", config.FailureTTL, defaultServiceFailureTTL, v),
+		cache:      make(map[int]servicePage),
+		failures:   make(map[int]serviceFailure),
+	}
+}
+
+// parseDuration parse a non negative duration, defaulting to defaultDuration when empty.
+func parseDuration(field, value string, defaultDuration time.Duration, v *validator) time.Duration {
+	if value == "" {
+		return defaultDuration
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err == nil && duration < 0 {
+		err = errors.New("must not be negative")
+	}
+
+	v.check(field, err)
+
+	return duration
+}
+
+// fetch get the page of status from the service, or from the cache while it is fresh. A recent failure is returned
+// again without asking the service.
+func (service *errorService) fetch(ctx context.Context, status int) ([]byte, string, error) {
+	if page, ok := service.cached(status); ok {
+		return page.body, page.contentType, nil
+	}
+
+	if err := service.failed(status); err != nil {
+		return nil, "", err
+	}
+
+	pageURL := strings.ReplaceAll(service.url, "{status}", strconv.Itoa(status))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set(fetchHeader, fetchToken)
+
+	response, err := service.client.Do(req)
+	if err != nil {
+		// a client going away says nothing of the service.
+		if ctx.Err() == nil {
+			service.fail(everyStatus, err)
+		}
+
+		return nil, "", err
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		err := fmt.Errorf("error page service answered %d for %s", response.StatusCode, pageURL)
+		service.fail(status, err)
+
+		return nil, "", err
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxServicePageSize))
+	if err != nil {
+		return nil, "", err
+	}
+
+	contentType := response.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/html; charset=utf-8"
+	}
+
+	service.store(status, servicePage{body: body, contentType: contentType})
+
+	return body, contentType, nil
+}
+
+func (service *errorService) cached(status int) (servicePage, bool) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	page, ok := service.cache[status]
+	if !ok || time.Now().After(page.expires) {
+		return servicePage{}, false
+	}
+
+	return page, true
+}
+
+// everyStatus marks the failures to reach the service, remembered for the pages of every status.
+const everyStatus = 0
+
+// failed get the remembered failure of the page of status, or nil.
+func (service *errorService) failed(status int) error {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	now := time.Now()
+
+	if service.unreachable.err != nil && now.Before(service.unreachable.expires) {
+		return fmt.Errorf("skipped after a recent failure: %w", service.unreachable.err)
+	}
+
+	if failure, ok := service.failures[status]; ok && now.Before(failure.expires) {
+		return fmt.Errorf("skipped after a recent failure: %w", failure.err)
+	}
+
+	return nil
+}
+
+// fail remember the failure of the page of status, or of every page for everyStatus.
+func (service *errorService) fail(status int, err error) {
+	if service.failureTTL <= 0 {
+		return
+	}
+
+	failure := serviceFailure{err: err, expires: time.Now().Add(service.failureTTL)}
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	if status == everyStatus {
+		service.unreachable = failure
+	} else {
+		service.failures[status] = failure
+	}
+}
+
+func (service *errorService) store(status int, page servicePage) {
+	if service.cacheTTL <= 0 {
+		return
+	}
+
+	page.expires = time.Now().Add(service.cacheTTL)
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	service.cache[status] = page
+}
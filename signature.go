@@ -0,0 +1,148 @@
+package pretty_error
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/packruler/pretty-error/httputil"
+	"github.com/packruler/pretty-error/logging"
+)
+
+// defaultSignatureLimit is the number of body bytes inspected for signatures when bodySignatureLimit is not configured.
+const defaultSignatureLimit = 4096
+
+// BodySignature replaces the 200 responses whose body contains Pattern, within the first bodySignatureLimit bytes,
+// with the error page of Status, 500 by default. Pattern is found as is, or as a regular expression when prefixed by
+// "regex:".
+type BodySignature struct {
+	Pattern string `json:"pattern,omitempty" toml:"pattern,omitempty" yaml:"pattern,omitempty" export:"true"`
+	Status  int    `json:"status,omitempty" toml:"status,omitempty" yaml:"status,omitempty" export:"true"`
+}
+
+type bodySignature struct {
+	pattern string
+	literal []byte
+	regex   *regexp.Regexp
+	status  int
+}
+
+func newBodySignatures(configs []BodySignature, v *validator) []bodySignature {
+	signatures := make([]bodySignature, len(configs))
+
+	for index, signatureConfig := range configs {
+		signatureField := fmt.Sprintf("bodySignatures[%d]", index)
+
+		signature := bodySignature{
+			pattern: signatureConfig.Pattern,
+			status: newContextStatus(signatureField+".status", signatureConfig.Status,
+				http.StatusInternalServerError, v),
+		}
+
+		switch {
+		case signatureConfig.Pattern == "":
+			v.check(signatureField+".pattern", errors.New("is required"))
+		case strings.HasPrefix(signatureConfig.Pattern, regexPrefix):
+			regex, err := regexp.Compile(strings.TrimPrefix(signatureConfig.Pattern, regexPrefix))
+			if v.check(signatureField+".pattern", err) {
+				signature.regex = regex
+			}
+		default:
+			signature.literal = []byte(signatureConfig.Pattern)
+		}
+
+		signatures[index] = signature
+	}
+
+	return signatures
+}
+
+func (signature bodySignature) match(body []byte) bool {
+	if signature.regex != nil {
+		return signature.regex.Match(body)
+	}
+
+	return signature.literal != nil && bytes.Contains(body, signature.literal)
+}
+
+// newSignatureLimit get the number of body bytes inspected for signatures.
+func newSignatureLimit(config *Config, v *validator) int {
+	if config.BodySignatureLimit < 0 {
+		v.check("bodySignatureLimit", errors.New("must not be negative"))
+	}
+
+	if config.BodySignatureLimit <= 0 {
+		return defaultSignatureLimit
+	}
+
+	return config.BodySignatureLimit
+}
+
+// inspectsBody determine if the 200 response is held back until the start of its body is inspected for signatures.
+// Compressed bodies are forwarded, their start cannot be decoded on its own.
+func (cc *codeCatcher) inspectsBody(code int, header http.Header) bool {
+	if len(cc.config.signatures) == 0 || code != http.StatusOK || !cc.replacing ||
+		cc.config.observeLimit > 0 || cc.config.dryRun {
+		return false
+	}
+
+	if encoding := header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return false
+	}
+
+	return httputil.MatchesContentType(header.Get("Content-Type"), cc.config.contentTypes)
+}
+
+// inspect hold back the start of an inspected body, deciding the response once signatureLimit bytes were written.
+func (cc *codeCatcher) inspect(buf []byte) (int, error) {
+	written, _ := cc.getBuffer().Write(buf)
+
+	if cc.getBuffer().Len() < cc.config.signatureLimit {
+		return written, nil
+	}
+
+	return written, cc.finishInspection()
+}
+
+// finishInspection replace a response whose inspected body matches a signature with the error page of its status,
+// or forward the held back response and body otherwise.
+func (cc *codeCatcher) finishInspection() error {
+	if !cc.inspecting {
+		return nil
+	}
+
+	cc.inspecting = false
+
+	body := cc.getBuffer().Bytes()
+	if len(body) > cc.config.signatureLimit {
+		body = body[:cc.config.signatureLimit]
+	}
+
+	for _, signature := range cc.config.signatures {
+		if signature.match(body) {
+			cc.config.logger.Debug("body signature matched", logging.F("path", cc.request.URL.Path),
+				logging.F("pattern", signature.pattern), logging.F("status", signature.status))
+			cc.core.CatchCode(signature.status)
+
+			return nil
+		}
+	}
+
+	held := append([]byte(nil), cc.getBuffer().Bytes()...)
+	cc.getBuffer().Reset()
+
+	if cc.forward() == httputil.ForwardResponse {
+		cc.core.SendHeaders()
+	}
+
+	if len(held) == 0 {
+		return nil
+	}
+
+	_, err := cc.writeBody(held)
+
+	return err
+}
@@ -0,0 +1,356 @@
+package pretty_error
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"strconv"
+	texttemplate "text/template"
+
+	"github.com/packruler/pretty-error/htmltemplates"
+	"github.com/packruler/pretty-error/httputil"
+	"github.com/packruler/pretty-error/logging"
+)
+
+// Names of the page sources, as recorded in metrics and traces.
+const (
+	sourceService     = "service"
+	sourceTemplateDir = "templateDir"
+	sourceTemplates   = "templates"
+	sourceEmbedded    = "embedded"
+	sourceMinimal     = "minimal"
+)
+
+// errNoPage reports a source without any page for a status, the next source is tried without logging.
+var errNoPage = errors.New("no page for status")
+
+// templateError reports a template of a source which failed to execute, as counted by the template errors metric.
+type templateError struct {
+	name string
+	err  error
+}
+
+func (e *templateError) Error() string {
+	return fmt.Sprintf("error rendering %s: %v", e.name, e.err)
+}
+
+func (e *templateError) Unwrap() error {
+	return e.err
+}
+
+// renderedPage is an error page body, with the source which provided it.
+type renderedPage struct {
+	body        []byte
+	contentType string
+	source      string
+	// nonced pages are rendered from templates able to use the per-response nonce.
+	nonced bool
+}
+
+// pageSource provides HTML error pages. Sources are tried in order until one provides the page,
+// the embedded template being the last resort.
+type pageSource interface {
+	name() string
+	page(ctx context.Context, status int, metadata htmltemplates.Metadata) (renderedPage, error)
+	// describe reports what the source loaded, for the status endpoint.
+	describe() sourceStatus
+}
+
+// newPageSources get the configured sources in the order they are tried: error page service, then template directory
+// or the templates given with WithTemplates.
+func newPageSources(config *Config, opts options, v *validator) []pageSource {
+	var sources []pageSource
+
+	delims := newDelimiters(config, v)
+
+	if service := newErrorService(config.Service, v); service != nil {
+		sources = append(sources, service)
+	}
+
+	if opts.templates != nil {
+		if config.TemplateDir != "" {
+			v.check("templateDir", errors.New("conflicts with the WithTemplates option"))
+		}
+
+		if templates := parseTemplateDir(opts.templates, sourceTemplates, delims, v); templates != nil {
+			sources = append(sources, templates)
+		}
+	} else if templateDir := newTemplateDir(config.TemplateDir, delims, v); templateDir != nil {
+		sources = append(sources, templateDir)
+	}
+
+	return sources
+}
+
+// renderPage get the page of status from the first source providing it, falling back to the embedded templates.
+// Sources provide the page in the negotiated format, or errNoPage when they have none in that format.
+func (bodyRewrite *rewriteBody) renderPage(
+	ctx context.Context,
+	status int,
+	metadata htmltemplates.Metadata,
+) (renderedPage, error) {
+	for _, source := range bodyRewrite.sources {
+		page, err := source.page(ctx, status, metadata)
+		if err == nil {
+			return page, nil
+		}
+
+		var failed *templateError
+		if errors.As(err, &failed) {
+			bodyRewrite.metrics.TemplateError()
+		}
+
+		if !errors.Is(err, errNoPage) {
+			bodyRewrite.logger.Warn("unable to get error page, trying next source", logging.F("source", source.name()),
+				logging.F("status", status), logging.F("error", err))
+		}
+	}
+
+	page := renderedPage{source: sourceEmbedded, nonced: true}
+
+	var err error
+
+	if metadata.OutputFormat == httputil.OutputFormatJSON {
+		page.contentType = "application/json; charset=utf-8"
+		page.body, err = htmltemplates.GetErrorEnvelope(status, metadata)
+	} else {
+		page.contentType = "text/html; charset=utf-8"
+		page.body, err = htmltemplates.GetErrorPage(status, metadata)
+	}
+
+	return page, err
+}
+
+// minimalPage get the bare page of status, served when even the embedded templates fail to render.
+func minimalPage(status int, metadata htmltemplates.Metadata) renderedPage {
+	if metadata.OutputFormat == httputil.OutputFormatJSON {
+		return renderedPage{
+			body:        htmltemplates.MinimalEnvelope(status, metadata),
+			contentType: "application/json; charset=utf-8",
+			source:      sourceMinimal,
+		}
+	}
+
+	return renderedPage{
+		body:        htmltemplates.MinimalPage(status, metadata),
+		contentType: "text/html; charset=utf-8",
+		source:      sourceMinimal,
+	}
+}
+
+func (service *errorService) name() string {
+	return sourceService
+}
+
+// page fetch the page of status. The nonce only applies to pages rendered from templates.
+func (service *errorService) page(ctx context.Context, status int, metadata htmltemplates.Metadata) (renderedPage, error) {
+	if metadata.OutputFormat != httputil.OutputFormatHTML {
+		return renderedPage{}, errNoPage
+	}
+
+	body, contentType, err := service.fetch(ctx, status)
+	if err != nil {
+		return renderedPage{}, err
+	}
+
+	return renderedPage{body: body, contentType: contentType, source: sourceService}, nil
+}
+
+// templateDir renders pages from the "*.html" templates of a directory, and JSON bodies from its "*.json" templates,
+// parsed once at startup.
+type templateDir struct {
+	source    string
+	templates map[string]*template.Template
+	// textTemplates render the JSON bodies with text/template, which does not HTML escape them.
+	textTemplates map[string]*texttemplate.Template
+}
+
+// newTemplateDir parse the templates of dir, nil when no directory is configured.
+func newTemplateDir(dir string, delims delimiters, v *validator) *templateDir {
+	if dir == "" {
+		return nil
+	}
+
+	if !checkDir("templateDir", dir, v) {
+		return nil
+	}
+
+	return parseTemplateDir(os.DirFS(dir), sourceTemplateDir, delims, v)
+}
+
+// delimiters of the actions in page templates, the default "{{" and "}}" when empty.
+type delimiters struct {
+	left  string
+	right string
+}
+
+// newDelimiters get the configured template delimiters, such as ["[[", "]]"] for templates of frontends which use
+// the default ones themselves.
+func newDelimiters(config *Config, v *validator) delimiters {
+	switch {
+	case len(config.Delimiters) == 0:
+		return delimiters{}
+	case len(config.Delimiters) != 2:
+		v.check("delimiters", errors.New("must hold the left and right delimiters"))
+	case config.Delimiters[0] == "" || config.Delimiters[1] == "":
+		v.check("delimiters", errors.New("must not be empty"))
+	default:
+		return delimiters{left: config.Delimiters[0], right: config.Delimiters[1]}
+	}
+
+	return delimiters{}
+}
+
+// checkDir verify dir is an existing directory, recording a problem against field otherwise.
+func checkDir(field, dir string, v *validator) bool {
+	info, err := os.Stat(dir)
+	if err == nil && !info.IsDir() {
+		err = fmt.Errorf("%s is not a directory", dir)
+	}
+
+	return v.check(field, err)
+}
+
+// parseTemplateDir parse the templates at the root of fsys and in its subdirectories named after middleware
+// instances with delims, recording problems against field.
+func parseTemplateDir(fsys fs.FS, field string, delims delimiters, v *validator) *templateDir {
+	names, err := fs.Glob(fsys, "*.html")
+	if !v.check(field, err) {
+		return nil
+	}
+
+	instanceNames, err := fs.Glob(fsys, "*/*.html")
+	if !v.check(field, err) {
+		return nil
+	}
+
+	templates := make(map[string]*template.Template, len(names)+len(instanceNames))
+
+	if hasLayout(names) {
+		parseLayout(fsys, names, field, delims, templates, v)
+
+		names = nil
+	}
+
+	for _, name := range append(names, instanceNames...) {
+		content, err := fs.ReadFile(fsys, name)
+		if !v.check(field+"."+name, err) {
+			continue
+		}
+
+		temp, err := newPageTemplate(name, delims).Parse(string(content))
+		if v.check(field+"."+name, err) {
+			templates[name] = temp
+		}
+	}
+
+	return &templateDir{source: field, templates: templates, textTemplates: parseTextTemplates(fsys, field, delims, v)}
+}
+
+// parseTextTemplates parse the "*.json" templates at the root of fsys and in its instance subdirectories.
+func parseTextTemplates(fsys fs.FS, field string, delims delimiters, v *validator) map[string]*texttemplate.Template {
+	templates := make(map[string]*texttemplate.Template)
+
+	for _, pattern := range []string{"*.json", "*/*.json"} {
+		names, err := fs.Glob(fsys, pattern)
+		if !v.check(field, err) {
+			continue
+		}
+
+		for _, name := range names {
+			content, err := fs.ReadFile(fsys, name)
+			if !v.check(field+"."+name, err) {
+				continue
+			}
+
+			temp, err := texttemplate.New(name).Delims(delims.left, delims.right).Funcs(htmltemplates.TextFuncMap()).
+				Parse(string(content))
+			if v.check(field+"."+name, err) {
+				templates[name] = temp
+			}
+		}
+	}
+
+	return templates
+}
+
+// newPageTemplate get an empty page template with delims and the template functions.
+func newPageTemplate(name string, delims delimiters) *template.Template {
+	return template.New(name).Delims(delims.left, delims.right).Funcs(htmltemplates.FuncMap())
+}
+
+func (dir *templateDir) name() string {
+	return dir.source
+}
+
+// page render the most specific template for status: "503.html", then "5xx.html", then "error.html", each first
+// looked up in the subdirectory named after the middleware instance. JSON bodies come from "503.json" and so on.
+func (dir *templateDir) page(_ context.Context, status int, metadata htmltemplates.Metadata) (renderedPage, error) {
+	if metadata.OutputFormat == httputil.OutputFormatJSON {
+		return dir.textPage(status, metadata)
+	}
+
+	for _, name := range templateNames(metadata.Middleware, status, ".html") {
+		temp, exists := dir.templates[name]
+		if !exists {
+			continue
+		}
+
+		body, err := htmltemplates.ExecuteTemplate(temp, status, metadata)
+		if err != nil {
+			return renderedPage{}, &templateError{name: name, err: err}
+		}
+
+		return renderedPage{
+			body:        body,
+			contentType: "text/html; charset=utf-8",
+			source:      dir.source,
+			nonced:      true,
+		}, nil
+	}
+
+	return renderedPage{}, errNoPage
+}
+
+// textPage render the most specific JSON template for status.
+func (dir *templateDir) textPage(status int, metadata htmltemplates.Metadata) (renderedPage, error) {
+	for _, name := range templateNames(metadata.Middleware, status, ".json") {
+		temp, exists := dir.textTemplates[name]
+		if !exists {
+			continue
+		}
+
+		body, err := htmltemplates.ExecuteTextTemplate(temp, status, metadata)
+		if err != nil {
+			return renderedPage{}, &templateError{name: name, err: err}
+		}
+
+		return renderedPage{body: body, contentType: "application/json; charset=utf-8", source: dir.source}, nil
+	}
+
+	return renderedPage{}, errNoPage
+}
+
+// templateNames get the names of the templates with extension matching status for the middleware instance, most
+// specific first.
+func templateNames(middleware string, status int, extension string) []string {
+	names := []string{
+		strconv.Itoa(status) + extension,
+		strconv.Itoa(status/100) + "xx" + extension,
+		"error" + extension,
+	}
+
+	if middleware == "" {
+		return names
+	}
+
+	instanceNames := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		instanceNames = append(instanceNames, middleware+"/"+name)
+	}
+
+	return append(instanceNames, names...)
+}
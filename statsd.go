@@ -0,0 +1,58 @@
+package pretty_error
+
+import (
+	"sync"
+
+	"github.com/packruler/pretty-error/metrics"
+)
+
+// Statsd holds the address of a statsd server, such as the Datadog agent, receiving the metrics besides Prometheus.
+type Statsd struct {
+	Address string `json:"address,omitempty" toml:"address,omitempty" yaml:"address,omitempty" export:"true"`
+	Prefix  string `json:"prefix,omitempty" toml:"prefix,omitempty" yaml:"prefix,omitempty" export:"true"`
+}
+
+// statsdRecorders holds the statsd recorders by address, prefix and middleware name. Traefik creates the middleware
+// again on every configuration reload, they share the socket opened first instead of leaking a new one each time.
+var statsdRecorders = struct {
+	mu        sync.Mutex
+	recorders map[[3]string]metrics.Recorder
+}{recorders: make(map[[3]string]metrics.Recorder)}
+
+// newRecorder get the recorder of the middleware instance: the default registry, and the statsd server when
+// configured.
+func newRecorder(config *Statsd, name string, v *validator) metrics.Recorder {
+	recorder := metrics.DefaultRegistry.Recorder(name)
+
+	if config == nil {
+		return recorder
+	}
+
+	statsd := newStatsdRecorder(config, name, v)
+	if statsd == nil {
+		return recorder
+	}
+
+	return metrics.Tee(recorder, statsd)
+}
+
+// newStatsdRecorder get the statsd recorder of the middleware instance, nil when the server cannot be reached.
+func newStatsdRecorder(config *Statsd, name string, v *validator) metrics.Recorder {
+	key := [3]string{config.Address, config.Prefix, name}
+
+	statsdRecorders.mu.Lock()
+	defer statsdRecorders.mu.Unlock()
+
+	if statsd, ok := statsdRecorders.recorders[key]; ok {
+		return statsd
+	}
+
+	statsd, err := metrics.NewStatsdRecorder(config.Address, config.Prefix, name)
+	if !v.check("statsd.address", err) {
+		return nil
+	}
+
+	statsdRecorders.recorders[key] = statsd
+
+	return statsd
+}
@@ -0,0 +1,140 @@
+package pretty_error
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/packruler/pretty-error/logging"
+	"github.com/packruler/pretty-error/metrics"
+)
+
+// statusReport describes the loaded configuration and the counters of a middleware instance.
+type statusReport struct {
+	Middleware string           `json:"middleware"`
+	StartedAt  time.Time        `json:"startedAt"`
+	Status     string           `json:"status"`
+	Sources    []sourceStatus   `json:"sources"`
+	Counters   metrics.Snapshot `json:"counters"`
+	// RecentErrors lists the last intercepted responses, most recent first.
+	RecentErrors []recentError `json:"recentErrors,omitempty"`
+}
+
+// sourceStatus describes a page source: the templates it loaded, or the pages it holds from the error page service.
+type sourceStatus struct {
+	Name      string             `json:"name"`
+	Templates []string           `json:"templates,omitempty"`
+	URL       string             `json:"url,omitempty"`
+	Cached    []cachedPageStatus `json:"cached,omitempty"`
+}
+
+// cachedPageStatus describes the freshness of a page cached from the error page service.
+type cachedPageStatus struct {
+	Status  int       `json:"status"`
+	Expires time.Time `json:"expires"`
+	Fresh   bool      `json:"fresh"`
+}
+
+// newStatusPath get the path of the status endpoint. The report describes the configuration and the traffic of the
+// middleware, so the endpoint requires the token of the preview endpoint.
+func newStatusPath(config *Config, v *validator) string {
+	if config.StatusPath != "" && config.Preview == nil {
+		v.check("statusPath", errors.New("requires preview.token"))
+	}
+
+	return config.StatusPath
+}
+
+// newMetricsPath get the path of the metrics endpoint, which requires the token of the preview endpoint like the
+// status endpoint.
+func newMetricsPath(config *Config, v *validator) string {
+	if config.MetricsPath != "" && config.Preview == nil {
+		v.check("metricsPath", errors.New("requires preview.token"))
+	}
+
+	return config.MetricsPath
+}
+
+// serveMetrics write the metrics of the middleware instance, to requests carrying the preview token.
+func (bodyRewrite *rewriteBody) serveMetrics(response http.ResponseWriter, req *http.Request) {
+	if !bodyRewrite.preview.authorized(req) {
+		writeUnauthorized(response)
+
+		return
+	}
+
+	metrics.DefaultRegistry.Handler(bodyRewrite.name).ServeHTTP(response, req)
+}
+
+// serveStatus write the status report of the middleware as JSON, to requests carrying the preview token.
+func (bodyRewrite *rewriteBody) serveStatus(response http.ResponseWriter, req *http.Request) {
+	if !bodyRewrite.preview.authorized(req) {
+		writeUnauthorized(response)
+
+		return
+	}
+
+	report := statusReport{
+		Middleware:   bodyRewrite.name,
+		StartedAt:    bodyRewrite.createdAt.UTC(),
+		Status:       "custom",
+		Counters:     metrics.DefaultRegistry.Snapshot(bodyRewrite.name),
+		RecentErrors: bodyRewrite.recentErrors.list(),
+	}
+
+	if ranges, ok := bodyRewrite.catcherConfig.codeMatcher.(fmt.Stringer); ok {
+		report.Status = ranges.String()
+	}
+
+	for _, source := range bodyRewrite.sources {
+		report.Sources = append(report.Sources, source.describe())
+	}
+
+	report.Sources = append(report.Sources, sourceStatus{Name: sourceEmbedded})
+
+	response.Header().Set("Content-Type", "application/json; charset=utf-8")
+	response.Header().Set("Cache-Control", "no-store")
+
+	if err := json.NewEncoder(response).Encode(report); err != nil {
+		bodyRewrite.logger.Warn("unable to write status report", logging.F("error", err))
+	}
+}
+
+func (service *errorService) describe() sourceStatus {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	described := sourceStatus{Name: sourceService, URL: service.url}
+	now := time.Now()
+
+	for status, page := range service.cache {
+		described.Cached = append(described.Cached, cachedPageStatus{
+			Status:  status,
+			Expires: page.expires.UTC(),
+			Fresh:   now.Before(page.expires),
+		})
+	}
+
+	sort.Slice(described.Cached, func(i, j int) bool { return described.Cached[i].Status < described.Cached[j].Status })
+
+	return described
+}
+
+func (dir *templateDir) describe() sourceStatus {
+	described := sourceStatus{Name: dir.source}
+
+	for name := range dir.templates {
+		described.Templates = append(described.Templates, name)
+	}
+
+	for name := range dir.textTemplates {
+		described.Templates = append(described.Templates, name)
+	}
+
+	sort.Strings(described.Templates)
+
+	return described
+}
@@ -0,0 +1,152 @@
+package pretty_error
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/packruler/pretty-error/httputil"
+	"github.com/packruler/pretty-error/logging"
+)
+
+// defaultStreamWindow is the number of bytes held back while streaming rewrites when streamWindow is not configured.
+const defaultStreamWindow = 4096
+
+// newStreamWindow get the stream window configured for streaming rewrites, or 0 when rewrites buffer whole bodies.
+func newStreamWindow(config *Config, v *validator) int {
+	if config.StreamWindow < 0 {
+		v.check("streamWindow", errors.New("must not be negative"))
+	}
+
+	if !config.StreamRewrites {
+		return 0
+	}
+
+	if config.StreamWindow <= 0 {
+		return defaultStreamWindow
+	}
+
+	return config.StreamWindow
+}
+
+// streamRewriter runs rewrites on a body as it is written, only holding back the last window bytes
+// and any match overlapping them, since they could still be part of a match completed by the next chunk.
+// Matches longer than the window may be missed.
+type streamRewriter struct {
+	writer  io.Writer
+	run     *rewriteRun
+	window  int
+	logger  logging.Logger
+	pending []byte
+	failed  bool
+	// elapsed is the time spent rewriting so far.
+	elapsed time.Duration
+}
+
+// Write rewrite and forward every byte of buf that can no longer be part of a match spanning the next chunk.
+func (stream *streamRewriter) Write(buf []byte) (int, error) {
+	stream.pending = append(stream.pending, buf...)
+
+	if err := stream.process(false); err != nil {
+		return 0, err
+	}
+
+	return len(buf), nil
+}
+
+// Close rewrite and forward the bytes held back.
+func (stream *streamRewriter) Close() error {
+	return stream.process(true)
+}
+
+func (stream *streamRewriter) process(final bool) error {
+	cut := len(stream.pending)
+
+	if !final {
+		cut = stream.safeCut()
+		if cut <= 0 {
+			return nil
+		}
+	}
+
+	chunk := stream.pending[:cut]
+
+	if !stream.failed {
+		start := time.Now()
+		rewritten, err := stream.run.rewrite(chunk)
+		stream.elapsed += time.Since(start)
+
+		if err != nil {
+			// once a rewrite failed, the rest of the body is passed through unchanged.
+			stream.logger.Error("unable to rewrite body", logging.F("error", err))
+
+			stream.failed = true
+		} else {
+			chunk = rewritten
+		}
+	}
+
+	if _, err := stream.writer.Write(chunk); err != nil {
+		return err
+	}
+
+	stream.pending = append([]byte(nil), stream.pending[cut:]...)
+
+	return nil
+}
+
+// safeCut find the end of the pending bytes which can be rewritten without knowing the following chunk.
+func (stream *streamRewriter) safeCut() int {
+	cut := len(stream.pending) - stream.window
+
+	for _, candidate := range stream.run.rewrites {
+		for _, match := range candidate.regex.FindAllIndex(stream.pending, -1) {
+			// a match reaching the cut could still grow with the next chunk.
+			if match[0] < cut && match[1] >= cut {
+				cut = match[0]
+			}
+		}
+	}
+
+	return cut
+}
+
+// startStream send the headers of a response whose body is rewritten while it is written.
+func (cc *codeCatcher) startStream() {
+	// the rewritten length is unknown until the whole body went through.
+	httputil.SetBodyLength(cc.Header(), -1)
+
+	if !cc.config.lastModified {
+		cc.Header().Del("Last-Modified")
+	}
+
+	cc.sendHeaders()
+
+	rewrites := cc.config.applicableRewrites(cc.getCode(), cc.Header())
+
+	cc.stream = &streamRewriter{
+		writer: cc.responseWriter,
+		run:    newRewriteRun(rewrites, cc.getCode(), cc.request, cc.Header(), cc.config.rewriteBudget),
+		window: cc.config.streamWindow,
+		logger: cc.config.logger,
+	}
+}
+
+// finishStream write what the stream rewriter held back, if the response was streamed.
+func (cc *codeCatcher) finishStream() {
+	if cc.stream == nil {
+		return
+	}
+
+	if err := cc.request.Context().Err(); err != nil {
+		cc.config.logger.Debug("client went away while rewriting", logging.F("error", err))
+
+		return
+	}
+
+	if err := cc.stream.Close(); err != nil {
+		cc.config.logger.Warn("unable to write rewritten body", logging.F("error", err))
+	}
+
+	cc.config.metrics.RewriteDuration(cc.stream.elapsed)
+}
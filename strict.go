@@ -0,0 +1,101 @@
+package pretty_error
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/packruler/pretty-error/htmltemplates"
+	"github.com/packruler/pretty-error/httputil"
+	"github.com/packruler/pretty-error/types"
+)
+
+// strictNonce stands for the per-response nonce while templates are checked.
+const strictNonce = "strict"
+
+// strictMetadata get the metadata templates are checked with, as negotiated for a default request.
+func strictMetadata(config *Config, name, theme string, assets *assetHandler) htmltemplates.Metadata {
+	metadata := htmltemplates.DefaultMetadata()
+	metadata.Theme = theme
+	metadata.Nonce = strictNonce
+	metadata.Offline = config.Offline
+	metadata.AssetsPath = assets.path()
+	metadata.Middleware = name
+	metadata.Labels = config.Labels
+
+	return metadata
+}
+
+// checkTemplates render the templates of the sources, and the embedded ones, for every intercepted status in every
+// language of config.StrictLanguages, or config.Languages, recording a problem for each template failing. The error page service is not
+// requested, its pages are fetched at request time.
+func checkTemplates(
+	config *Config,
+	sources []pageSource,
+	codeMatcher types.CodeMatcher,
+	metadata htmltemplates.Metadata,
+	v *validator,
+) {
+	if !config.Strict || codeMatcher == nil {
+		return
+	}
+
+	languages := config.StrictLanguages
+	if len(languages) == 0 {
+		languages = config.Languages
+	}
+
+	if len(languages) == 0 {
+		languages = []string{metadata.Language}
+	}
+
+	failed := make(map[string]bool)
+
+	for status := 100; status <= 599; status++ {
+		if !codeMatcher.Match(status) {
+			continue
+		}
+
+		for _, language := range languages {
+			metadata.Language = language
+
+			for _, source := range sources {
+				if _, ok := source.(*templateDir); !ok {
+					continue
+				}
+
+				for _, format := range []string{httputil.OutputFormatHTML, httputil.OutputFormatJSON} {
+					metadata.OutputFormat = format
+
+					_, err := source.page(context.Background(), status, metadata)
+
+					var templateFailure *templateError
+					if errors.As(err, &templateFailure) && !failed[templateFailure.name] {
+						failed[templateFailure.name] = true
+
+						v.check("strict", fmt.Errorf("status %d, language %s: %w", status, language, err))
+					}
+				}
+			}
+
+			checkEmbedded(status, metadata, failed, v)
+		}
+	}
+}
+
+// checkEmbedded render the embedded page and JSON envelope of status, recording a problem when one fails.
+func checkEmbedded(status int, metadata htmltemplates.Metadata, failed map[string]bool, v *validator) {
+	metadata.OutputFormat = httputil.OutputFormatHTML
+	if _, err := htmltemplates.GetErrorPage(status, metadata); err != nil && !failed[sourceEmbedded] {
+		failed[sourceEmbedded] = true
+
+		v.check("strict", fmt.Errorf("status %d, language %s: embedded page: %w", status, metadata.Language, err))
+	}
+
+	metadata.OutputFormat = httputil.OutputFormatJSON
+	if _, err := htmltemplates.GetErrorEnvelope(status, metadata); err != nil && !failed[sourceEmbedded+".json"] {
+		failed[sourceEmbedded+".json"] = true
+
+		v.check("strict", fmt.Errorf("status %d: embedded envelope: %w", status, err))
+	}
+}
@@ -0,0 +1,97 @@
+// Package tracing instruments the pretty error middleware through small Tracer and Span interfaces.
+// The middleware cannot depend on OpenTelemetry, so library users bridge these interfaces to their tracer,
+// typically by wrapping an OpenTelemetry trace.Tracer, and install it with SetTracer.
+package tracing
+
+import (
+	"context"
+	"sync"
+)
+
+// Attribute is a key value pair describing a span or an event.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String creates a string Attribute.
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int creates an integer Attribute.
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Bool creates a boolean Attribute.
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Span is one traced operation.
+type Span interface {
+	SetAttributes(attributes ...Attribute)
+	AddEvent(name string, attributes ...Attribute)
+	End()
+}
+
+// Tracer starts spans, as children of the span held by ctx if any.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+var (
+	mu     sync.RWMutex
+	tracer Tracer = noopTracer{}
+)
+
+// SetTracer install the Tracer used by the middleware. A nil tracer disables tracing.
+func SetTracer(newTracer Tracer) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if newTracer == nil {
+		newTracer = noopTracer{}
+	}
+
+	tracer = newTracer
+}
+
+// GetTracer get the installed Tracer, which does nothing unless SetTracer was called.
+func GetTracer() Tracer {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return tracer
+}
+
+type spanKey struct{}
+
+// ContextWithSpan get a copy of ctx holding span.
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext get the span held by ctx, or a span doing nothing.
+func SpanFromContext(ctx context.Context) Span {
+	if span, ok := ctx.Value(spanKey{}).(Span); ok {
+		return span
+	}
+
+	return noopSpan{}
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute) {}
+
+func (noopSpan) AddEvent(string, ...Attribute) {}
+
+func (noopSpan) End() {}
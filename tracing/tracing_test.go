@@ -0,0 +1,73 @@
+package tracing_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/packruler/pretty-error/tracing"
+)
+
+type recordingSpan struct {
+	attributes map[string]interface{}
+	events     []string
+	ended      bool
+}
+
+func (span *recordingSpan) SetAttributes(attributes ...tracing.Attribute) {
+	for _, attribute := range attributes {
+		span.attributes[attribute.Key] = attribute.Value
+	}
+}
+
+func (span *recordingSpan) AddEvent(name string, _ ...tracing.Attribute) {
+	span.events = append(span.events, name)
+}
+
+func (span *recordingSpan) End() {
+	span.ended = true
+}
+
+type recordingTracer struct {
+	spans []*recordingSpan
+}
+
+func (tracer *recordingTracer) Start(ctx context.Context, _ string) (context.Context, tracing.Span) {
+	span := &recordingSpan{attributes: make(map[string]interface{})}
+	tracer.spans = append(tracer.spans, span)
+
+	return ctx, span
+}
+
+func TestSetTracer(t *testing.T) {
+	defer tracing.SetTracer(nil)
+
+	// the default tracer does nothing.
+	_, span := tracing.GetTracer().Start(context.Background(), "noop")
+	span.SetAttributes(tracing.Int("status", 502))
+	span.End()
+
+	tracer := &recordingTracer{}
+	tracing.SetTracer(tracer)
+
+	_, span = tracing.GetTracer().Start(context.Background(), "recorded")
+	span.SetAttributes(tracing.Bool("replaced", true))
+	span.End()
+
+	if len(tracer.spans) != 1 || tracer.spans[0].attributes["replaced"] != true || !tracer.spans[0].ended {
+		t.Errorf("expected one ended span with attributes, got %+v", tracer.spans)
+	}
+}
+
+func TestSpanFromContext(t *testing.T) {
+	// without span, SpanFromContext returns a span doing nothing.
+	tracing.SpanFromContext(context.Background()).AddEvent("ignored")
+
+	span := &recordingSpan{attributes: make(map[string]interface{})}
+	ctx := tracing.ContextWithSpan(context.Background(), span)
+
+	tracing.SpanFromContext(ctx).AddEvent("recorded")
+
+	if len(span.events) != 1 || span.events[0] != "recorded" {
+		t.Errorf("expected recorded event, got %v", span.events)
+	}
+}
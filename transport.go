@@ -0,0 +1,44 @@
+package pretty_error
+
+import "net/http"
+
+// NewTransport wraps base, http.DefaultTransport when nil, to apply the middleware to the responses it returns before
+// they reach the client or the proxy using it, as an alternative to wrapping the ResponseWriter. Responses are
+// replaced like with NewResponseModifier; transport errors are returned unchanged.
+func NewTransport(base http.RoundTripper, config *Config, name string, opts ...Option) (http.RoundTripper, error) {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	bodyRewrite, err := newDetachedRewriteBody(config, name, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transport{base: base, bodyRewrite: bodyRewrite}, nil
+}
+
+// transport an http.RoundTripper replacing the responses of base by the ones of the middleware.
+type transport struct {
+	base        http.RoundTripper
+	bodyRewrite *rewriteBody
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Request == nil {
+		resp.Request = req
+	}
+
+	if err := t.bodyRewrite.modifyResponse(resp); err != nil {
+		_ = resp.Body.Close()
+
+		return nil, err
+	}
+
+	return resp, nil
+}
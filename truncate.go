@@ -0,0 +1,59 @@
+package pretty_error
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/packruler/pretty-error/logging"
+)
+
+// serveUpstream let next write its response to the catcher. It recovers the http.ErrAbortHandler panic with which
+// net/http/httputil.ReverseProxy aborts a response whose upstream failed while copying the body, other panics go on.
+func (interception *interception) serveUpstream(next http.Handler) {
+	defer interception.catcher.release()
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			if recovered != http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			interception.aborted = true
+		}
+	}()
+
+	next.ServeHTTP(interception.catcher, interception.req)
+}
+
+// truncated report whether the upstream failed to complete its response, aborting it or writing less body than its
+// Content-Length announced.
+func (interception *interception) truncated() bool {
+	return interception.aborted || interception.catcher.shortBody()
+}
+
+// shortBody report whether the upstream wrote less body than its Content-Length announced.
+func (cc *codeCatcher) shortBody() bool {
+	code := cc.getCode()
+	if cc.request.Method == http.MethodHead || code == http.StatusNoContent || code == http.StatusNotModified {
+		return false
+	}
+
+	length, err := strconv.ParseInt(cc.Header().Get("Content-Length"), 10, 64)
+
+	return err == nil && cc.core.BytesWritten() < length
+}
+
+// reportTruncated count and log a response the upstream failed to complete once part of it was sent. An aborted
+// response is aborted again, for the client to notice the truncation as it would have without the middleware.
+func (bodyRewrite *rewriteBody) reportTruncated(interception *interception) {
+	catcher := interception.catcher
+
+	bodyRewrite.metrics.Diagnostic(diagnosticTruncatedResponse)
+	bodyRewrite.logger.Warn("upstream response truncated", logging.F("middleware", bodyRewrite.name),
+		logging.F("path", interception.req.URL.Path), logging.F("status", catcher.getCode()),
+		logging.F("size", catcher.bodySize()), logging.F("aborted", interception.aborted))
+
+	if interception.aborted {
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,40 @@
+package types
+
+// CodeMatcher decides whether a response with the given status code should be intercepted.
+type CodeMatcher interface {
+	Match(status int) bool
+}
+
+// Match implements CodeMatcher with the HTTP code ranges.
+func (h HTTPCodeRanges) Match(status int) bool {
+	return h.Contains(status)
+}
+
+// CodeSet a CodeMatcher matching an explicit set of status codes.
+type CodeSet map[int]struct{}
+
+// NewCodeSet creates a CodeSet holding codes.
+func NewCodeSet(codes ...int) CodeSet {
+	set := make(CodeSet, len(codes))
+
+	for _, code := range codes {
+		set[code] = struct{}{}
+	}
+
+	return set
+}
+
+// Match implements CodeMatcher.
+func (s CodeSet) Match(status int) bool {
+	_, exists := s[status]
+
+	return exists
+}
+
+// CodeMatcherFunc an adapter to use an ordinary function as a CodeMatcher.
+type CodeMatcherFunc func(status int) bool
+
+// Match implements CodeMatcher by calling f.
+func (f CodeMatcherFunc) Match(status int) bool {
+	return f(status)
+}
@@ -2,46 +2,150 @@
 package types
 
 import (
+	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 )
 
-// HTTPCodeRanges holds HTTP code ranges.
-type HTTPCodeRanges [][2]int
+// HTTPCodeRanges holds HTTP code ranges, and the ranges carved out of them.
+type HTTPCodeRanges struct {
+	Include [][2]int
+	Exclude [][2]int
+}
+
+// exclusionPrefix marks a configured range as excluded.
+const exclusionPrefix = "!"
+
+// httpCodePresets symbolic names accepted in place of numeric ranges.
+var httpCodePresets = map[string][2]int{
+	"informational": {100, 199},
+	"success":       {200, 299},
+	"redirection":   {300, 399},
+	"client":        {400, 499},
+	"server":        {500, 599},
+	"error":         {400, 599},
+	"default":       {500, 599},
+}
+
+// presetRange get the range for a symbolic name like "client" or a class like "4xx".
+func presetRange(block string) ([2]int, bool) {
+	name := strings.ToLower(block)
+
+	if preset, exists := httpCodePresets[name]; exists {
+		return preset, true
+	}
+
+	if len(name) == 3 && strings.HasSuffix(name, "xx") && name[0] >= '1' && name[0] <= '5' {
+		class := int(name[0]-'0') * 100
+
+		return [2]int{class, class + 99}, true
+	}
+
+	return [2]int{}, false
+}
 
 // NewHTTPCodeRanges creates HTTPCodeRanges from a given []string.
 // Break out the http status code ranges into a low int and high int
-// for ease of use at runtime.
+// for ease of use at runtime. Classes like "4xx" and the presets "informational", "success",
+// "redirection", "client", "server", "error" and "default" (500-599) are expanded to their range.
+// Entries prefixed by "!" are excluded from the other ranges, for example ["5xx", "!501"].
+// Entries may also hold several comma separated ranges, surrounding whitespace is ignored
+// and reversed bounds are swapped.
 func NewHTTPCodeRanges(strBlocks []string) (HTTPCodeRanges, error) {
-	blocks := make(HTTPCodeRanges, 0, len(strBlocks))
-
-	for _, block := range strBlocks {
-		codes := strings.Split(block, "-")
-		// if only a single HTTP code was configured,
-		// assume the best and create the correct configuration on the user's behalf
-		if len(codes) == 1 {
-			codes = append(codes, codes[0])
-		}
+	var blocks HTTPCodeRanges
 
-		lowCode, err := strconv.Atoi(codes[0])
-		if err != nil {
-			return nil, err
-		}
+	for index, entry := range strBlocks {
+		for _, block := range strings.Split(entry, ",") {
+			block = strings.TrimSpace(block)
+			if block == "" {
+				continue
+			}
 
-		highCode, err := strconv.Atoi(codes[1])
-		if err != nil {
-			return nil, err
-		}
+			excluded := strings.HasPrefix(block, exclusionPrefix)
+
+			codeRange, err := parseRange(strings.TrimSpace(strings.TrimPrefix(block, exclusionPrefix)))
+			if err != nil {
+				return HTTPCodeRanges{}, fmt.Errorf("invalid status range %q in entry %d %q: %w", block, index, entry, err)
+			}
 
-		blocks = append(blocks, [2]int{lowCode, highCode})
+			if excluded {
+				blocks.Exclude = append(blocks.Exclude, codeRange)
+			} else {
+				blocks.Include = append(blocks.Include, codeRange)
+			}
+		}
 	}
 
 	return blocks, nil
 }
 
-// Contains tests whether the passed status code is within one of its HTTP code ranges.
+func parseRange(block string) ([2]int, error) {
+	if preset, ok := presetRange(block); ok {
+		return preset, nil
+	}
+
+	codes := strings.Split(block, "-")
+	// if only a single HTTP code was configured,
+	// assume the best and create the correct configuration on the user's behalf
+	if len(codes) == 1 {
+		codes = append(codes, codes[0])
+	}
+
+	if len(codes) != 2 {
+		return [2]int{}, errors.New("expected a code, a low-high range, a class like 4xx or a preset name")
+	}
+
+	lowCode, err := parseCode(codes[0])
+	if err != nil {
+		return [2]int{}, err
+	}
+
+	highCode, err := parseCode(codes[1])
+	if err != nil {
+		return [2]int{}, err
+	}
+
+	if lowCode > highCode {
+		lowCode, highCode = highCode, lowCode
+	}
+
+	return [2]int{lowCode, highCode}, nil
+}
+
+func parseCode(value string) (int, error) {
+	value = strings.TrimSpace(value)
+
+	code, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a status code", value)
+	}
+
+	if code < 100 || code > 599 {
+		return 0, fmt.Errorf("status code %d is outside of 100-599", code)
+	}
+
+	return code, nil
+}
+
+// ExcludingFrom get the ranges with base included when they only hold exclusions, such as ["!503"] carving 503 out of
+// base instead of matching nothing.
+func (h HTTPCodeRanges) ExcludingFrom(base [][2]int) HTTPCodeRanges {
+	if len(h.Include) == 0 && len(h.Exclude) > 0 {
+		h.Include = base
+	}
+
+	return h
+}
+
+// Contains tests whether the passed status code is within one of its HTTP code ranges
+// and not within one of its excluded ranges.
 func (h HTTPCodeRanges) Contains(statusCode int) bool {
-	for _, block := range h {
+	return inRanges(h.Include, statusCode) && !inRanges(h.Exclude, statusCode)
+}
+
+func inRanges(blocks [][2]int, statusCode int) bool {
+	for _, block := range blocks {
 		if statusCode >= block[0] && statusCode <= block[1] {
 			return true
 		}
@@ -49,3 +153,26 @@ func (h HTTPCodeRanges) Contains(statusCode int) bool {
 
 	return false
 }
+
+// String formats the HTTP code ranges like their configuration, for example "500-599,!501".
+func (h HTTPCodeRanges) String() string {
+	blocks := make([]string, 0, len(h.Include)+len(h.Exclude))
+
+	for _, block := range h.Include {
+		blocks = append(blocks, formatRange(block))
+	}
+
+	for _, block := range h.Exclude {
+		blocks = append(blocks, exclusionPrefix+formatRange(block))
+	}
+
+	return strings.Join(blocks, ",")
+}
+
+func formatRange(block [2]int) string {
+	if block[0] == block[1] {
+		return strconv.Itoa(block[0])
+	}
+
+	return strconv.Itoa(block[0]) + "-" + strconv.Itoa(block[1])
+}
@@ -0,0 +1,192 @@
+package types_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/packruler/pretty-error/types"
+)
+
+func TestNewHTTPCodeRanges(t *testing.T) {
+	tests := []struct {
+		desc     string
+		input    []string
+		expected types.HTTPCodeRanges
+		expErr   bool
+	}{
+		{
+			desc:     "should parse numeric ranges",
+			input:    []string{"404", "500-503"},
+			expected: types.HTTPCodeRanges{Include: [][2]int{{404, 404}, {500, 503}}},
+		},
+		{
+			desc:     "should expand classes",
+			input:    []string{"4xx", "5XX"},
+			expected: types.HTTPCodeRanges{Include: [][2]int{{400, 499}, {500, 599}}},
+		},
+		{
+			desc:     "should expand presets",
+			input:    []string{"client", "server", "default"},
+			expected: types.HTTPCodeRanges{Include: [][2]int{{400, 499}, {500, 599}, {500, 599}}},
+		},
+		{
+			desc:  "should parse exclusions",
+			input: []string{"500-599", "!501", "!4xx"},
+			expected: types.HTTPCodeRanges{
+				Include: [][2]int{{500, 599}},
+				Exclude: [][2]int{{501, 501}, {400, 499}},
+			},
+		},
+		{
+			desc:     "should tolerate whitespace, reversed bounds and comma separated ranges",
+			input:    []string{" 404 - 400 , 500", "!  501"},
+			expected: types.HTTPCodeRanges{Include: [][2]int{{400, 404}, {500, 500}}, Exclude: [][2]int{{501, 501}}},
+		},
+		{
+			desc:   "should reject unknown names",
+			input:  []string{"6xx"},
+			expErr: true,
+		},
+		{
+			desc:   "should reject codes out of range",
+			input:  []string{"500-700"},
+			expErr: true,
+		},
+		{
+			desc:   "should reject malformed ranges",
+			input:  []string{"400-450-500"},
+			expErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			output, err := types.NewHTTPCodeRanges(test.input)
+			if test.expErr {
+				if err == nil {
+					t.Errorf("expected error, got %v", output)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(output, test.expected) {
+				t.Errorf("got %v, want %v", output, test.expected)
+			}
+		})
+	}
+}
+
+func TestHTTPCodeRangesContains(t *testing.T) {
+	httpCodeRanges, err := types.NewHTTPCodeRanges([]string{"500-599", "!501", "!511"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := map[int]bool{
+		404: false,
+		500: true,
+		501: false,
+		502: true,
+		511: false,
+	}
+
+	for code, expected := range tests {
+		if contains := httpCodeRanges.Contains(code); contains != expected {
+			t.Errorf("got contains %v for %d, want %v", contains, code, expected)
+		}
+	}
+}
+
+func TestHTTPCodeRangesExcludingFrom(t *testing.T) {
+	base := [][2]int{{500, 599}}
+
+	excluded, err := types.NewHTTPCodeRanges([]string{"!503"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ranges := excluded.ExcludingFrom(base); !ranges.Contains(502) || ranges.Contains(503) || ranges.Contains(404) {
+		t.Errorf("expected 503 to be excluded from the base ranges, got %s", ranges)
+	}
+
+	included, err := types.NewHTTPCodeRanges([]string{"404", "!503"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ranges := included.ExcludingFrom(base); ranges.Contains(502) || !ranges.Contains(404) {
+		t.Errorf("expected the included ranges to be kept, got %s", ranges)
+	}
+
+	if ranges := (types.HTTPCodeRanges{}).ExcludingFrom(base); ranges.Contains(500) {
+		t.Errorf("expected empty ranges to stay empty, got %s", ranges)
+	}
+}
+
+func TestHTTPCodeRangesString(t *testing.T) {
+	httpCodeRanges, err := types.NewHTTPCodeRanges([]string{"404", "5xx", "!501"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if output := httpCodeRanges.String(); output != "404,500-599,!501" {
+		t.Errorf("got %q, want %q", output, "404,500-599,!501")
+	}
+
+	reparsed, err := types.NewHTTPCodeRanges(strings.Split(httpCodeRanges.String(), ","))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(reparsed, httpCodeRanges) {
+		t.Errorf("got %v after round trip, want %v", reparsed, httpCodeRanges)
+	}
+}
+
+func TestNewHTTPCodeRangesErrorMessage(t *testing.T) {
+	_, err := types.NewHTTPCodeRanges([]string{"404", "500, 50x"})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	expected := `invalid status range "50x" in entry 1 "500, 50x": "50x" is not a status code`
+	if err.Error() != expected {
+		t.Errorf("got error %q, want %q", err.Error(), expected)
+	}
+}
+
+func TestCodeMatchers(t *testing.T) {
+	httpCodeRanges, err := types.NewHTTPCodeRanges([]string{"5xx", "!501"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	matchers := map[string]types.CodeMatcher{
+		"ranges": httpCodeRanges,
+		"set":    types.NewCodeSet(500, 502),
+		"func": types.CodeMatcherFunc(func(status int) bool {
+			return status == 500 || status == 502
+		}),
+	}
+
+	tests := map[int]bool{
+		200: false,
+		500: true,
+		501: false,
+		502: true,
+	}
+
+	for name, matcher := range matchers {
+		for code, expected := range tests {
+			if match := matcher.Match(code); match != expected {
+				t.Errorf("%s: got match %v for %d, want %v", name, match, code, expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package pretty_error
+
+import "strings"
+
+// upstreamBody get the start of the body of the response replaced by catcher, for the templates, when configured.
+// Compressed bodies are left out, their start cannot be decoded on its own.
+func (bodyRewrite *rewriteBody) upstreamBody(catcher responseInterceptor) string {
+	if !bodyRewrite.catcherConfig.upstreamBody {
+		return ""
+	}
+
+	if encoding := catcher.Header().Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return ""
+	}
+
+	return strings.ToValidUTF8(catcher.getBuffer().String(), "�")
+}
@@ -0,0 +1,64 @@
+package pretty_error
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/packruler/pretty-error/types"
+)
+
+// ValidationError lists every problem found in a Config, each prefixed by the offending field.
+type ValidationError struct {
+	Problems []string
+}
+
+func (validationError *ValidationError) Error() string {
+	return fmt.Sprintf("invalid configuration, %d problem(s): %s",
+		len(validationError.Problems), strings.Join(validationError.Problems, "; "))
+}
+
+// validator collects configuration problems instead of failing on the first one.
+type validator struct {
+	problems []string
+}
+
+// check record err against field, returning whether there was no error.
+func (v *validator) check(field string, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	v.problems = append(v.problems, field+": "+err.Error())
+
+	return false
+}
+
+// err get a ValidationError listing every recorded problem, or nil when there was none.
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Problems: v.problems}
+}
+
+// anyStatusRanges hold every status, the ranges the status exclusions of rewrites, the banner and actions apply to.
+var anyStatusRanges = [][2]int{{100, 599}}
+
+// parseStatus parse status ranges, recording a problem for every invalid entry. Entries only holding exclusions
+// exclude statuses from base.
+func (v *validator) parseStatus(field string, entries []string, base [][2]int) types.HTTPCodeRanges {
+	httpCodeRanges, err := types.NewHTTPCodeRanges(entries)
+	if err == nil {
+		return httpCodeRanges.ExcludingFrom(base)
+	}
+
+	for index, entry := range entries {
+		if _, err := types.NewHTTPCodeRanges([]string{entry}); err != nil {
+			v.check(fmt.Sprintf("%s[%d]", field, index), errors.Unwrap(err))
+		}
+	}
+
+	return httpCodeRanges
+}
@@ -0,0 +1,220 @@
+package pretty_error
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/packruler/pretty-error/logging"
+)
+
+const (
+	defaultWebhookThreshold = 10
+	defaultWebhookWindow    = time.Minute
+	defaultWebhookCooldown  = 10 * time.Minute
+	defaultWebhookTimeout   = 5 * time.Second
+)
+
+// Formats of the webhook payloads.
+const (
+	webhookFormatGeneric = "generic"
+	webhookFormatSlack   = "slack"
+	webhookFormatDiscord = "discord"
+)
+
+// Webhook holds the configuration of the alerts posted when server errors burst: Threshold replaced 5xx responses
+// within Window trigger an alert, then no other alert is posted before the burst ends and Cooldown elapsed.
+type Webhook struct {
+	URL       string `json:"url,omitempty" toml:"url,omitempty" yaml:"url,omitempty" export:"true"`
+	Format    string `json:"format,omitempty" toml:"format,omitempty" yaml:"format,omitempty" export:"true"`
+	Threshold int    `json:"threshold,omitempty" toml:"threshold,omitempty" yaml:"threshold,omitempty" export:"true"`
+	Window    string `json:"window,omitempty" toml:"window,omitempty" yaml:"window,omitempty" export:"true"`
+	Cooldown  string `json:"cooldown,omitempty" toml:"cooldown,omitempty" yaml:"cooldown,omitempty" export:"true"`
+	Timeout   string `json:"timeout,omitempty" toml:"timeout,omitempty" yaml:"timeout,omitempty" export:"true"`
+}
+
+// webhookAlert is the payload of the generic format.
+type webhookAlert struct {
+	Middleware string         `json:"middleware"`
+	Time       time.Time      `json:"time"`
+	Count      int            `json:"count"`
+	Window     string         `json:"window"`
+	Statuses   map[string]int `json:"statuses"`
+}
+
+// webhookEvent is a replaced server error within the window.
+type webhookEvent struct {
+	time   time.Time
+	status int
+}
+
+// notifier posts an alert to the webhook when the rate of replaced server errors exceeds the threshold.
+type notifier struct {
+	url       string
+	format    string
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	client    *http.Client
+	logger    logging.Logger
+	name      string
+	now       func() time.Time
+	// post sends the alerts, asynchronously unless replaced in tests.
+	post func(payload []byte)
+
+	mu        sync.Mutex
+	events    []webhookEvent
+	alerting  bool
+	lastAlert time.Time
+}
+
+// newNotifier get the notifier of the configured webhook, nil when there is none.
+func newNotifier(config *Webhook, name string, logger logging.Logger, v *validator) *notifier {
+	if config == nil {
+		return nil
+	}
+
+	if config.URL == "" {
+		v.check("webhook.url", errors.New("is required"))
+	} else if _, err := url.Parse(config.URL); err != nil {
+		v.check("webhook.url", err)
+	}
+
+	format := strings.ToLower(config.Format)
+	switch format {
+	case "":
+		format = webhookFormatGeneric
+	case webhookFormatGeneric, webhookFormatSlack, webhookFormatDiscord:
+	default:
+		v.check("webhook.format", fmt.Errorf("unknown format %q", config.Format))
+	}
+
+	threshold := config.Threshold
+	if threshold < 0 {
+		v.check("webhook.threshold", errors.New("must not be negative"))
+	}
+
+	if threshold <= 0 {
+		threshold = defaultWebhookThreshold
+	}
+
+	notifier := &notifier{
+		url:       config.URL,
+		format:    format,
+		threshold: threshold,
+		window:    parseDuration("webhook.window", config.Window, defaultWebhookWindow, v),
+		cooldown:  parseDuration("webhook.cooldown", config.Cooldown, defaultWebhookCooldown, v),
+		client:    &http.Client{Timeout: parseDuration("webhook.timeout", config.Timeout, defaultWebhookTimeout, v)},
+		logger:    logger,
+		name:      name,
+		now:       time.Now,
+	}
+
+	notifier.post = func(payload []byte) { go notifier.send(payload) }
+
+	return notifier
+}
+
+// observe count a replaced response, alerting when it completes a burst of server errors.
+func (notifier *notifier) observe(status int) {
+	if notifier == nil || status < http.StatusInternalServerError {
+		return
+	}
+
+	now := notifier.now()
+
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+
+	notifier.events = append(notifier.events, webhookEvent{time: now, status: status})
+
+	first := 0
+	for first < len(notifier.events) && now.Sub(notifier.events[first].time) > notifier.window {
+		first++
+	}
+
+	notifier.events = notifier.events[first:]
+
+	if len(notifier.events) < notifier.threshold {
+		notifier.alerting = false
+
+		return
+	}
+
+	if notifier.alerting || (!notifier.lastAlert.IsZero() && now.Sub(notifier.lastAlert) < notifier.cooldown) {
+		return
+	}
+
+	notifier.alerting = true
+	notifier.lastAlert = now
+
+	payload, err := notifier.payload(now)
+	if err != nil {
+		notifier.logger.Warn("unable to build webhook alert", logging.F("error", err))
+
+		return
+	}
+
+	notifier.post(payload)
+}
+
+// payload describe the burst of the events in the window, in the configured format.
+func (notifier *notifier) payload(now time.Time) ([]byte, error) {
+	alert := webhookAlert{
+		Middleware: notifier.name,
+		Time:       now.UTC(),
+		Count:      len(notifier.events),
+		Window:     notifier.window.String(),
+		Statuses:   make(map[string]int),
+	}
+
+	for _, event := range notifier.events {
+		alert.Statuses[strconv.Itoa(event.status)]++
+	}
+
+	switch notifier.format {
+	case webhookFormatSlack:
+		return json.Marshal(map[string]string{"text": alert.message()})
+	case webhookFormatDiscord:
+		return json.Marshal(map[string]string{"content": alert.message()})
+	default:
+		return json.Marshal(alert)
+	}
+}
+
+// message summarize the alert for chat webhooks.
+func (alert webhookAlert) message() string {
+	statuses := make([]string, 0, len(alert.Statuses))
+	for status, count := range alert.Statuses {
+		statuses = append(statuses, fmt.Sprintf("%s x%d", status, count))
+	}
+
+	sort.Strings(statuses)
+
+	return fmt.Sprintf("pretty-error %s: %d server errors replaced within %s (%s)",
+		alert.Middleware, alert.Count, alert.Window, strings.Join(statuses, ", "))
+}
+
+// send post the alert to the webhook.
+func (notifier *notifier) send(payload []byte) {
+	response, err := notifier.client.Post(notifier.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		notifier.logger.Warn("unable to post webhook alert", logging.F("error", err))
+
+		return
+	}
+
+	_ = response.Body.Close()
+
+	if response.StatusCode >= http.StatusMultipleChoices {
+		notifier.logger.Warn("webhook rejected alert", logging.F("status", response.StatusCode))
+	}
+}
@@ -0,0 +1,555 @@
+// Code generated by internal/wrappergen; DO NOT EDIT.
+
+package pretty_error
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+// Optional interfaces of the wrapped http.ResponseWriter.
+const (
+	supportsFlusher = 1 << iota
+	supportsHijacker
+	supportsCloseNotifier
+	supportsPusher
+	supportsReaderFrom
+)
+
+// wrapCodeCatcher get catcher wrapped in the type exposing exactly the optional interfaces of the wrapped writer.
+func wrapCodeCatcher(catcher *codeCatcher) responseInterceptor {
+	var supported int
+
+	if _, ok := catcher.responseWriter.(http.Flusher); ok {
+		supported |= supportsFlusher
+	}
+
+	if _, ok := catcher.responseWriter.(http.Hijacker); ok {
+		supported |= supportsHijacker
+	}
+
+	if _, ok := catcher.responseWriter.(http.CloseNotifier); ok {
+		supported |= supportsCloseNotifier
+	}
+
+	if _, ok := catcher.responseWriter.(http.Pusher); ok {
+		supported |= supportsPusher
+	}
+
+	if _, ok := catcher.responseWriter.(io.ReaderFrom); ok {
+		supported |= supportsReaderFrom
+	}
+
+	switch supported {
+	case supportsFlusher:
+		return codeCatcherFlusher{catcher}
+	case supportsHijacker:
+		return codeCatcherHijacker{catcher}
+	case supportsFlusher | supportsHijacker:
+		return codeCatcherFlusherHijacker{catcher}
+	case supportsCloseNotifier:
+		return codeCatcherCloseNotifier{catcher}
+	case supportsFlusher | supportsCloseNotifier:
+		return codeCatcherFlusherCloseNotifier{catcher}
+	case supportsHijacker | supportsCloseNotifier:
+		return codeCatcherHijackerCloseNotifier{catcher}
+	case supportsFlusher | supportsHijacker | supportsCloseNotifier:
+		return codeCatcherFlusherHijackerCloseNotifier{catcher}
+	case supportsPusher:
+		return codeCatcherPusher{catcher}
+	case supportsFlusher | supportsPusher:
+		return codeCatcherFlusherPusher{catcher}
+	case supportsHijacker | supportsPusher:
+		return codeCatcherHijackerPusher{catcher}
+	case supportsFlusher | supportsHijacker | supportsPusher:
+		return codeCatcherFlusherHijackerPusher{catcher}
+	case supportsCloseNotifier | supportsPusher:
+		return codeCatcherCloseNotifierPusher{catcher}
+	case supportsFlusher | supportsCloseNotifier | supportsPusher:
+		return codeCatcherFlusherCloseNotifierPusher{catcher}
+	case supportsHijacker | supportsCloseNotifier | supportsPusher:
+		return codeCatcherHijackerCloseNotifierPusher{catcher}
+	case supportsFlusher | supportsHijacker | supportsCloseNotifier | supportsPusher:
+		return codeCatcherFlusherHijackerCloseNotifierPusher{catcher}
+	case supportsReaderFrom:
+		return codeCatcherReaderFrom{catcher}
+	case supportsFlusher | supportsReaderFrom:
+		return codeCatcherFlusherReaderFrom{catcher}
+	case supportsHijacker | supportsReaderFrom:
+		return codeCatcherHijackerReaderFrom{catcher}
+	case supportsFlusher | supportsHijacker | supportsReaderFrom:
+		return codeCatcherFlusherHijackerReaderFrom{catcher}
+	case supportsCloseNotifier | supportsReaderFrom:
+		return codeCatcherCloseNotifierReaderFrom{catcher}
+	case supportsFlusher | supportsCloseNotifier | supportsReaderFrom:
+		return codeCatcherFlusherCloseNotifierReaderFrom{catcher}
+	case supportsHijacker | supportsCloseNotifier | supportsReaderFrom:
+		return codeCatcherHijackerCloseNotifierReaderFrom{catcher}
+	case supportsFlusher | supportsHijacker | supportsCloseNotifier | supportsReaderFrom:
+		return codeCatcherFlusherHijackerCloseNotifierReaderFrom{catcher}
+	case supportsPusher | supportsReaderFrom:
+		return codeCatcherPusherReaderFrom{catcher}
+	case supportsFlusher | supportsPusher | supportsReaderFrom:
+		return codeCatcherFlusherPusherReaderFrom{catcher}
+	case supportsHijacker | supportsPusher | supportsReaderFrom:
+		return codeCatcherHijackerPusherReaderFrom{catcher}
+	case supportsFlusher | supportsHijacker | supportsPusher | supportsReaderFrom:
+		return codeCatcherFlusherHijackerPusherReaderFrom{catcher}
+	case supportsCloseNotifier | supportsPusher | supportsReaderFrom:
+		return codeCatcherCloseNotifierPusherReaderFrom{catcher}
+	case supportsFlusher | supportsCloseNotifier | supportsPusher | supportsReaderFrom:
+		return codeCatcherFlusherCloseNotifierPusherReaderFrom{catcher}
+	case supportsHijacker | supportsCloseNotifier | supportsPusher | supportsReaderFrom:
+		return codeCatcherHijackerCloseNotifierPusherReaderFrom{catcher}
+	case supportsFlusher | supportsHijacker | supportsCloseNotifier | supportsPusher | supportsReaderFrom:
+		return codeCatcherFlusherHijackerCloseNotifierPusherReaderFrom{catcher}
+	default:
+		return catcher
+	}
+}
+
+type codeCatcherFlusher struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherFlusher) Flush() {
+	wrapper.flush()
+}
+
+type codeCatcherHijacker struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return wrapper.hijack()
+}
+
+type codeCatcherFlusherHijacker struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherFlusherHijacker) Flush() {
+	wrapper.flush()
+}
+
+func (wrapper codeCatcherFlusherHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return wrapper.hijack()
+}
+
+type codeCatcherCloseNotifier struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherCloseNotifier) CloseNotify() <-chan bool {
+	return wrapper.closeNotify()
+}
+
+type codeCatcherFlusherCloseNotifier struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherFlusherCloseNotifier) Flush() {
+	wrapper.flush()
+}
+
+func (wrapper codeCatcherFlusherCloseNotifier) CloseNotify() <-chan bool {
+	return wrapper.closeNotify()
+}
+
+type codeCatcherHijackerCloseNotifier struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherHijackerCloseNotifier) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return wrapper.hijack()
+}
+
+func (wrapper codeCatcherHijackerCloseNotifier) CloseNotify() <-chan bool {
+	return wrapper.closeNotify()
+}
+
+type codeCatcherFlusherHijackerCloseNotifier struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherFlusherHijackerCloseNotifier) Flush() {
+	wrapper.flush()
+}
+
+func (wrapper codeCatcherFlusherHijackerCloseNotifier) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return wrapper.hijack()
+}
+
+func (wrapper codeCatcherFlusherHijackerCloseNotifier) CloseNotify() <-chan bool {
+	return wrapper.closeNotify()
+}
+
+type codeCatcherPusher struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherPusher) Push(target string, opts *http.PushOptions) error {
+	return wrapper.push(target, opts)
+}
+
+type codeCatcherFlusherPusher struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherFlusherPusher) Flush() {
+	wrapper.flush()
+}
+
+func (wrapper codeCatcherFlusherPusher) Push(target string, opts *http.PushOptions) error {
+	return wrapper.push(target, opts)
+}
+
+type codeCatcherHijackerPusher struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherHijackerPusher) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return wrapper.hijack()
+}
+
+func (wrapper codeCatcherHijackerPusher) Push(target string, opts *http.PushOptions) error {
+	return wrapper.push(target, opts)
+}
+
+type codeCatcherFlusherHijackerPusher struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherFlusherHijackerPusher) Flush() {
+	wrapper.flush()
+}
+
+func (wrapper codeCatcherFlusherHijackerPusher) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return wrapper.hijack()
+}
+
+func (wrapper codeCatcherFlusherHijackerPusher) Push(target string, opts *http.PushOptions) error {
+	return wrapper.push(target, opts)
+}
+
+type codeCatcherCloseNotifierPusher struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherCloseNotifierPusher) CloseNotify() <-chan bool {
+	return wrapper.closeNotify()
+}
+
+func (wrapper codeCatcherCloseNotifierPusher) Push(target string, opts *http.PushOptions) error {
+	return wrapper.push(target, opts)
+}
+
+type codeCatcherFlusherCloseNotifierPusher struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherFlusherCloseNotifierPusher) Flush() {
+	wrapper.flush()
+}
+
+func (wrapper codeCatcherFlusherCloseNotifierPusher) CloseNotify() <-chan bool {
+	return wrapper.closeNotify()
+}
+
+func (wrapper codeCatcherFlusherCloseNotifierPusher) Push(target string, opts *http.PushOptions) error {
+	return wrapper.push(target, opts)
+}
+
+type codeCatcherHijackerCloseNotifierPusher struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherHijackerCloseNotifierPusher) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return wrapper.hijack()
+}
+
+func (wrapper codeCatcherHijackerCloseNotifierPusher) CloseNotify() <-chan bool {
+	return wrapper.closeNotify()
+}
+
+func (wrapper codeCatcherHijackerCloseNotifierPusher) Push(target string, opts *http.PushOptions) error {
+	return wrapper.push(target, opts)
+}
+
+type codeCatcherFlusherHijackerCloseNotifierPusher struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherFlusherHijackerCloseNotifierPusher) Flush() {
+	wrapper.flush()
+}
+
+func (wrapper codeCatcherFlusherHijackerCloseNotifierPusher) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return wrapper.hijack()
+}
+
+func (wrapper codeCatcherFlusherHijackerCloseNotifierPusher) CloseNotify() <-chan bool {
+	return wrapper.closeNotify()
+}
+
+func (wrapper codeCatcherFlusherHijackerCloseNotifierPusher) Push(target string, opts *http.PushOptions) error {
+	return wrapper.push(target, opts)
+}
+
+type codeCatcherReaderFrom struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherReaderFrom) ReadFrom(reader io.Reader) (int64, error) {
+	return wrapper.readFrom(reader)
+}
+
+type codeCatcherFlusherReaderFrom struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherFlusherReaderFrom) Flush() {
+	wrapper.flush()
+}
+
+func (wrapper codeCatcherFlusherReaderFrom) ReadFrom(reader io.Reader) (int64, error) {
+	return wrapper.readFrom(reader)
+}
+
+type codeCatcherHijackerReaderFrom struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherHijackerReaderFrom) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return wrapper.hijack()
+}
+
+func (wrapper codeCatcherHijackerReaderFrom) ReadFrom(reader io.Reader) (int64, error) {
+	return wrapper.readFrom(reader)
+}
+
+type codeCatcherFlusherHijackerReaderFrom struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherFlusherHijackerReaderFrom) Flush() {
+	wrapper.flush()
+}
+
+func (wrapper codeCatcherFlusherHijackerReaderFrom) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return wrapper.hijack()
+}
+
+func (wrapper codeCatcherFlusherHijackerReaderFrom) ReadFrom(reader io.Reader) (int64, error) {
+	return wrapper.readFrom(reader)
+}
+
+type codeCatcherCloseNotifierReaderFrom struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherCloseNotifierReaderFrom) CloseNotify() <-chan bool {
+	return wrapper.closeNotify()
+}
+
+func (wrapper codeCatcherCloseNotifierReaderFrom) ReadFrom(reader io.Reader) (int64, error) {
+	return wrapper.readFrom(reader)
+}
+
+type codeCatcherFlusherCloseNotifierReaderFrom struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherFlusherCloseNotifierReaderFrom) Flush() {
+	wrapper.flush()
+}
+
+func (wrapper codeCatcherFlusherCloseNotifierReaderFrom) CloseNotify() <-chan bool {
+	return wrapper.closeNotify()
+}
+
+func (wrapper codeCatcherFlusherCloseNotifierReaderFrom) ReadFrom(reader io.Reader) (int64, error) {
+	return wrapper.readFrom(reader)
+}
+
+type codeCatcherHijackerCloseNotifierReaderFrom struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherHijackerCloseNotifierReaderFrom) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return wrapper.hijack()
+}
+
+func (wrapper codeCatcherHijackerCloseNotifierReaderFrom) CloseNotify() <-chan bool {
+	return wrapper.closeNotify()
+}
+
+func (wrapper codeCatcherHijackerCloseNotifierReaderFrom) ReadFrom(reader io.Reader) (int64, error) {
+	return wrapper.readFrom(reader)
+}
+
+type codeCatcherFlusherHijackerCloseNotifierReaderFrom struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherFlusherHijackerCloseNotifierReaderFrom) Flush() {
+	wrapper.flush()
+}
+
+func (wrapper codeCatcherFlusherHijackerCloseNotifierReaderFrom) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return wrapper.hijack()
+}
+
+func (wrapper codeCatcherFlusherHijackerCloseNotifierReaderFrom) CloseNotify() <-chan bool {
+	return wrapper.closeNotify()
+}
+
+func (wrapper codeCatcherFlusherHijackerCloseNotifierReaderFrom) ReadFrom(reader io.Reader) (int64, error) {
+	return wrapper.readFrom(reader)
+}
+
+type codeCatcherPusherReaderFrom struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherPusherReaderFrom) Push(target string, opts *http.PushOptions) error {
+	return wrapper.push(target, opts)
+}
+
+func (wrapper codeCatcherPusherReaderFrom) ReadFrom(reader io.Reader) (int64, error) {
+	return wrapper.readFrom(reader)
+}
+
+type codeCatcherFlusherPusherReaderFrom struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherFlusherPusherReaderFrom) Flush() {
+	wrapper.flush()
+}
+
+func (wrapper codeCatcherFlusherPusherReaderFrom) Push(target string, opts *http.PushOptions) error {
+	return wrapper.push(target, opts)
+}
+
+func (wrapper codeCatcherFlusherPusherReaderFrom) ReadFrom(reader io.Reader) (int64, error) {
+	return wrapper.readFrom(reader)
+}
+
+type codeCatcherHijackerPusherReaderFrom struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherHijackerPusherReaderFrom) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return wrapper.hijack()
+}
+
+func (wrapper codeCatcherHijackerPusherReaderFrom) Push(target string, opts *http.PushOptions) error {
+	return wrapper.push(target, opts)
+}
+
+func (wrapper codeCatcherHijackerPusherReaderFrom) ReadFrom(reader io.Reader) (int64, error) {
+	return wrapper.readFrom(reader)
+}
+
+type codeCatcherFlusherHijackerPusherReaderFrom struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherFlusherHijackerPusherReaderFrom) Flush() {
+	wrapper.flush()
+}
+
+func (wrapper codeCatcherFlusherHijackerPusherReaderFrom) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return wrapper.hijack()
+}
+
+func (wrapper codeCatcherFlusherHijackerPusherReaderFrom) Push(target string, opts *http.PushOptions) error {
+	return wrapper.push(target, opts)
+}
+
+func (wrapper codeCatcherFlusherHijackerPusherReaderFrom) ReadFrom(reader io.Reader) (int64, error) {
+	return wrapper.readFrom(reader)
+}
+
+type codeCatcherCloseNotifierPusherReaderFrom struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherCloseNotifierPusherReaderFrom) CloseNotify() <-chan bool {
+	return wrapper.closeNotify()
+}
+
+func (wrapper codeCatcherCloseNotifierPusherReaderFrom) Push(target string, opts *http.PushOptions) error {
+	return wrapper.push(target, opts)
+}
+
+func (wrapper codeCatcherCloseNotifierPusherReaderFrom) ReadFrom(reader io.Reader) (int64, error) {
+	return wrapper.readFrom(reader)
+}
+
+type codeCatcherFlusherCloseNotifierPusherReaderFrom struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherFlusherCloseNotifierPusherReaderFrom) Flush() {
+	wrapper.flush()
+}
+
+func (wrapper codeCatcherFlusherCloseNotifierPusherReaderFrom) CloseNotify() <-chan bool {
+	return wrapper.closeNotify()
+}
+
+func (wrapper codeCatcherFlusherCloseNotifierPusherReaderFrom) Push(target string, opts *http.PushOptions) error {
+	return wrapper.push(target, opts)
+}
+
+func (wrapper codeCatcherFlusherCloseNotifierPusherReaderFrom) ReadFrom(reader io.Reader) (int64, error) {
+	return wrapper.readFrom(reader)
+}
+
+type codeCatcherHijackerCloseNotifierPusherReaderFrom struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherHijackerCloseNotifierPusherReaderFrom) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return wrapper.hijack()
+}
+
+func (wrapper codeCatcherHijackerCloseNotifierPusherReaderFrom) CloseNotify() <-chan bool {
+	return wrapper.closeNotify()
+}
+
+func (wrapper codeCatcherHijackerCloseNotifierPusherReaderFrom) Push(target string, opts *http.PushOptions) error {
+	return wrapper.push(target, opts)
+}
+
+func (wrapper codeCatcherHijackerCloseNotifierPusherReaderFrom) ReadFrom(reader io.Reader) (int64, error) {
+	return wrapper.readFrom(reader)
+}
+
+type codeCatcherFlusherHijackerCloseNotifierPusherReaderFrom struct {
+	*codeCatcher
+}
+
+func (wrapper codeCatcherFlusherHijackerCloseNotifierPusherReaderFrom) Flush() {
+	wrapper.flush()
+}
+
+func (wrapper codeCatcherFlusherHijackerCloseNotifierPusherReaderFrom) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return wrapper.hijack()
+}
+
+func (wrapper codeCatcherFlusherHijackerCloseNotifierPusherReaderFrom) CloseNotify() <-chan bool {
+	return wrapper.closeNotify()
+}
+
+func (wrapper codeCatcherFlusherHijackerCloseNotifierPusherReaderFrom) Push(target string, opts *http.PushOptions) error {
+	return wrapper.push(target, opts)
+}
+
+func (wrapper codeCatcherFlusherHijackerCloseNotifierPusherReaderFrom) ReadFrom(reader io.Reader) (int64, error) {
+	return wrapper.readFrom(reader)
+}